@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Student visibility rules live here so every handler applies the same scope:
+// admins see every student, teachers see the members of their classes,
+// parents see their linked children and students only see themselves.
+
+// TeacherStudentIDs returns the distinct students visible to a teacher. Only
+// class membership counts: homework can only be assigned to these students, so
+// it never widens the scope.
+func TeacherStudentIDs(db *gorm.DB, teacherID string) []string {
+	return ClassStudentIDs(db, teacherID)
+}
+
+// StudentScope returns the student IDs the requester may read. all is true
+// when the requester is not restricted (admins).
+func StudentScope(c *gin.Context) (ids []string, all bool) {
+	userId, _ := c.Get("userId")
+	role, _ := c.Get("role")
+
+	switch fmt.Sprintf("%v", role) {
+	case string(RoleAdmin):
+		return nil, true
+	case string(RoleTeacher):
+		return TeacherStudentIDs(TenantDB(c), fmt.Sprintf("%v", userId)), false
+	case string(RoleParent):
+		return ParentStudentIDs(TenantDB(c), fmt.Sprintf("%v", userId)), false
+	default:
+		return []string{fmt.Sprintf("%v", userId)}, false
+	}
+}
+
+// CanAccessStudent reports whether the requester may read the student's data
+func CanAccessStudent(c *gin.Context, studentID string) bool {
+	ids, all := StudentScope(c)
+	if all {
+		return true
+	}
+	for _, id := range ids {
+		if id == studentID {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireStudentAccess responds with an error when the requester may not read
+// the student's data
+func RequireStudentAccess(c *gin.Context, studentID string) bool {
+	if CanAccessStudent(c, studentID) {
+		return true
+	}
+	SendJSON(c, 1, "Access denied for student: "+studentID, nil)
+	return false
+}
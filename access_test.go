@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTeacherStudentVisibility(t *testing.T) {
+	DB.Exec("DELETE FROM users")
+	DB.Exec("DELETE FROM classes")
+	DB.Exec("DELETE FROM homeworks")
+	DB.Exec("DELETE FROM student_wrong_questions")
+	DB.Create(&User{ID: "c1", Username: "child1", Role: RoleStudent})
+	DB.Create(&User{ID: "c2", Username: "child2", Role: RoleStudent})
+	DB.Create(&Class{ID: "k1", Name: "1A", TeacherIDs: []string{"t1"}, StudentIDs: []string{"c1"}})
+	DB.Create(&Homework{ID: "h1", TeacherID: "t2", StudentIDs: []string{"c2"}})
+	DB.Create(&StudentWrongQuestion{ID: "w1", StudentID: "c1", QuestionID: "q1", Status: 1})
+	DB.Create(&StudentWrongQuestion{ID: "w2", StudentID: "c2", QuestionID: "q1", Status: 1})
+
+	router := func(userId string, role Role) *gin.Engine {
+		r := gin.Default()
+		r.Use(func(c *gin.Context) {
+			c.Set("userId", userId)
+			c.Set("role", role)
+		})
+		r.GET("/students/:id", GetStudentDetail)
+		r.GET("/wrong-book", GetWrongBook)
+		r.GET("/history", GetHistory)
+		return r
+	}
+
+	tests := []struct {
+		name         string
+		userId       string
+		role         Role
+		url          string
+		expectedCode int
+	}{
+		{"Class teacher reads own student", "t1", RoleTeacher, "/students/c1", 0},
+		{"Homework alone gives no access", "t2", RoleTeacher, "/students/c2", 1},
+		{"Cross teacher detail denied", "t1", RoleTeacher, "/students/c2", 1},
+		{"Cross teacher wrong book denied", "t2", RoleTeacher, "/wrong-book?studentId=c1", 1},
+		{"Cross teacher history denied", "t2", RoleTeacher, "/history?studentId=c1", 1},
+		{"Admin reads anyone", "a1", RoleAdmin, "/students/c2", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tt.url, nil)
+			w := httptest.NewRecorder()
+			router(tt.userId, tt.role).ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+			var resp Response
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			assert.Equal(t, tt.expectedCode, resp.Code, resp.Err)
+		})
+	}
+
+	// Without a studentId the wrong book is limited to the teacher's own students
+	req, _ := http.NewRequest("GET", "/wrong-book", nil)
+	w := httptest.NewRecorder()
+	router("t1", RoleTeacher).ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), `"studentId":"c1"`)
+	assert.NotContains(t, w.Body.String(), `"studentId":"c2"`)
+
+	// Only admins see every history, parents use the student detail
+	DB.Exec("DELETE FROM histories")
+	DB.Create(&History{ID: "x1", StudentID: "c1"})
+	for role, total := range map[Role]string{RoleAdmin: `"total":1`, RoleParent: `"total":0`} {
+		req, _ = http.NewRequest("GET", "/history", nil)
+		w = httptest.NewRecorder()
+		router("u1", role).ServeHTTP(w, req)
+		assert.Contains(t, w.Body.String(), total, role)
+	}
+}
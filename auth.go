@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// Access tokens are short-lived JWTs; the long-lived part of a login is the
+// rotating refresh token, which is stored server side and can be revoked.
+var refreshTokenDuration = 30 * 24 * time.Hour
+
+const timeLayout = "2006-01-02 15:04:05"
+
+var errInvalidRefreshToken = errors.New("Invalid refresh token")
+
+// userDisabled reports whether an account was locked by an admin
+func userDisabled(u User) bool {
+	return u.Status == "inactive" || u.Status == "disabled"
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// issueAccessToken signs a short-lived JWT bound to a session and the user's school
+func issueAccessToken(user User, sessionID string) (string, error) {
+	return AppKeys.Sign(jwt.MapClaims{
+		"userId": user.ID,
+		"role":   user.Role,
+		"tid":    user.TenantID,
+		"sid":    sessionID,
+		"exp":    time.Now().Add(tokenDuration).Unix(),
+	})
+}
+
+// tokenPair issues an access token and stores a fresh refresh token for the session
+func tokenPair(tx *gorm.DB, user User, sessionID string) (gin.H, error) {
+	refresh, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&RefreshToken{
+		Hash:      hashToken(refresh),
+		SessionID: sessionID,
+		CreatedAt: time.Now().Format(timeLayout),
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	access, err := issueAccessToken(user, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"token":        access,
+		"refreshToken": refresh,
+		"expiresIn":    int(tokenDuration.Seconds()),
+	}, nil
+}
+
+// StartUserSession records a new device session for user and returns its tokens
+func StartUserSession(c *gin.Context, user User, passwordFree bool) (gin.H, error) {
+	now := time.Now()
+	session := UserSession{
+		ID:           strconv.FormatInt(now.UnixNano(), 36),
+		UserID:       user.ID,
+		UserAgent:    c.Request.UserAgent(),
+		IP:           c.ClientIP(),
+		ExpiresAt:    now.Add(refreshTokenDuration).Format(timeLayout),
+		CreatedAt:    now.Format(timeLayout),
+		LastUsedAt:   now.Format(timeLayout),
+		PasswordFree: passwordFree,
+	}
+
+	var tokens gin.H
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		var err error
+		tokens, err = tokenPair(tx, user, session.ID)
+		return err
+	})
+	return tokens, err
+}
+
+// SessionActive reports whether a session exists and has not been revoked or expired
+func SessionActive(sessionID string) bool {
+	var count int64
+	DB.Model(&UserSession{}).
+		Where("id = ? AND revoked_at = '' AND expires_at > ?", sessionID, time.Now().Format(timeLayout)).
+		Count(&count)
+	return count > 0
+}
+
+// sessionAccount is what AuthMiddleware checks about a token on every request
+type sessionAccount struct {
+	UserID             string
+	TenantID           string
+	Role               Role
+	Status             string
+	MustChangePassword bool
+	PasswordFree       bool // the session began with a login card or picture password
+	TenantStatus       string
+}
+
+// loadSessionAccount reads an active session of the user together with the
+// user and its school in one query. found is false when the session has
+// ended; UserID and TenantStatus are empty when the user or school is gone.
+func loadSessionAccount(sessionID, userID string) (acc sessionAccount, found bool) {
+	err := DB.Table("user_sessions").
+		Select("COALESCE(users.id, '') AS user_id, COALESCE(users.tenant_id, '') AS tenant_id, "+
+			"COALESCE(users.role, '') AS role, COALESCE(users.status, '') AS status, "+
+			"COALESCE(users.must_change_password, ?) AS must_change_password, "+
+			"COALESCE(user_sessions.password_free, ?) AS password_free, "+
+			"COALESCE(tenants.status, '') AS tenant_status", false, false).
+		Joins("LEFT JOIN users ON users.id = user_sessions.user_id").
+		Joins("LEFT JOIN tenants ON tenants.id = users.tenant_id").
+		Where("user_sessions.id = ? AND user_sessions.user_id = ? AND user_sessions.revoked_at = '' AND user_sessions.expires_at > ?",
+			sessionID, userID, time.Now().Format(timeLayout)).
+		Take(&acc).Error
+	return acc, err == nil
+}
+
+// RevokeSession ends one session
+func RevokeSession(tx *gorm.DB, sessionID string) error {
+	return tx.Model(&UserSession{}).
+		Where("id = ? AND revoked_at = ''", sessionID).
+		Update("revoked_at", time.Now().Format(timeLayout)).Error
+}
+
+// RevokeUserSessions ends every session of a user, e.g. when the account is
+// disabled, deleted or its password is reset
+func RevokeUserSessions(userID string) error {
+	return DB.Model(&UserSession{}).
+		Where("user_id = ? AND revoked_at = ''", userID).
+		Update("revoked_at", time.Now().Format(timeLayout)).Error
+}
+
+// RefreshTokenHandler swaps a refresh token for a new access and refresh token.
+// A refresh token that was already used means it leaked, so the whole session
+// is revoked.
+func RefreshTokenHandler(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refreshToken" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendJSON(c, 1, "Invalid request", nil)
+		return
+	}
+
+	var tokens gin.H
+	var reused bool
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var rt RefreshToken
+		if err := tx.First(&rt, "hash = ?", hashToken(req.RefreshToken)).Error; err != nil {
+			return errInvalidRefreshToken
+		}
+		if rt.UsedAt != "" {
+			reused = true
+			return RevokeSession(tx, rt.SessionID)
+		}
+
+		now := time.Now().Format(timeLayout)
+		var session UserSession
+		if err := tx.First(&session, "id = ?", rt.SessionID).Error; err != nil {
+			return errInvalidRefreshToken
+		}
+		if session.RevokedAt != "" || session.ExpiresAt <= now {
+			return errInvalidRefreshToken
+		}
+		var user User
+		if err := tx.First(&user, "id = ?", session.UserID).Error; err != nil || userDisabled(user) {
+			return errInvalidRefreshToken
+		}
+		if _, err := LoadTenant(user.TenantID); err != nil {
+			return err
+		}
+
+		// Only one concurrent refresh may consume the token
+		res := tx.Model(&RefreshToken{}).Where("hash = ? AND used_at = ''", rt.Hash).Update("used_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errInvalidRefreshToken
+		}
+		session.LastUsedAt = now
+		session.ExpiresAt = time.Now().Add(refreshTokenDuration).Format(timeLayout)
+		if err := tx.Save(&session).Error; err != nil {
+			return err
+		}
+
+		var err error
+		tokens, err = tokenPair(tx, user, session.ID)
+		return err
+	})
+	if reused {
+		err = errInvalidRefreshToken
+	}
+	if err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
+	SendJSON(c, 0, "", tokens)
+}
+
+// LogoutHandler ends the session a refresh token belongs to. It is public so a
+// client whose access token already expired can still log out.
+func LogoutHandler(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refreshToken" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendJSON(c, 1, "Invalid request", nil)
+		return
+	}
+
+	var rt RefreshToken
+	if err := DB.First(&rt, "hash = ?", hashToken(req.RefreshToken)).Error; err == nil {
+		RevokeSession(DB, rt.SessionID)
+	}
+	// Logging out with an unknown token is not an error, the client is logged out either way
+	SendJSON(c, 0, "", gin.H{"message": "Logged out"})
+}
+
+// LogoutAllHandler ends every session of the current user, on all devices
+func LogoutAllHandler(c *gin.Context) {
+	userId, _ := c.Get("userId")
+	if err := RevokeUserSessions(fmt.Sprintf("%v", userId)); err != nil {
+		SendJSON(c, 1, "Failed to log out", nil)
+		return
+	}
+	AddAuditLog(c, "LOGOUT_ALL", "Logged out of all devices")
+	SendJSON(c, 0, "", gin.H{"message": "Logged out of all devices"})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+func TestRefreshTokensAndRevocation(t *testing.T) {
+	DB.Exec("DELETE FROM users")
+	DB.Exec("DELETE FROM user_sessions")
+	DB.Exec("DELETE FROM refresh_tokens")
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	DB.Create(&User{ID: "u1", Username: "alice", Password: string(hashed), Role: RoleTeacher, Status: "active"})
+	DB.Create(&User{ID: "u2", Username: "locked", Password: string(hashed), Role: RoleTeacher, Status: "inactive"})
+
+	r := gin.Default()
+	r.POST("/auth/login", LoginHandler)
+	r.POST("/auth/refresh", RefreshTokenHandler)
+	r.POST("/auth/logout", LogoutHandler)
+	protected := r.Group("/", AuthMiddleware())
+	protected.GET("/me", GetMe)
+	protected.POST("/auth/logout-all", LogoutAllHandler)
+
+	call := func(method, url, token string, payload any) (int, int, map[string]any) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp struct {
+			Code int            `json:"code"`
+			Data map[string]any `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.Code, resp.Data
+	}
+	login := func() (string, string) {
+		_, code, data := call("POST", "/auth/login", "", map[string]string{"username": "alice", "password": "pw"})
+		assert.Equal(t, 0, code)
+		access, _ := data["token"].(string)
+		refresh, _ := data["refreshToken"].(string)
+		return access, refresh
+	}
+	me := func(token string) int {
+		status, _, _ := call("GET", "/me", token, nil)
+		return status
+	}
+
+	// Disabled accounts cannot log in
+	_, code, _ := call("POST", "/auth/login", "", map[string]string{"username": "locked", "password": "pw"})
+	assert.Equal(t, 1, code)
+
+	access, refresh := login()
+	assert.NotEmpty(t, refresh)
+	assert.Equal(t, http.StatusOK, me(access))
+
+	t.Run("Refresh rotates the token", func(t *testing.T) {
+		_, code, data := call("POST", "/auth/refresh", "", map[string]string{"refreshToken": refresh})
+		assert.Equal(t, 0, code)
+		assert.NotEqual(t, refresh, data["refreshToken"])
+		assert.Equal(t, http.StatusOK, me(data["token"].(string)))
+
+		// Reusing the old refresh token revokes the whole session
+		_, code, _ = call("POST", "/auth/refresh", "", map[string]string{"refreshToken": refresh})
+		assert.Equal(t, 1, code)
+		assert.Equal(t, http.StatusUnauthorized, me(data["token"].(string)))
+		_, code, _ = call("POST", "/auth/refresh", "", map[string]string{"refreshToken": data["refreshToken"].(string)})
+		assert.Equal(t, 1, code)
+	})
+
+	t.Run("Logout ends one session", func(t *testing.T) {
+		a1, r1 := login()
+		a2, _ := login()
+		_, code, _ := call("POST", "/auth/logout", "", map[string]string{"refreshToken": r1})
+		assert.Equal(t, 0, code)
+		assert.Equal(t, http.StatusUnauthorized, me(a1))
+		assert.Equal(t, http.StatusOK, me(a2))
+	})
+
+	t.Run("Logout all ends every session", func(t *testing.T) {
+		a1, _ := login()
+		a2, r2 := login()
+		status, code, _ := call("POST", "/auth/logout-all", a1, nil)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, 0, code)
+		assert.Equal(t, http.StatusUnauthorized, me(a1))
+		assert.Equal(t, http.StatusUnauthorized, me(a2))
+		_, code, _ = call("POST", "/auth/refresh", "", map[string]string{"refreshToken": r2})
+		assert.Equal(t, 1, code)
+	})
+
+	t.Run("Disabled and deleted users are rejected", func(t *testing.T) {
+		admin := gin.Default()
+		admin.Use(func(c *gin.Context) {
+			c.Set("userId", "1")
+			c.Set("role", RoleAdmin)
+		})
+		admin.PUT("/admin/users/:id", UpdateUser)
+		admin.DELETE("/admin/users/:id", DeleteUser)
+
+		a1, _ := login()
+		// Locking the account in the database alone is enough for the middleware
+		DB.Model(&User{}).Where("id = ?", "u1").Update("status", "inactive")
+		assert.Equal(t, http.StatusUnauthorized, me(a1))
+		DB.Model(&User{}).Where("id = ?", "u1").Update("status", "active")
+		assert.Equal(t, http.StatusOK, me(a1))
+
+		// Disabling through the admin API also revokes the sessions
+		body, _ := json.Marshal(map[string]any{"username": "alice", "role": RoleTeacher, "status": "inactive"})
+		req, _ := http.NewRequest("PUT", "/admin/users/u1", bytes.NewBuffer(body))
+		admin.ServeHTTP(httptest.NewRecorder(), req)
+		DB.Model(&User{}).Where("id = ?", "u1").Update("status", "active")
+		assert.Equal(t, http.StatusUnauthorized, me(a1))
+
+		a2, _ := login()
+		req, _ = http.NewRequest("DELETE", "/admin/users/u1", nil)
+		admin.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, http.StatusUnauthorized, me(a2))
+	})
+
+	t.Run("Tokens without a session are rejected", func(t *testing.T) {
+		forged, _ := issueAccessToken(User{ID: "u2", Role: RoleTeacher}, "no-such-session")
+		assert.Equal(t, http.StatusUnauthorized, me(forged))
+	})
+}
+
+func TestAuthMiddlewareReadsSessionInOneQuery(t *testing.T) {
+	DB.Exec("DELETE FROM users")
+	DB.Exec("DELETE FROM user_sessions")
+	student := User{ID: "s1", Username: "kid", Role: RoleStudent, Status: "active", MustChangePassword: true}
+	DB.Create(&student)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest("POST", "/auth/card-login", nil)
+	tokens, err := StartUserSession(ctx, student, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	r := gin.New()
+	r.GET("/ping", AuthMiddleware(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"role": c.GetString("role"), "passwordFree": c.GetBool("passwordFree")})
+	})
+	queries := 0
+	DB.Callback().Query().Before("gorm:query").Register("test:count_queries", func(*gorm.DB) { queries++ })
+	DB.Callback().Row().Before("gorm:row").Register("test:count_rows", func(*gorm.DB) { queries++ })
+	defer DB.Callback().Query().Remove("test:count_queries")
+	defer DB.Callback().Row().Remove("test:count_rows")
+
+	req, _ := http.NewRequest("GET", "/ping", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens["token"].(string))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"role":"STUDENT","passwordFree":true}`, w.Body.String())
+	assert.Equal(t, 1, queries)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// TeacherClasses returns the classes a teacher is assigned to
+func TeacherClasses(db *gorm.DB, teacherID string) []Class {
+	classes := make([]Class, 0)
+	db.Where("teacher_ids LIKE ?", "%\""+teacherID+"\"%").Find(&classes)
+	return classes
+}
+
+// ClassStudentIDs returns the distinct students enrolled in any class of the teacher
+func ClassStudentIDs(db *gorm.DB, teacherID string) []string {
+	seen := make(map[string]bool)
+	ids := make([]string, 0)
+	for _, cls := range TeacherClasses(db, teacherID) {
+		for _, sid := range cls.StudentIDs {
+			if !seen[sid] {
+				seen[sid] = true
+				ids = append(ids, sid)
+			}
+		}
+	}
+	return ids
+}
+
+// filterUserIDs keeps the IDs that belong to existing users of the given role, without duplicates
+func filterUserIDs(db *gorm.DB, ids []string, role Role) []string {
+	res := make([]string, 0)
+	if len(ids) == 0 {
+		return res
+	}
+	var existing []string
+	db.Model(&User{}).Where("id IN ? AND role = ?", ids, role).Pluck("id", &existing)
+	known := make(map[string]bool)
+	for _, id := range existing {
+		known[id] = true
+	}
+	for _, id := range ids {
+		if known[id] {
+			res = append(res, id)
+			delete(known, id)
+		}
+	}
+	return res
+}
+
+// requireEnrollable responds with an error unless the requester may add the
+// students to a class. Admins may add anyone; teachers only students in no
+// class yet or already in one of theirs, as a class widens whom they can see.
+func requireEnrollable(c *gin.Context, ids []string) bool {
+	userId, _ := c.Get("userId")
+	role, _ := c.Get("role")
+	if fmt.Sprintf("%v", role) == string(RoleAdmin) {
+		return true
+	}
+
+	own := make(map[string]bool)
+	for _, sid := range ClassStudentIDs(TenantDB(c), fmt.Sprintf("%v", userId)) {
+		own[sid] = true
+	}
+	var classes []Class
+	TenantDB(c).Select("student_ids").Find(&classes)
+	enrolled := make(map[string]bool)
+	for _, cls := range classes {
+		for _, sid := range cls.StudentIDs {
+			enrolled[sid] = true
+		}
+	}
+	for _, sid := range ids {
+		if enrolled[sid] && !own[sid] {
+			SendJSON(c, 1, "Student is in another teacher's class, ask an admin to enroll: "+sid, nil)
+			return false
+		}
+	}
+	return true
+}
+
+// loadManagedClass fetches a class the requester may modify: admins any class,
+// teachers only classes they teach
+func loadManagedClass(c *gin.Context, id string) (Class, bool) {
+	userId, _ := c.Get("userId")
+	role, _ := c.Get("role")
+
+	var cls Class
+	if err := TenantDB(c).First(&cls, "id = ?", id).Error; err != nil {
+		SendJSON(c, 1, "Class not found", nil)
+		return cls, false
+	}
+	if canManageClass(fmt.Sprintf("%v", userId), fmt.Sprintf("%v", role), cls) {
+		return cls, true
+	}
+	SendJSON(c, 1, "Permission denied for class", nil)
+	return cls, false
+}
+
+// canManageClass reports whether a user may modify the class
+func canManageClass(userID, role string, cls Class) bool {
+	if role == string(RoleAdmin) {
+		return true
+	}
+	if role == string(RoleTeacher) {
+		for _, tid := range cls.TeacherIDs {
+			if tid == userID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Class Handlers
+func GetClasses(c *gin.Context) {
+	userId, _ := c.Get("userId")
+	role, _ := c.Get("role")
+
+	classes := make([]Class, 0)
+	switch fmt.Sprintf("%v", role) {
+	case string(RoleAdmin):
+		TenantDB(c).Order("name").Find(&classes)
+	case string(RoleTeacher):
+		classes = TeacherClasses(TenantDB(c), fmt.Sprintf("%v", userId))
+	default:
+		TenantDB(c).Where("student_ids LIKE ?", "%\""+fmt.Sprintf("%v", userId)+"\"%").Find(&classes)
+	}
+	SendJSON(c, 0, "", classes)
+}
+
+func CreateClass(c *gin.Context) {
+	userId, _ := c.Get("userId")
+	role, _ := c.Get("role")
+	if fmt.Sprintf("%v", role) == string(RoleStudent) {
+		SendJSON(c, 1, "Permission denied", nil)
+		return
+	}
+
+	var cls Class
+	if err := c.ShouldBindJSON(&cls); err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
+	if cls.Name == "" {
+		SendJSON(c, 1, "Class name is required", nil)
+		return
+	}
+
+	// A teacher creating a class always teaches it
+	if fmt.Sprintf("%v", role) == string(RoleTeacher) {
+		cls.TeacherIDs = append(cls.TeacherIDs, fmt.Sprintf("%v", userId))
+	}
+	cls.TeacherIDs = filterUserIDs(TenantDB(c), cls.TeacherIDs, RoleTeacher)
+	cls.StudentIDs = filterUserIDs(TenantDB(c), cls.StudentIDs, RoleStudent)
+	if !requireEnrollable(c, cls.StudentIDs) {
+		return
+	}
+	cls.ID = strconv.FormatInt(time.Now().UnixNano(), 36)
+	cls.CreatedAt = time.Now().Format("2006-01-02 15:04:05")
+
+	if err := TenantDB(c).Create(&cls).Error; err != nil {
+		SendJSON(c, 1, "Failed to create class", nil)
+		return
+	}
+	AddAuditLog(c, "CREATE_CLASS", fmt.Sprintf("Created class: %s", cls.Name))
+	SendJSON(c, 0, "", cls)
+}
+
+func UpdateClass(c *gin.Context) {
+	cls, ok := loadManagedClass(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	// Fields left out of the request keep their current values
+	updateData := cls
+	updateData.TeacherIDs, updateData.StudentIDs = nil, nil
+	if err := c.ShouldBindJSON(&updateData); err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
+
+	if updateData.Name != "" {
+		cls.Name = updateData.Name
+	}
+	cls.Grade = updateData.Grade
+	cls.Subject = updateData.Subject
+	if updateData.TeacherIDs != nil {
+		teachers := filterUserIDs(TenantDB(c), updateData.TeacherIDs, RoleTeacher)
+		if len(teachers) == 0 {
+			SendJSON(c, 1, "A class needs at least one teacher", nil)
+			return
+		}
+		cls.TeacherIDs = teachers
+	}
+	if updateData.StudentIDs != nil {
+		students := filterUserIDs(TenantDB(c), updateData.StudentIDs, RoleStudent)
+		if !requireEnrollable(c, students) {
+			return
+		}
+		cls.StudentIDs = students
+	}
+
+	if err := TenantDB(c).Save(&cls).Error; err != nil {
+		SendJSON(c, 1, "Failed to update class", nil)
+		return
+	}
+	AddAuditLog(c, "UPDATE_CLASS", fmt.Sprintf("Updated class: %s", cls.Name))
+	SendJSON(c, 0, "", cls)
+}
+
+func DeleteClass(c *gin.Context) {
+	cls, ok := loadManagedClass(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	if err := TenantDB(c).Delete(&Class{}, "id = ?", cls.ID).Error; err != nil {
+		SendJSON(c, 1, "Failed to delete class", nil)
+		return
+	}
+	AddAuditLog(c, "DELETE_CLASS", fmt.Sprintf("Deleted class: %s", cls.Name))
+	SendJSON(c, 0, "", gin.H{"message": "Deleted"})
+}
+
+// EnrollClassStudents adds students to a class
+func EnrollClassStudents(c *gin.Context) {
+	cls, ok := loadManagedClass(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	var req struct {
+		StudentIDs []string `json:"studentIds" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
+
+	if !requireEnrollable(c, req.StudentIDs) {
+		return
+	}
+	cls.StudentIDs = filterUserIDs(TenantDB(c), append(cls.StudentIDs, req.StudentIDs...), RoleStudent)
+	if err := TenantDB(c).Save(&cls).Error; err != nil {
+		SendJSON(c, 1, "Failed to enroll students", nil)
+		return
+	}
+	AddAuditLog(c, "ENROLL_CLASS", fmt.Sprintf("Enrolled %d students in class: %s", len(req.StudentIDs), cls.Name))
+	SendJSON(c, 0, "", cls)
+}
+
+// UnenrollClassStudent removes one student from a class
+func UnenrollClassStudent(c *gin.Context) {
+	cls, ok := loadManagedClass(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	studentId := c.Param("studentId")
+	remaining := make([]string, 0, len(cls.StudentIDs))
+	for _, sid := range cls.StudentIDs {
+		if sid != studentId {
+			remaining = append(remaining, sid)
+		}
+	}
+	cls.StudentIDs = remaining
+
+	if err := TenantDB(c).Save(&cls).Error; err != nil {
+		SendJSON(c, 1, "Failed to remove student", nil)
+		return
+	}
+	AddAuditLog(c, "UNENROLL_CLASS", fmt.Sprintf("Removed student %s from class: %s", studentId, cls.Name))
+	SendJSON(c, 0, "", cls)
+}
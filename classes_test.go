@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassEnrollmentAndScoping(t *testing.T) {
+	DB.Exec("DELETE FROM users")
+	DB.Exec("DELETE FROM classes")
+	DB.Exec("DELETE FROM homeworks")
+	DB.Create(&User{ID: "t1", Username: "teacher1", Role: RoleTeacher})
+	DB.Create(&User{ID: "t2", Username: "teacher2", Role: RoleTeacher})
+	DB.Create(&User{ID: "c1", Username: "child1", Role: RoleStudent})
+	DB.Create(&User{ID: "c2", Username: "child2", Role: RoleStudent})
+	DB.Create(&User{ID: "c3", Username: "child3", Role: RoleStudent})
+
+	as := func(userId string, role Role) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Set("userId", userId)
+			c.Set("role", role)
+		}
+	}
+	teacher1 := gin.Default()
+	teacher1.Use(as("t1", RoleTeacher))
+	teacher1.POST("/classes", CreateClass)
+	teacher1.PUT("/classes/:id", UpdateClass)
+	teacher1.POST("/classes/:id/students", EnrollClassStudents)
+	teacher1.POST("/homeworks/assign", AssignHomework)
+	teacher1.GET("/students", GetStudents)
+
+	teacher2 := gin.Default()
+	teacher2.Use(as("t2", RoleTeacher))
+	teacher2.POST("/classes", CreateClass)
+	teacher2.POST("/classes/:id/students", EnrollClassStudents)
+	teacher2.POST("/homeworks/assign", AssignHomework)
+	teacher2.GET("/students", GetStudents)
+
+	call := func(r *gin.Engine, method, url string, payload any, out any) int {
+		var body []byte
+		if payload != nil {
+			body, _ = json.Marshal(payload)
+		}
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		resp := struct {
+			Code int `json:"code"`
+			Data any `json:"data"`
+		}{Data: out}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Code
+	}
+
+	// Unknown and non-student IDs are dropped, the creator becomes a teacher of the class
+	var cls Class
+	code := call(teacher1, "POST", "/classes", map[string]any{"name": "1A", "grade": 1, "studentIds": []string{"c1", "t2", "nobody"}}, &cls)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, []string{"t1"}, cls.TeacherIDs)
+	assert.Equal(t, []string{"c1"}, cls.StudentIDs)
+
+	code = call(teacher1, "POST", "/classes/"+cls.ID+"/students", map[string]any{"studentIds": []string{"c2", "c1"}}, &cls)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, []string{"c1", "c2"}, cls.StudentIDs)
+
+	// Other teachers cannot enroll into a class they do not teach
+	assert.Equal(t, 1, call(teacher2, "POST", "/classes/"+cls.ID+"/students", map[string]any{"studentIds": []string{"c3"}}, nil))
+
+	// Nor take students from it into their own class
+	var other Class
+	assert.Equal(t, 0, call(teacher2, "POST", "/classes", map[string]any{"name": "2B", "studentIds": []string{"c3"}}, &other))
+	assert.Equal(t, 1, call(teacher2, "POST", "/classes/"+other.ID+"/students", map[string]any{"studentIds": []string{"c1"}}, nil))
+	assert.Equal(t, 1, call(teacher2, "POST", "/classes", map[string]any{"name": "2C", "studentIds": []string{"c2"}}, nil))
+
+	// Updates only change the fields sent
+	var updated Class
+	assert.Equal(t, 0, call(teacher1, "PUT", "/classes/"+cls.ID, map[string]any{"subject": "Math"}, &updated))
+	assert.Equal(t, "1A", updated.Name)
+	assert.Equal(t, 1, updated.Grade)
+	assert.Equal(t, "Math", updated.Subject)
+	assert.Equal(t, []string{"c1", "c2"}, updated.StudentIDs)
+	assert.Equal(t, 1, call(teacher1, "PUT", "/classes/"+cls.ID, map[string]any{"studentIds": []string{"c1", "c3"}}, nil))
+
+	DB.Exec("DELETE FROM papers")
+	DB.Create(&Paper{ID: "p1", Name: "Paper"})
+	var hw Homework
+	call(teacher1, "POST", "/homeworks/assign", map[string]any{"name": "HW", "paperId": "p1", "classId": cls.ID}, &hw)
+	assert.ElementsMatch(t, []string{"c1", "c2"}, hw.StudentIDs)
+	assert.Equal(t, 2, hw.Total)
+
+	// Homework only goes to students of the teacher's classes
+	assert.Equal(t, 1, call(teacher1, "POST", "/homeworks/assign", map[string]any{"name": "HW", "paperId": "p1", "studentIds": []string{"c1", "c3"}}, nil))
+	assert.Equal(t, 1, call(teacher2, "POST", "/homeworks/assign", map[string]any{"name": "HW", "paperId": "p1", "classId": cls.ID}, nil))
+
+	// Students share the module's write access for practice, but can't assign homework
+	student := gin.Default()
+	student.Use(as("c1", RoleStudent))
+	student.POST("/homeworks/assign", AssignHomework)
+	assert.Equal(t, 1, call(student, "POST", "/homeworks/assign", map[string]any{"name": "HW", "paperId": "p1"}, nil))
+
+	var students []User
+	call(teacher1, "GET", "/students", nil, &students)
+	assert.Equal(t, 2, len(students))
+
+	students = nil
+	call(teacher2, "GET", "/students", nil, &students)
+	assert.Equal(t, 1, len(students))
+}
@@ -5,118 +5,131 @@ import (
 	"os"
 
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/schema"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var DB *gorm.DB
 
-func InitDB() error {
+// DialectorFromEnv picks the database driver from DB_DRIVER: "mysql" (default),
+// "postgres" or "sqlite". SQLite only needs DB_PATH, which makes a
+// single-binary deployment possible for small schools.
+func DialectorFromEnv() (gorm.Dialector, error) {
 	user := os.Getenv("DB_USER")
 	pass := os.Getenv("DB_PASSWORD")
 	host := os.Getenv("DB_HOST")
-	port := os.Getenv("DB_PORT")
 	name := os.Getenv("DB_NAME")
 
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		user, pass, host, port, name)
+	switch driver := getEnv("DB_DRIVER", "mysql"); driver {
+	case "mysql":
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+			user, pass, host, os.Getenv("DB_PORT"), name)
+		return mysql.Open(dsn), nil
+	case "postgres":
+		dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+			host, getEnv("DB_PORT", "5432"), user, pass, name, getEnv("DB_SSLMODE", "disable"))
+		return postgres.Open(dsn), nil
+	case "sqlite":
+		// WAL and a busy timeout let the background goroutines write while requests read
+		dsn := getEnv("DB_PATH", "smartedu.db") + "?_busy_timeout=5000&_journal_mode=WAL"
+		return sqlite.Open(dsn), nil
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q", driver)
+	}
+}
+
+// OpenDB connects to the database selected by DB_DRIVER without touching the
+// schema, with queries made through TenantDB scoped to their tenant
+func OpenDB() (*gorm.DB, error) {
+	dialector, err := DialectorFromEnv()
+	if err != nil {
+		return nil, err
+	}
 
 	// Standard connection with basic config
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+	db, err := gorm.Open(dialector, &gorm.Config{
 		NamingStrategy: schema.NamingStrategy{
 			SingularTable: false,
 		},
 	})
-
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return db, RegisterTenantScoping(db)
+}
 
-	DB = db
-
-	// Auto Migration
-	err = DB.AutoMigrate(
-		&User{},
-		&Question{},
-		&Paper{},
-		&Homework{},
-		&History{},
-		&Reinforcement{},
-		&Resource{},
-		&AuditLog{},
-		&StudentWrongQuestion{},
-		&SystemConfig{},
-		&RolePermission{},
-	)
+// InitDB connects and refuses to continue unless the schema is at the version
+// this binary expects. The schema itself is managed by `migrate up`.
+func InitDB() error {
+	db, err := OpenDB()
 	if err != nil {
 		return err
 	}
+	DB = db
 
-	// Seed initial users
-	var count int64
-	DB.Model(&User{}).Count(&count)
-	if count == 0 {
-		hashed, _ := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.DefaultCost)
-		initialUsers := []User{
-			{ID: "1", Username: "admin", Password: string(hashed), Role: RoleAdmin, Status: "active", Name: "超级管理员"},
-		}
-		DB.Create(&initialUsers)
-	}
+	return CheckSchemaVersion(DB)
+}
+
+// allModules are every permission module a rule can name; "children" only
+// belongs to parents, see defaultParentPermissions
+var allModules = []string{"dashboard", "wrong_book", "students", "questions", "papers", "assignments", "reinforcements", "resources", "users", "homework_audit", "audit_logs", "stats", "help_docs", "permissions", "system_config", "own_stats", "children"}
 
-	// Seed default permissions
-	var permCount int64
-	DB.Model(&RolePermission{}).Count(&permCount)
-	if permCount == 0 {
-		allModules := []string{"dashboard", "students", "questions", "papers", "assignments", "reinforcements", "resources", "users", "homework_audit", "audit_logs", "stats", "help_docs", "permissions", "system_config"}
-		
-		var defaultPerms []RolePermission
-		
-		// Admin: Full access
-		for _, m := range allModules {
-			defaultPerms = append(defaultPerms, RolePermission{Role: RoleAdmin, ModuleID: m, UIAccess: true, APIAccess: true})
+// defaultRolePermissions are the permissions a new school starts with
+func defaultRolePermissions() []RolePermission {
+	var defaultPerms []RolePermission
+	
+	// Admin: Full access
+	for _, m := range allModules {
+		if m == "children" {
+			continue
 		}
-		
-		// Teacher
-		teacherModules := map[string]bool{"dashboard":true, "students":true, "questions":true, "papers":true, "assignments":true, "reinforcements":true, "resources":true, "stats":true, "help_docs":true}
-		for _, m := range allModules {
-			if teacherModules[m] {
-				api := true
-				if m == "students" || m == "stats" { api = false }
-				defaultPerms = append(defaultPerms, RolePermission{Role: RoleTeacher, ModuleID: m, UIAccess: true, APIAccess: api})
+		defaultPerms = append(defaultPerms, apiPermission(RoleAdmin, m, true, true))
+	}
+	
+	// Teacher
+	teacherModules := map[string]bool{"dashboard":true, "wrong_book":true, "students":true, "questions":true, "papers":true, "assignments":true, "reinforcements":true, "resources":true, "stats":true, "own_stats":true, "help_docs":true}
+	for _, m := range allModules {
+		if teacherModules[m] {
+			rp := apiPermission(RoleTeacher, m, true, m != "stats" && m != "own_stats")
+			if m == "students" {
+				// Students are managed, not deleted, by their teachers
+				rp.CanDelete = false
 			}
-		}
-		
-		// Student
-		studentModules := map[string]bool{"dashboard":true, "assignments":true, "stats":true, "help_docs":true}
-		for _, m := range allModules {
-			if studentModules[m] {
-				api := false
-				if m == "assignments" { api = true }
-				defaultPerms = append(defaultPerms, RolePermission{Role: RoleStudent, ModuleID: m, UIAccess: true, APIAccess: api})
+			if m == "own_stats" {
+				// Their own statistics are only ever read
+				rp.CanRead = true
 			}
+			defaultPerms = append(defaultPerms, rp)
 		}
-		
-		DB.Create(&defaultPerms)
 	}
-
-	// Seed default config
-	var configCount int64
-	DB.Model(&SystemConfig{}).Where("`key` = ?", "error_logic").Count(&configCount)
-	if configCount == 0 {
-		defaultConfig := `{
-			"globalEnabled": true,
-			"excludeMistakesFromPractice": false,
-			"stages": {
-				"1": {"nextWrong": 2, "nextCorrect": 4, "showAnswer": false, "label": "出错"},
-				"2": {"nextWrong": 3, "nextCorrect": 4, "showAnswer": true, "label": "重试 (有答案)"},
-				"3": {"nextWrong": 5, "nextCorrect": 4, "showAnswer": false, "label": "重试 (无答案)"},
-				"4": {"nextWrong": 1, "nextCorrect": 4, "showAnswer": false, "label": "已知"},
-				"5": {"nextWrong": 5, "nextCorrect": 5, "showAnswer": false, "label": "困难"}
+	
+	// Student
+	studentModules := map[string]bool{"dashboard":true, "wrong_book":true, "assignments":true, "stats":true, "own_stats":true, "help_docs":true}
+	for _, m := range allModules {
+		if studentModules[m] {
+			api := false
+			if m == "assignments" { api = true }
+			rp := apiPermission(RoleStudent, m, true, api)
+			if m == "own_stats" {
+				rp.CanRead = true
 			}
-		}`
-		DB.Create(&SystemConfig{Key: "error_logic", Value: defaultConfig})
+			defaultPerms = append(defaultPerms, rp)
+		}
 	}
-
-	return nil
+	return defaultPerms
 }
+
+// defaultErrorLogicJSON is the "error_logic" config a new school starts with
+const defaultErrorLogicJSON = `{
+	"globalEnabled": true,
+	"excludeMistakesFromPractice": false,
+	"stages": {
+		"1": {"nextWrong": 2, "nextCorrect": 4, "showAnswer": false, "label": "出错"},
+		"2": {"nextWrong": 3, "nextCorrect": 4, "showAnswer": true, "label": "重试 (有答案)"},
+		"3": {"nextWrong": 5, "nextCorrect": 4, "showAnswer": false, "label": "重试 (无答案)"},
+		"4": {"nextWrong": 1, "nextCorrect": 4, "showAnswer": false, "label": "已知"},
+		"5": {"nextWrong": 5, "nextCorrect": 5, "showAnswer": false, "label": "困难"}
+	}
+}`
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialectorFromEnv(t *testing.T) {
+	tests := []struct {
+		driver  string
+		want    string
+		wantErr bool
+	}{
+		{"", "mysql", false},
+		{"mysql", "mysql", false},
+		{"postgres", "postgres", false},
+		{"sqlite", "sqlite", false},
+		{"oracle", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.driver, func(t *testing.T) {
+			if tt.driver != "" {
+				t.Setenv("DB_DRIVER", tt.driver)
+			}
+			dialector, err := DialectorFromEnv()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, dialector.Name())
+		})
+	}
+}
+
+func TestSQLiteFileDatabase(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "smartedu.db"))
+
+	prev := DB
+	defer func() { DB = prev }()
+
+	// A fresh database is refused until it is migrated
+	assert.ErrorContains(t, InitDB(), "migrate up")
+	assert.NoError(t, MigrateUp(DB, 0))
+	assert.NoError(t, InitDB())
+
+	// Seeding ran, including the config row looked up by its reserved-word column
+	var conf SystemConfig
+	assert.NoError(t, DB.Where(&SystemConfig{Key: "error_logic"}).First(&conf).Error)
+	var admin User
+	assert.NoError(t, DB.First(&admin, "username = ?", "admin").Error)
+}
+
+// TestDialectNeutralQueries covers the handlers that used MySQL-only SQL
+func TestDialectNeutralQueries(t *testing.T) {
+	DB.Exec("DELETE FROM resources")
+	DB.Exec("DELETE FROM histories")
+	DB.Exec("DELETE FROM homeworks")
+	DB.Exec("DELETE FROM system_configs")
+	ForgetCachedSettings("")
+
+	as := func(userId string, role Role) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Set("userId", userId)
+			c.Set("role", role)
+		}
+	}
+	get := func(r *gin.Engine, url string, out any) {
+		req, _ := http.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		resp := struct {
+			Data any `json:"data"`
+		}{Data: out}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+	}
+
+	t.Run("Resource search matches names and whole tags", func(t *testing.T) {
+		DB.Create(&Resource{ID: "r1", Name: "Apple", Tags: []string{"Fruit", "Red"}, Visibility: "public"})
+		DB.Create(&Resource{ID: "r2", Name: "Car", Tags: []string{"Vehicles"}, Visibility: "public"})
+		DB.Create(&Resource{ID: "r3", Name: "Carrot", Tags: []string{"Vegetable"}, Visibility: "public"})
+
+		r := gin.Default()
+		r.Use(as("t1", RoleTeacher))
+		r.GET("/resources", GetResources)
+
+		tests := []struct {
+			keyword string
+			want    []string
+		}{
+			{"fruit", []string{"r1"}},
+			{"car", []string{"r2", "r3"}},
+			{"veg", []string{}},
+		}
+		for _, tt := range tests {
+			var page struct {
+				List []Resource `json:"list"`
+			}
+			get(r, "/resources?keyword="+tt.keyword, &page)
+			ids := make([]string, 0)
+			for _, res := range page.List {
+				ids = append(ids, res.ID)
+			}
+			assert.ElementsMatch(t, tt.want, ids, tt.keyword)
+		}
+	})
+
+	t.Run("Accuracy sums totals", func(t *testing.T) {
+		DB.Create(&Homework{ID: "hw-sum", TeacherID: "t-sum"})
+		DB.Create(&History{ID: "h-sum1", StudentID: "s1", HomeworkID: "hw-sum", CorrectCount: 4, Total: 5})
+		DB.Create(&History{ID: "h-sum2", StudentID: "s2", HomeworkID: "hw-sum", CorrectCount: 2, Total: 3})
+
+		r := gin.Default()
+		r.Use(as("t-sum", RoleTeacher))
+		r.GET("/teacher/stats", GetTeacherStats)
+
+		var stats struct {
+			AccuracyRate float64 `json:"accuracyRate"`
+		}
+		get(r, "/teacher/stats", &stats)
+		assert.InDelta(t, 0.75, stats.AccuracyRate, 0.001)
+	})
+
+	t.Run("System config is looked up by key", func(t *testing.T) {
+		r := gin.Default()
+		r.Use(as("1", RoleAdmin))
+		r.GET("/config", GetSystemConfig)
+
+		conf := defaultErrorLogicConfig()
+		conf.GlobalEnabled = false
+		assert.NoError(t, DB.Create(&SystemConfig{Key: "error_logic", Value: mustJSON(conf)}).Error)
+		ForgetCachedSettings("")
+
+		var got ErrorLogicConfig
+		get(r, "/config", &got)
+		assert.False(t, got.GlobalEnabled)
+
+		var count int64
+		DB.Model(&SystemConfig{}).Where(&SystemConfig{Key: "error_logic"}).Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+}
+
+func mustJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Question types used by the frontend (see web/types.ts QuestionType)
+const (
+	QuestionTypeMultipleChoice = "MULTIPLE_CHOICE"
+	QuestionTypeMultipleSelect = "MULTIPLE_SELECT"
+	QuestionTypeTrueFalse      = "TRUE_FALSE"
+	QuestionTypeFillBlank      = "FILL_BLANK"
+	QuestionTypeCalculation    = "CALCULATION"
+)
+
+// Accepted spellings for TRUE_FALSE answers. The UI submits "正确"/"错误",
+// imported spreadsheets sometimes carry the other forms.
+var trueFalseValues = map[string]string{
+	"正确": "true", "对": "true", "√": "true", "true": "true", "t": "true", "yes": "true",
+	"错误": "false", "错": "false", "×": "false", "false": "false", "f": "false", "no": "false",
+}
+
+// GradeAnswer reports whether userAnswer is a correct answer to q.
+// The stored Question.Answer is the only source of truth.
+func GradeAnswer(q Question, userAnswer string) bool {
+	switch q.Type {
+	case QuestionTypeMultipleSelect:
+		return sameChoiceSet(q.Answer, userAnswer)
+	case QuestionTypeMultipleChoice:
+		// The editor allows several correct options on a single-choice question,
+		// the UI then renders it as a multi-select.
+		if strings.Contains(q.Answer, ",") {
+			return sameChoiceSet(q.Answer, userAnswer)
+		}
+		return normalizeText(q.Answer) == normalizeText(userAnswer)
+	case QuestionTypeTrueFalse:
+		expected, ok1 := trueFalseValues[normalizeText(q.Answer)]
+		actual, ok2 := trueFalseValues[normalizeText(userAnswer)]
+		if ok1 && ok2 {
+			return expected == actual
+		}
+		return normalizeText(q.Answer) == normalizeText(userAnswer)
+	case QuestionTypeCalculation:
+		expected, err1 := strconv.ParseFloat(normalizeText(q.Answer), 64)
+		actual, err2 := strconv.ParseFloat(normalizeText(userAnswer), 64)
+		if err1 == nil && err2 == nil {
+			return math.Abs(expected-actual) < 1e-9
+		}
+		return normalizeText(q.Answer) == normalizeText(userAnswer)
+	default:
+		// FILL_BLANK and anything unknown
+		return normalizeText(q.Answer) == normalizeText(userAnswer)
+	}
+}
+
+// normalizeText trims, lowercases and collapses inner whitespace so that
+// "  Apple  pie" and "apple pie" compare equal.
+func normalizeText(s string) string {
+	s = strings.ReplaceAll(s, "　", " ") // full-width space
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// sameChoiceSet compares comma separated option lists ignoring order,
+// whitespace and duplicates.
+func sameChoiceSet(expected, actual string) bool {
+	a := splitChoices(expected)
+	b := splitChoices(actual)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return len(a) > 0
+}
+
+func splitChoices(s string) []string {
+	s = strings.ReplaceAll(s, "，", ",") // full-width comma
+	seen := make(map[string]bool)
+	res := make([]string, 0)
+	for _, part := range strings.Split(s, ",") {
+		v := normalizeText(part)
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		res = append(res, v)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// GradeHistory re-grades every submitted question result against the stored
+// questions and recomputes the history totals. Results for unknown questions
+// (or, for homework, questions outside the assigned paper) are dropped.
+// Client supplied Status, Answer, IsCorrect and counters are ignored. A failed
+// lookup is returned rather than grading against what could be read.
+func GradeHistory(db *gorm.DB, h *History) ([]HistoryQuestionResult, error) {
+	questionsBytes, _ := json.Marshal(h.Questions)
+	var submitted []HistoryQuestionResult
+	json.Unmarshal(questionsBytes, &submitted)
+
+	ids := make([]string, 0, len(submitted))
+	for _, res := range submitted {
+		ids = append(ids, res.ID)
+	}
+
+	questionMap := make(map[string]Question)
+	if len(ids) > 0 {
+		var questions []Question
+		if err := db.Where("id IN ?", ids).Find(&questions).Error; err != nil {
+			return nil, err
+		}
+		for _, q := range questions {
+			questionMap[q.ID] = q
+		}
+	}
+
+	// For homework only the paper's questions count, and the total is the paper size
+	var allowed map[string]bool
+	total := 0
+	if h.HomeworkID != "" {
+		var hw Homework
+		var paper Paper
+		err := db.First(&hw, "id = ?", h.HomeworkID).Error
+		if err == nil {
+			err = db.First(&paper, "id = ?", hw.PaperID).Error
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			allowed = make(map[string]bool)
+			for _, q := range paper.Questions {
+				allowed[q.ID] = true
+			}
+			for _, id := range paper.QuestionIDs {
+				allowed[id] = true
+			}
+			total = len(allowed)
+		}
+	}
+
+	graded := make([]HistoryQuestionResult, 0, len(submitted))
+	seen := make(map[string]bool)
+	for _, res := range submitted {
+		q, ok := questionMap[res.ID]
+		if !ok || seen[res.ID] || (allowed != nil && !allowed[res.ID]) {
+			continue
+		}
+		seen[res.ID] = true
+
+		for i := range res.AttemptLog {
+			res.AttemptLog[i].IsCorrect = GradeAnswer(q, res.AttemptLog[i].Answer)
+		}
+		if len(res.AttemptLog) > 0 {
+			res.UserAnswer = res.AttemptLog[len(res.AttemptLog)-1].Answer
+			res.Attempts = len(res.AttemptLog)
+		}
+
+		res.Subject = q.Subject
+		res.Stem = q.StemText
+		res.StemImage = q.StemImage
+		res.Answer = q.Answer
+		res.Options = q.Options
+		if GradeAnswer(q, res.UserAnswer) {
+			res.Status = "correct"
+		} else {
+			res.Status = "wrong"
+		}
+		graded = append(graded, res)
+	}
+
+	correct := 0
+	for _, res := range graded {
+		if res.Status == "correct" {
+			correct++
+		}
+	}
+	if total < len(graded) {
+		total = len(graded)
+	}
+
+	h.CorrectCount = correct
+	h.WrongCount = len(graded) - correct
+	h.Score = correct
+	h.Total = total
+	h.Questions = make([]any, len(graded))
+	for i := range graded {
+		h.Questions[i] = graded[i]
+	}
+	return graded, nil
+}
+
+// wrongAttempts counts the failed tries behind a graded result
+func wrongAttempts(res HistoryQuestionResult) int {
+	wrongCount := 0
+	if len(res.AttemptLog) > 0 {
+		for _, att := range res.AttemptLog {
+			if !att.IsCorrect {
+				wrongCount++
+			}
+		}
+		return wrongCount
+	}
+
+	// Fallback if no detailed log
+	if res.Status == "correct" {
+		if res.Attempts > 1 {
+			wrongCount = res.Attempts - 1
+		}
+	} else {
+		wrongCount = res.Attempts
+		if wrongCount == 0 {
+			wrongCount = 1
+		}
+	}
+	return wrongCount
+}
+
+// ToStudentQuestions strips the answer key from a list of questions
+func ToStudentQuestions(questions []Question) []StudentQuestion {
+	res := make([]StudentQuestion, len(questions))
+	for i, q := range questions {
+		res[i] = StudentQuestion{
+			ID:          q.ID,
+			Subject:     q.Subject,
+			Grade:       q.Grade,
+			Type:        q.Type,
+			StemText:    q.StemText,
+			StemImage:   q.StemImage,
+			StemAssetID: q.StemAssetID,
+			StemSrcSet:  q.StemSrcSet,
+			Options:     q.Options,
+		}
+	}
+	return res
+}
+
+// RevealableAnswers returns which of the given questions a student may see the
+// answer and hint for, decided by the ShowAnswer flag of the student's current
+// wrong-book stage for that question.
+func RevealableAnswers(db *gorm.DB, studentID string, questionIDs []string) map[string]bool {
+	res := make(map[string]bool)
+	if len(questionIDs) == 0 {
+		return res
+	}
+
+	conf := LoadErrorLogicConfig(db)
+	if !conf.GlobalEnabled {
+		return res
+	}
+
+	var states []StudentWrongQuestion
+	db.Where("student_id = ? AND question_id IN ?", studentID, questionIDs).Find(&states)
+	for _, state := range states {
+		if stage, ok := conf.Stages[state.Status]; ok && stage.ShowAnswer {
+			res[state.QuestionID] = true
+		}
+	}
+	return res
+}
+
+// HideHistoryAnswers removes the answer key from the wrong results of a
+// student's history, unless the wrong-book stage allows showing it
+func HideHistoryAnswers(db *gorm.DB, h *History) {
+	questionsBytes, _ := json.Marshal(h.Questions)
+	var results []HistoryQuestionResult
+	if err := json.Unmarshal(questionsBytes, &results); err != nil {
+		return
+	}
+
+	ids := make([]string, 0)
+	for _, res := range results {
+		if res.Status != "correct" {
+			ids = append(ids, res.ID)
+		}
+	}
+	visible := RevealableAnswers(db, h.StudentID, ids)
+
+	h.Questions = make([]any, len(results))
+	for i := range results {
+		if results[i].Status != "correct" && !visible[results[i].ID] {
+			results[i].Answer = ""
+		}
+		h.Questions[i] = results[i]
+	}
+}
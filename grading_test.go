@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGradeAnswer_TableDriven(t *testing.T) {
+	tests := []struct {
+		name     string
+		question Question
+		answer   string
+		expected bool
+	}{
+		{"Single choice", Question{Type: QuestionTypeMultipleChoice, Answer: "B"}, "B", true},
+		{"Single choice wrong", Question{Type: QuestionTypeMultipleChoice, Answer: "B"}, "C", false},
+		{"Choice with several answers", Question{Type: QuestionTypeMultipleChoice, Answer: "A,C"}, "C,A", true},
+		{"Multi select order independent", Question{Type: QuestionTypeMultipleSelect, Answer: "A,B,D"}, "D, B,A", true},
+		{"Multi select missing option", Question{Type: QuestionTypeMultipleSelect, Answer: "A,B"}, "A", false},
+		{"Multi select empty", Question{Type: QuestionTypeMultipleSelect, Answer: "A"}, "", false},
+		{"True false", Question{Type: QuestionTypeTrueFalse, Answer: "正确"}, "正确", true},
+		{"True false alias", Question{Type: QuestionTypeTrueFalse, Answer: "对"}, "正确", true},
+		{"True false wrong", Question{Type: QuestionTypeTrueFalse, Answer: "正确"}, "错误", false},
+		{"Fill blank whitespace and case", Question{Type: QuestionTypeFillBlank, Answer: "Apple"}, "  apple ", true},
+		{"Fill blank wrong", Question{Type: QuestionTypeFillBlank, Answer: "apple"}, "apples", false},
+		{"Calculation numeric", Question{Type: QuestionTypeCalculation, Answer: "12"}, "12.0", true},
+		{"Calculation wrong", Question{Type: QuestionTypeCalculation, Answer: "12"}, "13", false},
+		{"Calculation non numeric", Question{Type: QuestionTypeCalculation, Answer: "3/4"}, "3/4", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, GradeAnswer(tt.question, tt.answer))
+		})
+	}
+}
+
+func TestCreateHistory_ServerGrading(t *testing.T) {
+	DB.Exec("DELETE FROM questions")
+	DB.Exec("DELETE FROM histories")
+	DB.Create(&Question{ID: "g1", Type: QuestionTypeMultipleChoice, Answer: "A", StemText: "1+1?"})
+	DB.Create(&Question{ID: "g2", Type: QuestionTypeCalculation, Answer: "4", StemText: "2+2"})
+
+	r := gin.Default()
+	r.POST("/history", func(c *gin.Context) {
+		c.Set("userId", "s1")
+		c.Set("role", RoleStudent)
+		CreateHistory(c)
+	})
+
+	// The client claims a perfect score
+	body, _ := json.Marshal(map[string]any{
+		"type":         "practice",
+		"correctCount": 3,
+		"total":        3,
+		"questions": []map[string]any{
+			{"id": "g1", "status": "correct", "userAnswer": "B", "answer": "B"},
+			{"id": "g2", "status": "correct", "attemptLog": []map[string]any{
+				{"answer": "5", "isCorrect": true},
+				{"answer": "4", "isCorrect": true},
+			}},
+			{"id": "unknown", "status": "correct", "userAnswer": "x"},
+		},
+	})
+	req, _ := http.NewRequest("POST", "/history", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Code int     `json:"code"`
+		Data History `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, 0, resp.Code)
+	assert.Equal(t, 1, resp.Data.CorrectCount)
+	assert.Equal(t, 1, resp.Data.WrongCount)
+	assert.Equal(t, 1, resp.Data.Score)
+	assert.Equal(t, 2, resp.Data.Total)
+	assert.Equal(t, 2, len(resp.Data.Questions))
+
+	// The wrong answer's key is kept for teachers but not echoed to the student
+	first := resp.Data.Questions[0].(map[string]any)
+	assert.Equal(t, "wrong", first["status"])
+	assert.Equal(t, "", first["answer"])
+
+	var stored History
+	DB.First(&stored, "id = ?", resp.Data.ID)
+	assert.Equal(t, "A", stored.Questions[0].(map[string]any)["answer"])
+
+	// Grading fails rather than scoring against questions it could not read
+	empty := openMigrationTestDB(t)
+	h := History{Questions: []any{map[string]any{"id": "g1", "userAnswer": "A"}}}
+	_, err := GradeHistory(empty, &h)
+	assert.Error(t, err)
+}
+
+func TestStudentAnswerVisibility(t *testing.T) {
+	DB.Exec("DELETE FROM questions")
+	DB.Exec("DELETE FROM student_wrong_questions")
+	DB.Exec("DELETE FROM practice_sessions")
+	DB.Exec("DELETE FROM system_configs")
+	ForgetCachedSettings("")
+	DB.Create(&Question{ID: "v1", Type: QuestionTypeFillBlank, Answer: "cat", Hint: "meow"})
+	DB.Create(&Question{ID: "v2", Type: QuestionTypeFillBlank, Answer: "dog", Hint: "woof"})
+	// Stage 2 shows the answer with the default config, stage 1 does not
+	DB.Create(&StudentWrongQuestion{ID: "w1", StudentID: "s1", QuestionID: "v1", Status: 2})
+	DB.Create(&StudentWrongQuestion{ID: "w2", StudentID: "s1", QuestionID: "v2", Status: 1})
+	DB.Create(&PracticeSession{ID: "ps1", StudentID: "s1", QuestionIDs: []string{"v1", "v2"}, Status: "active"})
+
+	asStudent := func(h gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Set("userId", "s1")
+			c.Set("role", RoleStudent)
+			h(c)
+		}
+	}
+	r := gin.Default()
+	r.GET("/questions", asStudent(GetQuestions))
+	r.POST("/questions/:id/check", asStudent(CheckAnswer))
+
+	req, _ := http.NewRequest("GET", "/questions", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.NotContains(t, w.Body.String(), "cat")
+	assert.NotContains(t, w.Body.String(), "meow")
+
+	check := func(id, answer, sessionID string) (int, map[string]any) {
+		body, _ := json.Marshal(map[string]string{"answer": answer, "sessionId": sessionID})
+		req, _ := http.NewRequest("POST", "/questions/"+id+"/check", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var resp struct {
+			Code int            `json:"code"`
+			Data map[string]any `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Code, resp.Data
+	}
+
+	tests := []struct {
+		name       string
+		id         string
+		answer     string
+		correct    bool
+		showAnswer bool
+	}{
+		{"Wrong answer on hidden stage", "v2", "cat", false, false},
+		{"Correct answer unlocks", "v2", "dog", true, true},
+		{"Wrong answer on show-answer stage", "v1", "dog", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, data := check(tt.id, tt.answer, "ps1")
+			assert.Equal(t, 0, code)
+			assert.Equal(t, tt.correct, data["correct"])
+			assert.Equal(t, tt.showAnswer, data["showAnswer"])
+			_, hasAnswer := data["answer"]
+			assert.Equal(t, tt.showAnswer, hasAnswer)
+		})
+	}
+
+	t.Run("Checks count against the session", func(t *testing.T) {
+		code, _ := check("v2", "dog", "")
+		assert.Equal(t, 1, code, "students need a session")
+		code, data := check("v2", "dog", "ps1")
+		assert.Equal(t, 1, code, "a solved question takes no more guesses")
+		assert.Nil(t, data)
+
+		for i := 2; i <= maxAttempts; i++ {
+			code, _ = check("v1", "dog", "ps1")
+			assert.Equal(t, 0, code)
+		}
+		code, data = check("v1", "cat", "ps1")
+		assert.Equal(t, 1, code, "the attempt limit is reached")
+		assert.Nil(t, data)
+
+		var s PracticeSession
+		DB.First(&s, "id = ?", "ps1")
+		assert.Len(t, s.Attempts["v1"], maxAttempts)
+	})
+}
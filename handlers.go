@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sort"
@@ -10,7 +11,6 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
@@ -32,38 +32,64 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 
+	if !checkLoginAllowed(c, req.Username) {
+		return
+	}
+
+	// Usernames are unique across schools, the user's school comes from the account
 	var foundUser User
 	if err := DB.Where("username = ?", req.Username).First(&foundUser).Error; err != nil {
+		loginFailed(c, req.Username)
 		SendJSON(c, 1, "Unauthorized", nil)
 		return
 	}
 
 	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(foundUser.Password), []byte(req.Password)); err != nil {
+		loginFailed(c, req.Username)
 		SendJSON(c, 1, "Unauthorized", nil)
 		return
 	}
+	loginSucceeded(req.Username)
+	completeLogin(c, foundUser, "", false)
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userId": foundUser.ID,
-		"role":   foundUser.Role,
-		"exp":    time.Now().Add(tokenDuration).Unix(),
-	})
+// completeLogin starts a session for a user whose credentials checked out and
+// sends the tokens. how tells the audit log which way they logged in;
+// passwordFree marks card and picture logins, see AuthMiddleware.
+func completeLogin(c *gin.Context, user User, how string, passwordFree bool) {
+	if userDisabled(user) {
+		SendJSON(c, 1, "Account is disabled", nil)
+		return
+	}
+	if _, err := LoadTenant(user.TenantID); err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
 
-	tokenString, _ := token.SignedString(jwtSecret)
+	tokens, err := StartUserSession(c, user, passwordFree)
+	if err != nil {
+		SendJSON(c, 1, "Failed to create session", nil)
+		return
+	}
 
 	// Set context for logging
-	c.Set("userId", foundUser.ID)
-	c.Set("role", string(foundUser.Role))
-	AddAuditLog(c, "LOGIN", fmt.Sprintf("User logged in: %s", foundUser.Username))
+	c.Set("userId", user.ID)
+	c.Set("role", string(user.Role))
+	c.Set("tenantId", user.TenantID)
+	AddAuditLog(c, "LOGIN", fmt.Sprintf("User logged in%s: %s", how, user.Username))
 
 	SendJSON(c, 0, "", gin.H{
-		"token": tokenString,
+		"token":        tokens["token"],
+		"refreshToken": tokens["refreshToken"],
+		"expiresIn":    tokens["expiresIn"],
 		"user": gin.H{
-			"id":       foundUser.ID,
-			"username": foundUser.Username,
-			"role":     foundUser.Role,
-			"name":     foundUser.Name,
+			"id":                 user.ID,
+			"username":           user.Username,
+			"role":               user.Role,
+			"name":               user.Name,
+			"mustChangePassword": user.MustChangePassword && !passwordFree,
+			"department":         user.Department,
 		},
 	})
 }
@@ -75,15 +101,27 @@ func RegisterHandler(c *gin.Context) {
 		return
 	}
 
-	// Check if registration is enabled
-	var sysConf SystemConfig
-	var settings SystemSettingsConfig
-	// Default to false if not configured
-	if err := DB.Where("`key` = ?", "system_settings").First(&sysConf).Error; err == nil {
-		json.Unmarshal([]byte(sysConf.Value), &settings)
+	// An invite code registers with the invited student's school, anyone else
+	// picks a school by its code
+	if req.InviteCode != "" {
+		tid := parentInviteTenant(req.InviteCode)
+		if tid == "" {
+			SendJSON(c, 1, errInvalidInviteCode.Error(), nil)
+			return
+		}
+		c.Set("tenantId", tid)
+	} else {
+		tenant, err := TenantByCode(req.Tenant)
+		if err != nil {
+			SendJSON(c, 1, err.Error(), nil)
+			return
+		}
+		c.Set("tenantId", tenant.ID)
 	}
-	
-	if !settings.RegistrationEnabled {
+
+	// Check if registration is enabled; an invite code is its own permission
+	settings := loadSystemSettings(TenantDB(c))
+	if !settings.RegistrationEnabled && req.InviteCode == "" {
 		SendJSON(c, 1, "Registration is currently disabled", nil)
 		return
 	}
@@ -100,7 +138,7 @@ func RegisterHandler(c *gin.Context) {
 		return
 	}
 
-	// Check if user exists
+	// Check if user exists; usernames are unique across all schools
 	var count int64
 	DB.Model(&User{}).Where("username = ?", req.PhoneNumber).Count(&count)
 	if count > 0 {
@@ -109,21 +147,38 @@ func RegisterHandler(c *gin.Context) {
 	}
 
 	// Create User
-	hashed, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashed, err := hashPassword(TenantDB(c), req.Password, req.PhoneNumber)
 	if err != nil {
-		SendJSON(c, 1, "Internal server error", nil)
+		SendJSON(c, 1, err.Error(), nil)
 		return
 	}
 
 	newUser := User{
 		ID:       strconv.FormatInt(time.Now().UnixNano(), 36),
 		Username: req.PhoneNumber,
-		Password: string(hashed),
+		Password: hashed,
 		Role:     RoleStudent,
 		Status:   "active",
 	}
+	if req.InviteCode != "" {
+		newUser.Role = RoleParent
+	}
 
-	if err := DB.Create(&newUser).Error; err != nil {
+	err = TenantDB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&newUser).Error; err != nil {
+			return err
+		}
+		if req.InviteCode == "" {
+			return nil
+		}
+		_, err := redeemParentInvite(tx, newUser.ID, req.InviteCode)
+		return err
+	})
+	if errors.Is(err, errInvalidInviteCode) {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
+	if err != nil {
 		SendJSON(c, 1, "Failed to create user", nil)
 		return
 	}
@@ -135,32 +190,43 @@ func RegisterHandler(c *gin.Context) {
 // User Handlers (Admin)
 func GetUsers(c *gin.Context) {
 	var users []User
-	DB.Find(&users)
+	TenantDB(c).Find(&users)
 	SendJSON(c, 0, "", users)
 }
 
 func GetStudents(c *gin.Context) {
-	var students []User
-	DB.Where("role = ?", RoleStudent).Find(&students)
+	students := make([]User, 0)
+	query := TenantDB(c).Where("role = ?", RoleStudent)
+	if ids, all := StudentScope(c); !all {
+		if len(ids) == 0 {
+			SendJSON(c, 0, "", students)
+			return
+		}
+		query = query.Where("id IN ?", ids)
+	}
+	query.Find(&students)
 	SendJSON(c, 0, "", students)
 }
 
 func GetStudentDetail(c *gin.Context) {
 	studentId := c.Param("id")
+	if !RequireStudentAccess(c, studentId) {
+		return
+	}
 	
 	var student User
-	if err := DB.First(&student, "id = ? AND role = ?", studentId, RoleStudent).Error; err != nil {
+	if err := TenantDB(c).First(&student, "id = ? AND role = ?", studentId, RoleStudent).Error; err != nil {
 		SendJSON(c, 1, "Student not found", nil)
 		return
 	}
 
 	// 1. Fetch targeted reinforcements
 	reinforcements := make([]Reinforcement, 0)
-	DB.Where("target_student_ids LIKE ?", "%\""+studentId+"\"%").Find(&reinforcements)
+	TenantDB(c).Where("target_student_ids LIKE ?", "%\""+studentId+"\"%").Find(&reinforcements)
 
 	// 2. Fetch recent learning logs (last 20)
 	history := make([]History, 0)
-	DB.Where("student_id = ?", studentId).Order("date DESC").Limit(20).Find(&history)
+	TenantDB(c).Where("student_id = ?", studentId).Order("date DESC").Limit(20).Find(&history)
 
 	// 3. Learning progress & stats (Accuracy trend last 14 days)
 	type ProgressPoint struct {
@@ -178,8 +244,8 @@ func GetStudentDetail(c *gin.Context) {
 			Correct int
 			Total   int
 		}
-		DB.Model(&History{}).
-			Select("SUM(correct_count) as correct, SUM(CAST(total AS UNSIGNED)) as total").
+		TenantDB(c).Model(&History{}).
+			Select("SUM(correct_count) as correct, SUM(total) as total").
 			Where("student_id = ? AND date LIKE ?", studentId, dateStr+"%").
 			Scan(&results)
 
@@ -193,24 +259,45 @@ func GetStudentDetail(c *gin.Context) {
 		})
 	}
 
-	// 4. Homework completion overview
-	type HomeworkBrief struct {
-		ID           string  `json:"id"`
-		Name         string  `json:"name"`
-		Status       string  `json:"status"` // "pending", "completed", "overdue"
-		StartDate    string  `json:"startDate"`
-		EndDate      string  `json:"endDate"`
-		Score        string  `json:"score"`
-		Total        string  `json:"total"`
-		CorrectCount int     `json:"correctCount"`
-		AccuracyRate float64 `json:"accuracyRate"`
+	// Parents see answers only as far as their child would
+	if role, _ := c.Get("role"); fmt.Sprintf("%v", role) == string(RoleParent) {
+		for i := range history {
+			HideHistoryAnswers(TenantDB(c), &history[i])
+		}
 	}
-	
+
+	// 4. Homework completion overview
+	homeworkOverview := studentHomeworkOverview(TenantDB(c), studentId)
+
+	SendJSON(c, 0, "", gin.H{
+		"student":        student,
+		"reinforcements": reinforcements,
+		"history":        history,
+		"progress":       progress,
+		"homework":       homeworkOverview,
+	})
+}
+
+// HomeworkBrief is one assigned homework and how the student did on it
+type HomeworkBrief struct {
+	ID           string  `json:"id"`
+	Name         string  `json:"name"`
+	Status       string  `json:"status"` // "pending", "completed", "overdue"
+	StartDate    string  `json:"startDate"`
+	EndDate      string  `json:"endDate"`
+	Score        string  `json:"score"`
+	Total        string  `json:"total"`
+	CorrectCount int     `json:"correctCount"`
+	AccuracyRate float64 `json:"accuracyRate"`
+}
+
+// studentHomeworkOverview lists the homework assigned to a student, newest first
+func studentHomeworkOverview(db *gorm.DB, studentId string) []HomeworkBrief {
 	homeworkOverview := make([]HomeworkBrief, 0)
-	
+
 	// Fetch all homeworks where this student is assigned
 	var assignedHomeworks []Homework
-	DB.Where("student_ids LIKE ?", "%\""+studentId+"\"%").Find(&assignedHomeworks)
+	db.Where("student_ids LIKE ?", "%\""+studentId+"\"%").Find(&assignedHomeworks)
 
 	for _, hw := range assignedHomeworks {
 		brief := HomeworkBrief{
@@ -227,19 +314,18 @@ func GetStudentDetail(c *gin.Context) {
 
 		var latestHistory History
 		// Find the latest history entry for this homework and student
-		err := DB.Where("student_id = ? AND homework_id = ?", studentId, hw.ID).
+		err := db.Where("student_id = ? AND homework_id = ?", studentId, hw.ID).
 			Order("date DESC").
 			First(&latestHistory).Error
 		
 		if err == nil { // Found a submission
 			brief.Status = "completed"
 			brief.Score = strconv.Itoa(latestHistory.CorrectCount) // Set score to correct count
-			brief.Total = latestHistory.Total
+			brief.Total = strconv.Itoa(latestHistory.Total)
 			brief.CorrectCount = latestHistory.CorrectCount
 			
-			totalInt, _ := strconv.Atoi(latestHistory.Total)
-			if totalInt > 0 {
-				brief.AccuracyRate = (float64(latestHistory.CorrectCount) / float64(totalInt)) * 100
+			if latestHistory.Total > 0 {
+				brief.AccuracyRate = (float64(latestHistory.CorrectCount) / float64(latestHistory.Total)) * 100
 			}
 		} else { // No submission found
 			// Check if homework is overdue
@@ -258,13 +344,7 @@ func GetStudentDetail(c *gin.Context) {
 		return homeworkOverview[i].StartDate > homeworkOverview[j].StartDate
 	})
 
-	SendJSON(c, 0, "", gin.H{
-		"student":        student,
-		"reinforcements": reinforcements,
-		"history":        history,
-		"progress":       progress,
-		"homework":       homeworkOverview,
-	})
+	return homeworkOverview
 }
 
 func CreateUser(c *gin.Context) {
@@ -273,17 +353,23 @@ func CreateUser(c *gin.Context) {
 		SendJSON(c, 1, err.Error(), nil)
 		return
 	}
+	if newUser.Role == RoleSuperAdmin {
+		SendJSON(c, 1, errSuperAdminManaged.Error(), nil)
+		return
+	}
 
 	// Hash password
-	hashed, err := bcrypt.GenerateFromPassword([]byte(newUser.Password), bcrypt.DefaultCost)
+	hashed, err := hashPassword(TenantDB(c), newUser.Password, newUser.Username)
 	if err != nil {
-		SendJSON(c, 1, "Failed to hash password", nil)
+		SendJSON(c, 1, err.Error(), nil)
 		return
 	}
-	newUser.Password = string(hashed)
+	newUser.Password = hashed
+	// The admin knows this password, so the user has to pick their own
+	newUser.MustChangePassword = true
 
 	newUser.ID = strconv.FormatInt(time.Now().UnixNano(), 36)
-	if err := DB.Create(&newUser).Error; err != nil {
+	if err := TenantDB(c).Create(&newUser).Error; err != nil {
 		SendJSON(c, 1, "Failed to create user", nil)
 		return
 	}
@@ -299,38 +385,55 @@ func UpdateUser(c *gin.Context) {
 	}
 
 	var user User
-	if err := DB.First(&user, "id = ?", id).Error; err != nil {
+	if err := TenantDB(c).First(&user, "id = ?", id).Error; err != nil {
 		SendJSON(c, 1, "User not found", nil)
 		return
 	}
+	if user.Role == RoleSuperAdmin || updateData.Role == RoleSuperAdmin {
+		SendJSON(c, 1, errSuperAdminManaged.Error(), nil)
+		return
+	}
 
 	if updateData.Password != "" {
 		// Hash new password
-		hashed, err := bcrypt.GenerateFromPassword([]byte(updateData.Password), bcrypt.DefaultCost)
+		hashed, err := hashPassword(TenantDB(c), updateData.Password, updateData.Username)
 		if err != nil {
-			SendJSON(c, 1, "Failed to hash password", nil)
+			SendJSON(c, 1, err.Error(), nil)
 			return
 		}
-		user.Password = string(hashed)
+		user.Password = hashed
+		user.MustChangePassword = true
 	}
 	user.Username = updateData.Username
 	user.Name = updateData.Name
 	user.Role = updateData.Role
 	user.Status = updateData.Status
+	user.Department = strings.TrimSpace(updateData.Department)
+	if updateData.Grade != 0 {
+		user.Grade = updateData.Grade
+	}
 
-	DB.Save(&user)
+	TenantDB(c).Save(&user)
+
+	// A locked account or a reset password logs the user out everywhere
+	if userDisabled(user) || updateData.Password != "" {
+		RevokeUserSessions(user.ID)
+	}
 	SendJSON(c, 0, "", user)
 }
 
 func GetMe(c *gin.Context) {
 	userId, _ := c.Get("userId")
 	var user User
-	if err := DB.First(&user, "id = ?", userId).Error; err != nil {
+	if err := TenantDB(c).First(&user, "id = ?", userId).Error; err != nil {
 		SendJSON(c, 1, "User not found", nil)
 		return
 	}
 	// Don't send password
 	user.Password = ""
+	if user.MustChangePassword && c.GetBool("passwordFree") {
+		user.MustChangePassword = false
+	}
 	SendJSON(c, 0, "", user)
 }
 
@@ -346,7 +449,7 @@ func UpdateMe(c *gin.Context) {
 	}
 
 	var user User
-	if err := DB.First(&user, "id = ?", userId).Error; err != nil {
+	if err := TenantDB(c).First(&user, "id = ?", userId).Error; err != nil {
 		SendJSON(c, 1, "User not found", nil)
 		return
 	}
@@ -355,15 +458,20 @@ func UpdateMe(c *gin.Context) {
 		user.Name = updateData.Name
 	}
 	if updateData.Password != "" {
-		hashed, err := bcrypt.GenerateFromPassword([]byte(updateData.Password), bcrypt.DefaultCost)
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(updateData.Password)) == nil {
+			SendJSON(c, 1, "New password must be different from the current one", nil)
+			return
+		}
+		hashed, err := hashPassword(TenantDB(c), updateData.Password, user.Username)
 		if err != nil {
-			SendJSON(c, 1, "Failed to hash password", nil)
+			SendJSON(c, 1, err.Error(), nil)
 			return
 		}
-		user.Password = string(hashed)
+		user.Password = hashed
+		user.MustChangePassword = false
 	}
 
-	if err := DB.Save(&user).Error; err != nil {
+	if err := TenantDB(c).Save(&user).Error; err != nil {
 		SendJSON(c, 1, "Failed to update user", nil)
 		return
 	}
@@ -375,10 +483,22 @@ func UpdateMe(c *gin.Context) {
 
 func DeleteUser(c *gin.Context) {
 	id := c.Param("id")
-	if err := DB.Delete(&User{}, "id = ?", id).Error; err != nil {
+	var user User
+	if err := TenantDB(c).First(&user, "id = ?", id).Error; err != nil {
+		SendJSON(c, 1, "User not found", nil)
+		return
+	}
+	if user.Role == RoleSuperAdmin {
+		SendJSON(c, 1, errSuperAdminManaged.Error(), nil)
+		return
+	}
+	if err := TenantDB(c).Delete(&User{}, "id = ?", id).Error; err != nil {
 		SendJSON(c, 1, "Failed to delete user", nil)
 		return
 	}
+	RevokeUserSessions(id)
+	DB.Where("user_id = ?", id).Delete(&UserIdentity{})
+	TenantDB(c).Where("student_id = ?", id).Delete(&StudentLogin{})
 	SendJSON(c, 0, "", gin.H{"message": "Deleted"})
 }
 
@@ -398,8 +518,8 @@ func GetDashboardStats(c *gin.Context) {
 			Correct int
 			Total   int
 		}
-		DB.Model(&History{}).
-			Select("SUM(correct_count) as correct, SUM(CAST(total AS UNSIGNED)) as total").
+		TenantDB(c).Model(&History{}).
+			Select("SUM(correct_count) as correct, SUM(total) as total").
 			Where("date LIKE ?", dateStr+"%").
 			Scan(&results)
 
@@ -414,8 +534,8 @@ func GetDashboardStats(c *gin.Context) {
 			Assigned  int64
 			Completed int64
 		}
-		DB.Model(&Homework{}).Where("start_date LIKE ?", dateStr+"%").Count(&counts.Assigned)
-		DB.Model(&Homework{}).Where("start_date LIKE ?", dateStr+"%").Where("status = ?", "completed").Count(&counts.Completed)
+		TenantDB(c).Model(&Homework{}).Where("start_date LIKE ?", dateStr+"%").Count(&counts.Assigned)
+		TenantDB(c).Model(&Homework{}).Where("start_date LIKE ?", dateStr+"%").Where("status = ?", "completed").Count(&counts.Completed)
 
 		compValue := 0.0
 		if counts.Assigned > 0 {
@@ -424,28 +544,33 @@ func GetDashboardStats(c *gin.Context) {
 		completionTrend[i] = StatPoint{Label: label, Value: compValue}
 	}
 
-	// Calculate online users (last 5 mins)
-	onlineCount := 0
+	// Calculate online users (last 5 mins). ActiveUsers holds every school,
+	// so only the users of this one are counted.
+	var onlineUserIDs []string
 	nowUnix := time.Now().Unix()
 	ActiveUsers.Range(func(key, value interface{}) bool {
 		lastActive := value.(int64)
 		if nowUnix-lastActive < 300 { // 300 seconds = 5 minutes
-			onlineCount++
+			onlineUserIDs = append(onlineUserIDs, key.(string))
 		}
 		return true
 	})
+	var onlineCount int64
+	if len(onlineUserIDs) > 0 {
+		TenantDB(c).Model(&User{}).Where("id IN ?", onlineUserIDs).Count(&onlineCount)
+	}
 
 	var totalUsers int64
 	var totalQuestions int64
-	DB.Model(&User{}).Count(&totalUsers)
-	DB.Model(&Question{}).Count(&totalQuestions)
+	TenantDB(c).Model(&User{}).Count(&totalUsers)
+	TenantDB(c).Model(&Question{}).Count(&totalQuestions)
 
 	stats := DashboardStats{
 		AccuracyTrend:   accuracyTrend,
 		CompletionTrend: completionTrend,
 		TotalUsers:      int(totalUsers),
 		TotalQuestions:  int(totalQuestions),
-		OnlineUsers:     onlineCount,
+		OnlineUsers:     int(onlineCount),
 	}
 	SendJSON(c, 0, "", stats)
 }
@@ -467,7 +592,7 @@ func GetOnlineUsers(c *gin.Context) {
 
 	var users []User
 	if len(onlineUserIDs) > 0 {
-		DB.Where("id IN ?", onlineUserIDs).Find(&users)
+		TenantDB(c).Where("id IN ?", onlineUserIDs).Find(&users)
 	}
 
 	var onlineUsers []OnlineUser
@@ -495,7 +620,7 @@ func GetQuestions(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
 	
-	query := DB.Model(&Question{})
+	query := TenantDB(c).Model(&Question{})
 
 	if subject != "" {
 		// Map English subject enums to Chinese stored values
@@ -523,11 +648,7 @@ func GetQuestions(c *gin.Context) {
 	}
 
 	// Check exclusion logic
-	var sysConf SystemConfig
-	var conf ErrorLogicConfig
-	if err := DB.Where("`key` = ?", "error_logic").First(&sysConf).Error; err == nil {
-		json.Unmarshal([]byte(sysConf.Value), &conf)
-	}
+	conf := LoadErrorLogicConfig(TenantDB(c))
 
 	if conf.GlobalEnabled && conf.ExcludeMistakesFromPractice {
 		userId, exists := c.Get("userId")
@@ -548,7 +669,7 @@ func GetQuestions(c *gin.Context) {
 			// But stage 5 is "Difficult" -> "Excluded from homework".
 			// Let's filter out ALL IDs found in StudentWrongQuestion table for this student.
 			
-			DB.Model(&StudentWrongQuestion{}).Where("student_id = ?", fmt.Sprintf("%v", userId)).Pluck("question_id", &wrongQIDs)
+			TenantDB(c).Model(&StudentWrongQuestion{}).Where("student_id = ?", fmt.Sprintf("%v", userId)).Pluck("question_id", &wrongQIDs)
 			
 			if len(wrongQIDs) > 0 {
 				query = query.Where("id NOT IN ?", wrongQIDs)
@@ -561,15 +682,56 @@ func GetQuestions(c *gin.Context) {
 
 	questions := make([]Question, 0)
 	query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&questions)
+
+	// Students never receive the answer key
+	var list interface{} = questions
+	if role, _ := c.Get("role"); fmt.Sprintf("%v", role) == string(RoleStudent) {
+		list = ToStudentQuestions(questions)
+	}
 	
 	SendJSON(c, 0, "", gin.H{
-		"list":     questions,
+		"list":     list,
 		"total":    total,
 		"page":     page,
 		"pageSize": pageSize,
 	})
 }
 
+// uploadQuestionImages resolves uploaded asset references and moves base64
+// stem and option images to storage
+func uploadQuestionImages(db *gorm.DB, q *Question) error {
+	if q.StemAssetID != "" {
+		asset, err := resolveAsset(db, q.StemAssetID, "image")
+		if err != nil {
+			return err
+		}
+		q.StemImage = asset.URL
+		q.StemSrcSet = asset.SrcSet
+	}
+	url, err := UploadDataURL(q.StemImage, "questions")
+	if err != nil {
+		return err
+	}
+	q.StemImage = url
+
+	for i, opt := range q.Options {
+		if opt.AssetID != "" {
+			asset, err := resolveAsset(db, opt.AssetID, "image")
+			if err != nil {
+				return err
+			}
+			opt.Image = asset.URL
+			q.Options[i].SrcSet = asset.SrcSet
+		}
+		url, err := UploadDataURL(opt.Image, "questions")
+		if err != nil {
+			return err
+		}
+		q.Options[i].Image = url
+	}
+	return nil
+}
+
 func CreateQuestion(c *gin.Context) {
 	var q Question
 	if err := c.ShouldBindJSON(&q); err != nil {
@@ -577,26 +739,20 @@ func CreateQuestion(c *gin.Context) {
 		return
 	}
 
-	// 1. Process stem image if it's base64
-	if strings.HasPrefix(q.StemImage, "data:image") {
-		url, err := UploadBase64ToOSS(q.StemImage)
-		if err == nil {
-			q.StemImage = url
-		}
+	owner, err := newOwnership(c, q.Ownership)
+	if err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
 	}
+	q.Ownership = owner
 
-	// 2. Process option images if any
-	for i, opt := range q.Options {
-		if strings.HasPrefix(opt.Image, "data:image") {
-			url, err := UploadBase64ToOSS(opt.Image)
-			if err == nil {
-				q.Options[i].Image = url
-			}
-		}
+	if err := uploadQuestionImages(TenantDB(c), &q); err != nil {
+		SendJSON(c, 1, "Failed to upload image: "+err.Error(), nil)
+		return
 	}
 
 	q.ID = time.Now().Format("20060102150405")
-	if err := DB.Create(&q).Error; err != nil {
+	if err := TenantDB(c).Create(&q).Error; err != nil {
 		SendJSON(c, 1, "Failed to create question", nil)
 		return
 	}
@@ -606,32 +762,34 @@ func CreateQuestion(c *gin.Context) {
 
 func UpdateQuestion(c *gin.Context) {
 	id := c.Param("id")
+	var existing Question
+	if err := TenantDB(c).First(&existing, "id = ?", id).Error; err != nil {
+		SendJSON(c, 1, "Question not found", nil)
+		return
+	}
+	if !RequireContentEdit(c, existing.Ownership) {
+		return
+	}
+
 	var q Question
 	if err := c.ShouldBindJSON(&q); err != nil {
 		SendJSON(c, 1, err.Error(), nil)
 		return
 	}
-
-	// 1. Process stem image if it's base64
-	if strings.HasPrefix(q.StemImage, "data:image") {
-		url, err := UploadBase64ToOSS(q.StemImage)
-		if err == nil {
-			q.StemImage = url
-		}
+	requested := q.Ownership
+	q.Ownership = existing.Ownership
+	if err := shareContent(c, &q.Ownership, requested); err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
 	}
 
-	// 2. Process option images
-	for i, opt := range q.Options {
-		if strings.HasPrefix(opt.Image, "data:image") {
-			url, err := UploadBase64ToOSS(opt.Image)
-			if err == nil {
-				q.Options[i].Image = url
-			}
-		}
+	if err := uploadQuestionImages(TenantDB(c), &q); err != nil {
+		SendJSON(c, 1, "Failed to upload image: "+err.Error(), nil)
+		return
 	}
 
 	q.ID = id
-	if err := DB.Save(&q).Error; err != nil {
+	if err := TenantDB(c).Save(&q).Error; err != nil {
 		SendJSON(c, 1, "Failed to update question", nil)
 		return
 	}
@@ -642,31 +800,86 @@ func UpdateQuestion(c *gin.Context) {
 func DeleteQuestion(c *gin.Context) {
 	id := c.Param("id")
 	var q Question
-	if err := DB.First(&q, "id = ?", id).Error; err != nil {
+	if err := TenantDB(c).First(&q, "id = ?", id).Error; err != nil {
 		SendJSON(c, 1, "Question not found", nil)
 		return
 	}
+	if !RequireContentOwner(c, q.Ownership) {
+		return
+	}
 	
 	stem := q.StemText
-	DB.Delete(&q)
+	TenantDB(c).Delete(&q)
 	AddAuditLog(c, "DELETE_QUESTION", fmt.Sprintf("Deleted question: %s", stem))
 	SendJSON(c, 0, "", gin.H{"message": "Deleted"})
 }
 
+// CheckAnswer grades an answer. Students check within a practice session so
+// every try counts against its attempt limit, see SubmitSessionAnswer.
+func CheckAnswer(c *gin.Context) {
+	id := c.Param("id")
+	var req struct {
+		Answer    string `json:"answer"`
+		SessionID string `json:"sessionId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
+
+	var q Question
+	if err := TenantDB(c).First(&q, "id = ?", id).Error; err != nil {
+		SendJSON(c, 1, "Question not found", nil)
+		return
+	}
+
+	role, _ := c.Get("role")
+	if fmt.Sprintf("%v", role) == string(RoleStudent) {
+		if req.SessionID == "" {
+			SendJSON(c, 1, "Students check answers within a practice session", nil)
+			return
+		}
+		userId, _ := c.Get("userId")
+		studentId := fmt.Sprintf("%v", userId)
+		logs, errMsg := recordAttempt(TenantDB(c), req.SessionID, studentId, q, req.Answer)
+		if errMsg != "" {
+			SendJSON(c, 1, errMsg, nil)
+			return
+		}
+		SendJSON(c, 0, "", attemptResult(c, studentId, q, logs))
+		return
+	}
+
+	SendJSON(c, 0, "", gin.H{
+		"correct":    GradeAnswer(q, req.Answer),
+		"showAnswer": true,
+		"answer":     q.Answer,
+		"hint":       q.Hint,
+	})
+}
+
 // Paper Handlers
 func GetPapers(c *gin.Context) {
+	role, _ := c.Get("role")
+	isStudent := fmt.Sprintf("%v", role) == string(RoleStudent)
+
 	var papers []Paper
-	DB.Find(&papers)
+	TenantDB(c).Find(&papers)
 
 	result := make([]interface{}, len(papers))
 	for i, p := range papers {
 		var assignedCount int64
-		DB.Model(&Homework{}).Where("paper_id = ?", p.ID).Count(&assignedCount)
+		TenantDB(c).Model(&Homework{}).Where("paper_id = ?", p.ID).Count(&assignedCount)
+
+		var questions interface{} = p.Questions
+		if isStudent {
+			questions = ToStudentQuestions(p.Questions)
+		}
 		
 		result[i] = gin.H{
 			"id":            p.ID,
 			"name":          p.Name,
-			"questions":     p.Questions,
+			"questions":     questions,
 			"total":         p.Total,
 			"assignedCount": assignedCount,
 		}
@@ -680,17 +893,23 @@ func CreatePaper(c *gin.Context) {
 		SendJSON(c, 1, err.Error(), nil)
 		return
 	}
+	owner, err := newOwnership(c, p.Ownership)
+	if err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
+	p.Ownership = owner
 	p.ID = strconv.FormatInt(time.Now().UnixNano(), 36)
 	
 	// Populate Questions from IDs if provided
 	if len(p.QuestionIDs) > 0 {
 		var questions []Question
-		DB.Where("id IN ?", p.QuestionIDs).Find(&questions)
+		TenantDB(c).Where("id IN ?", p.QuestionIDs).Find(&questions)
 		p.Questions = questions
 	}
 	p.Total = len(p.Questions)
 
-	if err := DB.Create(&p).Error; err != nil {
+	if err := TenantDB(c).Create(&p).Error; err != nil {
 		SendJSON(c, 1, "Failed to create paper", nil)
 		return
 	}
@@ -699,22 +918,37 @@ func CreatePaper(c *gin.Context) {
 
 func UpdatePaper(c *gin.Context) {
 	id := c.Param("id")
+	var existing Paper
+	if err := TenantDB(c).First(&existing, "id = ?", id).Error; err != nil {
+		SendJSON(c, 1, "Paper not found", nil)
+		return
+	}
+	if !RequireContentEdit(c, existing.Ownership) {
+		return
+	}
+
 	var p Paper
 	if err := c.ShouldBindJSON(&p); err != nil {
 		SendJSON(c, 1, err.Error(), nil)
 		return
 	}
+	requested := p.Ownership
+	p.Ownership = existing.Ownership
+	if err := shareContent(c, &p.Ownership, requested); err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
 
 	p.ID = id
 	// Populate questions
 	if len(p.QuestionIDs) > 0 {
 		var questions []Question
-		DB.Where("id IN ?", p.QuestionIDs).Find(&questions)
+		TenantDB(c).Where("id IN ?", p.QuestionIDs).Find(&questions)
 		p.Questions = questions
 	}
 	p.Total = len(p.Questions)
 	
-	if err := DB.Save(&p).Error; err != nil {
+	if err := TenantDB(c).Save(&p).Error; err != nil {
 		SendJSON(c, 1, "Failed to update paper", nil)
 		return
 	}
@@ -723,7 +957,15 @@ func UpdatePaper(c *gin.Context) {
 
 func DeletePaper(c *gin.Context) {
 	id := c.Param("id")
-	if err := DB.Delete(&Paper{}, "id = ?", id).Error; err != nil {
+	var p Paper
+	if err := TenantDB(c).First(&p, "id = ?", id).Error; err != nil {
+		SendJSON(c, 1, "Paper not found", nil)
+		return
+	}
+	if !RequireContentOwner(c, p.Ownership) {
+		return
+	}
+	if err := TenantDB(c).Delete(&Paper{}, "id = ?", id).Error; err != nil {
 		SendJSON(c, 1, "Failed to delete paper", nil)
 		return
 	}
@@ -751,7 +993,7 @@ func GetHomeworks(c *gin.Context) {
 
 	var results []HomeworkStat
 	
-	query := DB.Table("homeworks").
+	query := TenantDB(c).Table("homeworks").
 		Select(`
 			homeworks.*,
 			(SELECT COUNT(DISTINCT student_id) FROM histories WHERE histories.homework_id = homeworks.id) as completed
@@ -768,12 +1010,12 @@ func GetHomeworks(c *gin.Context) {
 		if results[i].Total > 0 && results[i].Completed >= results[i].Total {
 			if results[i].Status != "completed" {
 				results[i].Status = "completed"
-				DB.Model(&Homework{}).Where("id = ?", results[i].ID).Update("status", "completed")
+				TenantDB(c).Model(&Homework{}).Where("id = ?", results[i].ID).Update("status", "completed")
 			}
 		} else {
              if results[i].Status == "completed" {
                  results[i].Status = "pending"
-                 DB.Model(&Homework{}).Where("id = ?", results[i].ID).Update("status", "pending")
+                 TenantDB(c).Model(&Homework{}).Where("id = ?", results[i].ID).Update("status", "pending")
              }
         }
 	}
@@ -790,11 +1032,57 @@ func AssignHomework(c *gin.Context) {
 	}
 
 	h.TeacherID = fmt.Sprintf("%v", userId)
+
+	// Students reach the assignments module to practice, not to hand out work
+	role, _ := c.Get("role")
+	if fmt.Sprintf("%v", role) == string(RoleStudent) {
+		SendJSON(c, 1, "Permission denied", nil)
+		return
+	}
+
+	// The paper has to be one of the school's, or the homework only breaks
+	// when a student starts it
+	if err := TenantDB(c).First(&Paper{}, "id = ?", h.PaperID).Error; err != nil {
+		SendJSON(c, 1, "Paper not found", nil)
+		return
+	}
+
+	// Teachers assign to the students of their classes only
+	if fmt.Sprintf("%v", role) != string(RoleAdmin) {
+		inClasses := make(map[string]bool)
+		for _, sid := range ClassStudentIDs(TenantDB(c), h.TeacherID) {
+			inClasses[sid] = true
+		}
+		for _, sid := range h.StudentIDs {
+			if !inClasses[sid] {
+				SendJSON(c, 1, "Student is not in your classes: "+sid, nil)
+				return
+			}
+		}
+	}
+
+	// Assigning to a class the requester teaches expands to its current members
+	if h.ClassID != "" {
+		cls, ok := loadManagedClass(c, h.ClassID)
+		if !ok {
+			return
+		}
+		seen := make(map[string]bool)
+		for _, sid := range h.StudentIDs {
+			seen[sid] = true
+		}
+		for _, sid := range cls.StudentIDs {
+			if !seen[sid] {
+				seen[sid] = true
+				h.StudentIDs = append(h.StudentIDs, sid)
+			}
+		}
+	}
 	h.Total = len(h.StudentIDs)
 	
 	h.ID = strconv.FormatInt(time.Now().UnixNano(), 36)
 	h.Status = "pending"
-	if err := DB.Create(&h).Error; err != nil {
+	if err := TenantDB(c).Create(&h).Error; err != nil {
 		SendJSON(c, 1, "Failed to assign homework", nil)
 		return
 	}
@@ -811,10 +1099,16 @@ func BulkCreateQuestions(c *gin.Context) {
 	
 	now := time.Now().Unix()
 	for i := range list {
+		owner, err := newOwnership(c, list[i].Ownership)
+		if err != nil {
+			SendJSON(c, 1, err.Error(), nil)
+			return
+		}
+		list[i].Ownership = owner
 		list[i].ID = strconv.FormatInt(now, 10) + "_" + strconv.Itoa(i)
 	}
 	
-	if err := DB.Create(&list).Error; err != nil {
+	if err := TenantDB(c).Create(&list).Error; err != nil {
 		SendJSON(c, 1, "Failed to bulk create questions", nil)
 		return
 	}
@@ -829,18 +1123,18 @@ func CompleteHomework(c *gin.Context) {
 	studentId := fmt.Sprintf("%v", userId)
 
 	var h Homework
-	if err := DB.First(&h, "id = ?", id).Error; err != nil {
+	if err := TenantDB(c).First(&h, "id = ?", id).Error; err != nil {
 		SendJSON(c, 1, "Homework not found", nil)
 		return
 	}
 
 	// Check if this student already completed it in History
 	var exists int64
-	DB.Model(&History{}).Where("homework_id = ? AND student_id = ?", id, studentId).Count(&exists)
+	TenantDB(c).Model(&History{}).Where("homework_id = ? AND student_id = ?", id, studentId).Count(&exists)
 
 	// Recalculate accurate completion count
 	var currentCompleted int64
-	DB.Model(&History{}).
+	TenantDB(c).Model(&History{}).
 		Where("homework_id = ?", id).
 		Select("COUNT(DISTINCT student_id)").
 		Scan(&currentCompleted)
@@ -855,7 +1149,7 @@ func CompleteHomework(c *gin.Context) {
 		h.Status = "pending"
 	}
 	
-	DB.Save(&h)
+	TenantDB(c).Save(&h)
 	
 	AddAuditLog(c, "COMPLETE_HOMEWORK", fmt.Sprintf("Finished homework: %s", h.Name))
 	SendJSON(c, 0, "", h)
@@ -873,12 +1167,15 @@ func GetHistory(c *gin.Context) {
 	var total int64
 	role, _ := c.Get("role")
 	
-	query := DB.Model(&History{})
+	query := TenantDB(c).Model(&History{})
 	if fmt.Sprintf("%v", role) == string(RoleStudent) {
 		query = query.Where("student_id = ?", fmt.Sprintf("%v", userId))
 	} else if fmt.Sprintf("%v", role) == string(RoleTeacher) {
 		if targetStudentId != "" {
 			// Teacher viewing specific student history
+			if !RequireStudentAccess(c, targetStudentId) {
+				return
+			}
 			query = query.Where("student_id = ?", targetStudentId)
 		} else {
 			// Teacher viewing all their assigned homeworks' history
@@ -886,25 +1183,42 @@ func GetHistory(c *gin.Context) {
 			// 1. Get all homework IDs for this teacher
 			var homeworkIDs []string
 			
-			hwQuery := DB.Model(&Homework{}).Where("teacher_id = ?", fmt.Sprintf("%v", userId))
+			hwQuery := TenantDB(c).Model(&Homework{}).Where("teacher_id = ?", fmt.Sprintf("%v", userId))
 			if homeworkId != "" {
 				hwQuery = hwQuery.Where("id = ?", homeworkId)
 			}
 			
 			hwQuery.Pluck("id", &homeworkIDs)
 			
-			if len(homeworkIDs) > 0 {
+			visible, _ := StudentScope(c)
+			if homeworkId == "" && len(visible) > 0 {
+				// Without a homework filter, practice of their students counts too
+				if len(homeworkIDs) > 0 {
+					query = query.Where("homework_id IN ? OR student_id IN ?", homeworkIDs, visible)
+				} else {
+					query = query.Where("student_id IN ?", visible)
+				}
+			} else if len(homeworkIDs) > 0 {
 				query = query.Where("homework_id IN ?", homeworkIDs)
 			} else {
 				// No homeworks found for this teacher (or the specific one doesn't belong to them)
 				query = query.Where("1 = 0")
 			}
 		}
+	} else if fmt.Sprintf("%v", role) != string(RoleAdmin) {
+		// Parents see their children's history through GetStudentDetail
+		query = query.Where("1 = 0")
 	}
 
 	query.Count(&total)
 	query.Order("date DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&histories)
 
+	if fmt.Sprintf("%v", role) == string(RoleStudent) {
+		for i := range histories {
+			HideHistoryAnswers(TenantDB(c), &histories[i])
+		}
+	}
+
 	SendJSON(c, 0, "", gin.H{
 		"list":     histories,
 		"total":    total,
@@ -927,65 +1241,71 @@ func CreateHistory(c *gin.Context) {
 	h.StudentID = studentId
 	h.Date = time.Now().Format("2006-01-02 15:04:05")
 
-	if err := DB.Create(&h).Error; err != nil {
-		SendJSON(c, 1, "Failed to create history", nil)
+	// Finalize from the server held attempt log when the run used a session.
+	// Homework is only ever submitted that way.
+	var session PracticeSession
+	if h.SessionID != "" {
+		if err := TenantDB(c).First(&session, "id = ? AND student_id = ? AND status = ?", h.SessionID, studentId, "active").Error; err != nil {
+			SendJSON(c, 1, errSessionFinished.Error(), nil)
+			return
+		}
+		h.HomeworkID = session.HomeworkID
+		h.Questions = sessionResults(session)
+	} else if h.HomeworkID != "" {
+		SendJSON(c, 1, "Homework must be submitted through its practice session", nil)
 		return
 	}
-	
-	// Process Wrong Questions Logic
-	// Questions are stored as []any (serialized JSON)
-	// We need to marshal then unmarshal to access the fields
-	go func(history History) {
-		questionsBytes, _ := json.Marshal(history.Questions)
-		var results []HistoryQuestionResult
-		if err := json.Unmarshal(questionsBytes, &results); err == nil {
-			for _, res := range results {
-				isCorrect := res.Status == "correct"
-				
-				// Calculate wrong attempts
-				wrongCount := 0
-				if len(res.AttemptLog) > 0 {
-					for _, att := range res.AttemptLog {
-						if !att.IsCorrect {
-							wrongCount++
-						}
-					}
-				} else {
-					// Fallback if no detailed log
-					if isCorrect {
-						if res.Attempts > 1 {
-							wrongCount = res.Attempts - 1
-						}
-					} else {
-						wrongCount = res.Attempts
-						if wrongCount == 0 { wrongCount = 1 }
-					}
-				}
 
-				processWrongQuestion(history.StudentID, res.ID, isCorrect, wrongCount)
+	// Never trust client-side scoring: grade against the stored answers
+	results, err := GradeHistory(TenantDB(c), &h)
+	if err != nil {
+		SendJSON(c, 1, "Failed to grade history", nil)
+		return
+	}
+	if len(session.QuestionIDs) > h.Total {
+		h.Total = len(session.QuestionIDs)
+	}
+
+	err = TenantDB(c).Transaction(func(tx *gorm.DB) error {
+		// Only the submission that flips the session from active counts
+		if h.SessionID != "" {
+			res := tx.Model(&PracticeSession{}).Where("id = ? AND status = ?", h.SessionID, "active").Update("status", "finished")
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return errSessionFinished
 			}
 		}
-	}(h)
+		return tx.Create(&h).Error
+	})
+	if errors.Is(err, errSessionFinished) {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		SendJSON(c, 1, "Failed to create history", nil)
+		return
+	}
+	
+	// Process Wrong Questions Logic with server-decided correctness only
+	go func(db *gorm.DB, studentID string, results []HistoryQuestionResult) {
+		for _, res := range results {
+			processWrongQuestion(db, studentID, res.ID, res.Status == "correct", wrongAttempts(res))
+		}
+	}(TenantDB(c), h.StudentID, results)
 
-	AddAuditLog(c, "PRACTICE_FINISH", fmt.Sprintf("Completed session: %s (Score: %d/%s)", h.Name, h.CorrectCount, h.Total))
+	AddAuditLog(c, "PRACTICE_FINISH", fmt.Sprintf("Completed session: %s (Score: %d/%d)", h.Name, h.CorrectCount, h.Total))
+	if role, _ := c.Get("role"); fmt.Sprintf("%v", role) == string(RoleStudent) {
+		HideHistoryAnswers(TenantDB(c), &h)
+	}
 	SendJSON(c, 0, "", h)
 }
 
-// processWrongQuestion implements the Error Logic state machine
-func processWrongQuestion(studentID string, questionID string, isCorrect bool, wrongIncrement int) {
-	var state StudentWrongQuestion
-	// Check if record exists
-	err := DB.Where("student_id = ? AND question_id = ?", studentID, questionID).First(&state).Error
-	exists := err == nil
-
-	now := time.Now().Format("2006-01-02 15:04:05")
-
-	// Load Config
-	var sysConf SystemConfig
-	var conf ErrorLogicConfig
-	// Default hardcoded logic if config missing
-	conf = ErrorLogicConfig{
+func defaultErrorLogicConfig() ErrorLogicConfig {
+	return ErrorLogicConfig{
 		GlobalEnabled: true,
+		ExcludeMistakesFromPractice: false,
 		Stages: map[int]StageConfig{
 			1: {NextWrong: 2, NextCorrect: 4, ShowAnswer: false, Label: "Error"},
 			2: {NextWrong: 3, NextCorrect: 4, ShowAnswer: true, Label: "Retry (Ans)"},
@@ -994,10 +1314,48 @@ func processWrongQuestion(studentID string, questionID string, isCorrect bool, w
 			5: {NextWrong: 5, NextCorrect: 5, ShowAnswer: false, Label: "Difficult"},
 		},
 	}
-	
-	if err := DB.Where("`key` = ?", "error_logic").First(&sysConf).Error; err == nil {
+}
+
+// LoadErrorLogicConfig reads the "error_logic" config, falling back to the
+// hardcoded defaults if it is missing or can't be read. It is cached, see
+// InvalidateSettings.
+func LoadErrorLogicConfig(db *gorm.DB) ErrorLogicConfig {
+	loaded, err := cachedSettingFor(db, "error_logic", func() (any, error) {
+		conf := defaultErrorLogicConfig()
+		var sysConf SystemConfig
+		err := db.Where(&SystemConfig{Key: "error_logic"}).First(&sysConf).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return conf, nil
+		} else if err != nil {
+			return nil, err
+		}
 		json.Unmarshal([]byte(sysConf.Value), &conf)
+		return conf, nil
+	})
+	if err != nil {
+		return defaultErrorLogicConfig()
+	}
+	conf := loaded.(ErrorLogicConfig)
+
+	// Callers get their own stages so the cached ones stay as loaded
+	stages := make(map[int]StageConfig, len(conf.Stages))
+	for k, v := range conf.Stages {
+		stages[k] = v
 	}
+	conf.Stages = stages
+	return conf
+}
+
+// processWrongQuestion implements the Error Logic state machine
+func processWrongQuestion(db *gorm.DB, studentID string, questionID string, isCorrect bool, wrongIncrement int) {
+	var state StudentWrongQuestion
+	// Check if record exists
+	err := db.Where("student_id = ? AND question_id = ?", studentID, questionID).First(&state).Error
+	exists := err == nil
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+
+	conf := LoadErrorLogicConfig(db)
 
 	if !conf.GlobalEnabled {
 		return // Logic disabled
@@ -1015,7 +1373,7 @@ func processWrongQuestion(studentID string, questionID string, isCorrect bool, w
 			}
 			
 			state.LastUpdated = now
-			DB.Save(&state)
+			db.Save(&state)
 		} else if wrongIncrement > 0 {
 			// Correct now, but had errors -> Known (4)
 			newState := StudentWrongQuestion{
@@ -1026,7 +1384,7 @@ func processWrongQuestion(studentID string, questionID string, isCorrect bool, w
 				ErrorCount:  wrongIncrement,
 				LastUpdated: now,
 			}
-			DB.Create(&newState)
+			db.Create(&newState)
 		}
 		return
 	}
@@ -1042,7 +1400,7 @@ func processWrongQuestion(studentID string, questionID string, isCorrect bool, w
 			ErrorCount:  wrongIncrement,
 			LastUpdated: now,
 		}
-		DB.Create(&newState)
+		db.Create(&newState)
 	} else {
 		// Existing Error -> State Transition
 		state.ErrorCount += wrongIncrement
@@ -1054,7 +1412,7 @@ func processWrongQuestion(studentID string, questionID string, isCorrect bool, w
 		} else {
 			state.Status = 1 // Default reset
 		}
-		DB.Save(&state)
+		db.Save(&state)
 	}
 }
 
@@ -1064,7 +1422,7 @@ func GetStudentStats(c *gin.Context) {
 	studentId := fmt.Sprintf("%v", userId)
 
 	var histories []History
-	DB.Where("student_id = ?", studentId).Find(&histories)
+	TenantDB(c).Where("student_id = ?", studentId).Find(&histories)
 
 	practiceTrendMap := make(map[string]struct {
 		Count    int
@@ -1082,7 +1440,7 @@ func GetStudentStats(c *gin.Context) {
 
 	for _, h := range histories {
 		date := strings.Split(h.Date, " ")[0]
-		t, _ := strconv.Atoi(h.Total)
+		t := h.Total
 		
 		if h.Type == "homework" {
 			entry := homeworkTrendMap[date]
@@ -1152,13 +1510,13 @@ func GetTeacherStats(c *gin.Context) {
 	today := time.Now().Format("2006-01-02")
 	
 	var todayAssigned int64
-	DB.Model(&Homework{}).Where("teacher_id = ? AND start_date LIKE ?", teacherId, today+"%").Count(&todayAssigned)
+	TenantDB(c).Model(&Homework{}).Where("teacher_id = ? AND start_date LIKE ?", teacherId, today+"%").Count(&todayAssigned)
 
 	var totalAssigned int64
 	var totalCompleted int64
 	
 	// Calculate totals in one go to ensure consistency
-	DB.Table("homeworks").
+	TenantDB(c).Table("homeworks").
 		Where("teacher_id = ?", teacherId).
 		Select(`
 			COALESCE(SUM(total), 0) as assigned,
@@ -1175,10 +1533,10 @@ func GetTeacherStats(c *gin.Context) {
 		Correct int
 		Total   int
 	}
-	DB.Table("histories").
+	TenantDB(c).Table("histories").
 		Joins("JOIN homeworks ON homeworks.id = histories.homework_id").
 		Where("homeworks.teacher_id = ?", teacherId).
-		Select("SUM(histories.correct_count) as correct, SUM(CAST(histories.total AS UNSIGNED)) as total").
+		Select("SUM(histories.correct_count) as correct, SUM(histories.total) as total").
 		Scan(&results)
 
 	accuracy := 0.0
@@ -1196,7 +1554,7 @@ func GetTeacherStats(c *gin.Context) {
 	}
 	var recentStats []RecentHWStat
 	
-	DB.Table("homeworks").
+	TenantDB(c).Table("homeworks").
 		Select("homeworks.id, homeworks.name, homeworks.start_date as date, homeworks.total, (SELECT COUNT(DISTINCT student_id) FROM histories WHERE histories.homework_id = homeworks.id) as completed").
 		Where("homeworks.teacher_id = ?", teacherId).
 		Order("homeworks.start_date DESC").
@@ -1214,9 +1572,11 @@ func GetTeacherStats(c *gin.Context) {
 		}
 	}
 
-	// Calculate per-student summaries
+	// Calculate per-student summaries for the teacher's own students
 	var students []User
-	DB.Where("role = ?", RoleStudent).Find(&students)
+	if ids := TeacherStudentIDs(TenantDB(c), teacherId); len(ids) > 0 {
+		TenantDB(c).Where("role = ? AND id IN ?", RoleStudent, ids).Find(&students)
+	}
 	
 	studentSummaries := make([]gin.H, 0)
 	for _, s := range students {
@@ -1225,8 +1585,8 @@ func GetTeacherStats(c *gin.Context) {
 			Correct int
 			Total   int
 		}
-		DB.Model(&History{}).
-			Select("SUM(correct_count) as correct, SUM(CAST(total AS UNSIGNED)) as total").
+		TenantDB(c).Model(&History{}).
+			Select("SUM(correct_count) as correct, SUM(total) as total").
 			Where("student_id = ?", s.ID).
 			Scan(&res)
 		
@@ -1240,16 +1600,16 @@ func GetTeacherStats(c *gin.Context) {
 		var hwCompleted int64
 
 		// Count homeworks where the student is targeted
-		DB.Model(&Homework{}).
+		TenantDB(c).Model(&Homework{}).
 			Where("teacher_id = ? AND student_ids LIKE ?", teacherId, "%\""+s.ID+"\"%").
 			Count(&hwAssigned)
 
 		// Count unique homeworks the student has submitted (latest only)
-		DB.Table("histories").
+		TenantDB(c).Table("histories").
 			Select("COUNT(DISTINCT homework_id)").
 			Where("student_id = ? AND homework_id != ''", s.ID).
 			Where("id IN (?)",
-				DB.Table("histories").
+				TenantDB(c).Table("histories").
 					Select("MAX(id)").
 					Where("student_id = ?", s.ID).
 					Group("homework_id"),
@@ -1278,7 +1638,7 @@ func GetTeacherStats(c *gin.Context) {
 // Admin Handlers
 func AdminGetHomeworks(c *gin.Context) {
 	hws := make([]Homework, 0)
-	DB.Find(&hws)
+	TenantDB(c).Find(&hws)
 
 	type HomeworkDetail struct {
 		ID          string `json:"id"`
@@ -1295,18 +1655,24 @@ func AdminGetHomeworks(c *gin.Context) {
 	res := make([]HomeworkDetail, 0)
 	for _, h := range hws {
 		var teacher User
-		DB.First(&teacher, "id = ?", h.TeacherID)
+		TenantDB(c).First(&teacher, "id = ?", h.TeacherID)
+
+		className := h.ClassID
+		var cls Class
+		if h.ClassID != "" && TenantDB(c).First(&cls, "id = ?", h.ClassID).Error == nil {
+			className = cls.Name
+		}
 		
 		var histories []History
-		DB.Where("homework_id = ?", h.ID).Find(&histories)
+		TenantDB(c).Where("homework_id = ?", h.ID).Find(&histories)
 		
 		var count int64
-		DB.Model(&History{}).Where("homework_id = ?", h.ID).Distinct("student_id").Count(&count)
+		TenantDB(c).Model(&History{}).Where("homework_id = ?", h.ID).Distinct("student_id").Count(&count)
 
 		results := make([]any, 0)
 		for _, rec := range histories {
 			var student User
-			DB.First(&student, "id = ?", rec.StudentID)
+			TenantDB(c).First(&student, "id = ?", rec.StudentID)
 			
 			results = append(results, gin.H{
 				"id": rec.ID,
@@ -1322,7 +1688,7 @@ func AdminGetHomeworks(c *gin.Context) {
 			ID:          h.ID,
 			Name:        h.Name,
 			TeacherName: teacher.Username,
-			ClassName:   h.ClassID,
+			ClassName:   className,
 			StartDate:   h.StartDate,
 			Total:       h.Total,
 			Completed:   int(count),
@@ -1335,7 +1701,7 @@ func AdminGetHomeworks(c *gin.Context) {
 
 func AdminGetPractices(c *gin.Context) {
 	histories := make([]History, 0)
-	DB.Find(&histories)
+	TenantDB(c).Find(&histories)
 
 	type PracticeDetail struct {
 		ID          string `json:"id"`
@@ -1350,10 +1716,10 @@ func AdminGetPractices(c *gin.Context) {
 	res := make([]PracticeDetail, 0)
 	for _, h := range histories {
 		var student User
-		DB.First(&student, "id = ?", h.StudentID)
+		TenantDB(c).First(&student, "id = ?", h.StudentID)
 		
 		acc := "0%"
-		total, _ := strconv.Atoi(h.Total)
+		total := h.Total
 		if total > 0 {
 			acc = strconv.Itoa(int((float64(h.CorrectCount)/float64(total))*100)) + "%"
 		}
@@ -1374,7 +1740,7 @@ func AdminGetPractices(c *gin.Context) {
 // Reinforcement Handlers
 func GetReinforcements(c *gin.Context) {
 	list := make([]Reinforcement, 0)
-	DB.Find(&list)
+	TenantDB(c).Find(&list)
 	SendJSON(c, 0, "", list)
 }
 
@@ -1384,30 +1750,54 @@ func CreateReinforcement(c *gin.Context) {
 		SendJSON(c, 1, err.Error(), nil)
 		return
 	}
+	owner, err := newOwnership(c, r.Ownership)
+	if err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
+	r.Ownership = owner
 	r.ID = strconv.FormatInt(time.Now().UnixNano(), 36)
-	DB.Create(&r)
+	TenantDB(c).Create(&r)
 	SendJSON(c, 0, "", r)
 }
 
 func UpdateReinforcement(c *gin.Context) {
 	id := c.Param("id")
-	var r Reinforcement
-	if err := DB.First(&Reinforcement{}, "id = ?", id).Error; err != nil {
+	var existing Reinforcement
+	if err := TenantDB(c).First(&existing, "id = ?", id).Error; err != nil {
 		SendJSON(c, 1, "Reinforcement not found", nil)
 		return
 	}
+	if !RequireContentEdit(c, existing.Ownership) {
+		return
+	}
+	var r Reinforcement
 	if err := c.ShouldBindJSON(&r); err != nil {
 		SendJSON(c, 1, err.Error(), nil)
 		return
 	}
+	requested := r.Ownership
+	r.Ownership = existing.Ownership
+	if err := shareContent(c, &r.Ownership, requested); err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
 	r.ID = id
-	DB.Save(&r)
+	TenantDB(c).Save(&r)
 	SendJSON(c, 0, "", r)
 }
 
 func DeleteReinforcement(c *gin.Context) {
 	id := c.Param("id")
-	DB.Delete(&Reinforcement{}, "id = ?", id)
+	var r Reinforcement
+	if err := TenantDB(c).First(&r, "id = ?", id).Error; err != nil {
+		SendJSON(c, 1, "Reinforcement not found", nil)
+		return
+	}
+	if !RequireContentOwner(c, r.Ownership) {
+		return
+	}
+	TenantDB(c).Delete(&Reinforcement{}, "id = ?", id)
 	SendJSON(c, 0, "", gin.H{"message": "Deleted"})
 }
 
@@ -1421,9 +1811,9 @@ func GetResources(c *gin.Context) {
 	list := make([]Resource, 0)
 	var total int64
 	
-	query := DB.Model(&Resource{}).Where("visibility = 'public' OR creator_id = ?", fmt.Sprintf("%v", userId))
+	query := TenantDB(c).Model(&Resource{}).Where("visibility = 'public' OR creator_id = ?", fmt.Sprintf("%v", userId))
 	if keyword != "" {
-		query = query.Where("LOWER(name) LIKE ? OR JSON_CONTAINS(LOWER(tags), ?)", "%"+keyword+"%", "\""+keyword+"\"")
+		query = query.Where("LOWER(name) LIKE ? OR LOWER(tags) LIKE ?", "%"+keyword+"%", "%\""+keyword+"\"%")
 	}
 	
 	query.Count(&total)
@@ -1446,10 +1836,25 @@ func CreateResource(c *gin.Context) {
 
 	userId, _ := c.Get("userId")
 
-	if strings.HasPrefix(r.URL, "data:image") {
-		url, err := UploadBase64ToOSS(r.URL)
-		if err == nil { r.URL = url }
+	if r.AssetID != "" {
+		asset, err := resolveAsset(TenantDB(c), r.AssetID, "")
+		if err != nil {
+			SendJSON(c, 1, err.Error(), nil)
+			return
+		}
+		r.URL = asset.URL
+		r.ThumbnailURL = asset.ThumbnailURL
+		if r.Type == "" {
+			r.Type = asset.Kind
+		}
+	}
+
+	url, err := UploadDataURL(r.URL, "resources")
+	if err != nil {
+		SendJSON(c, 1, "Failed to upload resource: "+err.Error(), nil)
+		return
 	}
+	r.URL = url
 
 	r.ID = strconv.FormatInt(time.Now().UnixNano(), 36)
 	r.CreatorID = fmt.Sprintf("%v", userId)
@@ -1457,7 +1862,7 @@ func CreateResource(c *gin.Context) {
 	if r.Type == "" { r.Type = "image" }
 	if r.Tags == nil { r.Tags = make([]string, 0) }
 
-	DB.Create(&r)
+	TenantDB(c).Create(&r)
 	AddAuditLog(c, "CREATE_RESOURCE", fmt.Sprintf("Uploaded resource: %s", r.Name))
 	SendJSON(c, 0, "", r)
 }
@@ -1467,7 +1872,7 @@ func UpdateResource(c *gin.Context) {
 	userId, _ := c.Get("userId")
 
 	var r Resource
-	if err := DB.First(&r, "id = ? AND creator_id = ?", id, fmt.Sprintf("%v", userId)).Error; err != nil {
+	if err := TenantDB(c).First(&r, "id = ? AND creator_id = ?", id, fmt.Sprintf("%v", userId)).Error; err != nil {
 		SendJSON(c, 1, "Resource not found or permission denied", nil)
 		return
 	}
@@ -1481,7 +1886,7 @@ func UpdateResource(c *gin.Context) {
 	r.Name = updateData.Name
 	r.Visibility = updateData.Visibility
 	r.Tags = updateData.Tags
-	DB.Save(&r)
+	TenantDB(c).Save(&r)
 	SendJSON(c, 0, "", r)
 }
 
@@ -1489,7 +1894,7 @@ func DeleteResource(c *gin.Context) {
 	id := c.Param("id")
 	userId, _ := c.Get("userId")
 	
-	if err := DB.Delete(&Resource{}, "id = ? AND creator_id = ?", id, fmt.Sprintf("%v", userId)).Error; err != nil {
+	if err := TenantDB(c).Delete(&Resource{}, "id = ? AND creator_id = ?", id, fmt.Sprintf("%v", userId)).Error; err != nil {
 		SendJSON(c, 1, "Failed to delete resource", nil)
 		return
 	}
@@ -1499,7 +1904,7 @@ func DeleteResource(c *gin.Context) {
 // Audit Log Handlers
 func GetAuditLogs(c *gin.Context) {
 	var logs []AuditLog
-	DB.Order("timestamp DESC").Limit(50).Find(&logs)
+	TenantDB(c).Order("timestamp DESC").Limit(50).Find(&logs)
 	SendJSON(c, 0, "", logs)
 }
 
@@ -1510,7 +1915,7 @@ func AddAuditLog(c *gin.Context, action, details string) {
 	username := "system"
 	if uid, ok := userId.(string); ok {
 		var user User
-		if err := DB.First(&user, "id = ?", uid).Error; err == nil {
+		if err := TenantDB(c).First(&user, "id = ?", uid).Error; err == nil {
 			username = user.Username
 		} else {
 			username = "UID:" + uid
@@ -1528,7 +1933,7 @@ func AddAuditLog(c *gin.Context, action, details string) {
 		Timestamp: time.Now().Format("2006-01-02 15:04:05"),
 	}
 	
-	DB.Create(&log)
+	TenantDB(c).Create(&log)
 	fmt.Printf("[AUDIT] %s | %s | %s\n", log.Username, log.Action, log.Details)
 }
 
@@ -1540,41 +1945,50 @@ func GetWrongBook(c *gin.Context) {
 
 	targetStudentId := c.Query("studentId")
 	
-	query := DB.Preload("Question").Where("status != 4")
+	query := TenantDB(c).Preload("Question").Where("status != 4")
 
 	if fmt.Sprintf("%v", role) == string(RoleStudent) {
 		query = query.Where("student_id = ?", fmt.Sprintf("%v", requesterId))
 	} else {
 		// Teacher/Admin
 		if targetStudentId != "" {
+			if !RequireStudentAccess(c, targetStudentId) {
+				return
+			}
 			query = query.Where("student_id = ?", targetStudentId)
+		} else if ids, all := StudentScope(c); !all {
+			// If empty, return every student the teacher can see
+			if len(ids) == 0 {
+				SendJSON(c, 0, "", []StudentWrongQuestion{})
+				return
+			}
+			query = query.Where("student_id IN ?", ids)
 		}
-		// If empty, return all (Teacher view)
 	}
 
 	var wrongs []StudentWrongQuestion
 	query.Find(&wrongs)
 
+	// Students, and their parents, only see answers their current stage allows
+	if r := fmt.Sprintf("%v", role); r == string(RoleStudent) || r == string(RoleParent) {
+		conf := LoadErrorLogicConfig(TenantDB(c))
+		for i := range wrongs {
+			if stage, ok := conf.Stages[wrongs[i].Status]; !ok || !stage.ShowAnswer {
+				wrongs[i].Question.Answer = ""
+				wrongs[i].Question.Hint = ""
+			}
+		}
+	}
+
 	SendJSON(c, 0, "", wrongs)
 }
 
 // Admin Config Handlers
 func GetSystemConfig(c *gin.Context) {
 	var conf SystemConfig
-	if err := DB.Where("`key` = ?", "error_logic").First(&conf).Error; err != nil {
+	if err := TenantDB(c).Where(&SystemConfig{Key: "error_logic"}).First(&conf).Error; err != nil {
 		// Return default if not found
-		defaultConf := ErrorLogicConfig{
-			GlobalEnabled: true,
-			ExcludeMistakesFromPractice: false,
-			Stages: map[int]StageConfig{
-				1: {NextWrong: 2, NextCorrect: 4, ShowAnswer: false, Label: "Error"},
-				2: {NextWrong: 3, NextCorrect: 4, ShowAnswer: true, Label: "Retry (Ans)"},
-				3: {NextWrong: 5, NextCorrect: 4, ShowAnswer: false, Label: "Retry (No Ans)"},
-				4: {NextWrong: 1, NextCorrect: 4, ShowAnswer: false, Label: "Known"},
-				5: {NextWrong: 5, NextCorrect: 5, ShowAnswer: false, Label: "Difficult"},
-			},
-		}
-		SendJSON(c, 0, "", defaultConf)
+		SendJSON(c, 0, "", defaultErrorLogicConfig())
 		return
 	}
 	
@@ -1599,65 +2013,72 @@ func UpdateSystemConfig(c *gin.Context) {
 	
 	// Create or Update
 	var conf SystemConfig
-	if err := DB.Where("`key` = ?", "error_logic").First(&conf).Error; err != nil {
+	if err := TenantDB(c).Where(&SystemConfig{Key: "error_logic"}).First(&conf).Error; err != nil {
 		conf = SystemConfig{
 			Key: "error_logic",
 			Value: string(confJSON),
 		}
-		DB.Create(&conf)
+		TenantDB(c).Create(&conf)
 	} else {
 		conf.Value = string(confJSON)
-		DB.Save(&conf)
+		TenantDB(c).Save(&conf)
 	}
+	InvalidateSettings(TenantID(c))
 	
 	SendJSON(c, 0, "", parsedConf)
 }
 
 func GetSystemSettings(c *gin.Context) {
-	var conf SystemConfig
-	var settings SystemSettingsConfig
-	
-	// Default settings
-	settings.RegistrationEnabled = false 
-
-	if err := DB.Where("`key` = ?", "system_settings").First(&conf).Error; err == nil {
-		json.Unmarshal([]byte(conf.Value), &settings)
-	}
-	SendJSON(c, 0, "", settings)
+	SendJSON(c, 0, "", loadSystemSettings(TenantDB(c)))
 }
 
 func UpdateSystemSettings(c *gin.Context) {
-	var settings SystemSettingsConfig
+	// Fields left out of the request keep their current values
+	settings, err := readSystemSettings(TenantDB(c))
+	if err != nil {
+		SendJSON(c, 1, "Failed to load settings", nil)
+		return
+	}
 	if err := c.ShouldBindJSON(&settings); err != nil {
 		SendJSON(c, 1, err.Error(), nil)
 		return
 	}
+	if err := settings.PasswordPolicy.Validate(); err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
 
 	confJSON, _ := json.Marshal(settings)
 	
 	var conf SystemConfig
-	if err := DB.Where("`key` = ?", "system_settings").First(&conf).Error; err != nil {
+	if err := TenantDB(c).Where(&SystemConfig{Key: "system_settings"}).First(&conf).Error; err != nil {
 		conf = SystemConfig{
 			Key: "system_settings",
 			Value: string(confJSON),
 		}
-		DB.Create(&conf)
+		TenantDB(c).Create(&conf)
 	} else {
 		conf.Value = string(confJSON)
-		DB.Save(&conf)
+		TenantDB(c).Save(&conf)
 	}
+	InvalidateSettings(TenantID(c))
 	SendJSON(c, 0, "", settings)
 }
 
+// GetPublicConfig returns the settings of the school given by ?tenant=<code>,
+// the default school without one
 func GetPublicConfig(c *gin.Context) {
-	var conf SystemConfig
-	var settings SystemSettingsConfig
-	if err := DB.Where("`key` = ?", "system_settings").First(&conf).Error; err == nil {
-		json.Unmarshal([]byte(conf.Value), &settings)
+	tenant, err := TenantByCode(c.Query("tenant"))
+	if err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
 	}
+	settings := loadSystemSettings(ForTenant(tenant.ID))
 	// Only return public safe config
 	SendJSON(c, 0, "", gin.H{
 		"registrationEnabled": settings.RegistrationEnabled,
+		"passwordPolicy":      settings.PasswordPolicy,
+		"ssoEnabled":          AppSSO != nil,
 	})
 }
 
@@ -1665,13 +2086,13 @@ func GetPublicConfig(c *gin.Context) {
 func GetMyPermissions(c *gin.Context) {
 	role, _ := c.Get("role")
 	var perms []RolePermission
-	DB.Where("role = ?", role).Find(&perms)
+	TenantDB(c).Where("role = ?", role).Find(&perms)
 	SendJSON(c, 0, "", perms)
 }
 
 func GetRolePermissions(c *gin.Context) {
 	var perms []RolePermission
-	DB.Find(&perms)
+	TenantDB(c).Find(&perms)
 	SendJSON(c, 0, "", perms)
 }
 
@@ -1682,14 +2103,26 @@ func UpdateRolePermissions(c *gin.Context) {
 		return
 	}
 
-	// Simple way: clear and replace
-	// Production might use a more surgical update
-	DB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&RolePermission{})
-	if err := DB.Create(&perms).Error; err != nil {
+	if err := validateRolePermissions(c, perms); err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
+
+	// Only the rules that differ are written, all or none of them
+	var changes []string
+	err := TenantDB(c).Transaction(func(tx *gorm.DB) error {
+		var err error
+		changes, err = replaceRolePermissions(tx, perms)
+		return err
+	})
+	if err != nil {
 		SendJSON(c, 1, "Failed to update permissions", nil)
 		return
 	}
+	InvalidateSettings(TenantID(c))
 
-	AddAuditLog(c, "UPDATE_PERMISSIONS", fmt.Sprintf("Updated %d permission rules", len(perms)))
+	if len(changes) > 0 {
+		AddAuditLog(c, "UPDATE_PERMISSIONS", "Changed permissions: "+strings.Join(changes, "; "))
+	}
 	SendJSON(c, 0, "", perms)
 }
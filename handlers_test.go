@@ -18,24 +18,27 @@ func TestMain(m *testing.M) {
 	gin.SetMode(gin.TestMode)
 
 	// Use SQLite in-memory for testing
-	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
 	if err != nil {
 		panic("failed to connect database")
 	}
 
+	if err := RegisterTenantScoping(db); err != nil {
+		panic(err)
+	}
+
 	// Migrate the schema
-	db.AutoMigrate(
-		&User{},
-		&Question{},
-		&Paper{},
-		&Homework{},
-		&History{},
-		&Reinforcement{},
-		&Resource{},
-		&AuditLog{},
-	)
+	if err := MigrateUp(db, 0); err != nil {
+		panic("failed to migrate database: " + err.Error())
+	}
 	DB = db
 
+	keys, err := GenerateSigningKey("HS256")
+	if err != nil {
+		panic(err)
+	}
+	AppKeys = &KeySet{active: keys.Kid, keys: map[string]*SigningKey{keys.Kid: keys}}
+
 	os.Exit(m.Run())
 }
 
@@ -171,23 +174,37 @@ func TestPapers(t *testing.T) {
 
 func TestHomeworks(t *testing.T) {
 	DB.Exec("DELETE FROM homeworks")
+	DB.Exec("DELETE FROM classes")
+	DB.Exec("DELETE FROM papers")
+	DB.Create(&Class{ID: "k1", Name: "1A", TeacherIDs: []string{"2"}, StudentIDs: []string{"s1"}})
+	DB.Create(&Paper{ID: "p1", Name: "Paper 1"})
+	DB.Create(&Paper{ID: "p2", TenantID: "school2", Name: "Other school's paper"})
 
 	r := gin.Default()
 	r.GET("/homeworks", GetHomeworks)
 	r.POST("/homeworks/assign", func(c *gin.Context) {
 		c.Set("userId", "2")
+		c.Set("role", RoleTeacher)
+		c.Set("tenantId", defaultTenantID)
 		AssignHomework(c)
 	})
 
-	h := Homework{Name: "HW1", PaperID: "p1", StudentIDs: []string{"s1"}}
-	body, _ := json.Marshal(h)
-	req, _ := http.NewRequest("POST", "/homeworks/assign", bytes.NewBuffer(body))
-	w := httptest.NewRecorder()
-	r.ServeHTTP(w, req)
-	assert.Equal(t, http.StatusOK, w.Code)
+	assign := func(paperID string) int {
+		body, _ := json.Marshal(Homework{Name: "HW1", PaperID: paperID, StudentIDs: []string{"s1"}})
+		req, _ := http.NewRequest("POST", "/homeworks/assign", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Code
+	}
+	// Only the school's own papers can be assigned
+	assert.Equal(t, 1, assign("missing"))
+	assert.Equal(t, 1, assign("p2"))
+	assert.Equal(t, 0, assign("p1"))
 
-	req, _ = http.NewRequest("GET", "/homeworks", nil)
-	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/homeworks", nil)
+	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusOK, w.Code)
 
@@ -212,7 +229,7 @@ func TestUsers(t *testing.T) {
 	r.DELETE("/users/:id", DeleteUser)
 
 	// Create
-	u := User{Username: "testuser", Role: RoleStudent, Password: "123"}
+	u := User{Username: "testuser", Role: RoleStudent, Password: "start2024"}
 	body, _ := json.Marshal(u)
 	req, _ := http.NewRequest("POST", "/users", bytes.NewBuffer(body))
 	w := httptest.NewRecorder()
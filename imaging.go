@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Image pipeline settings. Phone photos are capped at maxImageWidth and
+// recompressed; smaller copies are produced for every imageWidths entry below
+// the source width, plus a thumbnail for resource listings.
+const (
+	maxImageWidth  = 1920
+	thumbnailWidth = 240
+	jpegQuality    = 82
+	maxImagePixels = 50_000_000 // refuse to decode anything bigger (decompression bombs)
+)
+
+var imageWidths = []int{320, 640, 1280}
+
+// processedImage is one encoded output of the pipeline
+type processedImage struct {
+	Name      string
+	Width     int
+	Height    int
+	Data      []byte
+	MimeType  string
+	Extension string
+}
+
+var errImageTooLarge = errors.New("image dimensions are too large")
+
+// ProcessImage decodes an uploaded image, applies and drops its EXIF
+// orientation and re-encodes it. The first result is the main image, followed
+// by the width variants and the thumbnail. Animated GIFs are returned as-is
+// since re-encoding would drop every frame but the first.
+func ProcessImage(data []byte) ([]processedImage, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, errImageTooLarge
+	}
+
+	if format == "gif" {
+		if g, err := gif.DecodeAll(bytes.NewReader(data)); err == nil && len(g.Image) > 1 {
+			return []processedImage{{Name: "original", Width: cfg.Width, Height: cfg.Height, Data: data, MimeType: "image/gif", Extension: "gif"}}, nil
+		}
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	if format == "jpeg" {
+		src = applyOrientation(src, exifOrientation(data))
+	}
+
+	encode := encodeJPEG
+	if !isOpaque(src) {
+		encode = encodePNG
+	}
+
+	width := src.Bounds().Dx()
+	mainWidth := width
+	if mainWidth > maxImageWidth {
+		mainWidth = maxImageWidth
+	}
+
+	outputs := make([]processedImage, 0, len(imageWidths)+2)
+	add := func(name string, w int) error {
+		img := resizeToWidth(src, w)
+		out, err := encode(img)
+		if err != nil {
+			return fmt.Errorf("encode %s: %w", name, err)
+		}
+		out.Name = name
+		out.Width = img.Bounds().Dx()
+		out.Height = img.Bounds().Dy()
+		outputs = append(outputs, out)
+		return nil
+	}
+
+	if err := add("original", mainWidth); err != nil {
+		return nil, err
+	}
+	for _, w := range imageWidths {
+		if w < mainWidth {
+			if err := add(fmt.Sprintf("w%d", w), w); err != nil {
+				return nil, err
+			}
+		}
+	}
+	thumb := thumbnailWidth
+	if thumb > width {
+		thumb = width
+	}
+	if err := add("thumb", thumb); err != nil {
+		return nil, err
+	}
+	return outputs, nil
+}
+
+// SrcSet builds an HTML srcset value from the width variants, smallest first
+func SrcSet(variants []ImageVariant) string {
+	sorted := make([]ImageVariant, 0, len(variants))
+	for _, v := range variants {
+		if v.Name != "thumb" {
+			sorted = append(sorted, v)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Width < sorted[j].Width })
+
+	parts := make([]string, 0, len(sorted))
+	for _, v := range sorted {
+		parts = append(parts, fmt.Sprintf("%s %dw", v.URL, v.Width))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func resizeToWidth(src image.Image, width int) image.Image {
+	b := src.Bounds()
+	if width >= b.Dx() {
+		dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+		return dst
+	}
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	return dst
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+func encodeJPEG(img image.Image) (processedImage, error) {
+	// JPEG has no alpha channel, flatten onto white
+	b := img.Bounds()
+	flat := image.NewRGBA(b)
+	draw.Draw(flat, b, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, b, img, b.Min, draw.Over)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return processedImage{}, err
+	}
+	return processedImage{Data: buf.Bytes(), MimeType: "image/jpeg", Extension: "jpg"}, nil
+}
+
+func encodePNG(img image.Image) (processedImage, error) {
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := enc.Encode(&buf, img); err != nil {
+		return processedImage{}, err
+	}
+	return processedImage{Data: buf.Bytes(), MimeType: "image/png", Extension: "png"}, nil
+}
+
+// exifOrientation reads the EXIF orientation tag (1-8) of a JPEG, 1 when absent
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return 1
+		}
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 { // image data starts, no EXIF found
+			return 1
+		}
+		size := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + size
+		if size < 2 || end > len(data) {
+			return 1
+		}
+		if marker == 0xE1 && bytes.HasPrefix(data[pos+4:end], []byte("Exif\x00\x00")) {
+			return tiffOrientation(data[pos+10 : end])
+		}
+		pos = end
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if v := int(order.Uint16(tiff[entry+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// applyOrientation rotates/flips img so it displays upright for EXIF orientation o
+func applyOrientation(img image.Image, o int) image.Image {
+	if o <= 1 || o > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch o {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
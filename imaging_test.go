@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testImage draws a w x h image whose top-left pixel is red and the rest blue
+func testImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{B: 255, A: 255})
+		}
+	}
+	img.Set(0, 0, color.NRGBA{R: 255, A: 255})
+	return img
+}
+
+func testPNG(w, h int) []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, testImage(w, h))
+	return buf.Bytes()
+}
+
+// testJPEGWithOrientation encodes a JPEG and inserts an EXIF APP1 segment
+// carrying the given orientation right after the SOI marker
+func testJPEGWithOrientation(w, h, orientation int) []byte {
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, testImage(w, h), &jpeg.Options{Quality: 95})
+	data := buf.Bytes()
+
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 1) // one IFD entry
+	tiff = binary.BigEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3) // SHORT
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(orientation))
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func TestExifOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"Upright", testJPEGWithOrientation(4, 2, 1), 1},
+		{"Rotate 90", testJPEGWithOrientation(4, 2, 6), 6},
+		{"Rotate 180", testJPEGWithOrientation(4, 2, 3), 3},
+		{"Not a JPEG", testPNG(4, 2), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, exifOrientation(tt.data))
+		})
+	}
+}
+
+func TestProcessImage(t *testing.T) {
+	t.Run("Large photo is capped, resized and thumbnailed", func(t *testing.T) {
+		outputs, err := ProcessImage(testPNG(2400, 1200))
+		assert.NoError(t, err)
+
+		widths := make(map[string]int)
+		for _, out := range outputs {
+			widths[out.Name] = out.Width
+			assert.Equal(t, "image/jpeg", out.MimeType, "opaque images are recompressed as JPEG")
+		}
+		assert.Equal(t, map[string]int{"original": 1920, "w320": 320, "w640": 640, "w1280": 1280, "thumb": 240}, widths)
+		assert.Equal(t, 960, outputs[0].Height)
+	})
+
+	t.Run("Small image is not upscaled", func(t *testing.T) {
+		outputs, err := ProcessImage(testPNG(100, 50))
+		assert.NoError(t, err)
+		assert.Len(t, outputs, 2)
+		assert.Equal(t, 100, outputs[0].Width)
+		assert.Equal(t, "thumb", outputs[1].Name)
+		assert.Equal(t, 100, outputs[1].Width)
+	})
+
+	t.Run("EXIF orientation is applied and stripped", func(t *testing.T) {
+		outputs, err := ProcessImage(testJPEGWithOrientation(40, 20, 6))
+		assert.NoError(t, err)
+		main := outputs[0]
+		assert.Equal(t, 20, main.Width)
+		assert.Equal(t, 40, main.Height)
+		assert.Equal(t, 1, exifOrientation(main.Data))
+		assert.False(t, bytes.Contains(main.Data, []byte("Exif\x00\x00")))
+	})
+
+	t.Run("Transparent image stays PNG", func(t *testing.T) {
+		img := testImage(10, 10)
+		img.Set(5, 5, color.NRGBA{})
+		var buf bytes.Buffer
+		png.Encode(&buf, img)
+
+		outputs, err := ProcessImage(buf.Bytes())
+		assert.NoError(t, err)
+		assert.Equal(t, "image/png", outputs[0].MimeType)
+	})
+
+	t.Run("Garbage is rejected", func(t *testing.T) {
+		_, err := ProcessImage([]byte("not an image"))
+		assert.Error(t, err)
+	})
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// The red marker starts at the top-left corner of a 4x2 image
+	tests := []struct {
+		orientation int
+		w, h        int
+		redX, redY  int
+	}{
+		{1, 4, 2, 0, 0},
+		{2, 4, 2, 3, 0},
+		{3, 4, 2, 3, 1},
+		{4, 4, 2, 0, 1},
+		{5, 2, 4, 0, 0},
+		{6, 2, 4, 1, 0},
+		{7, 2, 4, 1, 3},
+		{8, 2, 4, 0, 3},
+	}
+	for _, tt := range tests {
+		out := applyOrientation(testImage(4, 2), tt.orientation)
+		assert.Equal(t, tt.w, out.Bounds().Dx(), "orientation %d", tt.orientation)
+		assert.Equal(t, tt.h, out.Bounds().Dy(), "orientation %d", tt.orientation)
+		r, _, _, _ := out.At(tt.redX, tt.redY).RGBA()
+		assert.Equal(t, uint32(0xffff), r, "orientation %d", tt.orientation)
+	}
+}
+
+func TestSrcSet(t *testing.T) {
+	variants := []ImageVariant{
+		{Name: "original", Width: 1920, URL: "/a.jpg"},
+		{Name: "w640", Width: 640, URL: "/a_w640.jpg"},
+		{Name: "thumb", Width: 240, URL: "/a_thumb.jpg"},
+		{Name: "w320", Width: 320, URL: "/a_w320.jpg"},
+	}
+	assert.Equal(t, "/a_w320.jpg 320w, /a_w640.jpg 640w, /a.jpg 1920w", SrcSet(variants))
+}
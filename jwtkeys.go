@@ -0,0 +1,444 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Token signing keys live in a JSON key file (JWT_KEYS_FILE). The active key
+// signs new tokens; every key in the file verifies, so after `keys rotate`
+// tokens signed with the previous key stay valid until `keys remove`. Running
+// servers re-read the file every keyReloadInterval, see Watch.
+// RS256 and EdDSA keys publish their public half at /.well-known/jwks.json so
+// other services can verify our tokens without the secret.
+
+// AppKeys is the key set loaded at startup
+var AppKeys *KeySet
+
+// signingMethods maps the supported "alg" values to their jwt methods
+var signingMethods = map[string]jwt.SigningMethod{
+	"HS256": jwt.SigningMethodHS256,
+	"RS256": jwt.SigningMethodRS256,
+	"EdDSA": jwt.SigningMethodEdDSA,
+}
+
+// minKeyReload limits how often an unknown kid triggers a key file reload
+const minKeyReload = 10 * time.Second
+
+// keyReloadInterval is how often a running server re-reads its key file
+const keyReloadInterval = time.Minute
+
+// SigningKey is one entry of the key file. Secrets are base64, private keys PKCS#8 PEM.
+type SigningKey struct {
+	Kid        string `json:"kid"`
+	Alg        string `json:"alg"` // "HS256", "RS256" or "EdDSA"
+	Secret     string `json:"secret,omitempty"`
+	PrivateKey string `json:"privateKey,omitempty"`
+	CreatedAt  string `json:"createdAt"`
+
+	signKey   any
+	verifyKey any
+}
+
+type keyFile struct {
+	Active string        `json:"active"`
+	Keys   []*SigningKey `json:"keys"`
+}
+
+// KeySet signs and verifies tokens with the keys of one key file
+type KeySet struct {
+	mu         sync.RWMutex
+	path       string // empty when keys come from JWT_SECRET
+	active     string
+	keys       map[string]*SigningKey
+	lastReload time.Time
+}
+
+// load parses the key material of k
+func (k *SigningKey) load() error {
+	switch k.Alg {
+	case "HS256":
+		secret, err := base64.StdEncoding.DecodeString(k.Secret)
+		if err != nil || len(secret) < 32 {
+			return fmt.Errorf("key %s: HS256 secret must be at least 32 base64 encoded bytes", k.Kid)
+		}
+		k.signKey, k.verifyKey = secret, secret
+	case "RS256", "EdDSA":
+		block, _ := pem.Decode([]byte(k.PrivateKey))
+		if block == nil {
+			return fmt.Errorf("key %s: invalid PEM private key", k.Kid)
+		}
+		priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("key %s: %w", k.Kid, err)
+		}
+		switch p := priv.(type) {
+		case *rsa.PrivateKey:
+			if k.Alg != "RS256" {
+				return fmt.Errorf("key %s: RSA key used with %s", k.Kid, k.Alg)
+			}
+			k.signKey, k.verifyKey = p, &p.PublicKey
+		case ed25519.PrivateKey:
+			if k.Alg != "EdDSA" {
+				return fmt.Errorf("key %s: Ed25519 key used with %s", k.Kid, k.Alg)
+			}
+			k.signKey, k.verifyKey = p, p.Public()
+		default:
+			return fmt.Errorf("key %s: unsupported private key type %T", k.Kid, priv)
+		}
+	default:
+		return fmt.Errorf("key %s: unsupported alg %q", k.Kid, k.Alg)
+	}
+	return nil
+}
+
+// GenerateSigningKey creates a new key for alg
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	now := time.Now()
+	k := &SigningKey{
+		Kid:       strconv.FormatInt(now.UnixNano(), 36),
+		Alg:       alg,
+		CreatedAt: now.Format(timeLayout),
+	}
+
+	var priv any
+	switch alg {
+	case "HS256":
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		k.Secret = base64.StdEncoding.EncodeToString(secret)
+	case "RS256":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		priv = key
+	case "EdDSA":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		priv = key
+	default:
+		return nil, fmt.Errorf("unsupported alg %q (use HS256, RS256 or EdDSA)", alg)
+	}
+
+	if priv != nil {
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		k.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	}
+	return k, k.load()
+}
+
+// LoadKeySetFromEnv uses JWT_SECRET when set (a single HS256 key that cannot
+// be rotated), otherwise the key file at JWT_KEYS_FILE, creating it with a
+// JWT_ALG key (default HS256) on first start.
+func LoadKeySetFromEnv() (*KeySet, error) {
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		if len(secret) < 32 {
+			return nil, errors.New("JWT_SECRET must be at least 32 characters")
+		}
+		k := &SigningKey{Kid: "env", Alg: "HS256", signKey: []byte(secret), verifyKey: []byte(secret)}
+		return &KeySet{active: k.Kid, keys: map[string]*SigningKey{k.Kid: k}}, nil
+	}
+
+	path := getEnv("JWT_KEYS_FILE", "jwt_keys.json")
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		ks := &KeySet{path: path, keys: make(map[string]*SigningKey)}
+		if _, err := ks.Rotate(getEnv("JWT_ALG", "HS256")); err != nil {
+			return nil, fmt.Errorf("create key file: %w", err)
+		}
+		return ks, nil
+	}
+	return LoadKeySet(path)
+}
+
+// LoadKeySet reads a key file
+func LoadKeySet(path string) (*KeySet, error) {
+	ks := &KeySet{path: path}
+	if err := ks.reload(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+func (ks *KeySet) reload() error {
+	data, err := os.ReadFile(ks.path)
+	if err != nil {
+		return err
+	}
+	var f keyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("parse %s: %w", ks.path, err)
+	}
+	keys := make(map[string]*SigningKey)
+	for _, k := range f.Keys {
+		if err := k.load(); err != nil {
+			return err
+		}
+		keys[k.Kid] = k
+	}
+	if keys[f.Active] == nil {
+		return fmt.Errorf("%s: active key %q not found", ks.path, f.Active)
+	}
+
+	ks.mu.Lock()
+	ks.active, ks.keys, ks.lastReload = f.Active, keys, time.Now()
+	ks.mu.Unlock()
+	return nil
+}
+
+// save writes the key file atomically, readable by the owner only
+func (ks *KeySet) save() error {
+	f := keyFile{Active: ks.active}
+	for _, k := range ks.sortedKeys() {
+		f.Keys = append(f.Keys, k)
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(ks.path), ".jwt_keys-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), ks.path)
+}
+
+func (ks *KeySet) sortedKeys() []*SigningKey {
+	keys := make([]*SigningKey, 0, len(ks.keys))
+	for _, k := range ks.keys {
+		keys = append(keys, k)
+	}
+	// kids are creation timestamps too, they order keys made within the same second
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].CreatedAt != keys[j].CreatedAt {
+			return keys[i].CreatedAt < keys[j].CreatedAt
+		}
+		return keys[i].Kid < keys[j].Kid
+	})
+	return keys
+}
+
+// Rotate adds a new key for alg, makes it the signing key and saves the file.
+// Older keys keep verifying until they are removed. Other servers sign with
+// the new key from their next reload.
+func (ks *KeySet) Rotate(alg string) (*SigningKey, error) {
+	if ks.path == "" {
+		return nil, errors.New("keys from JWT_SECRET cannot be rotated, use JWT_KEYS_FILE")
+	}
+	k, err := GenerateSigningKey(alg)
+	if err != nil {
+		return nil, err
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys[k.Kid] = k
+	ks.active = k.Kid
+	return k, ks.save()
+}
+
+// Remove deletes a retired key from the file. Its tokens stop verifying here
+// at once and on other servers from their next reload.
+func (ks *KeySet) Remove(kid string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.keys[kid] == nil {
+		return fmt.Errorf("key %q not found", kid)
+	}
+	if kid == ks.active {
+		return errors.New("the active key cannot be removed, rotate first")
+	}
+	delete(ks.keys, kid)
+	return ks.save()
+}
+
+// Sign signs claims with the active key and sets the kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	ks.mu.RLock()
+	k := ks.keys[ks.active]
+	ks.mu.RUnlock()
+
+	token := jwt.NewWithClaims(signingMethods[k.Alg], claims)
+	token.Header["kid"] = k.Kid
+	return token.SignedString(k.signKey)
+}
+
+// Parse verifies a token. The key is chosen by kid and the token's alg must be
+// the one that key was made for, so an HS256 token can never be checked
+// against a public key.
+func (ks *KeySet) Parse(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, ks.keyFor, jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}))
+}
+
+func (ks *KeySet) keyFor(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	k := ks.lookup(kid)
+	if k == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != k.Alg {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+	}
+	return k.verifyKey, nil
+}
+
+// lookup finds a verification key, re-reading the key file once in a while
+// when the kid is unknown so keys rotated on another instance are picked up
+func (ks *KeySet) lookup(kid string) *SigningKey {
+	ks.mu.RLock()
+	k := ks.keys[kid]
+	stale := ks.path != "" && time.Since(ks.lastReload) > minKeyReload
+	ks.mu.RUnlock()
+	if k != nil || !stale {
+		return k
+	}
+	if err := ks.reload(); err != nil {
+		ks.mu.Lock()
+		ks.lastReload = time.Now()
+		ks.mu.Unlock()
+		return nil
+	}
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.keys[kid]
+}
+
+// Watch re-reads the key file every interval until stop is called, so keys
+// rotated or removed with the keys command take effect on every running
+// server without a restart. A file that fails to load keeps the current keys.
+func (ks *KeySet) Watch(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+	stop = func() { once.Do(func() { close(done) }) }
+	if ks.path == "" {
+		return stop
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := ks.reload(); err != nil {
+					log.Printf("jwt keys: keeping the loaded keys: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return stop
+}
+
+// JWKS returns the public keys in JSON Web Key Set format. HS256 keys are secret and never listed.
+func (ks *KeySet) JWKS() gin.H {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	keys := make([]gin.H, 0)
+	for _, k := range ks.sortedKeys() {
+		switch pub := k.verifyKey.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, gin.H{
+				"kty": "RSA", "use": "sig", "alg": k.Alg, "kid": k.Kid,
+				"n": b64(pub.N.Bytes()),
+				"e": b64(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, gin.H{
+				"kty": "OKP", "crv": "Ed25519", "use": "sig", "alg": k.Alg, "kid": k.Kid,
+				"x": b64(pub),
+			})
+		}
+	}
+	return gin.H{"keys": keys}
+}
+
+// JWKSHandler serves the public verification keys
+func JWKSHandler(c *gin.Context) {
+	c.JSON(200, AppKeys.JWKS())
+}
+
+// RunKeysCommand implements `keys list|rotate [alg]|remove <kid>`
+func RunKeysCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: keys list|rotate [HS256|RS256|EdDSA]|remove <kid>")
+	}
+	if os.Getenv("JWT_SECRET") != "" {
+		return errors.New("JWT_SECRET is set, key rotation needs JWT_KEYS_FILE instead")
+	}
+	ks, err := LoadKeySetFromEnv()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+	case "rotate":
+		alg := getEnv("JWT_ALG", "HS256")
+		if len(args) > 1 {
+			alg = args[1]
+		}
+		k, err := ks.Rotate(alg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("New active key %s (%s). Running servers sign with it within %s; remove the old key once its tokens have expired.\n", k.Kid, k.Alg, keyReloadInterval)
+	case "remove":
+		if len(args) < 2 {
+			return errors.New("usage: keys remove <kid>")
+		}
+		if err := ks.Remove(args[1]); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown keys command %q", args[0])
+	}
+
+	for _, k := range ks.sortedKeys() {
+		marker := " "
+		if k.Kid == ks.active {
+			marker = "*"
+		}
+		fmt.Printf("%s %-16s %-6s %s\n", marker, k.Kid, k.Alg, k.CreatedAt)
+	}
+	return nil
+}
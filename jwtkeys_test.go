@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeySetSignAndVerify(t *testing.T) {
+	for _, alg := range []string{"HS256", "RS256", "EdDSA"} {
+		t.Run(alg, func(t *testing.T) {
+			ks := &KeySet{path: filepath.Join(t.TempDir(), "keys.json"), keys: map[string]*SigningKey{}}
+			k, err := ks.Rotate(alg)
+			assert.NoError(t, err)
+
+			signed, err := ks.Sign(jwt.MapClaims{"userId": "u1"})
+			assert.NoError(t, err)
+			token, err := ks.Parse(signed)
+			assert.NoError(t, err)
+			assert.Equal(t, k.Kid, token.Header["kid"])
+			assert.Equal(t, alg, token.Method.Alg())
+
+			// The key file round-trips
+			loaded, err := LoadKeySet(ks.path)
+			assert.NoError(t, err)
+			_, err = loaded.Parse(signed)
+			assert.NoError(t, err)
+			info, _ := os.Stat(ks.path)
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		})
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	ks := &KeySet{path: filepath.Join(t.TempDir(), "keys.json"), keys: map[string]*SigningKey{}}
+	old, _ := ks.Rotate("HS256")
+	before, _ := ks.Sign(jwt.MapClaims{"userId": "u1"})
+
+	current, err := ks.Rotate("EdDSA")
+	assert.NoError(t, err)
+	after, _ := ks.Sign(jwt.MapClaims{"userId": "u1"})
+	token, _ := ks.Parse(after)
+	assert.Equal(t, current.Kid, token.Header["kid"])
+
+	// Tokens of the previous key keep verifying until it is removed
+	_, err = ks.Parse(before)
+	assert.NoError(t, err)
+	assert.Error(t, ks.Remove(current.Kid), "the active key stays")
+	assert.NoError(t, ks.Remove(old.Kid))
+	_, err = ks.Parse(before)
+	assert.Error(t, err)
+
+	// Another instance picks up a key rotated elsewhere on the next unknown kid
+	other, _ := LoadKeySet(ks.path)
+	other.lastReload = time.Time{}
+	ks.Rotate("RS256")
+	latest, _ := ks.Sign(jwt.MapClaims{"userId": "u1"})
+	_, err = other.Parse(latest)
+	assert.NoError(t, err)
+
+	// Watching servers drop removed keys and sign with the new active one
+	stop := other.Watch(10 * time.Millisecond)
+	defer stop()
+	midway, _ := other.Sign(jwt.MapClaims{"userId": "u1"})
+	newest, _ := ks.Rotate("HS256")
+	retired, _ := other.Parse(midway)
+	assert.NoError(t, ks.Remove(retired.Header["kid"].(string)))
+	assert.Eventually(t, func() bool {
+		_, err := other.Parse(midway)
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+	signed, _ := other.Sign(jwt.MapClaims{"userId": "u1"})
+	token, _ = ks.Parse(signed)
+	assert.Equal(t, newest.Kid, token.Header["kid"])
+}
+
+func TestKeySetRejectsForgedTokens(t *testing.T) {
+	ks := &KeySet{path: filepath.Join(t.TempDir(), "keys.json"), keys: map[string]*SigningKey{}}
+	rsaKey, _ := ks.Rotate("RS256")
+	hsKey, _ := GenerateSigningKey("HS256")
+	claims := jwt.MapClaims{"userId": "u1"}
+
+	// HS256 signed with the RSA public key, the classic algorithm confusion attack
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	confused.Header["kid"] = rsaKey.Kid
+	pub := rsaKey.verifyKey.(*rsa.PublicKey)
+	confusedSigned, _ := confused.SignedString(append(pub.N.Bytes(), big.NewInt(int64(pub.E)).Bytes()...))
+
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	none.Header["kid"] = rsaKey.Kid
+	noneSigned, _ := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	unknown.Header["kid"] = hsKey.Kid
+	unknownSigned, _ := unknown.SignedString(hsKey.signKey)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"Algorithm mismatch", confusedSigned},
+		{"alg none", noneSigned},
+		{"Unknown kid", unknownSigned},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ks.Parse(tt.token)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	ks := &KeySet{path: filepath.Join(t.TempDir(), "keys.json"), keys: map[string]*SigningKey{}}
+	ks.Rotate("HS256")
+	rsaKey, _ := ks.Rotate("RS256")
+	edKey, _ := ks.Rotate("EdDSA")
+
+	keys := ks.JWKS()["keys"].([]gin.H)
+	// Shared secrets are never published
+	assert.Len(t, keys, 2)
+	assert.Equal(t, rsaKey.Kid, keys[0]["kid"])
+	assert.Equal(t, "RSA", keys[0]["kty"])
+	assert.Equal(t, "AQAB", keys[0]["e"])
+	assert.Equal(t, edKey.Kid, keys[1]["kid"])
+	assert.Equal(t, "OKP", keys[1]["kty"])
+	x, _ := base64.RawURLEncoding.DecodeString(keys[1]["x"].(string))
+	assert.Equal(t, []byte(edKey.verifyKey.(ed25519.PublicKey)), x)
+}
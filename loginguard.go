@@ -0,0 +1,408 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Failed logins are counted per username and per client IP. After a few free
+// failures every further one doubles the wait before the next attempt, and
+// enough of them lock the key for a while. Attempts are refused before the
+// password is checked, so a locked key costs no bcrypt work.
+
+type throttlePolicy struct {
+	FreeFailures int // failures before backoff starts
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockAfter    int // failures that lock the key
+	LockFor      time.Duration
+}
+
+var (
+	userLoginPolicy = throttlePolicy{FreeFailures: 3, BaseDelay: time.Second, MaxDelay: 5 * time.Minute, LockAfter: 10, LockFor: 30 * time.Minute}
+	// A whole classroom often shares one address, so IPs get far more slack
+	ipLoginPolicy = throttlePolicy{FreeFailures: 30, BaseDelay: time.Second, MaxDelay: 5 * time.Minute, LockAfter: 200, LockFor: 30 * time.Minute}
+)
+
+// loginFailureWindow is how long a key must stay quiet before its count restarts
+var loginFailureWindow = time.Hour
+
+// LoginAttemptStore keeps the failure counters. Update must apply fn atomically.
+type LoginAttemptStore interface {
+	Get(key string) (LoginAttempt, error)
+	Update(key string, fn func(a *LoginAttempt)) (LoginAttempt, error)
+	Delete(key string) error
+	List() ([]LoginAttempt, error)
+}
+
+// LoginAttempts is the store in use; main switches to the database unless LOGIN_ATTEMPT_STORE=memory
+var LoginAttempts LoginAttemptStore = NewMemoryAttemptStore()
+
+// NewLoginAttemptStoreFromEnv picks the store from LOGIN_ATTEMPT_STORE (db or memory).
+// The memory store is per process, so multi-instance deployments need db.
+func NewLoginAttemptStoreFromEnv() (LoginAttemptStore, error) {
+	switch driver := getEnv("LOGIN_ATTEMPT_STORE", "db"); driver {
+	case "db":
+		return DBAttemptStore{}, nil
+	case "memory":
+		return NewMemoryAttemptStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown LOGIN_ATTEMPT_STORE %q", driver)
+	}
+}
+
+// maxMemoryAttempts bounds the memory store against floods of made-up usernames
+const maxMemoryAttempts = 10000
+
+type MemoryAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]LoginAttempt
+}
+
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{attempts: make(map[string]LoginAttempt)}
+}
+
+func (s *MemoryAttemptStore) Get(key string) (LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a, ok := s.attempts[key]; ok {
+		return a, nil
+	}
+	return LoginAttempt{Key: key}, nil
+}
+
+func (s *MemoryAttemptStore) Update(key string, fn func(a *LoginAttempt)) (LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.attempts) >= maxMemoryAttempts {
+		now := time.Now()
+		for k, a := range s.attempts {
+			if attemptExpired(a, now) {
+				delete(s.attempts, k)
+			}
+		}
+	}
+	a, ok := s.attempts[key]
+	if !ok {
+		a = LoginAttempt{Key: key}
+	}
+	fn(&a)
+	s.attempts[key] = a
+	return a, nil
+}
+
+func (s *MemoryAttemptStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
+
+func (s *MemoryAttemptStore) List() ([]LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]LoginAttempt, 0, len(s.attempts))
+	for _, a := range s.attempts {
+		list = append(list, a)
+	}
+	return list, nil
+}
+
+// DBAttemptStore shares the counters between instances through the login_attempts table.
+// Counters that went quiet are swept out as new keys come in, so floods of
+// made-up usernames don't grow the table for good.
+type DBAttemptStore struct{}
+
+// loginAttemptPruneInterval spaces out the sweeps of the login_attempts table
+var loginAttemptPruneInterval = time.Minute
+
+// lastAttemptPrune is the Unix time of the last sweep
+var lastAttemptPrune atomic.Int64
+
+func (DBAttemptStore) Get(key string) (LoginAttempt, error) {
+	var a LoginAttempt
+	err := DB.Where(&LoginAttempt{Key: key}).First(&a).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return LoginAttempt{Key: key}, nil
+	}
+	return a, err
+}
+
+func (DBAttemptStore) Update(key string, fn func(a *LoginAttempt)) (LoginAttempt, error) {
+	var a LoginAttempt
+	created := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		// Concurrent first failures of a key both insert; the loser keeps the
+		// winner's row and waits for its lock, so no failure is lost
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&LoginAttempt{Key: key})
+		if res.Error != nil {
+			return res.Error
+		}
+		created = res.RowsAffected > 0
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(&LoginAttempt{Key: key}).First(&a).Error; err != nil {
+			return err
+		}
+		fn(&a)
+		return tx.Save(&a).Error
+	})
+	if err == nil && created {
+		pruneLoginAttempts(time.Now())
+	}
+	return a, err
+}
+
+// pruneLoginAttempts deletes the counters attemptExpired would start over,
+// at most once per loginAttemptPruneInterval
+func pruneLoginAttempts(now time.Time) {
+	last := lastAttemptPrune.Load()
+	if now.Sub(time.Unix(last, 0)) < loginAttemptPruneInterval || !lastAttemptPrune.CompareAndSwap(last, now.Unix()) {
+		return
+	}
+	err := DB.Where("last_failure_at < ? AND locked_until < ?",
+		now.Add(-loginFailureWindow).Format(timeLayout), now.Format(timeLayout)).
+		Delete(&LoginAttempt{}).Error
+	if err != nil {
+		log.Printf("login attempts: failed to prune: %v", err)
+	}
+}
+
+func (DBAttemptStore) Delete(key string) error {
+	return DB.Where(&LoginAttempt{Key: key}).Delete(&LoginAttempt{}).Error
+}
+
+func (DBAttemptStore) List() ([]LoginAttempt, error) {
+	list := make([]LoginAttempt, 0)
+	err := DB.Find(&list).Error
+	return list, err
+}
+
+func parseLocalTime(s string) time.Time {
+	t, _ := time.ParseInLocation(timeLayout, s, time.Local)
+	return t
+}
+
+func loginPolicy(key string) throttlePolicy {
+	if strings.HasPrefix(key, "ip:") {
+		return ipLoginPolicy
+	}
+	return userLoginPolicy
+}
+
+func userLoginKey(username string) string {
+	return "user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+// attemptExpired reports whether a counter has gone quiet long enough to start over
+func attemptExpired(a LoginAttempt, now time.Time) bool {
+	return now.After(parseLocalTime(a.LastFailureAt).Add(loginFailureWindow)) &&
+		now.After(parseLocalTime(a.LockedUntil))
+}
+
+// loginWait is how long the key must wait before the next attempt
+func loginWait(a LoginAttempt, now time.Time) time.Duration {
+	until := parseLocalTime(a.BlockedUntil)
+	if locked := parseLocalTime(a.LockedUntil); locked.After(until) {
+		until = locked
+	}
+	return until.Sub(now)
+}
+
+// recordLoginFailure counts a failure against key and reports whether it just got locked
+func recordLoginFailure(key string, now time.Time) (LoginAttempt, bool, error) {
+	locked := false
+	a, err := LoginAttempts.Update(key, func(a *LoginAttempt) {
+		if a.Failures > 0 && attemptExpired(*a, now) {
+			*a = LoginAttempt{Key: a.Key}
+		}
+		p := loginPolicy(key)
+		a.Failures++
+		a.LastFailureAt = now.Format(timeLayout)
+		switch {
+		case a.Failures >= p.LockAfter:
+			// Once over the limit every further failure locks again
+			a.LockedUntil = now.Add(p.LockFor).Format(timeLayout)
+			locked = true
+		case a.Failures > p.FreeFailures:
+			delay := p.BaseDelay << (a.Failures - p.FreeFailures - 1)
+			if delay > p.MaxDelay || delay <= 0 {
+				delay = p.MaxDelay
+			}
+			a.BlockedUntil = now.Add(delay).Format(timeLayout)
+		}
+	})
+	return a, locked, err
+}
+
+// ConfigureTrustedProxies makes the client IP the throttle counts by come
+// from X-Forwarded-For only when the request arrives from one of the
+// TRUSTED_PROXIES (comma-separated IPs or CIDRs). None are trusted by default,
+// as anyone could otherwise pick the IP they are counted under.
+func ConfigureTrustedProxies(r *gin.Engine) error {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		return fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+	return nil
+}
+
+// loginKeys are the counters a login attempt is checked against. Logins
+// without a username, such as with a QR card, only count against the IP.
+func loginKeys(c *gin.Context, username string) []string {
+	if username == "" {
+		return []string{"ip:" + c.ClientIP()}
+	}
+	return []string{userLoginKey(username), "ip:" + c.ClientIP()}
+}
+
+// checkLoginAllowed refuses the request while the username or the IP is
+// backing off or locked. It returns false after writing the response.
+func checkLoginAllowed(c *gin.Context, username string) bool {
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range loginKeys(c, username) {
+		a, err := LoginAttempts.Get(key)
+		if err != nil {
+			continue
+		}
+		if w := loginWait(a, now); w > wait {
+			wait = w
+		}
+	}
+	if wait <= 0 {
+		return true
+	}
+	seconds := int(wait.Round(time.Second).Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	SendJSON(c, 1, fmt.Sprintf("Too many failed login attempts, try again in %s", time.Duration(seconds)*time.Second), gin.H{"retryAfter": seconds})
+	return false
+}
+
+// loginFailed counts a failed login for the username and the IP. The username
+// is counted whether or not it exists, so lockouts don't reveal accounts.
+// Login routes carry no school, so the lockout of an account is written to the
+// audit log of the account's school. IP counters and unknown usernames belong
+// to no school and only go to the server log.
+func loginFailed(c *gin.Context, username string) {
+	now := time.Now()
+	for _, key := range loginKeys(c, username) {
+		a, locked, err := recordLoginFailure(key, now)
+		if err != nil || !locked {
+			continue
+		}
+		details := fmt.Sprintf("%s locked until %s after %d failed logins (last from %s)",
+			key, a.LockedUntil, a.Failures, c.ClientIP())
+		var user User
+		if strings.HasPrefix(key, "user:") &&
+			DB.Select("tenant_id").First(&user, "LOWER(username) = ?", strings.TrimPrefix(key, "user:")).Error == nil {
+			scoped := c.Copy()
+			scoped.Set("tenantId", user.TenantID)
+			AddAuditLog(scoped, "LOGIN_LOCKOUT", details)
+		} else {
+			log.Printf("[LOGIN_LOCKOUT] %s", details)
+		}
+	}
+}
+
+// loginSucceeded clears the username counter. The IP counter is left to
+// expire, or one valid account would reset it for guesses at all the others.
+func loginSucceeded(username string) {
+	LoginAttempts.Delete(userLoginKey(username))
+}
+
+// GetLoginLockouts lists the usernames, and for super-admins also the IPs,
+// that are currently backing off or locked
+func GetLoginLockouts(c *gin.Context) {
+	all, err := LoginAttempts.List()
+	if err != nil {
+		SendJSON(c, 1, "Failed to load lockouts", nil)
+		return
+	}
+	now := time.Now()
+	list := make([]gin.H, 0)
+	for _, a := range all {
+		if !ownLockoutKey(c, a.Key) {
+			continue
+		}
+		if wait := loginWait(a, now); wait > 0 {
+			list = append(list, gin.H{
+				"key":           a.Key,
+				"failures":      a.Failures,
+				"lastFailureAt": a.LastFailureAt,
+				"locked":        now.Before(parseLocalTime(a.LockedUntil)),
+				"retryAfter":    int(wait.Seconds()),
+			})
+		}
+	}
+	SendJSON(c, 0, "", list)
+}
+
+// ownLockoutKey reports whether the requester may see a counter. A username
+// counter belongs to the school of that user. An IP counter is shared by every
+// school and can be locked by guesses at any of them, so only super-admins
+// see IP counters.
+func ownLockoutKey(c *gin.Context, key string) bool {
+	if role, _ := c.Get("role"); fmt.Sprintf("%v", role) == string(RoleSuperAdmin) {
+		return true
+	}
+	if !strings.HasPrefix(key, "user:") {
+		return false
+	}
+	var count int64
+	TenantDB(c).Model(&User{}).Where("LOWER(username) = ?", strings.TrimPrefix(key, "user:")).Count(&count)
+	return count > 0
+}
+
+// UnlockUser clears the failed login counter of an account
+func UnlockUser(c *gin.Context) {
+	var user User
+	if err := TenantDB(c).First(&user, "id = ?", c.Param("id")).Error; err != nil {
+		SendJSON(c, 1, "User not found", nil)
+		return
+	}
+	if err := LoginAttempts.Delete(userLoginKey(user.Username)); err != nil {
+		SendJSON(c, 1, "Failed to unlock user", nil)
+		return
+	}
+	AddAuditLog(c, "UNLOCK_USER", fmt.Sprintf("Unlocked login for %s", user.Username))
+	SendJSON(c, 0, "", gin.H{"message": "User unlocked"})
+}
+
+// ClearLoginLockout clears a counter from GetLoginLockouts. Schools clear the
+// counters of their users, super-admins also a locked classroom IP.
+func ClearLoginLockout(c *gin.Context) {
+	key := c.Param("key")
+	if !strings.HasPrefix(key, "user:") && !strings.HasPrefix(key, "ip:") {
+		SendJSON(c, 1, "Invalid lockout key", nil)
+		return
+	}
+	if !ownLockoutKey(c, key) {
+		SendJSON(c, 1, "Lockout not found", nil)
+		return
+	}
+	if err := LoginAttempts.Delete(key); err != nil {
+		SendJSON(c, 1, "Failed to clear lockout", nil)
+		return
+	}
+	AddAuditLog(c, "UNLOCK_USER", fmt.Sprintf("Cleared login lockout %s", key))
+	SendJSON(c, 0, "", gin.H{"message": "Lockout cleared"})
+}
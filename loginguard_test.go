@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginThrottling(t *testing.T) {
+	defer func(store LoginAttemptStore, user, ip throttlePolicy) {
+		LoginAttempts, userLoginPolicy, ipLoginPolicy = store, user, ip
+	}(LoginAttempts, userLoginPolicy, ipLoginPolicy)
+	userLoginPolicy = throttlePolicy{FreeFailures: 2, BaseDelay: time.Minute, MaxDelay: 10 * time.Minute, LockAfter: 4, LockFor: time.Hour}
+	ipLoginPolicy = throttlePolicy{FreeFailures: 6, BaseDelay: time.Minute, MaxDelay: 10 * time.Minute, LockAfter: 100, LockFor: time.Hour}
+
+	DB.Exec("DELETE FROM users")
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	DB.Create(&User{ID: "u1", Username: "alice", Password: string(hashed), Role: RoleStudent, Status: "active"})
+
+	r := gin.Default()
+	r.POST("/auth/login", LoginHandler)
+	admin := r.Group("/admin", func(c *gin.Context) {
+		c.Set("userId", "1")
+		c.Set("role", RoleAdmin)
+	})
+	admin.GET("/users/lockouts", GetLoginLockouts)
+	admin.DELETE("/users/lockouts/:key", ClearLoginLockout)
+	admin.POST("/users/:id/unlock", UnlockUser)
+	super := r.Group("/super", func(c *gin.Context) {
+		c.Set("userId", "0")
+		c.Set("role", RoleSuperAdmin)
+	})
+	super.GET("/lockouts", GetLoginLockouts)
+	super.DELETE("/lockouts/:key", ClearLoginLockout)
+
+	call := func(method, url, ip string, payload any) (string, map[string]any, []map[string]any) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		req.RemoteAddr = ip + ":40000"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp struct {
+			Err  string          `json:"err"`
+			Data json.RawMessage `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		var obj map[string]any
+		var list []map[string]any
+		json.Unmarshal(resp.Data, &obj)
+		json.Unmarshal(resp.Data, &list)
+		return resp.Err, obj, list
+	}
+	login := func(username, password, ip string) (string, map[string]any) {
+		errMsg, data, _ := call("POST", "/auth/login", ip, map[string]string{"username": username, "password": password})
+		return errMsg, data
+	}
+
+	stores := []struct {
+		name  string
+		store LoginAttemptStore
+	}{
+		{"Memory", NewMemoryAttemptStore()},
+		{"Database", DBAttemptStore{}},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			LoginAttempts = st.store
+			DB.Exec("DELETE FROM login_attempts")
+			DB.Exec("DELETE FROM audit_logs")
+			ip := "192.0.2.10"
+
+			// Free failures, then backoff refuses even the right password
+			for i := 0; i < 3; i++ {
+				errMsg, _ := login("alice", "wrong", ip)
+				assert.Equal(t, "Unauthorized", errMsg)
+			}
+			errMsg, data := login("Alice", "pw", ip)
+			assert.Contains(t, errMsg, "Too many failed login attempts")
+			assert.InDelta(t, 60, data["retryAfter"], 2)
+
+			// Let the backoff pass; the next failure locks the account
+			LoginAttempts.Update(userLoginKey("alice"), func(a *LoginAttempt) { a.BlockedUntil = "" })
+			errMsg, _ = login("alice", "wrong", ip)
+			assert.Equal(t, "Unauthorized", errMsg)
+			errMsg, _ = login("alice", "pw", "198.51.100.7")
+			assert.Contains(t, errMsg, "Too many failed login attempts", "the lock follows the account to other IPs")
+
+			var count int64
+			DB.Model(&AuditLog{}).Where("action = ?", "LOGIN_LOCKOUT").Count(&count)
+			assert.Equal(t, int64(1), count)
+
+			_, _, lockouts := call("GET", "/admin/users/lockouts", ip, nil)
+			found := false
+			for _, l := range lockouts {
+				if l["key"] == "user:alice" {
+					found = true
+					assert.Equal(t, true, l["locked"])
+				}
+			}
+			assert.True(t, found)
+
+			// Unlocking lets the right password in, and success resets the counter
+			errMsg, _, _ = call("POST", "/admin/users/u1/unlock", ip, nil)
+			assert.Empty(t, errMsg)
+			errMsg, data = login("alice", "pw", "198.51.100.7")
+			assert.Empty(t, errMsg)
+			assert.NotEmpty(t, data["token"])
+			a, _ := LoginAttempts.Get(userLoginKey("alice"))
+			assert.Zero(t, a.Failures)
+
+			// Guessing across many usernames from one IP throttles the IP,
+			// whether or not the accounts exist
+			for _, name := range []string{"13800000001", "13800000002", "13800000003"} {
+				errMsg, _ = login(name, "wrong", ip)
+				assert.Equal(t, "Unauthorized", errMsg)
+			}
+			errMsg, _ = login("alice", "pw", ip)
+			assert.Contains(t, errMsg, "Too many failed login attempts")
+			// An IP may be locked by guesses at any school, so only super-admins see and clear it
+			_, _, lockouts = call("GET", "/admin/users/lockouts", ip, nil)
+			for _, l := range lockouts {
+				assert.NotEqual(t, "ip:"+ip, l["key"])
+			}
+			errMsg, _, _ = call("DELETE", "/admin/users/lockouts/ip:"+ip, ip, nil)
+			assert.Equal(t, "Lockout not found", errMsg)
+			_, _, lockouts = call("GET", "/super/lockouts", ip, nil)
+			found = false
+			for _, l := range lockouts {
+				found = found || l["key"] == "ip:"+ip
+			}
+			assert.True(t, found)
+			errMsg, _, _ = call("DELETE", "/super/lockouts/ip:"+ip, ip, nil)
+			assert.Empty(t, errMsg)
+			errMsg, _ = login("alice", "pw", ip)
+			assert.Empty(t, errMsg)
+		})
+	}
+}
+
+func TestLoginLockoutAudit(t *testing.T) {
+	defer func(store LoginAttemptStore, user, ip throttlePolicy) {
+		LoginAttempts, userLoginPolicy, ipLoginPolicy = store, user, ip
+	}(LoginAttempts, userLoginPolicy, ipLoginPolicy)
+	LoginAttempts = NewMemoryAttemptStore()
+	userLoginPolicy = throttlePolicy{LockAfter: 1, LockFor: time.Hour}
+	ipLoginPolicy = throttlePolicy{LockAfter: 1, LockFor: time.Hour}
+
+	DB.Exec("DELETE FROM users")
+	DB.Exec("DELETE FROM audit_logs")
+	DB.Create(&User{ID: "u1", TenantID: "north", Username: "Bob", Password: "x", Role: RoleStudent, Status: "active"})
+
+	r := gin.Default()
+	r.POST("/auth/login", LoginHandler)
+	for _, name := range []string{"bob", "nobody"} {
+		body, _ := json.Marshal(map[string]string{"username": name, "password": "wrong"})
+		req, _ := http.NewRequest("POST", "/auth/login", bytes.NewBuffer(body))
+		req.RemoteAddr = "192.0.2.20:40000"
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Only the existing account's lockout is logged, to its own school
+	var logs []AuditLog
+	DB.Where("action = ?", "LOGIN_LOCKOUT").Find(&logs)
+	if assert.Len(t, logs, 1) {
+		assert.Equal(t, "north", logs[0].TenantID)
+		assert.Contains(t, logs[0].Details, "user:bob")
+	}
+}
+
+func TestLoginFailureWindow(t *testing.T) {
+	defer func(store LoginAttemptStore) { LoginAttempts = store }(LoginAttempts)
+	LoginAttempts = NewMemoryAttemptStore()
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		recordLoginFailure("user:bob", now)
+	}
+	a, locked, _ := recordLoginFailure("user:bob", now.Add(loginFailureWindow+time.Minute))
+	assert.False(t, locked)
+	assert.Equal(t, 1, a.Failures, "a quiet hour starts the count over")
+
+	for i := 0; i < 3; i++ {
+		a, _, _ = recordLoginFailure("user:bob", now)
+	}
+	assert.Equal(t, 4, a.Failures)
+	wait := loginWait(a, now)
+	assert.True(t, wait > 0 && wait <= time.Second, "the first backoff step is one second, got %v", wait)
+}
+
+func TestDBAttemptStorePrunesQuietKeys(t *testing.T) {
+	DB.Exec("DELETE FROM login_attempts")
+	lastAttemptPrune.Store(0)
+	now := time.Now()
+	quiet := now.Add(-2 * loginFailureWindow).Format(timeLayout)
+	DB.Create(&LoginAttempt{Key: "user:gone", Failures: 2, LastFailureAt: quiet})
+	DB.Create(&LoginAttempt{Key: "user:locked", Failures: 10, LastFailureAt: quiet, LockedUntil: now.Add(time.Hour).Format(timeLayout)})
+
+	store := DBAttemptStore{}
+	_, err := store.Update("user:new", func(a *LoginAttempt) { a.Failures++; a.LastFailureAt = now.Format(timeLayout) })
+	assert.NoError(t, err)
+	var keys []string
+	DB.Model(&LoginAttempt{}).Order("key").Pluck("key", &keys)
+	assert.Equal(t, []string{"user:locked", "user:new"}, keys, "quiet counters go, locks stay")
+
+	// A key that already has a row is counted on, not inserted again
+	a, err := store.Update("user:new", func(a *LoginAttempt) { a.Failures++ })
+	assert.NoError(t, err)
+	assert.Equal(t, 2, a.Failures)
+}
+
+func TestTrustedProxies(t *testing.T) {
+	clientIP := func(remoteAddr string) string {
+		r := gin.New()
+		if err := ConfigureTrustedProxies(r); err != nil {
+			t.Fatal(err)
+		}
+		var ip string
+		r.GET("/", func(c *gin.Context) { ip = loginKeys(c, "")[0] })
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr + ":40000"
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+		return ip
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "")
+	assert.Equal(t, "ip:198.51.100.1", clientIP("198.51.100.1"), "no proxy is trusted by default")
+
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 127.0.0.1")
+	assert.Equal(t, "ip:203.0.113.9", clientIP("10.1.2.3"))
+	assert.Equal(t, "ip:198.51.100.1", clientIP("198.51.100.1"), "others can't pick their IP")
+
+	t.Setenv("TRUSTED_PROXIES", "not-an-ip")
+	assert.Error(t, ConfigureTrustedProxies(gin.New()))
+}
@@ -34,7 +34,39 @@ func LoadEnv() {
 
 func main() {
 	LoadEnv() // Load .env file at startup
+
+	// `migrate status|up|down` manages the schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := RunMigrateCommand(os.Args[2:]); err != nil {
+			fmt.Printf("Migration failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// `keys list|rotate|remove` manages the token signing keys and exits
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		if err := RunKeysCommand(os.Args[2:]); err != nil {
+			fmt.Printf("Key command failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// `tenants list|super-admin` manages schools and their super-admins and exits
+	if len(os.Args) > 1 && os.Args[1] == "tenants" {
+		if err := RunTenantsCommand(os.Args[2:]); err != nil {
+			fmt.Printf("Tenants command failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	r := gin.Default()
+	if err := ConfigureTrustedProxies(r); err != nil {
+		fmt.Printf("Failed to configure trusted proxies: %v\n", err)
+		os.Exit(1)
+	}
 
 	// Initialize MySQL
 	if err := InitDB(); err != nil {
@@ -42,98 +74,219 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Initialize object storage
+	storage, err := NewStorageFromEnv()
+	if err != nil {
+		fmt.Printf("Failed to initialize storage: %v\n", err)
+		os.Exit(1)
+	}
+	AppStorage = storage
+
+	// Load the token signing keys
+	keys, err := LoadKeySetFromEnv()
+	if err != nil {
+		fmt.Printf("Failed to load signing keys: %v\n", err)
+		os.Exit(1)
+	}
+	AppKeys = keys
+	keys.Watch(keyReloadInterval)
+
+	// Failed login counters
+	attempts, err := NewLoginAttemptStoreFromEnv()
+	if err != nil {
+		fmt.Printf("Failed to initialize login attempt store: %v\n", err)
+		os.Exit(1)
+	}
+	LoginAttempts = attempts
+
+	// Delivery of reset codes
+	notifier, err := NewNotifierFromEnv()
+	if err != nil {
+		fmt.Printf("Failed to initialize notifier: %v\n", err)
+		os.Exit(1)
+	}
+	AppNotifier = notifier
+
+	// Single sign-on, off unless OIDC_ISSUER is set
+	sso, err := NewOIDCProviderFromEnv()
+	if err != nil {
+		fmt.Printf("Failed to configure single sign-on: %v\n", err)
+		os.Exit(1)
+	}
+	AppSSO = sso
+
 	// Global Middlewares
 	r.Use(CORSMiddleware())
 
+	registerRoutes(r)
+	if err := CheckRoutePermissions(r.Routes()); err != nil {
+		fmt.Printf("Invalid routes: %v\n", err)
+		os.Exit(1)
+	}
+
+	r.Run(":8080")
+}
+
+// registerRoutes sets up the routes. Each one declares the permission module
+// and access level it needs, see Guarded.
+func registerRoutes(r *gin.Engine) {
+	routes := Guard(&r.RouterGroup)
+
+	// Local uploads are served by Gin itself, sandboxed in case an older
+	// upload holds script
+	if local, ok := AppStorage.(*LocalStorage); ok {
+		routes.Group("/uploads", sandboxUploads).Static("/", local.Dir)
+	}
+
+	// Public keys for services that verify our tokens
+	routes.GET("/.well-known/jwks.json", Public, JWKSHandler)
+
 	// API Routes
-	api := r.Group("/api")
+	api := routes.Group("/api")
 	{
 		// Public routes
-		api.POST("/auth/login", LoginHandler)
-		api.POST("/auth/register", RegisterHandler)
-		api.GET("/config/public", GetPublicConfig)
+		api.POST("/auth/login", Public, LoginHandler)
+		api.POST("/auth/register", Public, RegisterHandler)
+		api.POST("/auth/refresh", Public, RefreshTokenHandler)
+		api.POST("/auth/logout", Public, LogoutHandler)
+		api.POST("/auth/password-reset/request", Public, RequestPasswordReset)
+		api.POST("/auth/password-reset/confirm", Public, ConfirmPasswordReset)
+		api.GET("/auth/sso/login", Public, SSOLoginHandler)
+		api.GET("/auth/sso/callback", Public, SSOCallbackHandler)
+		api.POST("/auth/sso/exchange", Public, SSOExchangeHandler)
+		api.POST("/auth/card-login", Public, CardLoginHandler)
+		api.GET("/auth/roster", Public, GetClassRoster)
+		api.POST("/auth/picture-login", Public, PictureLoginHandler)
+		api.GET("/config/public", Public, GetPublicConfig)
 
 		// Protected routes
 		protected := api.Group("/")
 		protected.Use(AuthMiddleware(), PermissionMiddleware())
 		{
 			// Me
-			protected.GET("/me", GetMe)
-			protected.GET("/me/permissions", GetMyPermissions)
-			protected.PUT("/me", UpdateMe)
+			protected.GET("/me", SignedIn, GetMe)
+			protected.GET("/me/permissions", SignedIn, GetMyPermissions)
+			protected.PUT("/me", SignedIn, UpdateMe)
+			protected.POST("/auth/logout-all", SignedIn, LogoutAllHandler)
 
 			// Questions
-			protected.GET("/questions", GetQuestions)
-			protected.POST("/questions", CreateQuestion)
-			protected.POST("/questions/bulk", BulkCreateQuestions)
-			protected.PUT("/questions/:id", UpdateQuestion)
-			protected.DELETE("/questions/:id", DeleteQuestion)
+			protected.GET("/questions", Read("questions"), GetQuestions)
+			protected.POST("/questions", Write("questions"), CreateQuestion)
+			protected.POST("/questions/bulk", Write("questions"), BulkCreateQuestions)
+			protected.PUT("/questions/:id", Write("questions"), UpdateQuestion)
+			protected.DELETE("/questions/:id", Write("questions"), DeleteQuestion)
+			protected.POST("/questions/:id/check", Write("assignments"), CheckAnswer)
 
 			// Papers
-			protected.GET("/papers", GetPapers)
-			protected.POST("/papers", CreatePaper)
-			protected.PUT("/papers/:id", UpdatePaper)
-			protected.DELETE("/papers/:id", DeletePaper)
+			protected.GET("/papers", Read("papers"), GetPapers)
+			protected.POST("/papers", Write("papers"), CreatePaper)
+			protected.PUT("/papers/:id", Write("papers"), UpdatePaper)
+			protected.DELETE("/papers/:id", Write("papers"), DeletePaper)
 
 			// Homeworks
-			protected.GET("/homeworks", GetHomeworks)
-			protected.POST("/homeworks/assign", AssignHomework)
-			protected.PUT("/homeworks/:id/complete", CompleteHomework)
+			protected.GET("/homeworks", Read("assignments"), GetHomeworks)
+			protected.POST("/homeworks/assign", Write("assignments"), AssignHomework)
+			protected.PUT("/homeworks/:id/complete", Write("assignments"), CompleteHomework)
 
 			// Reinforcements
-			protected.GET("/reinforcements", GetReinforcements)
-			protected.POST("/reinforcements", CreateReinforcement)
-			protected.PUT("/reinforcements/:id", UpdateReinforcement)
-			protected.DELETE("/reinforcements/:id", DeleteReinforcement)
+			protected.GET("/reinforcements", Read("reinforcements"), GetReinforcements)
+			protected.POST("/reinforcements", Write("reinforcements"), CreateReinforcement)
+			protected.PUT("/reinforcements/:id", Write("reinforcements"), UpdateReinforcement)
+			protected.DELETE("/reinforcements/:id", Write("reinforcements"), DeleteReinforcement)
+
+			// Uploads
+			protected.POST("/uploads", Write("resources"), UploadAsset)
+			protected.GET("/uploads/:id", Read("resources"), GetAsset)
 
 			// Resources
-			protected.GET("/resources", GetResources)
-			protected.POST("/resources", CreateResource)
-			protected.PUT("/resources/:id", UpdateResource)
-			protected.DELETE("/resources/:id", DeleteResource)
+			protected.GET("/resources", Read("resources"), GetResources)
+			protected.POST("/resources", Write("resources"), CreateResource)
+			protected.PUT("/resources/:id", Write("resources"), UpdateResource)
+			protected.DELETE("/resources/:id", Write("resources"), DeleteResource)
+
+			// Practice Sessions
+			protected.POST("/sessions", Write("assignments"), StartSession)
+			protected.POST("/sessions/:id/answer", Update("assignments"), SubmitSessionAnswer)
 
 			// History
-			protected.GET("/history", GetHistory)
-			protected.POST("/history", CreateHistory)
+			protected.GET("/history", Read("assignments"), GetHistory)
+			protected.POST("/history", Write("assignments"), CreateHistory)
 
 			// Wrong Question Book
-			protected.GET("/wrong-book", GetWrongBook)
+			protected.GET("/wrong-book", Read("wrong_book"), GetWrongBook)
+
+			// Classes
+			protected.GET("/classes", Read("assignments"), GetClasses)
+			protected.POST("/classes", Write("assignments"), CreateClass)
+			protected.PUT("/classes/:id", Write("assignments"), UpdateClass)
+			protected.DELETE("/classes/:id", Write("assignments"), DeleteClass)
+			protected.POST("/classes/:id/students", Update("assignments"), EnrollClassStudents)
+			protected.DELETE("/classes/:id/students/:studentId", Update("assignments"), UnenrollClassStudent)
+			protected.POST("/classes/:id/login-cards", Update("assignments"), CreateClassLoginCards)
+			protected.POST("/classes/:id/roster-code", Update("assignments"), CreateRosterCode)
 
 			// Students
-			protected.GET("/students", GetStudents)
-			protected.GET("/students/:id", GetStudentDetail)
+			protected.GET("/students", Read("students"), GetStudents)
+			protected.GET("/students/:id", Read("students"), GetStudentDetail)
+			protected.POST("/students/:id/reset-code", Update("students"), CreatePrintedResetCode)
+			protected.POST("/students/:id/parent-invite", Write("students"), CreateParentInvite)
+			protected.GET("/students/:id/parents", Read("students"), GetStudentParents)
+			protected.DELETE("/students/:id/parents/:parentId", Update("students"), RemoveStudentParent)
+			protected.GET("/students/:id/login", Read("students"), GetStudentLogin)
+			protected.POST("/students/:id/login-card", Update("students"), CreateLoginCard)
+			protected.POST("/students/:id/picture-password", Update("students"), CreatePicturePassword)
+			protected.DELETE("/students/:id/login", Update("students"), RevokeStudentLogin)
 
-			// Student Stats
-			protected.GET("/student/stats", GetStudentStats)
+			// Parents
+			protected.GET("/parent/children", Read("children"), GetChildren)
+			protected.POST("/parent/children", Write("children"), LinkChild)
+			protected.DELETE("/parent/children/:id", Write("children"), UnlinkChild)
+			protected.GET("/parent/children/:id/weekly", Read("children"), GetChildWeeklySummary)
+
+			// Student Stats, of the caller only
+			protected.GET("/student/stats", Read("own_stats"), GetStudentStats)
 			
-			// Teacher Stats
-			protected.GET("/teacher/stats", GetTeacherStats)
+			// Teacher Stats, of the caller only
+			protected.GET("/teacher/stats", Read("own_stats"), GetTeacherStats)
 
 			// Admin: User Management
 			admin := protected.Group("/admin")
 			{
-				admin.GET("/users", GetUsers)
-				admin.POST("/users", CreateUser)
-				admin.PUT("/users/:id", UpdateUser)
-				admin.DELETE("/users/:id", DeleteUser)
-				admin.GET("/logs", GetAuditLogs)
-				admin.GET("/homeworks", AdminGetHomeworks)
-				admin.GET("/practices", AdminGetPractices)
+				admin.GET("/users", Read("users"), GetUsers)
+				admin.POST("/users", Write("users"), CreateUser)
+				admin.POST("/users/import", Write("users"), ImportUsers)
+				admin.GET("/users/lockouts", Read("users"), GetLoginLockouts)
+				admin.DELETE("/users/lockouts/:key", Update("users"), ClearLoginLockout)
+				admin.POST("/users/:id/unlock", Update("users"), UnlockUser)
+				admin.PUT("/users/:id", Write("users"), UpdateUser)
+				admin.DELETE("/users/:id", Write("users"), DeleteUser)
+				admin.GET("/logs", Read("audit_logs"), GetAuditLogs)
+				admin.GET("/homeworks", Read("homework_audit"), AdminGetHomeworks)
+				admin.GET("/practices", Read("homework_audit"), AdminGetPractices)
 				
 				// System Config
-				admin.GET("/config", GetSystemConfig)
-				admin.POST("/config", UpdateSystemConfig)
-				admin.GET("/settings", GetSystemSettings)
-				admin.POST("/settings", UpdateSystemSettings)
-				admin.GET("/permissions", GetRolePermissions)
-				admin.POST("/permissions", UpdateRolePermissions)
+				admin.GET("/config", Read("system_config"), GetSystemConfig)
+				admin.POST("/config", Update("system_config"), UpdateSystemConfig)
+				admin.GET("/settings", Read("system_config"), GetSystemSettings)
+				admin.POST("/settings", Update("system_config"), UpdateSystemSettings)
+				admin.GET("/permissions", Read("permissions"), GetRolePermissions)
+				admin.POST("/permissions", Update("permissions"), UpdateRolePermissions)
+				admin.POST("/content/creator", Update("permissions"), AssignContentCreator)
 			}
 
 			// Analytics
-			protected.GET("/dashboard/stats", GetDashboardStats)
-			protected.GET("/dashboard/online-users", GetOnlineUsers)
+			protected.GET("/dashboard/stats", Read("stats"), GetDashboardStats)
+			protected.GET("/dashboard/online-users", Read("users"), GetOnlineUsers)
+
+			// Super-admin: Schools, checked by role as they are no permission module
+			super := protected.Group("/super", SuperAdminMiddleware())
+			{
+				super.GET("/tenants", SignedIn, GetTenants)
+				super.POST("/tenants", SignedIn, CreateTenant)
+				super.PUT("/tenants/:id", SignedIn, UpdateTenant)
+				super.GET("/lockouts", SignedIn, GetLoginLockouts)
+				super.DELETE("/lockouts/:key", SignedIn, ClearLoginLockout)
+			}
 		}
 	}
-
-	r.Run(":8080")
 }
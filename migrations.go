@@ -0,0 +1,899 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Migration is one versioned schema step. Versions are applied in order and
+// recorded in the schema_migrations table; Down undoes exactly what Up did.
+// Steps only touch the table snapshots of migrationschemas.go, never the live
+// models, so each version always stands for the same schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration is a row of the schema_migrations table
+type SchemaMigration struct {
+	Version   int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"type:varchar(191)"`
+	AppliedAt string `gorm:"type:varchar(191)"`
+}
+
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		Up: func(tx *gorm.DB) error {
+			// Existing deployments already have these tables from the old
+			// AutoMigrate at boot, AutoMigrate leaves them as they are
+			return tx.AutoMigrate(initialSchemaV1...)
+		},
+		Down: func(tx *gorm.DB) error {
+			tables := make([]any, 0, len(initialSchemaV1))
+			for i := len(initialSchemaV1) - 1; i >= 0; i-- {
+				tables = append(tables, initialSchemaV1[i])
+			}
+			return tx.Migrator().DropTable(tables...)
+		},
+	},
+	{
+		Version: 2,
+		Name:    "seed_defaults",
+		Up:      seedDefaultsV2,
+		// Seeded rows may have been edited since, leave them alone
+		Down: func(tx *gorm.DB) error { return nil },
+	},
+	{
+		Version: 3,
+		Name:    "history_scores_to_int",
+		Up:      historyScoresToInt,
+		Down:    historyScoresToText,
+	},
+	{
+		Version: 4,
+		Name:    "auth_sessions",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&userSessionV4{}, &refreshTokenV4{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&refreshTokenV4{}, &userSessionV4{})
+		},
+	},
+	{
+		Version: 5,
+		Name:    "login_attempts",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&loginAttemptV5{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&loginAttemptV5{})
+		},
+	},
+	{
+		Version: 6,
+		Name:    "must_change_password",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().AddColumn(&userPasswordV6{}, "MustChangePassword"); err != nil {
+				return err
+			}
+			// The seeded admin still on its well-known password has to change it
+			var admin userPasswordV6
+			if err := tx.First(&admin, "id = ?", "1").Error; err == nil &&
+				bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte("123")) == nil {
+				return tx.Model(&admin).Update("must_change_password", true).Error
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&userPasswordV6{}, "MustChangePassword")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "password_reset_codes",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&passwordResetCodeV7{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&passwordResetCodeV7{})
+		},
+	},
+	{
+		Version: 8,
+		Name:    "user_grade",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().AddColumn(&userGradeV8{}, "Grade")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&userGradeV8{}, "Grade")
+		},
+	},
+	{
+		Version: 9,
+		Name:    "parent_accounts",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&parentLinkV9{}, &parentInviteV9{}); err != nil {
+				return err
+			}
+			var count int64
+			tx.Model(&rolePermissionV1{}).Where("role = ?", RoleParent).Count(&count)
+			if count > 0 {
+				return nil
+			}
+			return tx.Create(parentPermissionsV9).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Where("role = ?", RoleParent).Delete(&rolePermissionV1{}).Error; err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable(&parentInviteV9{}, &parentLinkV9{})
+		},
+	},
+	{
+		Version: 10,
+		Name:    "tenants",
+		Up:      addTenants,
+		Down:    removeTenants,
+	},
+	{
+		Version: 11,
+		Name:    "user_identities",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&userIdentityV11{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&userIdentityV11{})
+		},
+	},
+	{
+		Version: 12,
+		Name:    "student_logins",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&studentLoginV12{}); err != nil {
+				return err
+			}
+			m := tx.Migrator()
+			if err := m.AddColumn(&classRosterV12{}, "RosterCodeHash"); err != nil {
+				return err
+			}
+			return m.CreateIndex(&classRosterV12{}, "RosterCodeHash")
+		},
+		Down: func(tx *gorm.DB) error {
+			m := tx.Migrator()
+			if err := m.DropIndex(&classRosterV12{}, "RosterCodeHash"); err != nil {
+				return err
+			}
+			if err := m.DropColumn(&classRosterV12{}, "RosterCodeHash"); err != nil {
+				return err
+			}
+			return m.DropTable(&studentLoginV12{})
+		},
+	},
+	{
+		Version: 13,
+		Name:    "wrong_book_module",
+		Up:      addWrongBookModule,
+		Down: func(tx *gorm.DB) error {
+			return tx.Where("module_id = ?", "wrong_book").Delete(&rolePermissionV10{}).Error
+		},
+	},
+	{
+		Version: 14,
+		Name:    "permission_actions",
+		Up:      splitAPIAccess,
+		Down:    mergeAPIAccess,
+	},
+	{
+		Version: 15,
+		Name:    "content_ownership",
+		Up: func(tx *gorm.DB) error {
+			m := tx.Migrator()
+			for _, table := range contentOwnershipV15 {
+				for _, field := range []string{"CreatorID", "CoOwnerIDs", "Department"} {
+					if err := m.AddColumn(table, field); err != nil {
+						return err
+					}
+				}
+			}
+			return m.AddColumn(&userDepartmentV15{}, "Department")
+		},
+		Down: func(tx *gorm.DB) error {
+			m := tx.Migrator()
+			for _, table := range contentOwnershipV15 {
+				for _, field := range []string{"CreatorID", "CoOwnerIDs", "Department"} {
+					if err := m.DropColumn(table, field); err != nil {
+						return err
+					}
+				}
+			}
+			return m.DropColumn(&userDepartmentV15{}, "Department")
+		},
+	},
+	{
+		// Reset codes, parent invites and login cards are student routes
+		Version: 16,
+		Name:    "teacher_student_access",
+		Up: func(tx *gorm.DB) error {
+			return grantUntouchedAccess(tx, RoleTeacher, "students", "can_read", "can_create", "can_update")
+		},
+		Down: func(tx *gorm.DB) error {
+			return revokeAccess(tx, RoleTeacher, "students")
+		},
+	},
+	{
+		Version: 17,
+		Name:    "sso_login_codes",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&ssoLoginCodeV17{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&ssoLoginCodeV17{})
+		},
+	},
+	{
+		// What stays without a creator is handed out by an admin through
+		// POST /api/admin/content/creator
+		Version: 18,
+		Name:    "question_creator_backfill",
+		Up:      backfillQuestionCreators,
+		Down: func(tx *gorm.DB) error {
+			return nil
+		},
+	},
+	{
+		// The own statistics of teachers and students get their own module,
+		// so reading them doesn't open the school-wide dashboard stats
+		Version: 19,
+		Name:    "own_stats_access",
+		Up:      addOwnStatsModule,
+		Down: func(tx *gorm.DB) error {
+			return tx.Where("module_id = ?", "own_stats").Delete(&rolePermissionV14{}).Error
+		},
+	},
+	{
+		// Card and picture sessions are let past the forced password change
+		Version: 20,
+		Name:    "password_free_sessions",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().AddColumn(&userSessionV20{}, "PasswordFree")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&userSessionV20{}, "PasswordFree")
+		},
+	},
+	{
+		// Migration 13 copied the parent dashboard row; new schools give
+		// parents the wrong book read-only and out of their menu
+		Version: 21,
+		Name:    "parent_wrong_book",
+		Up: func(tx *gorm.DB) error {
+			return tx.Model(&rolePermissionV14{}).
+				Where("role = ? AND module_id = ? AND ui_access = ?", RoleParent, "wrong_book", true).
+				Where("can_read = ? AND can_create = ? AND can_update = ? AND can_delete = ?", true, true, true, true).
+				Updates(map[string]any{"ui_access": false, "can_create": false, "can_update": false, "can_delete": false}).Error
+		},
+		// New schools start with these rows too, leave them alone
+		Down: func(tx *gorm.DB) error { return nil },
+	},
+}
+
+// seedDefaultsV2 creates the initial admin, default permissions and error
+// logic config on an empty database. Migration 6 makes the admin change the
+// well-known password.
+func seedDefaultsV2(tx *gorm.DB) error {
+	var count int64
+	tx.Model(&userV1{}).Count(&count)
+	if count == 0 {
+		hashed, _ := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.DefaultCost)
+		admin := userV1{ID: "1", Username: "admin", Password: string(hashed), Role: RoleAdmin, Status: "active", Name: "超级管理员"}
+		if err := tx.Create(&admin).Error; err != nil {
+			return err
+		}
+	}
+
+	tx.Model(&rolePermissionV1{}).Count(&count)
+	if count == 0 {
+		if err := tx.Create(rolePermissionsV2()).Error; err != nil {
+			return err
+		}
+	}
+
+	tx.Model(&systemConfigV1{}).Where(&systemConfigV1{Key: "error_logic"}).Count(&count)
+	if count == 0 {
+		return tx.Create(&systemConfigV1{Key: "error_logic", Value: errorLogicV2}).Error
+	}
+	return nil
+}
+
+// historyScoresToInt converts histories.score/total from text to integers
+func historyScoresToInt(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if err := m.RenameColumn(&historyScoreColumns{}, "score", "score_text"); err != nil {
+		return err
+	}
+	if err := m.RenameColumn(&historyScoreColumns{}, "total", "total_text"); err != nil {
+		return err
+	}
+	if err := m.AddColumn(&historyScoreColumns{}, "Score"); err != nil {
+		return err
+	}
+	if err := m.AddColumn(&historyScoreColumns{}, "Total"); err != nil {
+		return err
+	}
+
+	var rows []struct {
+		ID        string
+		ScoreText string
+		TotalText string
+	}
+	if err := tx.Table("histories").Select("id, score_text, total_text").Find(&rows).Error; err != nil {
+		return err
+	}
+	for _, row := range rows {
+		err := tx.Table("histories").Where("id = ?", row.ID).Updates(map[string]any{
+			"score": parseLegacyCount(row.ScoreText),
+			"total": parseLegacyCount(row.TotalText),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := m.DropColumn(&historyScoreColumns{}, "score_text"); err != nil {
+		return err
+	}
+	return m.DropColumn(&historyScoreColumns{}, "total_text")
+}
+
+// historyScoresToText reverts historyScoresToInt
+func historyScoresToText(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if err := m.AddColumn(&historyScoreColumns{}, "ScoreText"); err != nil {
+		return err
+	}
+	if err := m.AddColumn(&historyScoreColumns{}, "TotalText"); err != nil {
+		return err
+	}
+
+	var rows []struct {
+		ID    string
+		Score int
+		Total int
+	}
+	if err := tx.Table("histories").Select("id, score, total").Find(&rows).Error; err != nil {
+		return err
+	}
+	for _, row := range rows {
+		err := tx.Table("histories").Where("id = ?", row.ID).Updates(map[string]any{
+			"score_text": strconv.Itoa(row.Score),
+			"total_text": strconv.Itoa(row.Total),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := m.DropColumn(&historyScoreColumns{}, "score"); err != nil {
+		return err
+	}
+	if err := m.DropColumn(&historyScoreColumns{}, "total"); err != nil {
+		return err
+	}
+	if err := m.RenameColumn(&historyScoreColumns{}, "score_text", "score"); err != nil {
+		return err
+	}
+	return m.RenameColumn(&historyScoreColumns{}, "total_text", "total")
+}
+
+// parseLegacyCount reads the old text scores: "4", " 4 " or "4/5" (the count before the slash)
+func parseLegacyCount(s string) int {
+	s = strings.TrimSpace(strings.SplitN(s, "/", 2)[0])
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// addWrongBookModule splits the wrong book off the dashboard module it used
+// to be checked against. Every role keeps the access it had through the
+// dashboard.
+func addWrongBookModule(tx *gorm.DB) error {
+	var perms []rolePermissionV10
+	if err := tx.Where("module_id = ?", "dashboard").Find(&perms).Error; err != nil {
+		return err
+	}
+	for _, p := range perms {
+		var count int64
+		tx.Model(&rolePermissionV10{}).Where("tenant_id = ? AND role = ? AND module_id = ?", p.TenantID, p.Role, "wrong_book").Count(&count)
+		if count > 0 {
+			continue
+		}
+		p.ModuleID = "wrong_book"
+		if err := tx.Create(&p).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// permissionActions are the columns migration 14 split APIAccess into
+var permissionActions = []string{"CanRead", "CanCreate", "CanUpdate", "CanDelete"}
+
+// splitAPIAccess replaces the api_access switch of role_permissions with
+// separate read, create, update and delete columns. A role that had API
+// access to a module gets all four.
+func splitAPIAccess(tx *gorm.DB) error {
+	m := tx.Migrator()
+	for _, field := range permissionActions {
+		if err := m.AddColumn(&rolePermissionV14{}, field); err != nil {
+			return err
+		}
+	}
+	err := tx.Table("role_permissions").Where("api_access = ?", true).Updates(map[string]any{
+		"can_read": true, "can_create": true, "can_update": true, "can_delete": true,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return m.DropColumn(&rolePermissionV10{}, "APIAccess")
+}
+
+// grantUntouchedAccess gives a role the actions on a module in the schools
+// where the role sees the module but has no API access to it, as the defaults
+// left it. Schools that changed the rule keep theirs. Migration 14 left the
+// actions it didn't grant NULL, which counts as no access.
+func grantUntouchedAccess(tx *gorm.DB, role Role, module string, actions ...string) error {
+	updates := make(map[string]any, len(actions))
+	for _, action := range actions {
+		updates[action] = true
+	}
+	return tx.Model(&rolePermissionV14{}).
+		Where("role = ? AND module_id = ? AND ui_access = ?", role, module, true).
+		Where("COALESCE(can_read, ?) = ? AND COALESCE(can_create, ?) = ? AND COALESCE(can_update, ?) = ? AND COALESCE(can_delete, ?) = ?",
+			false, false, false, false, false, false, false, false).
+		Updates(updates).Error
+}
+
+// revokeAccess takes the API access to a module from a role in every school
+func revokeAccess(tx *gorm.DB, role Role, module string) error {
+	return tx.Model(&rolePermissionV14{}).
+		Where("role = ? AND module_id = ?", role, module).
+		Updates(map[string]any{"can_read": false, "can_create": false, "can_update": false, "can_delete": false}).Error
+}
+
+// addOwnStatsModule adds the own_stats rule next to every stats rule of the
+// school staff and students. Admins keep what they had; teachers and students
+// may read their own statistics where the stats page is shown to them.
+func addOwnStatsModule(tx *gorm.DB) error {
+	var perms []rolePermissionV14
+	if err := tx.Where("module_id = ? AND role IN ?", "stats", []Role{RoleAdmin, RoleTeacher, RoleStudent}).Find(&perms).Error; err != nil {
+		return err
+	}
+	for _, p := range perms {
+		var count int64
+		tx.Model(&rolePermissionV14{}).Where("tenant_id = ? AND role = ? AND module_id = ?", p.TenantID, p.Role, "own_stats").Count(&count)
+		if count > 0 {
+			continue
+		}
+		own := rolePermissionV14{TenantID: p.TenantID, Role: p.Role, ModuleID: "own_stats", UIAccess: p.UIAccess, CanRead: p.UIAccess}
+		if p.Role == RoleAdmin {
+			own = p
+			own.ModuleID = "own_stats"
+		}
+		if err := tx.Create(&own).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillQuestionCreators gives questions from before migration 15 the
+// creator their CREATE_QUESTION audit log names. A log only counts when its
+// stem matches exactly one question of the school that has no creator yet and
+// its user is still a teacher or admin there. Papers, reinforcements and bulk
+// imported questions were never logged one by one, so they stay as they are.
+func backfillQuestionCreators(tx *gorm.DB) error {
+	var logs []auditLogV18
+	if err := tx.Where("action = ?", "CREATE_QUESTION").Order("timestamp").Find(&logs).Error; err != nil {
+		return err
+	}
+	for _, log := range logs {
+		stem, ok := strings.CutPrefix(log.Details, "Created question: ")
+		if !ok || stem == "" {
+			continue
+		}
+		var author int64
+		if err := tx.Model(&userRoleV18{}).
+			Where("id = ? AND tenant_id = ? AND role IN ?", log.UserID, log.TenantID, []Role{RoleTeacher, RoleAdmin}).
+			Count(&author).Error; err != nil {
+			return err
+		}
+		if author == 0 {
+			continue
+		}
+		var ids []string
+		if err := tx.Model(&questionCreatorV18{}).
+			Where("tenant_id = ? AND stem_text = ?", log.TenantID, stem).
+			Where("creator_id IS NULL OR creator_id = ?", "").
+			Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) != 1 {
+			continue
+		}
+		if err := tx.Model(&questionCreatorV18{}).Where("id = ?", ids[0]).Update("creator_id", log.UserID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeAPIAccess reverts splitAPIAccess. A role keeps API access to a module
+// it could read, which may grant writes it was denied since.
+func mergeAPIAccess(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if err := m.AddColumn(&rolePermissionV10{}, "APIAccess"); err != nil {
+		return err
+	}
+	if err := tx.Table("role_permissions").Where("can_read = ?", true).Update("api_access", true).Error; err != nil {
+		return err
+	}
+	for _, field := range permissionActions {
+		if err := m.DropColumn(&rolePermissionV14{}, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addTenants creates the default school and gives every school-owned table a
+// tenant_id. The column default assigns the existing rows to the default
+// school; config and permissions are rebuilt since their primary key changes.
+func addTenants(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if err := tx.AutoMigrate(&tenantV10{}); err != nil {
+		return err
+	}
+	var count int64
+	tx.Model(&tenantV10{}).Where("id = ?", defaultTenantID).Count(&count)
+	if count == 0 {
+		err := tx.Create(&tenantV10{
+			ID:        defaultTenantID,
+			Code:      defaultTenantID,
+			Name:      "Default School",
+			Status:    "active",
+			CreatedAt: time.Now().Format("2006-01-02 15:04:05"),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, table := range tenantTablesV10 {
+		if !m.HasColumn(table, "tenant_id") {
+			err := tx.Exec("ALTER TABLE ? ADD COLUMN ? VARCHAR(191) DEFAULT 'default'", clause.Table{Name: table}, clause.Column{Name: "tenant_id"}).Error
+			if err != nil {
+				return err
+			}
+		}
+		if index := tenantIndexV10(table); !m.HasIndex(table, index) {
+			err := tx.Exec("CREATE INDEX ? ON ? (?)", clause.Column{Name: index}, clause.Table{Name: table}, clause.Column{Name: "tenant_id"}).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// The key decides, not the column: a database once upgraded by an older
+	// build may have tenant_id outside the key, which still allows only one
+	// school per key
+	keyed, err := primaryKeyHasTenant(tx, "system_configs")
+	if err != nil {
+		return err
+	}
+	if !keyed {
+		var configs []systemConfigV1
+		if err := tx.Find(&configs).Error; err != nil {
+			return err
+		}
+		if err := m.DropTable(&systemConfigV1{}); err != nil {
+			return err
+		}
+		if err := m.CreateTable(&systemConfigV10{}); err != nil {
+			return err
+		}
+		for _, c := range configs {
+			if err := tx.Create(&systemConfigV10{TenantID: defaultTenantID, Key: c.Key, Value: c.Value}).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	if keyed, err = primaryKeyHasTenant(tx, "role_permissions"); err != nil || keyed {
+		return err
+	}
+	var perms []rolePermissionV1
+	if err := tx.Find(&perms).Error; err != nil {
+		return err
+	}
+	if err := m.DropTable(&rolePermissionV1{}); err != nil {
+		return err
+	}
+	if err := m.CreateTable(&rolePermissionV10{}); err != nil {
+		return err
+	}
+	for _, p := range perms {
+		err := tx.Create(&rolePermissionV10{TenantID: defaultTenantID, Role: p.Role, ModuleID: p.ModuleID, UIAccess: p.UIAccess, APIAccess: p.APIAccess}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tenantIndexV10 is the name migration 10 gives the tenant_id index of table
+func tenantIndexV10(table string) string {
+	return "idx_" + table + "_tenant_id"
+}
+
+// primaryKeyHasTenant reports whether tenant_id is part of the primary key of table
+func primaryKeyHasTenant(tx *gorm.DB, table string) (bool, error) {
+	columns, err := tx.Migrator().ColumnTypes(table)
+	if err != nil {
+		return false, err
+	}
+	for _, col := range columns {
+		if pk, ok := col.PrimaryKey(); ok && pk && col.Name() == "tenant_id" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// removeTenants reverts addTenants. It refuses while other schools exist, as
+// their rows would be merged into the remaining single school.
+func removeTenants(tx *gorm.DB) error {
+	m := tx.Migrator()
+	var count int64
+	tx.Model(&tenantV10{}).Where("id <> ?", defaultTenantID).Count(&count)
+	if count > 0 {
+		return fmt.Errorf("%d schools besides the default one exist, remove them first", count)
+	}
+
+	var configs []systemConfigV10
+	if err := tx.Find(&configs).Error; err != nil {
+		return err
+	}
+	var perms []rolePermissionV10
+	if err := tx.Find(&perms).Error; err != nil {
+		return err
+	}
+	if err := m.DropTable(&systemConfigV10{}, &rolePermissionV10{}); err != nil {
+		return err
+	}
+	if err := m.CreateTable(&systemConfigV1{}, &rolePermissionV1{}); err != nil {
+		return err
+	}
+	for _, c := range configs {
+		if err := tx.Create(&systemConfigV1{Key: c.Key, Value: c.Value}).Error; err != nil {
+			return err
+		}
+	}
+	for _, p := range perms {
+		err := tx.Create(&rolePermissionV1{Role: p.Role, ModuleID: p.ModuleID, UIAccess: p.UIAccess, APIAccess: p.APIAccess}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, table := range tenantTablesV10 {
+		if m.HasIndex(table, tenantIndexV10(table)) {
+			if err := m.DropIndex(table, tenantIndexV10(table)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Exec("ALTER TABLE ? DROP COLUMN ?", clause.Table{Name: table}, clause.Column{Name: "tenant_id"}).Error; err != nil {
+			return err
+		}
+	}
+	return m.DropTable(&tenantV10{})
+}
+
+// LatestSchemaVersion is the version this binary expects
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the highest applied migration, 0 on a fresh database
+func SchemaVersion(db *gorm.DB) (int, error) {
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return 0, nil
+	}
+	var version int
+	err := db.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	return version, err
+}
+
+// CheckSchemaVersion fails unless the database is exactly at LatestSchemaVersion
+func CheckSchemaVersion(db *gorm.DB) error {
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	latest := LatestSchemaVersion()
+	switch {
+	case version < latest:
+		return fmt.Errorf("database schema is at version %d, expected %d: run `migrate up` first", version, latest)
+	case version > latest:
+		return fmt.Errorf("database schema is at version %d, newer than this build (%d): upgrade the server or run `migrate down`", version, latest)
+	}
+	return nil
+}
+
+// MigrateUp applies pending migrations up to and including target, or all of
+// them when target is 0. Each migration runs in its own transaction.
+func MigrateUp(db *gorm.DB, target int) error {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+	if target == 0 {
+		target = LatestSchemaVersion()
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	for _, mig := range migrations {
+		if mig.Version > target || applied[mig.Version] {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := mig.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{
+				Version:   mig.Version,
+				Name:      mig.Name,
+				AppliedAt: time.Now().Format("2006-01-02 15:04:05"),
+			}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d %s: %w", mig.Version, mig.Name, err)
+		}
+	}
+	return nil
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first
+func MigrateDown(db *gorm.DB, steps int) error {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		mig := migrations[i]
+		if !applied[mig.Version] {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := mig.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, "version = ?", mig.Version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("revert migration %d %s: %w", mig.Version, mig.Name, err)
+		}
+		steps--
+	}
+	return nil
+}
+
+func appliedMigrations(db *gorm.DB) (map[int]bool, error) {
+	var rows []SchemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]bool)
+	for _, row := range rows {
+		applied[row.Version] = true
+	}
+	return applied, nil
+}
+
+// MigrationState is one line of `migrate status`
+type MigrationState struct {
+	Version   int
+	Name      string
+	AppliedAt string // empty when pending
+}
+
+// MigrationStatus lists every known migration and when it was applied
+func MigrationStatus(db *gorm.DB) ([]MigrationState, error) {
+	var rows []SchemaMigration
+	if db.Migrator().HasTable(&SchemaMigration{}) {
+		if err := db.Find(&rows).Error; err != nil {
+			return nil, err
+		}
+	}
+	appliedAt := make(map[int]string)
+	for _, row := range rows {
+		appliedAt[row.Version] = row.AppliedAt
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, mig := range migrations {
+		states = append(states, MigrationState{Version: mig.Version, Name: mig.Name, AppliedAt: appliedAt[mig.Version]})
+	}
+	return states, nil
+}
+
+// RunMigrateCommand implements `migrate status|up [version]|down [steps]`
+func RunMigrateCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate status|up [version]|down [steps]")
+	}
+	db, err := OpenDB()
+	if err != nil {
+		return err
+	}
+
+	arg := 0
+	if len(args) > 1 {
+		if arg, err = strconv.Atoi(args[1]); err != nil || arg < 0 {
+			return fmt.Errorf("invalid number %q", args[1])
+		}
+	}
+
+	switch args[0] {
+	case "status":
+		states, err := MigrationStatus(db)
+		if err != nil {
+			return err
+		}
+		for _, st := range states {
+			applied := "pending"
+			if st.AppliedAt != "" {
+				applied = "applied " + st.AppliedAt
+			}
+			fmt.Printf("%4d  %-28s %s\n", st.Version, st.Name, applied)
+		}
+	case "up":
+		if err := MigrateUp(db, arg); err != nil {
+			return err
+		}
+	case "down":
+		if arg == 0 {
+			arg = 1
+		}
+		if err := MigrateDown(db, arg); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Schema version: %d (latest %d)\n", version, LatestSchemaVersion())
+	return nil
+}
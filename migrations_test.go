@@ -0,0 +1,297 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func openMigrationTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "migrate.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestMigrateHistoryScores(t *testing.T) {
+	db := openMigrationTestDB(t)
+
+	// A database as the old AutoMigrate left it, with text scores
+	assert.NoError(t, MigrateUp(db, 2))
+	db.Create(&historyV1{ID: "h1", Score: "4", Total: "5"})
+	db.Create(&historyV1{ID: "h2", Score: "3/4", Total: " 4 "})
+	db.Create(&historyV1{ID: "h3", Score: "", Total: "n/a"})
+	assert.ErrorContains(t, CheckSchemaVersion(db), "run `migrate up`")
+
+	assert.NoError(t, MigrateUp(db, 0))
+	assert.NoError(t, CheckSchemaVersion(db))
+
+	tests := []struct {
+		id           string
+		score, total int
+	}{
+		{"h1", 4, 5},
+		{"h2", 3, 4},
+		{"h3", 0, 0},
+	}
+	for _, tt := range tests {
+		var h History
+		assert.NoError(t, db.First(&h, "id = ?", tt.id).Error)
+		assert.Equal(t, tt.score, h.Score, tt.id)
+		assert.Equal(t, tt.total, h.Total, tt.id)
+	}
+
+	// Stepping back below migration 3 restores text columns
+	assert.NoError(t, MigrateDown(db, LatestSchemaVersion()-2))
+	version, _ := SchemaVersion(db)
+	assert.Equal(t, 2, version)
+	var old historyV1
+	assert.NoError(t, db.First(&old, "id = ?", "h1").Error)
+	assert.Equal(t, "4", old.Score)
+	assert.Equal(t, "5", old.Total)
+}
+
+func TestMigrateStatusAndRoundTrip(t *testing.T) {
+	db := openMigrationTestDB(t)
+
+	states, err := MigrationStatus(db)
+	assert.NoError(t, err)
+	assert.Len(t, states, len(migrations))
+	for _, st := range states {
+		assert.Empty(t, st.AppliedAt, "nothing is applied on a fresh database")
+	}
+
+	assert.NoError(t, MigrateUp(db, 0))
+	states, _ = MigrationStatus(db)
+	for _, st := range states {
+		assert.NotEmpty(t, st.AppliedAt, st.Name)
+	}
+
+	// Running up again is a no-op
+	assert.NoError(t, MigrateUp(db, 0))
+
+	// All the way down and back up
+	assert.NoError(t, MigrateDown(db, len(migrations)))
+	version, _ := SchemaVersion(db)
+	assert.Equal(t, 0, version)
+	assert.False(t, db.Migrator().HasTable(&User{}))
+
+	assert.NoError(t, MigrateUp(db, 0))
+	assert.True(t, db.Migrator().HasColumn(&History{}, "Score"))
+
+	// A database migrated by a newer build is refused
+	db.Create(&SchemaMigration{Version: LatestSchemaVersion() + 1, Name: "from_the_future"})
+	assert.ErrorContains(t, CheckSchemaVersion(db), "newer than this build")
+}
+
+func TestMigrateWrongBookModule(t *testing.T) {
+	db := openMigrationTestDB(t)
+	assert.NoError(t, MigrateUp(db, 12))
+
+	// Before migration 13 the wrong book was checked against the dashboard
+	db.Model(&rolePermissionV10{}).Where("role = ? AND module_id = ?", RoleTeacher, "dashboard").Update("api_access", false)
+
+	assert.NoError(t, MigrateUp(db, 0))
+	var perms []RolePermission
+	db.Where("module_id = ?", "wrong_book").Find(&perms)
+	access := make(map[Role]bool)
+	for _, p := range perms {
+		access[p.Role] = p.CanRead
+	}
+	assert.Equal(t, map[Role]bool{RoleAdmin: true, RoleTeacher: false, RoleStudent: false, RoleParent: true}, access)
+
+	// Parents end up with the row a new school gives them
+	var parent RolePermission
+	db.First(&parent, "role = ? AND module_id = ?", RoleParent, "wrong_book")
+	for _, want := range defaultParentPermissions() {
+		if want.ModuleID == "wrong_book" {
+			want.TenantID = defaultTenantID
+			assert.Equal(t, want, parent)
+		}
+	}
+}
+
+func TestMigratePermissionActions(t *testing.T) {
+	db := openMigrationTestDB(t)
+
+	assert.NoError(t, MigrateUp(db, 9))
+	db.Where("1 = 1").Delete(&rolePermissionV1{})
+	db.Create(&[]rolePermissionV1{
+		{Role: RoleTeacher, ModuleID: "papers", UIAccess: true, APIAccess: true},
+		{Role: RoleTeacher, ModuleID: "dashboard", UIAccess: true, APIAccess: false},
+	})
+
+	assert.NoError(t, MigrateUp(db, 0))
+	var perms []RolePermission
+	db.Order("module_id").Find(&perms)
+	assert.Equal(t, []RolePermission{
+		{TenantID: defaultTenantID, Role: RoleTeacher, ModuleID: "dashboard", UIAccess: true},
+		{TenantID: defaultTenantID, Role: RoleTeacher, ModuleID: "papers", UIAccess: true, CanRead: true, CanCreate: true, CanUpdate: true, CanDelete: true},
+		{TenantID: defaultTenantID, Role: RoleTeacher, ModuleID: "wrong_book", UIAccess: true},
+	}, perms)
+
+	// Stepping back below migration 14 merges them again
+	assert.NoError(t, MigrateDown(db, LatestSchemaVersion()-13))
+	var old []rolePermissionV10
+	db.Where("module_id = ?", "papers").Find(&old)
+	assert.Len(t, old, 1)
+	assert.True(t, old[0].APIAccess)
+}
+
+// liveModels are all the models the handlers read and write
+var liveModels = []any{
+	&User{}, &Question{}, &Paper{}, &Homework{}, &History{}, &Reinforcement{},
+	&Resource{}, &AuditLog{}, &StudentWrongQuestion{}, &SystemConfig{},
+	&RolePermission{}, &PracticeSession{}, &Class{}, &Asset{}, &UserSession{},
+	&RefreshToken{}, &LoginAttempt{}, &PasswordResetCode{}, &ParentLink{},
+	&ParentInvite{}, &Tenant{}, &UserIdentity{}, &StudentLogin{}, &SSOLoginCode{},
+}
+
+// assertSchemaFitsModels checks every column of the live models exists and
+// that config and permissions are keyed per school
+func assertSchemaFitsModels(t *testing.T, db *gorm.DB) {
+	for _, model := range liveModels {
+		stmt := &gorm.Statement{DB: db}
+		assert.NoError(t, stmt.Parse(model))
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" {
+				assert.True(t, db.Migrator().HasColumn(model, field.DBName), stmt.Schema.Table+"."+field.DBName)
+			}
+		}
+	}
+	for _, table := range []string{"system_configs", "role_permissions"} {
+		keyed, err := primaryKeyHasTenant(db, table)
+		assert.NoError(t, err)
+		assert.True(t, keyed, table)
+	}
+}
+
+func TestMigrateQuestionCreators(t *testing.T) {
+	db := openMigrationTestDB(t)
+	assert.NoError(t, MigrateUp(db, 17))
+
+	db.Create(&[]userRoleV18{
+		{ID: "amy", TenantID: defaultTenantID, Role: RoleTeacher},
+		{ID: "kid", TenantID: defaultTenantID, Role: RoleStudent},
+	})
+	db.Create(&[]questionCreatorV18{
+		{ID: "q1", TenantID: defaultTenantID, StemText: "1 + 1"},
+		{ID: "q2", TenantID: defaultTenantID, StemText: "Twice"},
+		{ID: "q3", TenantID: defaultTenantID, StemText: "Twice"},
+		{ID: "q4", TenantID: defaultTenantID, StemText: "By a student"},
+		{ID: "q5", TenantID: "school2", StemText: "Elsewhere"},
+		{ID: "q6", TenantID: defaultTenantID, StemText: "Taken", CreatorID: "ben"},
+	})
+	for _, stem := range []string{"1 + 1", "Twice", "Elsewhere", "Taken"} {
+		db.Create(&auditLogV18{TenantID: defaultTenantID, UserID: "amy", Action: "CREATE_QUESTION", Details: "Created question: " + stem})
+	}
+	db.Create(&auditLogV18{TenantID: defaultTenantID, UserID: "kid", Action: "CREATE_QUESTION", Details: "Created question: By a student"})
+
+	assert.NoError(t, MigrateUp(db, 0))
+	var questions []questionCreatorV18
+	db.Order("id").Find(&questions)
+	creators := make(map[string]string)
+	for _, q := range questions {
+		creators[q.ID] = q.CreatorID
+	}
+	assert.Equal(t, map[string]string{"q1": "amy", "q2": "", "q3": "", "q4": "", "q5": "", "q6": "ben"}, creators)
+}
+
+func TestMigrateTeacherStudentAccess(t *testing.T) {
+	db := openMigrationTestDB(t)
+	assert.NoError(t, MigrateUp(db, 15))
+
+	// A school that took the students page from its teachers keeps it that way
+	db.Create(&tenantV10{ID: "school2", Code: "s2", Name: "School 2", Status: "active"})
+	db.Create(&rolePermissionV14{TenantID: "school2", Role: RoleTeacher, ModuleID: "students", UIAccess: false})
+
+	assert.NoError(t, MigrateUp(db, 16))
+	var perms []RolePermission
+	db.Where("role = ? AND module_id = ?", RoleTeacher, "students").Order("tenant_id").Find(&perms)
+	assert.Equal(t, []RolePermission{
+		{TenantID: defaultTenantID, Role: RoleTeacher, ModuleID: "students", UIAccess: true, CanRead: true, CanCreate: true, CanUpdate: true},
+		{TenantID: "school2", Role: RoleTeacher, ModuleID: "students"},
+	}, perms)
+}
+
+func TestMigrateOwnStatsAccess(t *testing.T) {
+	db := openMigrationTestDB(t)
+	assert.NoError(t, MigrateUp(db, 18))
+
+	// A school that hid the stats page from students keeps it hidden
+	db.Create(&tenantV10{ID: "school2", Code: "s2", Name: "School 2", Status: "active"})
+	db.Create(&[]rolePermissionV14{
+		{TenantID: "school2", Role: RoleTeacher, ModuleID: "stats", UIAccess: true},
+		{TenantID: "school2", Role: RoleStudent, ModuleID: "stats", UIAccess: false},
+	})
+
+	assert.NoError(t, MigrateUp(db, 0))
+	var perms []RolePermission
+	db.Where("module_id = ? AND role IN ?", "own_stats", []Role{RoleTeacher, RoleStudent}).Order("tenant_id, role").Find(&perms)
+	read := make(map[string]bool)
+	for _, p := range perms {
+		read[p.TenantID+"/"+string(p.Role)] = p.CanRead
+		assert.False(t, p.CanCreate || p.CanUpdate || p.CanDelete, "own stats are read only")
+	}
+	var statsReaders int64
+	db.Model(&RolePermission{}).Where("module_id = ? AND role <> ? AND can_read = ?", "stats", RoleAdmin, true).Count(&statsReaders)
+	assert.Zero(t, statsReaders, "the school-wide stats stay with admins")
+	assert.Equal(t, map[string]bool{
+		defaultTenantID + "/" + string(RoleStudent): true,
+		defaultTenantID + "/" + string(RoleTeacher): true,
+		"school2/" + string(RoleStudent):            false,
+		"school2/" + string(RoleTeacher):            true,
+	}, read)
+}
+
+func TestMigrateFreshDatabaseFitsModels(t *testing.T) {
+	db := openMigrationTestDB(t)
+	assert.NoError(t, MigrateUp(db, 0))
+	assertSchemaFitsModels(t, db)
+}
+
+func TestMigrateFromBaselineSchema(t *testing.T) {
+	db := openMigrationTestDB(t)
+	fixture, err := os.ReadFile(filepath.Join("testdata", "baseline_schema.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range strings.Split(string(fixture), ";\n") {
+		var lines []string
+		for _, line := range strings.Split(stmt, "\n") {
+			if !strings.HasPrefix(line, "--") {
+				lines = append(lines, line)
+			}
+		}
+		if stmt = strings.TrimSpace(strings.Join(lines, "\n")); stmt != "" {
+			assert.NoError(t, db.Exec(stmt).Error, stmt)
+		}
+	}
+
+	assert.NoError(t, MigrateUp(db, 0))
+	assertSchemaFitsModels(t, db)
+
+	// The rows are kept and belong to the default school
+	var admin User
+	assert.NoError(t, db.First(&admin, "id = ?", "1").Error)
+	assert.Equal(t, defaultTenantID, admin.TenantID)
+	var h History
+	assert.NoError(t, db.First(&h, "id = ?", "h1").Error)
+	assert.Equal(t, 3, h.Score)
+	var papers RolePermission
+	assert.NoError(t, db.First(&papers, "role = ? AND module_id = ?", RoleTeacher, "papers").Error)
+	assert.Equal(t, RolePermission{TenantID: defaultTenantID, Role: RoleTeacher, ModuleID: "papers", UIAccess: true}, papers)
+	var conf SystemConfig
+	assert.NoError(t, db.Where(&SystemConfig{Key: "error_logic"}).First(&conf).Error)
+	assert.Equal(t, `{"globalEnabled": false}`, conf.Value, "seeding keeps the existing config")
+
+	// A second school stores its own config and permissions under the same keys
+	assert.NoError(t, db.Create(&RolePermission{TenantID: "school2", Role: RoleAdmin, ModuleID: "dashboard", CanRead: true}).Error)
+	assert.NoError(t, db.Create(&SystemConfig{TenantID: "school2", Key: "error_logic", Value: "{}"}).Error)
+}
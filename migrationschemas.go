@@ -0,0 +1,523 @@
+package main
+
+// Tables as the migration in their name created or changed them. Migrations
+// only ever use these snapshots, never the live models, so a schema version
+// stands for the same tables whatever the models look like today. Change a
+// model through a new migration with a new snapshot, never by editing these.
+
+// Migration 1: the tables the boot-time AutoMigrate created before schema
+// versions existed
+
+type userV1 struct {
+	ID       string `gorm:"primaryKey;type:varchar(191)"`
+	Username string `gorm:"type:varchar(191);unique"`
+	Name     string `gorm:"type:varchar(191)"`
+	Password string `gorm:"type:varchar(191)"`
+	Role     Role   `gorm:"type:varchar(191)"`
+	Status   string `gorm:"type:varchar(191)"`
+}
+
+func (userV1) TableName() string { return "users" }
+
+type questionV1 struct {
+	ID          string `gorm:"primaryKey;type:varchar(191)"`
+	Subject     string `gorm:"type:varchar(191)"`
+	Grade       int
+	Type        string `gorm:"type:varchar(191)"`
+	StemText    string `gorm:"type:text"`
+	StemImage   string `gorm:"type:text"`
+	StemAssetID string `gorm:"type:varchar(191)"`
+	StemSrcSet  string `gorm:"type:text"`
+	Answer      string `gorm:"type:text"`
+	Options     []any  `gorm:"serializer:json"`
+	Hint        string `gorm:"type:text"`
+}
+
+func (questionV1) TableName() string { return "questions" }
+
+type paperV1 struct {
+	ID          string   `gorm:"primaryKey;type:varchar(191)"`
+	Name        string   `gorm:"type:varchar(191)"`
+	Questions   []any    `gorm:"serializer:json"`
+	QuestionIDs []string `gorm:"serializer:json"`
+	Total       int
+}
+
+func (paperV1) TableName() string { return "papers" }
+
+type homeworkV1 struct {
+	ID         string `gorm:"primaryKey;type:varchar(191)"`
+	TeacherID  string `gorm:"type:varchar(191)"`
+	PaperID    string `gorm:"type:varchar(191)"`
+	Name       string `gorm:"type:varchar(191)"`
+	ClassID    string `gorm:"type:varchar(191)"`
+	StartDate  string `gorm:"type:varchar(191)"`
+	EndDate    string `gorm:"type:varchar(191)"`
+	Status     string `gorm:"type:varchar(191)"`
+	Completed  int
+	Total      int
+	StudentIDs []string `gorm:"serializer:json"`
+}
+
+func (homeworkV1) TableName() string { return "homeworks" }
+
+// historyV1 has text score columns, migration 3 turns them into integers
+type historyV1 struct {
+	ID           string `gorm:"primaryKey;type:varchar(191)"`
+	StudentID    string `gorm:"type:varchar(191)"`
+	HomeworkID   string `gorm:"type:varchar(191)"`
+	Type         string `gorm:"type:varchar(191)"`
+	Name         string `gorm:"type:varchar(191)"`
+	NameEn       string `gorm:"type:varchar(191)"`
+	Date         string `gorm:"type:varchar(191)"`
+	Score        string `gorm:"type:varchar(191)"`
+	Total        string `gorm:"type:varchar(191)"`
+	CorrectCount int
+	WrongCount   int
+	SessionID    string `gorm:"type:varchar(191)"`
+	Questions    []any  `gorm:"serializer:json"`
+}
+
+func (historyV1) TableName() string { return "histories" }
+
+type reinforcementV1 struct {
+	ID               string `gorm:"primaryKey;type:varchar(191)"`
+	Name             string `gorm:"type:varchar(191)"`
+	Type             string `gorm:"type:varchar(191)"`
+	Image            string `gorm:"type:text"`
+	Prompt           string `gorm:"type:text"`
+	Duration         int
+	IsGlobal         bool
+	TargetStudentIDs []string `gorm:"serializer:json"`
+	RuleType         string   `gorm:"type:varchar(191)"`
+	RuleValue        int
+	IsActive         bool `gorm:"default:true"`
+}
+
+func (reinforcementV1) TableName() string { return "reinforcements" }
+
+type resourceV1 struct {
+	ID           string   `gorm:"primaryKey;type:varchar(191)"`
+	Name         string   `gorm:"type:varchar(191)"`
+	URL          string   `gorm:"type:text"`
+	AssetID      string   `gorm:"type:varchar(191)"`
+	ThumbnailURL string   `gorm:"type:text"`
+	Type         string   `gorm:"type:varchar(191)"`
+	Tags         []string `gorm:"serializer:json"`
+	Visibility   string   `gorm:"type:varchar(191)"`
+	CreatorID    string   `gorm:"type:varchar(191)"`
+	CreatedAt    string   `gorm:"type:varchar(191)"`
+}
+
+func (resourceV1) TableName() string { return "resources" }
+
+type auditLogV1 struct {
+	ID        string `gorm:"primaryKey;type:varchar(191)"`
+	UserID    string `gorm:"type:varchar(191)"`
+	Username  string `gorm:"type:varchar(191)"`
+	Action    string `gorm:"type:varchar(191)"`
+	Details   string `gorm:"type:text"`
+	Timestamp string `gorm:"type:varchar(191)"`
+}
+
+func (auditLogV1) TableName() string { return "audit_logs" }
+
+type studentWrongQuestionV1 struct {
+	ID          string     `gorm:"primaryKey;type:varchar(191)"`
+	StudentID   string     `gorm:"type:varchar(191);index"`
+	QuestionID  string     `gorm:"type:varchar(191);index"`
+	Question    questionV1 `gorm:"foreignKey:QuestionID"`
+	Status      int
+	ErrorCount  int
+	LastUpdated string `gorm:"type:varchar(191)"`
+}
+
+func (studentWrongQuestionV1) TableName() string { return "student_wrong_questions" }
+
+// systemConfigV1 and rolePermissionV1 are keyed without a school, migration
+// 10 rebuilds them
+type systemConfigV1 struct {
+	Key   string `gorm:"primaryKey;type:varchar(191)"`
+	Value string `gorm:"type:text"`
+}
+
+func (systemConfigV1) TableName() string { return "system_configs" }
+
+type rolePermissionV1 struct {
+	Role      Role   `gorm:"primaryKey;type:varchar(191)"`
+	ModuleID  string `gorm:"primaryKey;type:varchar(191)"`
+	UIAccess  bool
+	APIAccess bool
+}
+
+func (rolePermissionV1) TableName() string { return "role_permissions" }
+
+type practiceSessionV1 struct {
+	ID          string         `gorm:"primaryKey;type:varchar(191)"`
+	StudentID   string         `gorm:"type:varchar(191);index"`
+	HomeworkID  string         `gorm:"type:varchar(191)"`
+	QuestionIDs []string       `gorm:"serializer:json"`
+	Attempts    map[string]any `gorm:"serializer:json"`
+	Status      string         `gorm:"type:varchar(191)"`
+	CreatedAt   string         `gorm:"type:varchar(191)"`
+}
+
+func (practiceSessionV1) TableName() string { return "practice_sessions" }
+
+type classV1 struct {
+	ID         string `gorm:"primaryKey;type:varchar(191)"`
+	Name       string `gorm:"type:varchar(191)"`
+	Grade      int
+	Subject    string   `gorm:"type:varchar(191)"`
+	TeacherIDs []string `gorm:"serializer:json"`
+	StudentIDs []string `gorm:"serializer:json"`
+	CreatedAt  string   `gorm:"type:varchar(191)"`
+}
+
+func (classV1) TableName() string { return "classes" }
+
+type assetV1 struct {
+	ID           string `gorm:"primaryKey;type:varchar(191)"`
+	Kind         string `gorm:"type:varchar(191)"`
+	MimeType     string `gorm:"type:varchar(191)"`
+	Size         int64
+	Name         string `gorm:"type:varchar(191)"`
+	Key          string `gorm:"type:varchar(191)"`
+	URL          string `gorm:"type:text"`
+	Width        int
+	Height       int
+	Variants     []any  `gorm:"serializer:json"`
+	SrcSet       string `gorm:"type:text"`
+	ThumbnailURL string `gorm:"type:text"`
+	CreatorID    string `gorm:"type:varchar(191)"`
+	CreatedAt    string `gorm:"type:varchar(191)"`
+}
+
+func (assetV1) TableName() string { return "assets" }
+
+// initialSchemaV1 lists the migration 1 tables in creation order
+var initialSchemaV1 = []any{
+	&userV1{}, &questionV1{}, &paperV1{}, &homeworkV1{}, &historyV1{},
+	&reinforcementV1{}, &resourceV1{}, &auditLogV1{}, &studentWrongQuestionV1{},
+	&systemConfigV1{}, &rolePermissionV1{}, &practiceSessionV1{}, &classV1{}, &assetV1{},
+}
+
+// Migration 2: the rows the boot-time seeding created
+
+// rolePermissionsV2 are the permissions of an empty database before schools
+// and parents existed
+func rolePermissionsV2() []rolePermissionV1 {
+	modules := []string{"dashboard", "students", "questions", "papers", "assignments", "reinforcements", "resources", "users", "homework_audit", "audit_logs", "stats", "help_docs", "permissions", "system_config"}
+	teacherAPI := map[string]bool{"dashboard": true, "students": false, "questions": true, "papers": true, "assignments": true, "reinforcements": true, "resources": true, "stats": false, "help_docs": true}
+	studentAPI := map[string]bool{"dashboard": false, "assignments": true, "stats": false, "help_docs": false}
+
+	var perms []rolePermissionV1
+	for _, m := range modules {
+		perms = append(perms, rolePermissionV1{Role: RoleAdmin, ModuleID: m, UIAccess: true, APIAccess: true})
+	}
+	for _, m := range modules {
+		if api, ok := teacherAPI[m]; ok {
+			perms = append(perms, rolePermissionV1{Role: RoleTeacher, ModuleID: m, UIAccess: true, APIAccess: api})
+		}
+	}
+	for _, m := range modules {
+		if api, ok := studentAPI[m]; ok {
+			perms = append(perms, rolePermissionV1{Role: RoleStudent, ModuleID: m, UIAccess: true, APIAccess: api})
+		}
+	}
+	return perms
+}
+
+const errorLogicV2 = `{
+	"globalEnabled": true,
+	"excludeMistakesFromPractice": false,
+	"stages": {
+		"1": {"nextWrong": 2, "nextCorrect": 4, "showAnswer": false, "label": "出错"},
+		"2": {"nextWrong": 3, "nextCorrect": 4, "showAnswer": true, "label": "重试 (有答案)"},
+		"3": {"nextWrong": 5, "nextCorrect": 4, "showAnswer": false, "label": "重试 (无答案)"},
+		"4": {"nextWrong": 1, "nextCorrect": 4, "showAnswer": false, "label": "已知"},
+		"5": {"nextWrong": 5, "nextCorrect": 5, "showAnswer": false, "label": "困难"}
+	}
+}`
+
+// Migration 3
+
+// historyScoreColumns holds the columns migration 3 swaps between text and int
+type historyScoreColumns struct {
+	ScoreText string `gorm:"type:varchar(191)"`
+	TotalText string `gorm:"type:varchar(191)"`
+	Score     int
+	Total     int
+}
+
+func (historyScoreColumns) TableName() string { return "histories" }
+
+// Migration 4
+
+type userSessionV4 struct {
+	ID         string `gorm:"primaryKey;type:varchar(191)"`
+	UserID     string `gorm:"type:varchar(191);index"`
+	UserAgent  string `gorm:"type:text"`
+	IP         string `gorm:"type:varchar(191)"`
+	ExpiresAt  string `gorm:"type:varchar(191)"`
+	RevokedAt  string `gorm:"type:varchar(191)"`
+	CreatedAt  string `gorm:"type:varchar(191)"`
+	LastUsedAt string `gorm:"type:varchar(191)"`
+}
+
+func (userSessionV4) TableName() string { return "user_sessions" }
+
+type refreshTokenV4 struct {
+	Hash      string `gorm:"primaryKey;type:varchar(191)"`
+	SessionID string `gorm:"type:varchar(191);index"`
+	UsedAt    string `gorm:"type:varchar(191)"`
+	CreatedAt string `gorm:"type:varchar(191)"`
+}
+
+func (refreshTokenV4) TableName() string { return "refresh_tokens" }
+
+// Migration 5
+
+type loginAttemptV5 struct {
+	Key           string `gorm:"primaryKey;type:varchar(191)"`
+	Failures      int
+	LastFailureAt string `gorm:"type:varchar(191)"`
+	BlockedUntil  string `gorm:"type:varchar(191)"`
+	LockedUntil   string `gorm:"type:varchar(191)"`
+}
+
+func (loginAttemptV5) TableName() string { return "login_attempts" }
+
+// Migration 6
+
+type userPasswordV6 struct {
+	ID                 string `gorm:"primaryKey;type:varchar(191)"`
+	Password           string `gorm:"type:varchar(191)"`
+	MustChangePassword bool
+}
+
+func (userPasswordV6) TableName() string { return "users" }
+
+// Migration 7
+
+type passwordResetCodeV7 struct {
+	ID        string `gorm:"primaryKey;type:varchar(191)"`
+	UserID    string `gorm:"type:varchar(191);index"`
+	CodeHash  string `gorm:"type:varchar(191)"`
+	Channel   string `gorm:"type:varchar(191)"`
+	Attempts  int
+	ExpiresAt string `gorm:"type:varchar(191)"`
+	UsedAt    string `gorm:"type:varchar(191)"`
+	CreatedBy string `gorm:"type:varchar(191)"`
+	CreatedAt string `gorm:"type:varchar(191)"`
+}
+
+func (passwordResetCodeV7) TableName() string { return "password_reset_codes" }
+
+// Migration 8
+
+type userGradeV8 struct {
+	Grade int
+}
+
+func (userGradeV8) TableName() string { return "users" }
+
+// Migration 9
+
+type parentLinkV9 struct {
+	ParentID  string `gorm:"primaryKey;type:varchar(191)"`
+	StudentID string `gorm:"primaryKey;type:varchar(191);index"`
+	CreatedAt string `gorm:"type:varchar(191)"`
+}
+
+func (parentLinkV9) TableName() string { return "parent_links" }
+
+type parentInviteV9 struct {
+	ID        string `gorm:"primaryKey;type:varchar(191)"`
+	StudentID string `gorm:"type:varchar(191);index"`
+	CodeHash  string `gorm:"type:varchar(191);uniqueIndex"`
+	ExpiresAt string `gorm:"type:varchar(191)"`
+	UsedAt    string `gorm:"type:varchar(191)"`
+	UsedBy    string `gorm:"type:varchar(191)"`
+	CreatedBy string `gorm:"type:varchar(191)"`
+	CreatedAt string `gorm:"type:varchar(191)"`
+}
+
+func (parentInviteV9) TableName() string { return "parent_invites" }
+
+// parentPermissionsV9 are the rows migration 9 gives the new PARENT role
+var parentPermissionsV9 = []rolePermissionV1{
+	{Role: RoleParent, ModuleID: "children", UIAccess: true, APIAccess: true},
+	{Role: RoleParent, ModuleID: "students", UIAccess: false, APIAccess: true},
+	{Role: RoleParent, ModuleID: "dashboard", UIAccess: true, APIAccess: true},
+	{Role: RoleParent, ModuleID: "help_docs", UIAccess: true, APIAccess: false},
+}
+
+// Migration 10
+
+type tenantV10 struct {
+	ID        string `gorm:"primaryKey;type:varchar(191)"`
+	Code      string `gorm:"type:varchar(191);uniqueIndex"`
+	Name      string `gorm:"type:varchar(191)"`
+	Status    string `gorm:"type:varchar(191)"`
+	CreatedAt string `gorm:"type:varchar(191)"`
+}
+
+func (tenantV10) TableName() string { return "tenants" }
+
+type systemConfigV10 struct {
+	TenantID string `gorm:"primaryKey;type:varchar(191);default:'default'"`
+	Key      string `gorm:"primaryKey;type:varchar(191)"`
+	Value    string `gorm:"type:text"`
+}
+
+func (systemConfigV10) TableName() string { return "system_configs" }
+
+// rolePermissionV10 has the school in its key; migration 14 splits APIAccess
+type rolePermissionV10 struct {
+	TenantID  string `gorm:"primaryKey;type:varchar(191)"`
+	Role      Role   `gorm:"primaryKey;type:varchar(191)"`
+	ModuleID  string `gorm:"primaryKey;type:varchar(191)"`
+	UIAccess  bool
+	APIAccess bool
+}
+
+func (rolePermissionV10) TableName() string { return "role_permissions" }
+
+// tenantTablesV10 are the tables migration 10 adds a tenant_id column to;
+// tables created later have it from the start
+var tenantTablesV10 = []string{
+	"users", "questions", "papers", "homeworks", "classes", "histories",
+	"practice_sessions", "resources", "assets", "audit_logs", "reinforcements",
+	"student_wrong_questions", "parent_links", "parent_invites",
+}
+
+// Migration 11
+
+type userIdentityV11 struct {
+	Issuer      string `gorm:"primaryKey;type:varchar(191)"`
+	Subject     string `gorm:"primaryKey;type:varchar(191)"`
+	UserID      string `gorm:"type:varchar(191);index"`
+	CreatedAt   string `gorm:"type:varchar(191)"`
+	LastLoginAt string `gorm:"type:varchar(191)"`
+}
+
+func (userIdentityV11) TableName() string { return "user_identities" }
+
+// Migration 12
+
+type studentLoginV12 struct {
+	StudentID       string `gorm:"primaryKey;type:varchar(191)"`
+	TenantID        string `gorm:"type:varchar(191);default:'default';index"`
+	CardHash        string `gorm:"type:varchar(191);index"`
+	CardIssuedAt    string `gorm:"type:varchar(191)"`
+	PictureHash     string `gorm:"type:varchar(191)"`
+	PictureIssuedAt string `gorm:"type:varchar(191)"`
+	LastLoginAt     string `gorm:"type:varchar(191)"`
+	UpdatedBy       string `gorm:"type:varchar(191)"`
+}
+
+func (studentLoginV12) TableName() string { return "student_logins" }
+
+type classRosterV12 struct {
+	RosterCodeHash string `gorm:"type:varchar(191);index"`
+}
+
+func (classRosterV12) TableName() string { return "classes" }
+
+// Migration 14
+
+type rolePermissionV14 struct {
+	TenantID  string `gorm:"primaryKey;type:varchar(191)"`
+	Role      Role   `gorm:"primaryKey;type:varchar(191)"`
+	ModuleID  string `gorm:"primaryKey;type:varchar(191)"`
+	UIAccess  bool
+	CanRead   bool
+	CanCreate bool
+	CanUpdate bool
+	CanDelete bool
+}
+
+func (rolePermissionV14) TableName() string { return "role_permissions" }
+
+// Migration 15
+
+type questionOwnershipV15 struct {
+	CreatorID  string   `gorm:"type:varchar(191)"`
+	CoOwnerIDs []string `gorm:"serializer:json"`
+	Department string   `gorm:"type:varchar(191)"`
+}
+
+func (questionOwnershipV15) TableName() string { return "questions" }
+
+type paperOwnershipV15 struct {
+	CreatorID  string   `gorm:"type:varchar(191)"`
+	CoOwnerIDs []string `gorm:"serializer:json"`
+	Department string   `gorm:"type:varchar(191)"`
+}
+
+func (paperOwnershipV15) TableName() string { return "papers" }
+
+type reinforcementOwnershipV15 struct {
+	CreatorID  string   `gorm:"type:varchar(191)"`
+	CoOwnerIDs []string `gorm:"serializer:json"`
+	Department string   `gorm:"type:varchar(191)"`
+}
+
+func (reinforcementOwnershipV15) TableName() string { return "reinforcements" }
+
+type userDepartmentV15 struct {
+	Department string `gorm:"type:varchar(191)"`
+}
+
+func (userDepartmentV15) TableName() string { return "users" }
+
+// contentOwnershipV15 are the tables migration 15 gives an owner
+var contentOwnershipV15 = []any{&questionOwnershipV15{}, &paperOwnershipV15{}, &reinforcementOwnershipV15{}}
+
+// Migration 17
+
+type ssoLoginCodeV17 struct {
+	Hash      string `gorm:"primaryKey;type:varchar(191)"`
+	UserID    string `gorm:"type:varchar(191)"`
+	ExpiresAt string `gorm:"type:varchar(191);index"`
+}
+
+func (ssoLoginCodeV17) TableName() string { return "sso_login_codes" }
+
+// Migration 18
+
+type auditLogV18 struct {
+	TenantID  string `gorm:"type:varchar(191)"`
+	UserID    string `gorm:"type:varchar(191)"`
+	Action    string `gorm:"type:varchar(191)"`
+	Details   string `gorm:"type:text"`
+	Timestamp string `gorm:"type:varchar(191)"`
+}
+
+func (auditLogV18) TableName() string { return "audit_logs" }
+
+type questionCreatorV18 struct {
+	ID        string `gorm:"primaryKey;type:varchar(191)"`
+	TenantID  string `gorm:"type:varchar(191)"`
+	StemText  string `gorm:"type:text"`
+	CreatorID string `gorm:"type:varchar(191)"`
+}
+
+func (questionCreatorV18) TableName() string { return "questions" }
+
+type userRoleV18 struct {
+	ID       string `gorm:"primaryKey;type:varchar(191)"`
+	TenantID string `gorm:"type:varchar(191)"`
+	Role     Role   `gorm:"type:varchar(191)"`
+}
+
+func (userRoleV18) TableName() string { return "users" }
+
+// Migration 20
+
+type userSessionV20 struct {
+	PasswordFree bool
+}
+
+func (userSessionV20) TableName() string { return "user_sessions" }
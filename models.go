@@ -6,15 +6,122 @@ const (
 	RoleStudent Role = "STUDENT"
 	RoleTeacher Role = "TEACHER"
 	RoleAdmin   Role = "ADMIN"
+	RoleParent  Role = "PARENT"
+	// Manages tenants; has no access to the data inside a school
+	RoleSuperAdmin Role = "SUPER_ADMIN"
 )
 
+// Tenant is one school. Every school-owned row carries its TenantID and
+// queries made through TenantDB only ever see the caller's school.
+type Tenant struct {
+	ID        string `json:"id" gorm:"primaryKey;type:varchar(191)"`
+	Code      string `json:"code" gorm:"type:varchar(191);uniqueIndex"`
+	Name      string `json:"name" gorm:"type:varchar(191)"`
+	Status    string `json:"status" gorm:"type:varchar(191)"` // "active", "disabled"
+	CreatedAt string `json:"createdAt" gorm:"type:varchar(191)"`
+}
+
 type User struct {
 	ID       string `json:"id" gorm:"primaryKey;type:varchar(191)"`
+	TenantID string `json:"-" gorm:"type:varchar(191);default:'default';index"`
 	Username string `json:"username" gorm:"type:varchar(191);unique"`
 	Name     string `json:"name" gorm:"type:varchar(191)"`
 	Password string `json:"password,omitempty" gorm:"type:varchar(191)"`
 	Role     Role   `json:"role" gorm:"type:varchar(191)"`
 	Status   string `json:"status" gorm:"type:varchar(191)"`
+	Grade    int    `json:"grade,omitempty"`
+	// Teachers of a department share its content pool, see Ownership
+	Department string `json:"department,omitempty" gorm:"type:varchar(191)"`
+	// Set for accounts whose password an admin chose; only PUT /me works until it is changed
+	MustChangePassword bool `json:"mustChangePassword"`
+}
+
+// UserSession is one logged-in device. Access tokens carry its ID as "sid",
+// so revoking the session cuts off both its refresh and access tokens.
+type UserSession struct {
+	ID         string `json:"id" gorm:"primaryKey;type:varchar(191)"`
+	UserID     string `json:"userId" gorm:"type:varchar(191);index"`
+	UserAgent  string `json:"userAgent" gorm:"type:text"`
+	IP         string `json:"ip" gorm:"type:varchar(191)"`
+	ExpiresAt  string `json:"expiresAt" gorm:"type:varchar(191)"`
+	RevokedAt  string `json:"revokedAt,omitempty" gorm:"type:varchar(191)"`
+	CreatedAt  string `json:"createdAt" gorm:"type:varchar(191)"`
+	LastUsedAt string `json:"lastUsedAt" gorm:"type:varchar(191)"`
+	// PasswordFree sessions began with a login card or picture password
+	PasswordFree bool `json:"passwordFree"`
+}
+
+// RefreshToken is one issued refresh token of a session, stored as a SHA-256
+// hash. Each token is single-use: refreshing marks it used and issues the next.
+type RefreshToken struct {
+	Hash      string `gorm:"primaryKey;type:varchar(191)"`
+	SessionID string `gorm:"type:varchar(191);index"`
+	UsedAt    string `gorm:"type:varchar(191)"`
+	CreatedAt string `gorm:"type:varchar(191)"`
+}
+
+// PasswordResetCode is a one-time code for setting a new password without the
+// old one. Only a SHA-256 hash of the code is stored.
+type PasswordResetCode struct {
+	ID        string `json:"id" gorm:"primaryKey;type:varchar(191)"`
+	UserID    string `json:"userId" gorm:"type:varchar(191);index"`
+	CodeHash  string `json:"-" gorm:"type:varchar(191)"`
+	Channel   string `json:"channel" gorm:"type:varchar(191)"` // "notify" or "printed"
+	Attempts  int    `json:"attempts"`
+	ExpiresAt string `json:"expiresAt" gorm:"type:varchar(191)"`
+	UsedAt    string `json:"usedAt,omitempty" gorm:"type:varchar(191)"`
+	CreatedBy string `json:"createdBy" gorm:"type:varchar(191)"`
+	CreatedAt string `json:"createdAt" gorm:"type:varchar(191)"`
+}
+
+// ParentLink gives a parent read-only access to one of their children
+type ParentLink struct {
+	ParentID  string `json:"parentId" gorm:"primaryKey;type:varchar(191)"`
+	StudentID string `json:"studentId" gorm:"primaryKey;type:varchar(191);index"`
+	TenantID  string `json:"-" gorm:"type:varchar(191);default:'default';index"`
+	CreatedAt string `json:"createdAt" gorm:"type:varchar(191)"`
+}
+
+// ParentInvite is a one-time code a teacher hands out so a parent can link
+// their account to a student. Only a SHA-256 hash of the code is stored.
+type ParentInvite struct {
+	ID        string `json:"id" gorm:"primaryKey;type:varchar(191)"`
+	TenantID  string `json:"-" gorm:"type:varchar(191);default:'default';index"`
+	StudentID string `json:"studentId" gorm:"type:varchar(191);index"`
+	CodeHash  string `json:"-" gorm:"type:varchar(191);uniqueIndex"`
+	ExpiresAt string `json:"expiresAt" gorm:"type:varchar(191)"`
+	UsedAt    string `json:"usedAt,omitempty" gorm:"type:varchar(191)"`
+	UsedBy    string `json:"usedBy,omitempty" gorm:"type:varchar(191)"`
+	CreatedBy string `json:"createdBy" gorm:"type:varchar(191)"`
+	CreatedAt string `json:"createdAt" gorm:"type:varchar(191)"`
+}
+
+// UserIdentity links an account to its subject at a single sign-on provider
+type UserIdentity struct {
+	Issuer      string `json:"issuer" gorm:"primaryKey;type:varchar(191)"`
+	Subject     string `json:"subject" gorm:"primaryKey;type:varchar(191)"`
+	UserID      string `json:"userId" gorm:"type:varchar(191);index"`
+	CreatedAt   string `json:"createdAt" gorm:"type:varchar(191)"`
+	LastLoginAt string `json:"lastLoginAt" gorm:"type:varchar(191)"`
+}
+
+// SSOLoginCode hands a finished single sign-on from the callback to the web
+// app, which redeems it once for the session tokens. Only a SHA-256 hash of
+// the code is stored.
+type SSOLoginCode struct {
+	Hash      string `gorm:"primaryKey;type:varchar(191)"`
+	UserID    string `gorm:"type:varchar(191)"`
+	ExpiresAt string `gorm:"type:varchar(191);index"`
+}
+
+// LoginAttempt counts recent failed logins for one username ("user:<name>")
+// or client IP ("ip:<addr>")
+type LoginAttempt struct {
+	Key           string `json:"key" gorm:"primaryKey;type:varchar(191)"`
+	Failures      int    `json:"failures"`
+	LastFailureAt string `json:"lastFailureAt" gorm:"type:varchar(191)"`
+	BlockedUntil  string `json:"blockedUntil,omitempty" gorm:"type:varchar(191)"`
+	LockedUntil   string `json:"lockedUntil,omitempty" gorm:"type:varchar(191)"`
 }
 
 type LoginRequest struct {
@@ -25,24 +132,57 @@ type LoginRequest struct {
 type RegisterRequest struct {
 	PhoneNumber string `json:"phoneNumber" binding:"required"`
 	Password    string `json:"password" binding:"required"`
+	// A parent invite code registers a PARENT account linked to the invited student
+	InviteCode string `json:"inviteCode"`
+	// Code of the school to join, the default school when empty
+	Tenant string `json:"tenant"`
 }
 
 type Question struct {
-	ID         string   `json:"id" gorm:"primaryKey;type:varchar(191)"`
-	Subject    string   `json:"subject" gorm:"type:varchar(191)"`
-	Grade      int      `json:"grade"`
-	Type       string   `json:"type" gorm:"type:varchar(191)"`
-	StemText   string   `json:"stemText" gorm:"type:text"`
-	StemImage  string   `json:"stemImage,omitempty" gorm:"type:text"`
-	Answer     string   `json:"answer" gorm:"type:text"`
-	Options    []Option `json:"options,omitempty" gorm:"serializer:json"`
-	Hint       string   `json:"hint,omitempty" gorm:"type:text"`
+	ID          string   `json:"id" gorm:"primaryKey;type:varchar(191)"`
+	TenantID    string   `json:"-" gorm:"type:varchar(191);default:'default';index"`
+	Subject     string   `json:"subject" gorm:"type:varchar(191)"`
+	Grade       int      `json:"grade"`
+	Type        string   `json:"type" gorm:"type:varchar(191)"`
+	StemText    string   `json:"stemText" gorm:"type:text"`
+	StemImage   string   `json:"stemImage,omitempty" gorm:"type:text"`
+	StemAssetID string   `json:"stemAssetId,omitempty" gorm:"type:varchar(191)"`
+	StemSrcSet  string   `json:"stemSrcset,omitempty" gorm:"type:text"`
+	Answer      string   `json:"answer" gorm:"type:text"`
+	Options     []Option `json:"options,omitempty" gorm:"serializer:json"`
+	Hint        string   `json:"hint,omitempty" gorm:"type:text"`
+	Ownership
+}
+
+// Ownership records who authored a piece of content and who else may change
+// it. The rules are in ownership.go.
+type Ownership struct {
+	CreatorID  string   `json:"creatorId" gorm:"type:varchar(191)"`
+	CoOwnerIDs []string `json:"coOwnerIds" gorm:"serializer:json"`
+	// Department puts the content in that department's pool, which all its teachers may edit
+	Department string `json:"department,omitempty" gorm:"type:varchar(191)"`
+}
+
+// StudentQuestion is the question payload sent to students. It carries no
+// Answer or Hint, those are only unlocked through the answer check endpoint.
+type StudentQuestion struct {
+	ID          string   `json:"id"`
+	Subject     string   `json:"subject"`
+	Grade       int      `json:"grade"`
+	Type        string   `json:"type"`
+	StemText    string   `json:"stemText"`
+	StemImage   string   `json:"stemImage,omitempty"`
+	StemAssetID string   `json:"stemAssetId,omitempty"`
+	StemSrcSet  string   `json:"stemSrcset,omitempty"`
+	Options     []Option `json:"options,omitempty"`
 }
 
 type Option struct {
-	Text  string `json:"text,omitempty"`
-	Image string `json:"image,omitempty"`
-	Value string `json:"value"`
+	Text    string `json:"text,omitempty"`
+	Image   string `json:"image,omitempty"`
+	AssetID string `json:"assetId,omitempty"`
+	SrcSet  string `json:"srcset,omitempty"`
+	Value   string `json:"value"`
 }
 
 type StatPoint struct {
@@ -79,6 +219,7 @@ type DashboardStats struct {
 
 type Reinforcement struct {
 	ID               string   `json:"id" gorm:"primaryKey;type:varchar(191)"`
+	TenantID         string   `json:"-" gorm:"type:varchar(191);default:'default';index"`
 	Name             string   `json:"name" gorm:"type:varchar(191)"`
 	Type             string   `json:"type" gorm:"type:varchar(191)"`
 	Image            string   `json:"image,omitempty" gorm:"type:text"`
@@ -89,18 +230,22 @@ type Reinforcement struct {
 	RuleType         string   `json:"ruleType" gorm:"type:varchar(191)"` // "fixed", "correct_count", "average"
 	RuleValue        int      `json:"ruleValue"`
 	IsActive         bool     `json:"isActive" gorm:"default:true"`
+	Ownership
 }
 
 type Paper struct {
 	ID          string     `json:"id" gorm:"primaryKey;type:varchar(191)"`
+	TenantID    string     `json:"-" gorm:"type:varchar(191);default:'default';index"`
 	Name        string     `json:"name" gorm:"type:varchar(191)"`
 	Questions   []Question `json:"questions" gorm:"serializer:json"`
 	QuestionIDs []string   `json:"questionIds,omitempty" gorm:"serializer:json"`
 	Total       int        `json:"total"`
+	Ownership
 }
 
 type Homework struct {
 	ID         string   `json:"id" gorm:"primaryKey;type:varchar(191)"`
+	TenantID   string   `json:"-" gorm:"type:varchar(191);default:'default';index"`
 	TeacherID  string   `json:"teacherId" gorm:"type:varchar(191)"`
 	PaperID    string   `json:"paperId" gorm:"type:varchar(191)"`
 	Name       string   `json:"name" gorm:"type:varchar(191)"`
@@ -113,19 +258,50 @@ type Homework struct {
 	StudentIDs []string `json:"studentIds,omitempty" gorm:"serializer:json"`
 }
 
+// Class groups students under one or more teachers. Homework assigned to a
+// class is expanded to its current StudentIDs.
+type Class struct {
+	ID         string   `json:"id" gorm:"primaryKey;type:varchar(191)"`
+	TenantID   string   `json:"-" gorm:"type:varchar(191);default:'default';index"`
+	Name       string   `json:"name" gorm:"type:varchar(191)"`
+	Grade      int      `json:"grade"`
+	Subject    string   `json:"subject" gorm:"type:varchar(191)"`
+	TeacherIDs []string `json:"teacherIds" gorm:"serializer:json"`
+	StudentIDs []string `json:"studentIds" gorm:"serializer:json"`
+	CreatedAt  string   `json:"createdAt" gorm:"type:varchar(191)"`
+	// SHA-256 of the code that opens the class roster on a classroom tablet
+	RosterCodeHash string `json:"-" gorm:"type:varchar(191);index"`
+}
+
+// StudentLogin holds the password-free ways in of a young student: a printed
+// QR card and a picture sequence picked after their name on the class roster.
+// Only hashes are stored; clearing a field revokes that way in.
+type StudentLogin struct {
+	StudentID       string `json:"studentId" gorm:"primaryKey;type:varchar(191)"`
+	TenantID        string `json:"-" gorm:"type:varchar(191);default:'default';index"`
+	CardHash        string `json:"-" gorm:"type:varchar(191);index"` // SHA-256 of the card token
+	CardIssuedAt    string `json:"cardIssuedAt" gorm:"type:varchar(191)"`
+	PictureHash     string `json:"-" gorm:"type:varchar(191)"` // bcrypt of the picture sequence
+	PictureIssuedAt string `json:"pictureIssuedAt" gorm:"type:varchar(191)"`
+	LastLoginAt     string `json:"lastLoginAt" gorm:"type:varchar(191)"`
+	UpdatedBy       string `json:"updatedBy" gorm:"type:varchar(191)"`
+}
+
 type History struct {
-	ID           string   `json:"id" gorm:"primaryKey;type:varchar(191)"`
-	StudentID    string   `json:"studentId" gorm:"type:varchar(191)"`
-	HomeworkID   string   `json:"homeworkId" gorm:"type:varchar(191)"`
-	Type         string   `json:"type" gorm:"type:varchar(191)"`
-	Name         string   `json:"name" gorm:"type:varchar(191)"`
-	NameEn       string   `json:"nameEn" gorm:"type:varchar(191)"`
-	Date         string   `json:"date" gorm:"type:varchar(191)"`
-	Score        string   `json:"score,omitempty" gorm:"type:varchar(191)"`
-	Total        string   `json:"total,omitempty" gorm:"type:varchar(191)"`
-	CorrectCount int      `json:"correctCount,omitempty"`
-	WrongCount   int      `json:"wrongCount,omitempty"`
-	Questions    []any    `json:"questions" gorm:"serializer:json"` // Stores HistoryQuestionResult
+	ID           string `json:"id" gorm:"primaryKey;type:varchar(191)"`
+	TenantID     string `json:"-" gorm:"type:varchar(191);default:'default';index"`
+	StudentID    string `json:"studentId" gorm:"type:varchar(191)"`
+	HomeworkID   string `json:"homeworkId" gorm:"type:varchar(191)"`
+	Type         string `json:"type" gorm:"type:varchar(191)"`
+	Name         string `json:"name" gorm:"type:varchar(191)"`
+	NameEn       string `json:"nameEn" gorm:"type:varchar(191)"`
+	Date         string `json:"date" gorm:"type:varchar(191)"`
+	Score        int    `json:"score,omitempty"`
+	Total        int    `json:"total,omitempty"`
+	CorrectCount int    `json:"correctCount,omitempty"`
+	WrongCount   int    `json:"wrongCount,omitempty"`
+	SessionID    string `json:"sessionId,omitempty" gorm:"type:varchar(191)"`
+	Questions    []any  `json:"questions" gorm:"serializer:json"` // Stores HistoryQuestionResult
 }
 
 // HistoryQuestionResult is a helper struct to define the JSON structure inside History.Questions
@@ -133,6 +309,7 @@ type HistoryQuestionResult struct {
 	ID          string       `json:"id"`
 	Subject     string       `json:"subject"` // Added for filtering
 	Stem        string       `json:"stem"`
+	StemImage   string       `json:"stemImage,omitempty"`
 	Answer      string       `json:"answer"`
 	UserAnswer  string       `json:"userAnswer"`
 	Status      string       `json:"status"` // "correct", "wrong"
@@ -147,19 +324,65 @@ type AttemptLog struct {
 	IsCorrect bool   `json:"isCorrect"`
 }
 
+// PracticeSession holds the server side attempt log of one practice or homework run
+type PracticeSession struct {
+	ID          string                  `json:"id" gorm:"primaryKey;type:varchar(191)"`
+	TenantID    string                  `json:"-" gorm:"type:varchar(191);default:'default';index"`
+	StudentID   string                  `json:"studentId" gorm:"type:varchar(191);index"`
+	HomeworkID  string                  `json:"homeworkId" gorm:"type:varchar(191)"`
+	QuestionIDs []string                `json:"questionIds" gorm:"serializer:json"`
+	Attempts    map[string][]AttemptLog `json:"attempts" gorm:"serializer:json"`
+	Status      string                  `json:"status" gorm:"type:varchar(191)"` // "active", "finished"
+	CreatedAt   string                  `json:"createdAt" gorm:"type:varchar(191)"`
+}
+
 type Resource struct {
-	ID         string   `json:"id" gorm:"primaryKey;type:varchar(191)"`
-	Name       string   `json:"name" gorm:"type:varchar(191)"`
-	URL        string   `json:"url" gorm:"type:text"`
-	Type       string   `json:"type" gorm:"type:varchar(191)"`
-	Tags       []string `json:"tags" gorm:"serializer:json"`
-	Visibility string   `json:"visibility" gorm:"type:varchar(191)"`
-	CreatorID  string   `json:"creatorId" gorm:"type:varchar(191)"`
-	CreatedAt  string   `json:"createdAt" gorm:"type:varchar(191)"`
+	ID           string   `json:"id" gorm:"primaryKey;type:varchar(191)"`
+	TenantID     string   `json:"-" gorm:"type:varchar(191);default:'default';index"`
+	Name         string   `json:"name" gorm:"type:varchar(191)"`
+	URL          string   `json:"url" gorm:"type:text"`
+	AssetID      string   `json:"assetId,omitempty" gorm:"type:varchar(191)"`
+	ThumbnailURL string   `json:"thumbnailUrl,omitempty" gorm:"type:text"`
+	Type         string   `json:"type" gorm:"type:varchar(191)"`
+	Tags         []string `json:"tags" gorm:"serializer:json"`
+	Visibility   string   `json:"visibility" gorm:"type:varchar(191)"`
+	CreatorID    string   `json:"creatorId" gorm:"type:varchar(191)"`
+	CreatedAt    string   `json:"createdAt" gorm:"type:varchar(191)"`
+}
+
+// Asset is a file uploaded through POST /api/uploads. Questions, options and
+// resources reference it by ID so the URL can change without touching them.
+type Asset struct {
+	ID           string         `json:"id" gorm:"primaryKey;type:varchar(191)"`
+	TenantID     string         `json:"-" gorm:"type:varchar(191);default:'default';index"`
+	Kind         string         `json:"kind" gorm:"type:varchar(191)"` // "image", "audio", "video", "pdf"
+	MimeType     string         `json:"mimeType" gorm:"type:varchar(191)"`
+	Size         int64          `json:"size"`
+	Name         string         `json:"name" gorm:"type:varchar(191)"`
+	Key          string         `json:"-" gorm:"type:varchar(191)"`
+	URL          string         `json:"url" gorm:"type:text"`
+	Width        int            `json:"width,omitempty"`
+	Height       int            `json:"height,omitempty"`
+	Variants     []ImageVariant `json:"variants,omitempty" gorm:"serializer:json"`
+	SrcSet       string         `json:"srcset,omitempty" gorm:"type:text"`
+	ThumbnailURL string         `json:"thumbnailUrl,omitempty" gorm:"type:text"`
+	CreatorID    string         `json:"creatorId" gorm:"type:varchar(191)"`
+	CreatedAt    string         `json:"createdAt" gorm:"type:varchar(191)"`
+}
+
+// ImageVariant is a resized copy of an image asset produced by ProcessImage
+type ImageVariant struct {
+	Name   string `json:"name"` // "original", "w320", "w640", "w1280", "thumb"
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Size   int64  `json:"size"`
+	Key    string `json:"key"`
+	URL    string `json:"url"`
 }
 
 type AuditLog struct {
 	ID        string `json:"id" gorm:"primaryKey;type:varchar(191)"`
+	TenantID  string `json:"-" gorm:"type:varchar(191);default:'default';index"`
 	UserID    string `json:"userId" gorm:"type:varchar(191)"`
 	Username  string `json:"username" gorm:"type:varchar(191)"`
 	Action    string `json:"action" gorm:"type:varchar(191)"`
@@ -171,6 +394,7 @@ type AuditLog struct {
 
 type StudentWrongQuestion struct {
 	ID          string   `json:"id" gorm:"primaryKey;type:varchar(191)"`
+	TenantID    string   `json:"-" gorm:"type:varchar(191);default:'default';index"`
 	StudentID   string   `json:"studentId" gorm:"type:varchar(191);index"`
 	QuestionID  string   `json:"questionId" gorm:"type:varchar(191);index"`
 	Question    Question `json:"question" gorm:"foreignKey:QuestionID"`
@@ -180,8 +404,9 @@ type StudentWrongQuestion struct {
 }
 
 type SystemConfig struct {
-	Key   string `json:"key" gorm:"primaryKey;type:varchar(191)"`
-	Value string `json:"value" gorm:"type:text"` // JSON encoded value
+	TenantID string `json:"-" gorm:"primaryKey;type:varchar(191);default:'default'"`
+	Key      string `json:"key" gorm:"primaryKey;type:varchar(191)"`
+	Value    string `json:"value" gorm:"type:text"` // JSON encoded value
 }
 
 // ConfigValue defines the structure for "error_logic" config
@@ -200,12 +425,29 @@ type StageConfig struct {
 
 // SystemSettingsConfig defines general system settings
 type SystemSettingsConfig struct {
-	RegistrationEnabled bool `json:"registrationEnabled"`
+	RegistrationEnabled bool           `json:"registrationEnabled"`
+	PasswordPolicy      PasswordPolicy `json:"passwordPolicy"`
+}
+
+// PasswordPolicy is checked whenever a password is set
+type PasswordPolicy struct {
+	MinLength        int  `json:"minLength"`
+	RequireLetter    bool `json:"requireLetter"`
+	RequireDigit     bool `json:"requireDigit"`
+	RequireMixedCase bool `json:"requireMixedCase"`
+	RequireSymbol    bool `json:"requireSymbol"`
+	DisallowUsername bool `json:"disallowUsername"`
 }
 
+// RolePermission is what a role may do with a module: see it in the menu and
+// read, create, update or delete its data through the API
 type RolePermission struct {
-	Role        Role   `json:"role" gorm:"primaryKey;type:varchar(191)"`
-	ModuleID    string `json:"moduleId" gorm:"primaryKey;type:varchar(191)"`
-	UIAccess    bool   `json:"uiAccess"`
-	APIAccess   bool   `json:"apiAccess"`
+	TenantID  string `json:"-" gorm:"primaryKey;type:varchar(191);default:'default'"`
+	Role      Role   `json:"role" gorm:"primaryKey;type:varchar(191)"`
+	ModuleID  string `json:"moduleId" gorm:"primaryKey;type:varchar(191)"`
+	UIAccess  bool   `json:"uiAccess"`
+	CanRead   bool   `json:"canRead"`
+	CanCreate bool   `json:"canCreate"`
+	CanUpdate bool   `json:"canUpdate"`
+	CanDelete bool   `json:"canDelete"`
 }
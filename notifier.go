@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Notifier delivers short messages such as password reset codes to a user's
+// phone or mailbox. Real SMS or mail gateways implement the same interface.
+type Notifier interface {
+	Send(to, subject, body string) error
+}
+
+// AppNotifier is the notifier in use
+var AppNotifier Notifier = LogNotifier{}
+
+// NewNotifierFromEnv picks the notifier from NOTIFIER: log (default) or file
+func NewNotifierFromEnv() (Notifier, error) {
+	switch driver := getEnv("NOTIFIER", "log"); driver {
+	case "log":
+		return LogNotifier{}, nil
+	case "file":
+		return &FileNotifier{Path: getEnv("NOTIFIER_FILE", "notifications.log")}, nil
+	default:
+		return nil, fmt.Errorf("unknown NOTIFIER %q", driver)
+	}
+}
+
+// LogNotifier prints messages to the server log, for local development
+type LogNotifier struct{}
+
+func (LogNotifier) Send(to, subject, body string) error {
+	log.Printf("[NOTIFY] to=%s subject=%q body=%q", to, subject, body)
+	return nil
+}
+
+// FileNotifier appends messages to a file, for local testing
+type FileNotifier struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (n *FileNotifier) Send(to, subject, body string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f, err := os.OpenFile(n.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\t%s\n", time.Now().Format(timeLayout), to, subject, body)
+	return err
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Authored content (questions, papers and reinforcements) belongs to its
+// creator. Admins may change anything; the creator and the co-owners they
+// picked may edit and delete; when the content is put in a department's pool
+// every teacher of that department may edit it too. Only the creator or an
+// admin changes who else has access. Content from before creators were
+// recorded has none, so only admins change it until one assigns a creator,
+// one piece at a time or all at once through AssignContentCreator.
+
+var errInvalidCoOwner = errors.New("Co-owners must be teachers or admins of this school")
+var errNotYourDepartment = errors.New("Content can only be shared with your own department")
+
+func isAdminRequest(c *gin.Context) bool {
+	role, _ := c.Get("role")
+	return fmt.Sprintf("%v", role) == string(RoleAdmin)
+}
+
+func requesterID(c *gin.Context) string {
+	userId, _ := c.Get("userId")
+	return fmt.Sprintf("%v", userId)
+}
+
+// requesterDepartment returns the department of the requesting teacher
+func requesterDepartment(c *gin.Context) string {
+	var user User
+	if err := TenantDB(c).Select("department").First(&user, "id = ?", requesterID(c)).Error; err != nil {
+		return ""
+	}
+	return user.Department
+}
+
+// IsContentOwner reports whether the requester may delete the content and
+// edit it regardless of department
+func IsContentOwner(c *gin.Context, o Ownership) bool {
+	if isAdminRequest(c) {
+		return true
+	}
+	uid := requesterID(c)
+	if o.CreatorID != "" && o.CreatorID == uid {
+		return true
+	}
+	for _, id := range o.CoOwnerIDs {
+		if id == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// CanEditContent reports whether the requester may change the content
+func CanEditContent(c *gin.Context, o Ownership) bool {
+	if IsContentOwner(c, o) {
+		return true
+	}
+	role, _ := c.Get("role")
+	return o.Department != "" && fmt.Sprintf("%v", role) == string(RoleTeacher) && requesterDepartment(c) == o.Department
+}
+
+// RequireContentEdit aborts with 403 when the requester may not change the content
+func RequireContentEdit(c *gin.Context, o Ownership) bool {
+	if CanEditContent(c, o) {
+		return true
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Only the owners of this content, its department or an admin can change it"})
+	return false
+}
+
+// RequireContentOwner aborts with 403 when the requester may not delete the content
+func RequireContentOwner(c *gin.Context, o Ownership) bool {
+	if IsContentOwner(c, o) {
+		return true
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Only the owners of this content or an admin can delete it"})
+	return false
+}
+
+// newOwnership makes the requester the creator of new content, shared as requested
+func newOwnership(c *gin.Context, requested Ownership) (Ownership, error) {
+	o := Ownership{CreatorID: requesterID(c), CoOwnerIDs: make([]string, 0)}
+	return o, shareContent(c, &o, requested)
+}
+
+// shareContent applies the requested co-owners and department to o. Only the
+// creator and admins manage sharing, for anyone else the request is ignored
+// so editors can send back the content as they received it. Admins may also
+// hand the content to another creator.
+func shareContent(c *gin.Context, o *Ownership, requested Ownership) error {
+	admin := isAdminRequest(c)
+	if !admin && o.CreatorID != requesterID(c) {
+		return nil
+	}
+
+	if admin && requested.CreatorID != "" && requested.CreatorID != o.CreatorID {
+		if !isContentAuthor(c, requested.CreatorID) {
+			return errInvalidCoOwner
+		}
+		o.CreatorID = requested.CreatorID
+	}
+
+	coOwners := make([]string, 0)
+	seen := map[string]bool{o.CreatorID: true}
+	for _, id := range requested.CoOwnerIDs {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		if !isContentAuthor(c, id) {
+			return errInvalidCoOwner
+		}
+		seen[id] = true
+		coOwners = append(coOwners, id)
+	}
+	o.CoOwnerIDs = coOwners
+
+	department := strings.TrimSpace(requested.Department)
+	if department != "" && !admin && department != requesterDepartment(c) {
+		return errNotYourDepartment
+	}
+	o.Department = department
+	return nil
+}
+
+// isContentAuthor reports whether the user may own content: a teacher or admin of this school
+func isContentAuthor(c *gin.Context, userID string) bool {
+	var count int64
+	TenantDB(c).Model(&User{}).Where("id = ? AND role IN ?", userID, []Role{RoleTeacher, RoleAdmin}).Count(&count)
+	return count > 0
+}
+
+// AssignContentCreator hands all content of the school that has no creator
+// to the teacher or admin in creatorId. The types narrow it down to some of
+// questions, papers and reinforcements.
+func AssignContentCreator(c *gin.Context) {
+	var req struct {
+		CreatorID string   `json:"creatorId"`
+		Types     []string `json:"types"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
+	if !isAdminRequest(c) {
+		SendJSON(c, 1, "Only admins can assign creators", nil)
+		return
+	}
+	if !isContentAuthor(c, req.CreatorID) {
+		SendJSON(c, 1, "Creators must be teachers or admins of this school", nil)
+		return
+	}
+
+	tables := map[string]any{"questions": &Question{}, "papers": &Paper{}, "reinforcements": &Reinforcement{}}
+	types := req.Types
+	if len(types) == 0 {
+		types = []string{"questions", "papers", "reinforcements"}
+	}
+	assigned := make(map[string]int64, len(types))
+	counts := make([]string, 0, len(types))
+	err := TenantDB(c).Transaction(func(tx *gorm.DB) error {
+		for _, kind := range types {
+			model, ok := tables[kind]
+			if !ok {
+				return fmt.Errorf("Unknown content type: %s", kind)
+			}
+			res := tx.Model(model).Where("creator_id IS NULL OR creator_id = ?", "").Update("creator_id", req.CreatorID)
+			if res.Error != nil {
+				return res.Error
+			}
+			assigned[kind] = res.RowsAffected
+			counts = append(counts, fmt.Sprintf("%d %s", res.RowsAffected, kind))
+		}
+		return nil
+	})
+	if err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
+
+	AddAuditLog(c, "ASSIGN_CREATOR", fmt.Sprintf("Assigned %s without a creator to %s", strings.Join(counts, ", "), req.CreatorID))
+	SendJSON(c, 0, "success", assigned)
+}
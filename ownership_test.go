@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentOwnership(t *testing.T) {
+	DB.Exec("DELETE FROM users")
+	DB.Exec("DELETE FROM questions")
+	DB.Exec("DELETE FROM papers")
+	DB.Exec("DELETE FROM reinforcements")
+	DB.Create(&User{ID: "amy", Username: "amy", Role: RoleTeacher, Status: "active", Department: "math"})
+	DB.Create(&User{ID: "ben", Username: "ben", Role: RoleTeacher, Status: "active", Department: "math"})
+	DB.Create(&User{ID: "cat", Username: "cat", Role: RoleTeacher, Status: "active", Department: "art"})
+	DB.Create(&User{ID: "boss", Username: "boss", Role: RoleAdmin, Status: "active"})
+	DB.Create(&User{ID: "kid", Username: "kid", Role: RoleStudent, Status: "active"})
+
+	r := gin.Default()
+	r.Use(func(c *gin.Context) {
+		c.Set("userId", c.GetHeader("X-User"))
+		c.Set("role", c.GetHeader("X-Role"))
+	})
+	r.POST("/questions", CreateQuestion)
+	r.PUT("/questions/:id", UpdateQuestion)
+	r.DELETE("/questions/:id", DeleteQuestion)
+	r.POST("/papers", CreatePaper)
+	r.PUT("/papers/:id", UpdatePaper)
+	r.DELETE("/papers/:id", DeletePaper)
+	r.POST("/reinforcements", CreateReinforcement)
+	r.PUT("/reinforcements/:id", UpdateReinforcement)
+	r.DELETE("/reinforcements/:id", DeleteReinforcement)
+	r.POST("/admin/content/creator", AssignContentCreator)
+
+	roles := map[string]Role{"amy": RoleTeacher, "ben": RoleTeacher, "cat": RoleTeacher, "boss": RoleAdmin}
+	call := func(user, method, url string, payload any) (int, Response) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		req.Header.Set("X-User", user)
+		req.Header.Set("X-Role", string(roles[user]))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	created := func(resp Response) (string, Ownership) {
+		data, _ := json.Marshal(resp.Data)
+		var content struct {
+			ID string `json:"id"`
+			Ownership
+		}
+		json.Unmarshal(data, &content)
+		return content.ID, content.Ownership
+	}
+
+	for _, kind := range []string{"questions", "papers", "reinforcements"} {
+		t.Run(kind, func(t *testing.T) {
+			_, resp := call("amy", "POST", "/"+kind, map[string]any{"name": "Mine", "stemText": "Mine", "creatorId": "ben"})
+			assert.Equal(t, 0, resp.Code, resp.Err)
+			id, owner := created(resp)
+			assert.Equal(t, "amy", owner.CreatorID, "the creator is whoever creates it")
+			url := "/" + kind + "/" + id
+
+			status, _ := call("ben", "PUT", url, map[string]any{"name": "Taken"})
+			assert.Equal(t, http.StatusForbidden, status, "other teachers can't edit")
+			status, _ = call("ben", "DELETE", url, nil)
+			assert.Equal(t, http.StatusForbidden, status, "other teachers can't delete")
+
+			// A co-owner edits and deletes but doesn't manage sharing
+			_, resp = call("amy", "PUT", url, map[string]any{"name": "Ours", "coOwnerIds": []string{"cat"}})
+			assert.Equal(t, 0, resp.Code, resp.Err)
+			_, resp = call("cat", "PUT", url, map[string]any{"name": "Ours too", "coOwnerIds": []string{"cat", "ben"}, "department": "art"})
+			assert.Equal(t, 0, resp.Code, resp.Err)
+			_, owner = created(resp)
+			assert.Equal(t, Ownership{CreatorID: "amy", CoOwnerIDs: []string{"cat"}}, owner)
+
+			// The department pool edits but doesn't delete
+			_, resp = call("amy", "PUT", url, map[string]any{"name": "Pooled", "department": "art"})
+			assert.Equal(t, errNotYourDepartment.Error(), resp.Err, "only your own department")
+			_, resp = call("amy", "PUT", url, map[string]any{"name": "Pooled", "department": "math"})
+			assert.Equal(t, 0, resp.Code, resp.Err)
+			_, resp = call("ben", "PUT", url, map[string]any{"name": "Pool edit"})
+			assert.Equal(t, 0, resp.Code, resp.Err)
+			status, _ = call("cat", "PUT", url, map[string]any{"name": "Not my pool"})
+			assert.Equal(t, http.StatusForbidden, status, "removing a co-owner takes their access")
+			status, _ = call("ben", "DELETE", url, nil)
+			assert.Equal(t, http.StatusForbidden, status)
+
+			_, resp = call("amy", "PUT", url, map[string]any{"coOwnerIds": []string{"kid"}})
+			assert.Equal(t, errInvalidCoOwner.Error(), resp.Err)
+
+			_, resp = call("boss", "DELETE", url, nil)
+			assert.Equal(t, 0, resp.Code, resp.Err)
+		})
+	}
+
+	t.Run("Content without a creator", func(t *testing.T) {
+		DB.Create(&Question{ID: "legacy", StemText: "Old"})
+		status, _ := call("amy", "PUT", "/questions/legacy", map[string]any{"stemText": "Mine now"})
+		assert.Equal(t, http.StatusForbidden, status)
+
+		_, resp := call("boss", "PUT", "/questions/legacy", map[string]any{"stemText": "Old", "creatorId": "amy"})
+		assert.Equal(t, 0, resp.Code, resp.Err)
+		_, resp = call("amy", "PUT", "/questions/legacy", map[string]any{"stemText": "Mine now"})
+		assert.Equal(t, 0, resp.Code, resp.Err)
+	})
+
+	t.Run("Assigning all content without a creator", func(t *testing.T) {
+		DB.Create(&Question{ID: "old-q", StemText: "Old"})
+		DB.Create(&Paper{ID: "old-p", Name: "Old"})
+		DB.Create(&Reinforcement{ID: "old-r", Name: "Old"})
+
+		_, resp := call("amy", "POST", "/admin/content/creator", map[string]any{"creatorId": "amy"})
+		assert.Equal(t, 1, resp.Code, "admins only")
+		_, resp = call("boss", "POST", "/admin/content/creator", map[string]any{"creatorId": "kid"})
+		assert.Equal(t, 1, resp.Code, "students own nothing")
+		_, resp = call("boss", "POST", "/admin/content/creator", map[string]any{"creatorId": "ben", "types": []string{"homeworks"}})
+		assert.Equal(t, 1, resp.Code)
+
+		_, resp = call("boss", "POST", "/admin/content/creator", map[string]any{"creatorId": "ben", "types": []string{"papers"}})
+		assert.Equal(t, 0, resp.Code, resp.Err)
+		assert.Equal(t, map[string]any{"papers": float64(1)}, resp.Data)
+		_, resp = call("boss", "POST", "/admin/content/creator", map[string]any{"creatorId": "cat"})
+		assert.Equal(t, 0, resp.Code, resp.Err)
+		assert.Equal(t, map[string]any{"questions": float64(1), "papers": float64(0), "reinforcements": float64(1)}, resp.Data)
+
+		var legacy, old Question
+		DB.First(&legacy, "id = ?", "legacy")
+		assert.Equal(t, "amy", legacy.CreatorID, "content with a creator keeps it")
+		DB.First(&old, "id = ?", "old-q")
+		assert.Equal(t, "cat", old.CreatorID)
+		var p Paper
+		DB.First(&p, "id = ?", "old-p")
+		assert.Equal(t, "ben", p.CreatorID)
+	})
+}
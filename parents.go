@@ -0,0 +1,374 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Parents get a read-only view of their own children. A teacher creates an
+// invite code for a student; a parent redeems it when registering or later
+// from their account, and may be linked to several children this way.
+
+var parentInviteDuration = 14 * 24 * time.Hour
+
+var errInvalidInviteCode = errors.New("Invalid or expired invite code")
+
+func parentInviteHash(code string) string {
+	return hashToken("parent:" + normalizeResetCode(code))
+}
+
+// defaultParentPermissions are the modules a new PARENT role starts with:
+// their children's pages, student details and wrong book, all read-only
+func defaultParentPermissions() []RolePermission {
+	return []RolePermission{
+		{Role: RoleParent, ModuleID: "children", UIAccess: true, CanRead: true, CanCreate: true, CanDelete: true},
+		{Role: RoleParent, ModuleID: "students", UIAccess: false, CanRead: true},
+		{Role: RoleParent, ModuleID: "dashboard", UIAccess: true, CanRead: true},
+		{Role: RoleParent, ModuleID: "wrong_book", UIAccess: false, CanRead: true},
+		{Role: RoleParent, ModuleID: "help_docs", UIAccess: true},
+	}
+}
+
+// ParentStudentIDs returns the students linked to a parent
+func ParentStudentIDs(db *gorm.DB, parentID string) []string {
+	ids := make([]string, 0)
+	db.Model(&ParentLink{}).Where("parent_id = ?", parentID).Order("created_at").Pluck("student_id", &ids)
+	return ids
+}
+
+// redeemParentInvite uses up an invite code and links the parent to its student
+func redeemParentInvite(tx *gorm.DB, parentID, code string) (string, error) {
+	now := time.Now().Format(timeLayout)
+	var invite ParentInvite
+	if err := tx.Where("code_hash = ? AND used_at = '' AND expires_at > ?", parentInviteHash(code), now).
+		First(&invite).Error; err != nil {
+		return "", errInvalidInviteCode
+	}
+	res := tx.Model(&ParentInvite{}).Where("id = ? AND used_at = ''", invite.ID).
+		Updates(map[string]any{"used_at": now, "used_by": parentID})
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if res.RowsAffected == 0 {
+		return "", errInvalidInviteCode
+	}
+
+	var count int64
+	tx.Model(&ParentLink{}).Where("parent_id = ? AND student_id = ?", parentID, invite.StudentID).Count(&count)
+	if count > 0 {
+		return invite.StudentID, nil
+	}
+	return invite.StudentID, tx.Create(&ParentLink{ParentID: parentID, StudentID: invite.StudentID, CreatedAt: now}).Error
+}
+
+// parentInviteTenant returns the school of a pending invite code, empty when
+// the code is unknown, used or expired
+func parentInviteTenant(code string) string {
+	var invite ParentInvite
+	err := DB.Select("tenant_id").
+		Where("code_hash = ? AND used_at = '' AND expires_at > ?", parentInviteHash(code), time.Now().Format(timeLayout)).
+		First(&invite).Error
+	if err != nil {
+		return ""
+	}
+	return invite.TenantID
+}
+
+// CreateParentInvite lets a teacher hand out a code that links a parent to the
+// student. The plain code is only ever returned here.
+func CreateParentInvite(c *gin.Context) {
+	role, _ := c.Get("role")
+	if r := fmt.Sprintf("%v", role); r != string(RoleTeacher) && r != string(RoleAdmin) {
+		SendJSON(c, 1, "Only teachers can invite parents", nil)
+		return
+	}
+	studentID := c.Param("id")
+	if !RequireStudentAccess(c, studentID) {
+		return
+	}
+	var student User
+	if err := TenantDB(c).First(&student, "id = ? AND role = ?", studentID, RoleStudent).Error; err != nil {
+		SendJSON(c, 1, "Student not found", nil)
+		return
+	}
+
+	raw, err := randomCode(printedCodeAlphabet, 8)
+	if err != nil {
+		SendJSON(c, 1, "Failed to create invite code", nil)
+		return
+	}
+	userId, _ := c.Get("userId")
+	now := time.Now()
+	invite := ParentInvite{
+		ID:        strconv.FormatInt(now.UnixNano(), 36),
+		StudentID: student.ID,
+		CodeHash:  parentInviteHash(raw),
+		ExpiresAt: now.Add(parentInviteDuration).Format(timeLayout),
+		CreatedBy: fmt.Sprintf("%v", userId),
+		CreatedAt: now.Format(timeLayout),
+	}
+	if err := TenantDB(c).Create(&invite).Error; err != nil {
+		SendJSON(c, 1, "Failed to create invite code", nil)
+		return
+	}
+
+	AddAuditLog(c, "CREATE_PARENT_INVITE", fmt.Sprintf("Parent invite for student %s", student.Username))
+	SendJSON(c, 0, "", gin.H{
+		"studentId": student.ID,
+		"name":      student.Name,
+		"code":      raw[:4] + "-" + raw[4:],
+		"expiresAt": invite.ExpiresAt,
+	})
+}
+
+// GetStudentParents lists the parent accounts linked to a student
+func GetStudentParents(c *gin.Context) {
+	role, _ := c.Get("role")
+	if r := fmt.Sprintf("%v", role); r != string(RoleTeacher) && r != string(RoleAdmin) {
+		SendJSON(c, 1, "Only teachers can view linked parents", nil)
+		return
+	}
+	studentID := c.Param("id")
+	if !RequireStudentAccess(c, studentID) {
+		return
+	}
+	var parentIDs []string
+	TenantDB(c).Model(&ParentLink{}).Where("student_id = ?", studentID).Pluck("parent_id", &parentIDs)
+	parents := make([]User, 0)
+	if len(parentIDs) > 0 {
+		TenantDB(c).Select("id", "username", "name", "role", "status").Where("id IN ?", parentIDs).Find(&parents)
+	}
+	SendJSON(c, 0, "", parents)
+}
+
+// RemoveStudentParent unlinks a parent from a student, e.g. after a code
+// reached the wrong person
+func RemoveStudentParent(c *gin.Context) {
+	role, _ := c.Get("role")
+	if r := fmt.Sprintf("%v", role); r != string(RoleTeacher) && r != string(RoleAdmin) {
+		SendJSON(c, 1, "Only teachers can remove linked parents", nil)
+		return
+	}
+	studentID, parentID := c.Param("id"), c.Param("parentId")
+	if !RequireStudentAccess(c, studentID) {
+		return
+	}
+	res := TenantDB(c).Where("parent_id = ? AND student_id = ?", parentID, studentID).Delete(&ParentLink{})
+	if res.Error != nil || res.RowsAffected == 0 {
+		SendJSON(c, 1, "Parent is not linked to this student", nil)
+		return
+	}
+	AddAuditLog(c, "REMOVE_PARENT_LINK", fmt.Sprintf("Unlinked parent %s from student %s", parentID, studentID))
+	SendJSON(c, 0, "", nil)
+}
+
+func requireParent(c *gin.Context) (string, bool) {
+	userId, _ := c.Get("userId")
+	role, _ := c.Get("role")
+	if fmt.Sprintf("%v", role) != string(RoleParent) {
+		SendJSON(c, 1, "Only parents can use this", nil)
+		return "", false
+	}
+	return fmt.Sprintf("%v", userId), true
+}
+
+// GetChildren lists the parent's linked children with their classes
+func GetChildren(c *gin.Context) {
+	parentID, ok := requireParent(c)
+	if !ok {
+		return
+	}
+	type Child struct {
+		ID       string   `json:"id"`
+		Name     string   `json:"name"`
+		Username string   `json:"username"`
+		Grade    int      `json:"grade,omitempty"`
+		Classes  []string `json:"classes"`
+	}
+	children := make([]Child, 0)
+	ids := ParentStudentIDs(TenantDB(c), parentID)
+	if len(ids) == 0 {
+		SendJSON(c, 0, "", children)
+		return
+	}
+
+	var students []User
+	TenantDB(c).Where("id IN ? AND role = ?", ids, RoleStudent).Find(&students)
+	var classes []Class
+	TenantDB(c).Find(&classes)
+	for _, s := range students {
+		child := Child{ID: s.ID, Name: s.Name, Username: s.Username, Grade: s.Grade, Classes: []string{}}
+		for _, cls := range classes {
+			for _, sid := range cls.StudentIDs {
+				if sid == s.ID {
+					child.Classes = append(child.Classes, cls.Name)
+					break
+				}
+			}
+		}
+		children = append(children, child)
+	}
+	SendJSON(c, 0, "", children)
+}
+
+// LinkChild redeems another invite code for a parent who already has an account
+func LinkChild(c *gin.Context) {
+	parentID, ok := requireParent(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendJSON(c, 1, "Invalid request", nil)
+		return
+	}
+	var studentID string
+	err := TenantDB(c).Transaction(func(tx *gorm.DB) error {
+		var err error
+		studentID, err = redeemParentInvite(tx, parentID, req.Code)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, errInvalidInviteCode) {
+			SendJSON(c, 1, err.Error(), nil)
+		} else {
+			SendJSON(c, 1, "Failed to link child", nil)
+		}
+		return
+	}
+	AddAuditLog(c, "LINK_CHILD", fmt.Sprintf("Parent linked to student %s", studentID))
+	SendJSON(c, 0, "", gin.H{"studentId": studentID})
+}
+
+// UnlinkChild removes one of the parent's own links
+func UnlinkChild(c *gin.Context) {
+	parentID, ok := requireParent(c)
+	if !ok {
+		return
+	}
+	res := TenantDB(c).Where("parent_id = ? AND student_id = ?", parentID, c.Param("id")).Delete(&ParentLink{})
+	if res.Error != nil || res.RowsAffected == 0 {
+		SendJSON(c, 1, "Child not found", nil)
+		return
+	}
+	AddAuditLog(c, "UNLINK_CHILD", fmt.Sprintf("Parent unlinked from student %s", c.Param("id")))
+	SendJSON(c, 0, "", nil)
+}
+
+// weekBounds returns the Monday starting the week of day and the Monday after
+func weekBounds(day time.Time) (time.Time, time.Time) {
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	offset := (int(day.Weekday()) + 6) % 7
+	start := day.AddDate(0, 0, -offset)
+	return start, start.AddDate(0, 0, 7)
+}
+
+// GetChildWeeklySummary sums up a child's week: practice, homework and the
+// wrong book. ?week=YYYY-MM-DD picks the week containing that day.
+func GetChildWeeklySummary(c *gin.Context) {
+	if _, ok := requireParent(c); !ok {
+		return
+	}
+	studentID := c.Param("id")
+	if !RequireStudentAccess(c, studentID) {
+		return
+	}
+
+	day := time.Now()
+	if w := c.Query("week"); w != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", w, time.Local)
+		if err != nil {
+			SendJSON(c, 1, "week must be a date like 2006-01-02", nil)
+			return
+		}
+		day = parsed
+	}
+	start, end := weekBounds(day)
+	from, to := start.Format(timeLayout), end.Format(timeLayout)
+
+	var histories []History
+	TenantDB(c).Select("homework_id", "date", "total", "correct_count").
+		Where("student_id = ? AND date >= ? AND date < ?", studentID, from, to).
+		Find(&histories)
+	type DaySummary struct {
+		Date      string `json:"date"`
+		Practices int    `json:"practices"`
+		Questions int    `json:"questions"`
+		Correct   int    `json:"correct"`
+	}
+	days := make([]DaySummary, 7)
+	for i := range days {
+		days[i].Date = start.AddDate(0, 0, i).Format("2006-01-02")
+	}
+	practices, questions, correct, activeDays := 0, 0, 0, 0
+	for _, h := range histories {
+		for i := range days {
+			if strings.HasPrefix(h.Date, days[i].Date) {
+				days[i].Practices++
+				days[i].Questions += h.Total
+				days[i].Correct += h.CorrectCount
+			}
+		}
+		practices++
+		questions += h.Total
+		correct += h.CorrectCount
+	}
+	for _, d := range days {
+		if d.Practices > 0 {
+			activeDays++
+		}
+	}
+	accuracy := 0.0
+	if questions > 0 {
+		accuracy = float64(correct) / float64(questions) * 100
+	}
+
+	// Homework that was open at some point during the week
+	weekFirst, weekLast := days[0].Date, days[6].Date
+	homework := make([]HomeworkBrief, 0)
+	for _, hw := range studentHomeworkOverview(TenantDB(c), studentID) {
+		if (hw.StartDate == "" || hw.StartDate <= weekLast) && (hw.EndDate == "" || hw.EndDate >= weekFirst) {
+			homework = append(homework, hw)
+		}
+	}
+	sort.Slice(homework, func(i, j int) bool { return homework[i].EndDate < homework[j].EndDate })
+	homeworkCounts := map[string]int{"completed": 0, "pending": 0, "overdue": 0}
+	for _, hw := range homework {
+		homeworkCounts[hw.Status]++
+	}
+
+	var open, difficult, mastered int64
+	TenantDB(c).Model(&StudentWrongQuestion{}).Where("student_id = ? AND status != 4", studentID).Count(&open)
+	TenantDB(c).Model(&StudentWrongQuestion{}).Where("student_id = ? AND status = 5", studentID).Count(&difficult)
+	TenantDB(c).Model(&StudentWrongQuestion{}).
+		Where("student_id = ? AND status = 4 AND last_updated >= ? AND last_updated < ?", studentID, from, to).
+		Count(&mastered)
+
+	SendJSON(c, 0, "", gin.H{
+		"studentId":       studentID,
+		"weekStart":       weekFirst,
+		"weekEnd":         weekLast,
+		"practices":       practices,
+		"questions":       questions,
+		"correct":         correct,
+		"accuracy":        accuracy,
+		"activeDays":      activeDays,
+		"days":            days,
+		"homework":        homework,
+		"homeworkSummary": homeworkCounts,
+		"wrongBook": gin.H{
+			"open":             open,
+			"difficult":        difficult,
+			"masteredThisWeek": mastered,
+		},
+	})
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParentInviteAndChildAccess(t *testing.T) {
+	DB.Exec("DELETE FROM users")
+	DB.Exec("DELETE FROM classes")
+	DB.Exec("DELETE FROM parent_links")
+	DB.Exec("DELETE FROM parent_invites")
+	DB.Where(&SystemConfig{Key: "system_settings"}).Delete(&SystemConfig{}) // registration disabled
+	ForgetCachedSettings("")
+	DB.Create(&User{ID: "t1", Username: "teacher1", Role: RoleTeacher, Status: "active"})
+	DB.Create(&User{ID: "c1", Username: "child1", Name: "小明", Role: RoleStudent, Status: "active"})
+	DB.Create(&User{ID: "c2", Username: "child2", Name: "小红", Role: RoleStudent, Status: "active"})
+	DB.Create(&User{ID: "c3", Username: "child3", Role: RoleStudent, Status: "active"})
+	DB.Create(&Class{ID: "k1", Name: "1A", TeacherIDs: []string{"t1"}, StudentIDs: []string{"c1", "c2"}})
+
+	as := func(userId string, role Role) *gin.Engine {
+		r := gin.Default()
+		r.Use(func(c *gin.Context) {
+			c.Set("userId", userId)
+			c.Set("role", string(role))
+		})
+		r.POST("/students/:id/parent-invite", CreateParentInvite)
+		r.GET("/students/:id/parents", GetStudentParents)
+		r.DELETE("/students/:id/parents/:parentId", RemoveStudentParent)
+		r.GET("/students/:id", GetStudentDetail)
+		r.GET("/students", GetStudents)
+		r.GET("/parent/children", GetChildren)
+		r.POST("/parent/children", LinkChild)
+		r.POST("/auth/register", RegisterHandler)
+		return r
+	}
+	call := func(r *gin.Engine, method, url string, payload any) (int, Response) {
+		var body []byte
+		if payload != nil {
+			body, _ = json.Marshal(payload)
+		}
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	invite := func(studentID string) string {
+		_, resp := call(as("t1", RoleTeacher), "POST", "/students/"+studentID+"/parent-invite", nil)
+		assert.Equal(t, 0, resp.Code, resp.Err)
+		return resp.Data.(map[string]any)["code"].(string)
+	}
+
+	_, denied := call(as("t1", RoleTeacher), "POST", "/students/c3/parent-invite", nil)
+	assert.Equal(t, 1, denied.Code, "teachers only invite for their own students")
+
+	code := invite("c1")
+	assert.Regexp(t, `^[A-HJ-NP-Z2-9]{4}-[A-HJ-NP-Z2-9]{4}$`, code)
+
+	// A wrong code creates no account, even though registration itself is closed
+	_, resp := call(as("", ""), "POST", "/auth/register", map[string]string{"phoneNumber": "13900000000", "password": "family-2024", "inviteCode": "AAAA-BBBB"})
+	assert.Equal(t, 1, resp.Code)
+	var count int64
+	DB.Model(&User{}).Where("username = ?", "13900000000").Count(&count)
+	assert.Zero(t, count)
+
+	_, resp = call(as("", ""), "POST", "/auth/register", map[string]string{"phoneNumber": "13900000000", "password": "family-2024", "inviteCode": code})
+	assert.Equal(t, 0, resp.Code, resp.Err)
+	var parent User
+	DB.First(&parent, "username = ?", "13900000000")
+	assert.Equal(t, RoleParent, parent.Role)
+
+	_, resp = call(as("", ""), "POST", "/auth/register", map[string]string{"phoneNumber": "13900000001", "password": "family-2024", "inviteCode": code})
+	assert.Equal(t, 1, resp.Code, "invite codes are single use")
+
+	pa := as(parent.ID, RoleParent)
+	_, resp = call(pa, "POST", "/parent/children", map[string]string{"code": invite("c2")})
+	assert.Equal(t, 0, resp.Code, resp.Err)
+	_, resp = call(pa, "GET", "/parent/children", nil)
+	children := resp.Data.([]any)
+	assert.Len(t, children, 2)
+	assert.Equal(t, []any{"1A"}, children[0].(map[string]any)["classes"])
+
+	tests := []struct {
+		name         string
+		url          string
+		expectedCode int
+	}{
+		{"Own child", "/students/c1", 0},
+		{"Second child", "/students/c2", 0},
+		{"Someone else's child", "/students/c3", 1},
+	}
+	for _, tt := range tests {
+		_, resp := call(pa, "GET", tt.url, nil)
+		assert.Equal(t, tt.expectedCode, resp.Code, tt.name)
+	}
+	_, resp = call(pa, "GET", "/students", nil)
+	assert.Len(t, resp.Data, 2, "the student list only holds the parent's children")
+
+	_, resp = call(pa, "POST", "/students/c1/parent-invite", nil)
+	assert.Equal(t, 1, resp.Code, "parents can't invite further parents")
+
+	// The teacher sees and can remove the link
+	_, resp = call(as("t1", RoleTeacher), "GET", "/students/c1/parents", nil)
+	assert.Len(t, resp.Data, 1)
+	_, resp = call(as("t1", RoleTeacher), "DELETE", "/students/c1/parents/"+parent.ID, nil)
+	assert.Equal(t, 0, resp.Code)
+	_, resp = call(pa, "GET", "/students/c1", nil)
+	assert.Equal(t, 1, resp.Code)
+}
+
+func TestParentsAreReadOnly(t *testing.T) {
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("userId", "p1")
+		c.Set("role", string(RoleParent))
+	}, PermissionMiddleware())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	api := Guard(r.Group("/api"))
+	api.GET("/students/:id", Read("students"), ok)
+	api.POST("/students/:id/reset-code", Write("students"), ok)
+	api.GET("/wrong-book", Read("wrong_book"), ok)
+	api.GET("/questions", Read("questions"), ok)
+	api.POST("/parent/children", Write("children"), ok)
+	api.PUT("/me", SignedIn, ok)
+
+	tests := []struct {
+		method         string
+		url            string
+		expectedStatus int
+	}{
+		{"GET", "/api/students/c1", http.StatusOK},
+		{"POST", "/api/students/c1/reset-code", http.StatusForbidden},
+		{"GET", "/api/wrong-book", http.StatusOK},
+		{"GET", "/api/questions", http.StatusForbidden},
+		{"POST", "/api/parent/children", http.StatusOK},
+		{"PUT", "/api/me", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.url, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, tt.expectedStatus, w.Code, tt.method+" "+tt.url)
+	}
+}
+
+func TestChildWeeklySummary(t *testing.T) {
+	DB.Exec("DELETE FROM users")
+	DB.Exec("DELETE FROM parent_links")
+	DB.Exec("DELETE FROM histories")
+	DB.Exec("DELETE FROM homeworks")
+	DB.Exec("DELETE FROM student_wrong_questions")
+	DB.Create(&ParentLink{ParentID: "p1", StudentID: "c1"})
+
+	// Wednesday 2026-03-04 lies in the week starting Monday 2026-03-02
+	DB.Create(&History{ID: "h1", StudentID: "c1", HomeworkID: "hw1", Date: "2026-03-02 18:00:00", Total: 10, CorrectCount: 8})
+	DB.Create(&History{ID: "h2", StudentID: "c1", Date: "2026-03-04 19:00:00", Total: 10, CorrectCount: 6})
+	DB.Create(&History{ID: "h3", StudentID: "c1", Date: "2026-03-09 19:00:00", Total: 10, CorrectCount: 10}) // next week
+	DB.Create(&History{ID: "h4", StudentID: "c2", Date: "2026-03-03 19:00:00", Total: 10, CorrectCount: 0})
+	DB.Create(&Homework{ID: "hw1", Name: "Done", StartDate: "2026-03-01", EndDate: "2026-03-03", StudentIDs: []string{"c1"}})
+	DB.Create(&Homework{ID: "hw2", Name: "Missed", StartDate: "2026-03-02", EndDate: "2026-03-05", StudentIDs: []string{"c1"}})
+	DB.Create(&Homework{ID: "hw3", Name: "Last month", StartDate: "2026-02-01", EndDate: "2026-02-05", StudentIDs: []string{"c1"}})
+	DB.Create(&StudentWrongQuestion{ID: "w1", StudentID: "c1", QuestionID: "q1", Status: 1})
+	DB.Create(&StudentWrongQuestion{ID: "w2", StudentID: "c1", QuestionID: "q2", Status: 5})
+	DB.Create(&StudentWrongQuestion{ID: "w3", StudentID: "c1", QuestionID: "q3", Status: 4, LastUpdated: "2026-03-06 10:00:00"})
+
+	summary := func(userId string, role Role, url string) (int, Response) {
+		r := gin.Default()
+		r.Use(func(c *gin.Context) {
+			c.Set("userId", userId)
+			c.Set("role", string(role))
+		})
+		r.GET("/parent/children/:id/weekly", GetChildWeeklySummary)
+		req, _ := http.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	_, resp := summary("p1", RoleParent, "/parent/children/c2/weekly?week=2026-03-04")
+	assert.Equal(t, 1, resp.Code)
+	_, resp = summary("p1", RoleParent, "/parent/children/c1/weekly?week=bad")
+	assert.Equal(t, 1, resp.Code)
+
+	_, resp = summary("p1", RoleParent, "/parent/children/c1/weekly?week=2026-03-04")
+	assert.Equal(t, 0, resp.Code, resp.Err)
+	data := resp.Data.(map[string]any)
+	assert.Equal(t, "2026-03-02", data["weekStart"])
+	assert.Equal(t, "2026-03-08", data["weekEnd"])
+	assert.Equal(t, float64(2), data["practices"])
+	assert.Equal(t, float64(70), data["accuracy"])
+	assert.Equal(t, float64(2), data["activeDays"])
+	assert.Len(t, data["homework"], 2)
+	assert.Equal(t, map[string]any{"completed": float64(1), "pending": float64(0), "overdue": float64(1)}, data["homeworkSummary"])
+	assert.Equal(t, map[string]any{"open": float64(2), "difficult": float64(1), "masteredThisWeek": float64(1)}, data["wrongBook"])
+
+	start, end := weekBounds(time.Date(2026, 3, 8, 23, 0, 0, 0, time.Local))
+	assert.Equal(t, "2026-03-02", start.Format("2006-01-02"), "Sunday belongs to the week before")
+	assert.Equal(t, "2026-03-09", end.Format("2006-01-02"))
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// A forgotten password is reset with a one-time code: either a short numeric
+// code sent through AppNotifier to the phone number the account logs in with,
+// or a longer code a teacher prints for a young student without a phone.
+
+var (
+	resetCodeDuration    = 15 * time.Minute
+	printedCodeDuration  = 7 * 24 * time.Hour
+	resetRequestCooldown = time.Minute
+)
+
+// maxResetAttempts wrong guesses burn a code
+const maxResetAttempts = 5
+
+// printedCodeAlphabet leaves out characters that are easy to misread on paper (0/O, 1/I)
+const printedCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+var errInvalidResetCode = errors.New("Invalid or expired reset code")
+
+func normalizeResetCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToUpper(strings.TrimSpace(code)))
+}
+
+func resetCodeHash(userID, code string) string {
+	return hashToken(userID + ":" + normalizeResetCode(code))
+}
+
+func randomCode(alphabet string, length int) (string, error) {
+	b := make([]byte, length)
+	max := big.NewInt(int64(len(alphabet)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = alphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// isPhoneNumber matches the mainland mobile numbers students register with
+func isPhoneNumber(s string) bool {
+	if len(s) != 11 || !strings.HasPrefix(s, "1") {
+		return false
+	}
+	_, err := strconv.ParseInt(s, 10, 64)
+	return err == nil
+}
+
+// issueResetCode stores a new code for user, replacing its unused codes of the same channel
+func issueResetCode(user User, code, channel, createdBy string, ttl time.Duration) (PasswordResetCode, error) {
+	now := time.Now()
+	rc := PasswordResetCode{
+		ID:        strconv.FormatInt(now.UnixNano(), 36),
+		UserID:    user.ID,
+		CodeHash:  resetCodeHash(user.ID, code),
+		Channel:   channel,
+		ExpiresAt: now.Add(ttl).Format(timeLayout),
+		CreatedBy: createdBy,
+		CreatedAt: now.Format(timeLayout),
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND channel = ? AND used_at = ''", user.ID, channel).
+			Delete(&PasswordResetCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&rc).Error
+	})
+	return rc, err
+}
+
+// RequestPasswordReset sends a reset code to the phone number an account logs
+// in with. The answer is the same whether or not a code was sent, so it can't
+// be used to find out which accounts exist.
+func RequestPasswordReset(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendJSON(c, 1, "Invalid request", nil)
+		return
+	}
+	sent := gin.H{"message": "If the account can receive codes, a code has been sent"}
+
+	var user User
+	if err := DB.First(&user, "username = ?", strings.TrimSpace(req.Username)).Error; err != nil ||
+		userDisabled(user) || !isPhoneNumber(user.Username) {
+		SendJSON(c, 0, "", sent)
+		return
+	}
+	c.Set("tenantId", user.TenantID)
+
+	// One code per cooldown keeps the endpoint from being used to flood a phone.
+	// No new code is sent while one is open, even one burned by wrong guesses,
+	// or every request would bring another maxResetAttempts guesses.
+	now := time.Now()
+	var recent int64
+	TenantDB(c).Model(&PasswordResetCode{}).
+		Where("user_id = ? AND channel = ? AND (created_at > ? OR (used_at = '' AND expires_at > ?))",
+			user.ID, "notify", now.Add(-resetRequestCooldown).Format(timeLayout), now.Format(timeLayout)).
+		Count(&recent)
+	if recent > 0 {
+		SendJSON(c, 0, "", sent)
+		return
+	}
+
+	code, err := randomCode("0123456789", 6)
+	if err != nil {
+		SendJSON(c, 1, "Failed to create reset code", nil)
+		return
+	}
+	if _, err := issueResetCode(user, code, "notify", "", resetCodeDuration); err != nil {
+		SendJSON(c, 1, "Failed to create reset code", nil)
+		return
+	}
+	body := fmt.Sprintf("您的密码重置验证码为 %s，%d 分钟内有效。如非本人操作请忽略。", code, int(resetCodeDuration.Minutes()))
+	if err := AppNotifier.Send(user.Username, "密码重置验证码", body); err != nil {
+		log.Printf("password reset: failed to notify %s: %v", user.Username, err)
+	}
+
+	AddAuditLog(c, "PASSWORD_RESET_REQUEST", fmt.Sprintf("Reset code sent to %s", user.Username))
+	SendJSON(c, 0, "", sent)
+}
+
+// ConfirmPasswordReset sets a new password with a reset code. The code is used
+// up, every session of the account ends and a login lockout is lifted.
+func ConfirmPasswordReset(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendJSON(c, 1, "Invalid request", nil)
+		return
+	}
+
+	var user User
+	if err := DB.First(&user, "username = ?", strings.TrimSpace(req.Username)).Error; err != nil || userDisabled(user) {
+		SendJSON(c, 1, errInvalidResetCode.Error(), nil)
+		return
+	}
+	c.Set("tenantId", user.TenantID)
+
+	// The code is checked before the password, so only a holder of the code
+	// learns from the policy error that the account exists
+	wrongCode := false
+	var policyErr error
+	err := TenantDB(c).Transaction(func(tx *gorm.DB) error {
+		now := time.Now().Format(timeLayout)
+		var codes []PasswordResetCode
+		if err := tx.Where("user_id = ? AND used_at = '' AND expires_at > ? AND attempts < ?", user.ID, now, maxResetAttempts).
+			Find(&codes).Error; err != nil {
+			return err
+		}
+		hash := resetCodeHash(user.ID, req.Code)
+		var match *PasswordResetCode
+		for i := range codes {
+			if subtle.ConstantTimeCompare([]byte(codes[i].CodeHash), []byte(hash)) == 1 {
+				match = &codes[i]
+			}
+		}
+		if match == nil {
+			wrongCode = true
+			return errInvalidResetCode
+		}
+		// The new password has to meet the policy of the user's school
+		hashed, err := hashPassword(tx, req.Password, user.Username)
+		if err != nil {
+			policyErr = err
+			return err
+		}
+
+		res := tx.Model(&PasswordResetCode{}).Where("id = ? AND used_at = ''", match.ID).Update("used_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errInvalidResetCode
+		}
+		return tx.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]any{
+			"password":             hashed,
+			"must_change_password": false,
+		}).Error
+	})
+	if wrongCode {
+		// A wrong guess counts against every open code of the account
+		TenantDB(c).Model(&PasswordResetCode{}).Where("user_id = ? AND used_at = ''", user.ID).
+			Update("attempts", gorm.Expr("attempts + 1"))
+	}
+	if err != nil {
+		if errors.Is(err, errInvalidResetCode) || policyErr != nil {
+			SendJSON(c, 1, err.Error(), nil)
+		} else {
+			SendJSON(c, 1, "Failed to reset password", nil)
+		}
+		return
+	}
+
+	RevokeUserSessions(user.ID)
+	loginSucceeded(user.Username)
+	c.Set("userId", user.ID)
+	c.Set("role", string(user.Role))
+	AddAuditLog(c, "PASSWORD_RESET", fmt.Sprintf("Password reset with a code: %s", user.Username))
+	SendJSON(c, 0, "", gin.H{"message": "Password has been reset"})
+}
+
+// CreatePrintedResetCode lets a teacher hand a student a reset code on paper.
+// The plain code is only ever returned here.
+func CreatePrintedResetCode(c *gin.Context) {
+	role, _ := c.Get("role")
+	if r := fmt.Sprintf("%v", role); r != string(RoleTeacher) && r != string(RoleAdmin) {
+		SendJSON(c, 1, "Only teachers can create reset codes", nil)
+		return
+	}
+	studentID := c.Param("id")
+	if !RequireStudentAccess(c, studentID) {
+		return
+	}
+	var student User
+	if err := TenantDB(c).First(&student, "id = ? AND role = ?", studentID, RoleStudent).Error; err != nil {
+		SendJSON(c, 1, "Student not found", nil)
+		return
+	}
+
+	raw, err := randomCode(printedCodeAlphabet, 8)
+	if err != nil {
+		SendJSON(c, 1, "Failed to create reset code", nil)
+		return
+	}
+	userId, _ := c.Get("userId")
+	rc, err := issueResetCode(student, raw, "printed", fmt.Sprintf("%v", userId), printedCodeDuration)
+	if err != nil {
+		SendJSON(c, 1, "Failed to create reset code", nil)
+		return
+	}
+
+	AddAuditLog(c, "CREATE_RESET_CODE", fmt.Sprintf("Printed reset code for student %s", student.Username))
+	SendJSON(c, 0, "", gin.H{
+		"studentId": student.ID,
+		"username":  student.Username,
+		"name":      student.Name,
+		"code":      raw[:4] + "-" + raw[4:],
+		"expiresAt": rc.ExpiresAt,
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordResetWithNotifiedCode(t *testing.T) {
+	defer func(n Notifier, cooldown time.Duration) { AppNotifier, resetRequestCooldown = n, cooldown }(AppNotifier, resetRequestCooldown)
+	outbox := filepath.Join(t.TempDir(), "notifications.log")
+	AppNotifier = &FileNotifier{Path: outbox}
+
+	DB.Exec("DELETE FROM users")
+	DB.Exec("DELETE FROM password_reset_codes")
+	DB.Exec("DELETE FROM user_sessions")
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("forgotten1"), bcrypt.MinCost)
+	DB.Create(&User{ID: "s1", Username: "13800000000", Password: string(hashed), Role: RoleStudent, Status: "active"})
+	DB.Create(&User{ID: "s2", Username: "xiaoming", Password: string(hashed), Role: RoleStudent, Status: "active"})
+	DB.Create(&UserSession{ID: "old", UserID: "s1", ExpiresAt: time.Now().Add(time.Hour).Format(timeLayout)})
+
+	r := gin.Default()
+	r.POST("/request", RequestPasswordReset)
+	r.POST("/confirm", ConfirmPasswordReset)
+	post := func(url string, payload any) Response {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", url, bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+	sentCodes := func() []string {
+		data, _ := os.ReadFile(outbox)
+		return regexp.MustCompile(`验证码为 (\d{6})`).FindAllString(string(data), -1)
+	}
+	lastCode := func() string {
+		codes := sentCodes()
+		return strings.TrimPrefix(codes[len(codes)-1], "验证码为 ")
+	}
+
+	// A weak password doesn't tell unknown accounts from existing ones
+	for _, name := range []string{"nobody", "13800000000"} {
+		resp := post("/confirm", map[string]string{"username": name, "code": "000000", "password": "short"})
+		assert.Equal(t, "Invalid or expired reset code", resp.Err, name)
+	}
+
+	// Unknown accounts and accounts without a phone get the same answer, and nothing is sent
+	for _, name := range []string{"nobody", "xiaoming"} {
+		resp := post("/request", map[string]string{"username": name})
+		assert.Equal(t, 0, resp.Code)
+	}
+	assert.Empty(t, sentCodes())
+
+	assert.Equal(t, 0, post("/request", map[string]string{"username": "13800000000"}).Code)
+	assert.Len(t, sentCodes(), 1)
+	post("/request", map[string]string{"username": "13800000000"})
+	assert.Len(t, sentCodes(), 1, "a second request within the cooldown sends nothing")
+	code := lastCode()
+
+	var stored PasswordResetCode
+	DB.First(&stored, "user_id = ?", "s1")
+	assert.NotContains(t, stored.CodeHash, code, "only the hash is stored")
+
+	tests := []struct {
+		name     string
+		code     string
+		password string
+		wantErr  string
+	}{
+		{"Wrong code", "000000", "new-pass-2024", "Invalid or expired"},
+		{"Weak password with a wrong code", "000000", "short", "Invalid or expired"},
+		{"Weak password", code, "short", "at least 8 characters"},
+		{"Valid", code, "new-pass-2024", ""},
+		{"Code is single use", code, "other-pass-2024", "Invalid or expired"},
+	}
+	for _, tt := range tests {
+		resp := post("/confirm", map[string]string{"username": "13800000000", "code": tt.code, "password": tt.password})
+		assert.Equal(t, tt.wantErr == "", resp.Code == 0, tt.name)
+		assert.Contains(t, resp.Err, tt.wantErr, tt.name)
+	}
+
+	var user User
+	DB.First(&user, "id = ?", "s1")
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("new-pass-2024")))
+	assert.False(t, SessionActive("old"), "a reset ends existing sessions")
+
+	t.Run("Too many wrong guesses burn the code", func(t *testing.T) {
+		resetRequestCooldown = 0
+		post("/request", map[string]string{"username": "13800000000"})
+		code := lastCode()
+		for i := 0; i < maxResetAttempts; i++ {
+			post("/confirm", map[string]string{"username": "13800000000", "code": "999999", "password": "new-pass-2025"})
+		}
+		resp := post("/confirm", map[string]string{"username": "13800000000", "code": code, "password": "new-pass-2025"})
+		assert.Equal(t, 1, resp.Code)
+
+		// A burned code stays in the way until it expires, so asking again brings no new guesses
+		sent := len(sentCodes())
+		post("/request", map[string]string{"username": "13800000000"})
+		assert.Len(t, sentCodes(), sent)
+	})
+
+	t.Run("Expired codes are refused", func(t *testing.T) {
+		DB.Model(&PasswordResetCode{}).Where("used_at = ''").Update("expires_at", time.Now().Add(-time.Minute).Format(timeLayout))
+		post("/request", map[string]string{"username": "13800000000"})
+		DB.Model(&PasswordResetCode{}).Where("used_at = ''").Update("expires_at", time.Now().Add(-time.Minute).Format(timeLayout))
+		resp := post("/confirm", map[string]string{"username": "13800000000", "code": lastCode(), "password": "new-pass-2025"})
+		assert.Equal(t, 1, resp.Code)
+	})
+}
+
+func TestPrintedResetCode(t *testing.T) {
+	DB.Exec("DELETE FROM users")
+	DB.Exec("DELETE FROM classes")
+	DB.Exec("DELETE FROM password_reset_codes")
+	DB.Create(&User{ID: "s1", Username: "xiaoming", Name: "小明", Role: RoleStudent, Status: "active", MustChangePassword: true})
+	DB.Create(&Class{ID: "c1", TeacherIDs: []string{"t1"}, StudentIDs: []string{"s1"}})
+
+	create := func(userID string, role Role) (int, Response) {
+		r := gin.Default()
+		r.Use(func(c *gin.Context) {
+			c.Set("userId", userID)
+			c.Set("role", string(role))
+		})
+		r.POST("/students/:id/reset-code", CreatePrintedResetCode)
+		req, _ := http.NewRequest("POST", "/students/s1/reset-code", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	_, resp := create("t2", RoleTeacher)
+	assert.Equal(t, 1, resp.Code, "teachers only reach their own students")
+	_, resp = create("s1", RoleStudent)
+	assert.Equal(t, 1, resp.Code)
+
+	_, first := create("t1", RoleTeacher)
+	_, resp = create("t1", RoleTeacher)
+	assert.Equal(t, 0, resp.Code)
+	data := resp.Data.(map[string]any)
+	code := data["code"].(string)
+	assert.Regexp(t, `^[A-HJ-NP-Z2-9]{4}-[A-HJ-NP-Z2-9]{4}$`, code)
+	assert.Equal(t, "小明", data["name"])
+
+	r := gin.Default()
+	r.POST("/confirm", ConfirmPasswordReset)
+	confirm := func(code string) int {
+		body, _ := json.Marshal(map[string]string{"username": "xiaoming", "code": code, "password": "panda-2024"})
+		req, _ := http.NewRequest("POST", "/confirm", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Code
+	}
+
+	assert.Equal(t, 1, confirm(first.Data.(map[string]any)["code"].(string)), "a new printed code replaces the old one")
+	// Typed in lower case and without the dash still works
+	assert.Equal(t, 0, confirm(strings.ToLower(strings.ReplaceAll(code, "-", ""))))
+	var student User
+	DB.First(&student, "id = ?", "s1")
+	assert.False(t, student.MustChangePassword)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// bcrypt ignores everything past 72 bytes, so longer passwords are refused
+// rather than silently truncated
+const maxPasswordBytes = 72
+
+// defaultSystemSettings applies wherever system_settings is missing a field
+func defaultSystemSettings() SystemSettingsConfig {
+	return SystemSettingsConfig{
+		RegistrationEnabled: false,
+		PasswordPolicy: PasswordPolicy{
+			MinLength:        8,
+			RequireLetter:    true,
+			RequireDigit:     true,
+			DisallowUsername: true,
+		},
+	}
+}
+
+// loadSystemSettings returns the cached system_settings, see InvalidateSettings.
+// The defaults stand in while they can't be read.
+func loadSystemSettings(db *gorm.DB) SystemSettingsConfig {
+	settings, err := cachedSettingFor(db, "system_settings", func() (any, error) {
+		return readSystemSettings(db)
+	})
+	if err != nil {
+		return defaultSystemSettings()
+	}
+	return settings.(SystemSettingsConfig)
+}
+
+// readSystemSettings reads system_settings over the defaults
+func readSystemSettings(db *gorm.DB) (SystemSettingsConfig, error) {
+	settings := defaultSystemSettings()
+	var conf SystemConfig
+	err := db.Where(&SystemConfig{Key: "system_settings"}).First(&conf).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return settings, nil
+	} else if err != nil {
+		return settings, err
+	}
+	json.Unmarshal([]byte(conf.Value), &settings)
+	return settings, nil
+}
+
+// Validate checks that the policy itself is sensible
+func (p PasswordPolicy) Validate() error {
+	if p.MinLength < 6 || p.MinLength > maxPasswordBytes {
+		return fmt.Errorf("Minimum password length must be between 6 and %d", maxPasswordBytes)
+	}
+	return nil
+}
+
+// Check returns a user-facing reason why password does not meet the policy
+func (p PasswordPolicy) Check(password, username string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("Password must be at least %d characters", p.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("Password must be at most %d bytes", maxPasswordBytes)
+	}
+
+	var letter, digit, upper, lower, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsLetter(r):
+			letter = true
+			upper = upper || unicode.IsUpper(r)
+			lower = lower || unicode.IsLower(r)
+		case !unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	switch {
+	case p.RequireLetter && !letter:
+		return errors.New("Password must contain a letter")
+	case p.RequireDigit && !digit:
+		return errors.New("Password must contain a digit")
+	case p.RequireMixedCase && !(upper && lower):
+		return errors.New("Password must contain upper and lower case letters")
+	case p.RequireSymbol && !symbol:
+		return errors.New("Password must contain a symbol")
+	}
+	if p.DisallowUsername && username != "" &&
+		strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return errors.New("Password must not contain the username")
+	}
+	return nil
+}
+
+// hashPassword checks password against the configured policy and hashes it
+func hashPassword(db *gorm.DB, password, username string) (string, error) {
+	if err := loadSystemSettings(db).PasswordPolicy.Check(password, username); err != nil {
+		return "", err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", errors.New("Failed to hash password")
+	}
+	return string(hashed), nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	strict := PasswordPolicy{MinLength: 10, RequireLetter: true, RequireDigit: true, RequireMixedCase: true, RequireSymbol: true, DisallowUsername: true}
+
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		password string
+		wantErr  string
+	}{
+		{"Default accepts letters and digits", defaultSystemSettings().PasswordPolicy, "maple2024", ""},
+		{"Empty", defaultSystemSettings().PasswordPolicy, "", "at least 8 characters"},
+		{"Seeded admin password", defaultSystemSettings().PasswordPolicy, "123", "at least 8 characters"},
+		{"No digit", defaultSystemSettings().PasswordPolicy, "onlyletters", "contain a digit"},
+		{"No letter", defaultSystemSettings().PasswordPolicy, "13800000000", "contain a letter"},
+		{"Contains username", defaultSystemSettings().PasswordPolicy, "Alice2024!", "must not contain the username"},
+		{"Over the bcrypt limit", defaultSystemSettings().PasswordPolicy, string(bytes.Repeat([]byte("a1"), 40)), "at most 72 bytes"},
+		{"Strict needs mixed case", strict, "maple-2024-x", "upper and lower case"},
+		{"Strict needs a symbol", strict, "Maple2024xyz", "contain a symbol"},
+		{"Strict accepts", strict, "Maple-2024-x", ""},
+		{"Minimum length counts characters", PasswordPolicy{MinLength: 6}, "密码密码密码", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Check(tt.password, "alice")
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSystemSettingsPasswordPolicy(t *testing.T) {
+	DB.Exec("DELETE FROM system_configs WHERE `key` = ?", "system_settings")
+	ForgetCachedSettings("")
+	t.Cleanup(func() {
+		DB.Exec("DELETE FROM system_configs WHERE `key` = ?", "system_settings")
+		ForgetCachedSettings("")
+	})
+
+	r := gin.Default()
+	r.POST("/settings", UpdateSystemSettings)
+	post := func(payload string) Response {
+		req, _ := http.NewRequest("POST", "/settings", bytes.NewBufferString(payload))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	// Older clients only send registrationEnabled, the policy keeps its defaults
+	assert.Equal(t, 0, post(`{"registrationEnabled": true}`).Code)
+	settings := loadSystemSettings(DB)
+	assert.True(t, settings.RegistrationEnabled)
+	assert.Equal(t, defaultSystemSettings().PasswordPolicy, settings.PasswordPolicy)
+
+	assert.Equal(t, 0, post(`{"passwordPolicy": {"minLength": 12, "requireSymbol": true}}`).Code)
+	settings = loadSystemSettings(DB)
+	assert.True(t, settings.RegistrationEnabled)
+	assert.Equal(t, 12, settings.PasswordPolicy.MinLength)
+	assert.True(t, settings.PasswordPolicy.RequireSymbol)
+	assert.ErrorContains(t, settings.PasswordPolicy.Check("maple2024abc", ""), "symbol")
+
+	assert.Equal(t, 1, post(`{"passwordPolicy": {"minLength": 0}}`).Code)
+	assert.Equal(t, 12, loadSystemSettings(DB).PasswordPolicy.MinLength)
+}
+
+func TestMustChangePassword(t *testing.T) {
+	DB.Exec("DELETE FROM users")
+	DB.Exec("DELETE FROM login_attempts")
+	DB.Create(&User{ID: "1", Username: "admin", Role: RoleAdmin, Status: "active"})
+
+	admin := gin.Default()
+	admin.Use(func(c *gin.Context) {
+		c.Set("userId", "1")
+		c.Set("role", RoleAdmin)
+	})
+	admin.POST("/admin/users", CreateUser)
+
+	r := gin.Default()
+	r.POST("/auth/login", LoginHandler)
+	protected := r.Group("/", AuthMiddleware())
+	protected.GET("/me", GetMe)
+	protected.PUT("/me", UpdateMe)
+
+	call := func(router *gin.Engine, method, url, token string, payload any) (int, Response) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	_, resp := call(admin, "POST", "/admin/users", "", map[string]any{"username": "bob", "role": RoleTeacher, "status": "active"})
+	assert.Equal(t, 1, resp.Code, "admins cannot create accounts without a password")
+	_, resp = call(admin, "POST", "/admin/users", "", map[string]any{"username": "bob", "password": "welcome2024", "role": RoleTeacher, "status": "active", "mustChangePassword": false})
+	assert.Equal(t, 0, resp.Code)
+	var bob User
+	DB.First(&bob, "username = ?", "bob")
+	assert.True(t, bob.MustChangePassword)
+
+	_, resp = call(r, "POST", "/auth/login", "", map[string]string{"username": "bob", "password": "welcome2024"})
+	data := resp.Data.(map[string]any)
+	token := data["token"].(string)
+	assert.Equal(t, true, data["user"].(map[string]any)["mustChangePassword"])
+
+	status, _ := call(r, "GET", "/me", token, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  string
+	}{
+		{"Same password", "welcome2024", "must be different"},
+		{"Weak password", "bob", "at least 8 characters"},
+		{"New password", "river-stone-42", ""},
+	}
+	for _, tt := range tests {
+		status, resp := call(r, "PUT", "/me", token, map[string]string{"password": tt.password})
+		assert.Equal(t, http.StatusOK, status, tt.name)
+		assert.Equal(t, tt.wantErr == "", resp.Code == 0, tt.name)
+		assert.Contains(t, resp.Err, tt.wantErr, tt.name)
+	}
+
+	status, _ = call(r, "GET", "/me", token, nil)
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestMigrateFlagsSeededAdmin(t *testing.T) {
+	db := openMigrationTestDB(t)
+	assert.NoError(t, MigrateUp(db, 5))
+	db.Model(&User{}).Where("id = ?", "1").Update("must_change_password", false)
+
+	assert.NoError(t, MigrateUp(db, 0))
+	var admin User
+	db.First(&admin, "id = ?", "1")
+	assert.True(t, admin.MustChangePassword)
+}
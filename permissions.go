@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AccessLevel is what a route does with its permission module
+type AccessLevel string
+
+const (
+	AccessPublic   AccessLevel = "public"    // no login needed
+	AccessSignedIn AccessLevel = "signed-in" // any logged-in user, the handler checks the rest
+	AccessRead     AccessLevel = "read"
+	AccessCreate   AccessLevel = "create"
+	AccessUpdate   AccessLevel = "update"
+	AccessDelete   AccessLevel = "delete"
+
+	// AccessWrite is only declared: registering the route turns it into
+	// create, update or delete following the HTTP method
+	AccessWrite AccessLevel = "write"
+)
+
+// RoutePermission is the module and access level a route declares
+type RoutePermission struct {
+	Module string
+	Access AccessLevel
+}
+
+var (
+	Public   = RoutePermission{Access: AccessPublic}
+	SignedIn = RoutePermission{Access: AccessSignedIn}
+)
+
+// Read declares a route that only reads data of a module
+func Read(module string) RoutePermission {
+	return RoutePermission{Module: module, Access: AccessRead}
+}
+
+// Write declares a route that changes data of a module the way its HTTP
+// method says: POST creates, PUT updates and DELETE deletes
+func Write(module string) RoutePermission {
+	return RoutePermission{Module: module, Access: AccessWrite}
+}
+
+// Create, Update and Delete declare the action of routes whose HTTP method
+// doesn't tell, like a POST that unlocks a user
+func Create(module string) RoutePermission {
+	return RoutePermission{Module: module, Access: AccessCreate}
+}
+
+func Update(module string) RoutePermission {
+	return RoutePermission{Module: module, Access: AccessUpdate}
+}
+
+func Delete(module string) RoutePermission {
+	return RoutePermission{Module: module, Access: AccessDelete}
+}
+
+// accessForMethod is the action a Write route takes
+func accessForMethod(method string) AccessLevel {
+	switch method {
+	case "POST":
+		return AccessCreate
+	case "PUT", "PATCH":
+		return AccessUpdate
+	case "DELETE":
+		return AccessDelete
+	}
+	return AccessRead
+}
+
+// Allows reports whether the permission covers an action on its module
+func (p RolePermission) Allows(access AccessLevel) bool {
+	switch access {
+	case AccessRead:
+		return p.CanRead
+	case AccessCreate:
+		return p.CanCreate
+	case AccessUpdate:
+		return p.CanUpdate
+	case AccessDelete:
+		return p.CanDelete
+	}
+	return false
+}
+
+// apiPermission is a permission whose API actions are all allowed or all
+// denied, the way the single APIAccess switch worked before
+func apiPermission(role Role, module string, ui, api bool) RolePermission {
+	return RolePermission{Role: role, ModuleID: module, UIAccess: ui, CanRead: api, CanCreate: api, CanUpdate: api, CanDelete: api}
+}
+
+var errPermissionsLockout = errors.New("You cannot remove your own access to the permissions module")
+
+// validateRolePermissions checks a complete permission list before it
+// replaces the current one: known roles and modules, one rule per role and
+// module, and the requester's role keeps managing permissions so an admin
+// can't lock everyone with that role out of this page
+func validateRolePermissions(c *gin.Context, perms []RolePermission) error {
+	role, _ := c.Get("role")
+	keepsAccess := false
+	seen := make(map[string]bool)
+	for _, rp := range perms {
+		if !slices.Contains([]Role{RoleAdmin, RoleTeacher, RoleStudent, RoleParent}, rp.Role) {
+			return fmt.Errorf("Unknown role: %s", rp.Role)
+		}
+		if !slices.Contains(allModules, rp.ModuleID) {
+			return fmt.Errorf("Unknown module: %s", rp.ModuleID)
+		}
+		key := permissionKey(rp)
+		if seen[key] {
+			return fmt.Errorf("Duplicate permission: %s", key)
+		}
+		seen[key] = true
+		if fmt.Sprintf("%v", role) == string(rp.Role) && rp.ModuleID == "permissions" && rp.UIAccess && rp.CanRead && rp.CanUpdate {
+			keepsAccess = true
+		}
+	}
+	if !keepsAccess {
+		return errPermissionsLockout
+	}
+	return nil
+}
+
+func permissionKey(rp RolePermission) string {
+	return string(rp.Role) + "/" + rp.ModuleID
+}
+
+// describePermission lists what a rule allows, for the audit log
+func describePermission(rp RolePermission, exists bool) string {
+	var allowed []string
+	for _, a := range []struct {
+		name string
+		ok   bool
+	}{{"ui", rp.UIAccess}, {"read", rp.CanRead}, {"create", rp.CanCreate}, {"update", rp.CanUpdate}, {"delete", rp.CanDelete}} {
+		if exists && a.ok {
+			allowed = append(allowed, a.name)
+		}
+	}
+	if len(allowed) == 0 {
+		return "none"
+	}
+	return strings.Join(allowed, ",")
+}
+
+// replaceRolePermissions turns the permissions of the school tx is scoped to
+// into perms, touching only the rules that differ, and returns the changes
+// as "ROLE/module: before -> after"
+func replaceRolePermissions(tx *gorm.DB, perms []RolePermission) ([]string, error) {
+	var current []RolePermission
+	if err := tx.Find(&current).Error; err != nil {
+		return nil, err
+	}
+	before := make(map[string]RolePermission, len(current))
+	for _, rp := range current {
+		before[permissionKey(rp)] = rp
+	}
+
+	var changes []string
+	for _, rp := range perms {
+		key := permissionKey(rp)
+		old, exists := before[key]
+		delete(before, key)
+		if exists && old.UIAccess == rp.UIAccess && old.CanRead == rp.CanRead && old.CanCreate == rp.CanCreate && old.CanUpdate == rp.CanUpdate && old.CanDelete == rp.CanDelete {
+			continue
+		}
+
+		var err error
+		if exists {
+			err = tx.Model(&RolePermission{}).Where("role = ? AND module_id = ?", rp.Role, rp.ModuleID).Updates(map[string]any{
+				"ui_access": rp.UIAccess, "can_read": rp.CanRead, "can_create": rp.CanCreate, "can_update": rp.CanUpdate, "can_delete": rp.CanDelete,
+			}).Error
+		} else {
+			err = tx.Create(&rp).Error
+		}
+		if err != nil {
+			return nil, err
+		}
+		if from, to := describePermission(old, exists), describePermission(rp, true); from != to {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", key, from, to))
+		}
+	}
+
+	// Rules left out of the list are removed
+	for key, old := range before {
+		if err := tx.Where("role = ? AND module_id = ?", old.Role, old.ModuleID).Delete(&RolePermission{}).Error; err != nil {
+			return nil, err
+		}
+		if from := describePermission(old, true); from != "none" {
+			changes = append(changes, fmt.Sprintf("%s: %s -> none", key, from))
+		}
+	}
+	sort.Strings(changes)
+	return changes, nil
+}
+
+// routePermissions holds the declared permission of every route, keyed by
+// "METHOD /full/path" as gin reports it through c.FullPath()
+var routePermissions = map[string]RoutePermission{}
+
+func routeKey(method, fullPath string) string {
+	return method + " " + fullPath
+}
+
+// LookupRoutePermission returns the permission a route declared
+func LookupRoutePermission(method, fullPath string) (RoutePermission, bool) {
+	perm, ok := routePermissions[routeKey(method, fullPath)]
+	return perm, ok
+}
+
+// Guarded is a router group whose routes must declare the permission they
+// need. PermissionMiddleware denies every route that didn't.
+type Guarded struct {
+	*gin.RouterGroup
+}
+
+// Guard wraps a router group so its routes declare their permissions
+func Guard(group *gin.RouterGroup) Guarded {
+	return Guarded{group}
+}
+
+// Group creates a guarded sub-group
+func (g Guarded) Group(relativePath string, handlers ...gin.HandlerFunc) Guarded {
+	return Guarded{g.RouterGroup.Group(relativePath, handlers...)}
+}
+
+// Handle registers a route together with its permission
+func (g Guarded) Handle(method, relativePath string, perm RoutePermission, handlers ...gin.HandlerFunc) {
+	if perm.Access == AccessWrite {
+		perm.Access = accessForMethod(method)
+	}
+	routePermissions[routeKey(method, joinRoutePath(g.BasePath(), relativePath))] = perm
+	g.RouterGroup.Handle(method, relativePath, handlers...)
+}
+
+func (g Guarded) GET(relativePath string, perm RoutePermission, handlers ...gin.HandlerFunc) {
+	g.Handle("GET", relativePath, perm, handlers...)
+}
+
+func (g Guarded) POST(relativePath string, perm RoutePermission, handlers ...gin.HandlerFunc) {
+	g.Handle("POST", relativePath, perm, handlers...)
+}
+
+func (g Guarded) PUT(relativePath string, perm RoutePermission, handlers ...gin.HandlerFunc) {
+	g.Handle("PUT", relativePath, perm, handlers...)
+}
+
+func (g Guarded) DELETE(relativePath string, perm RoutePermission, handlers ...gin.HandlerFunc) {
+	g.Handle("DELETE", relativePath, perm, handlers...)
+}
+
+// Static serves a directory publicly
+func (g Guarded) Static(relativePath, root string) {
+	g.RouterGroup.Static(relativePath, root)
+	file := joinRoutePath(g.BasePath(), path.Join(relativePath, "/*filepath"))
+	routePermissions[routeKey("GET", file)] = Public
+	routePermissions[routeKey("HEAD", file)] = Public
+}
+
+// joinRoutePath joins paths the way gin does, keeping a trailing slash
+func joinRoutePath(base, relative string) string {
+	if relative == "" {
+		return base
+	}
+	joined := path.Join(base, relative)
+	if strings.HasSuffix(relative, "/") && !strings.HasSuffix(joined, "/") {
+		return joined + "/"
+	}
+	return joined
+}
+
+// CheckRoutePermissions fails if any registered route has no declared
+// permission, so a forgotten declaration stops the server at startup rather
+// than answering 403 in production
+func CheckRoutePermissions(routes gin.RoutesInfo) error {
+	var missing []string
+	for _, route := range routes {
+		if _, ok := LookupRoutePermission(route.Method, route.Path); !ok {
+			missing = append(missing, routeKey(route.Method, route.Path))
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("routes without a declared permission: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
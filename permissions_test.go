@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+func TestAllRoutesDeclarePermissions(t *testing.T) {
+	r := gin.New()
+	registerRoutes(r)
+	assert.NoError(t, CheckRoutePermissions(r.Routes()))
+
+	// A route registered past Guarded is caught at startup
+	r.GET("/api/undeclared", func(c *gin.Context) {})
+	assert.ErrorContains(t, CheckRoutePermissions(r.Routes()), "GET /api/undeclared")
+
+	tests := []struct {
+		method, path string
+		expected     RoutePermission
+	}{
+		{"GET", "/api/questions", Read("questions")},
+		{"PUT", "/api/questions/:id", Update("questions")},
+		{"DELETE", "/api/questions/:id", Delete("questions")},
+		{"POST", "/api/questions/:id/check", Create("assignments")},
+		{"GET", "/api/wrong-book", Read("wrong_book")},
+		{"GET", "/api/me", SignedIn},
+		{"GET", "/api/dashboard/online-users", Read("users")},
+		{"POST", "/api/admin/permissions", Update("permissions")},
+		{"POST", "/api/auth/login", Public},
+	}
+	for _, tt := range tests {
+		perm, ok := LookupRoutePermission(tt.method, tt.path)
+		assert.True(t, ok, tt.path)
+		assert.Equal(t, tt.expected, perm, tt.method+" "+tt.path)
+	}
+}
+
+func TestPermissionMiddlewareDeniesByDefault(t *testing.T) {
+	// Teachers may edit papers but not delete them
+	DB.Model(&RolePermission{}).Where("role = ? AND module_id = ?", RoleTeacher, "papers").Update("can_delete", false)
+	ForgetCachedSettings("")
+	t.Cleanup(func() {
+		DB.Model(&RolePermission{}).Where("role = ? AND module_id = ?", RoleTeacher, "papers").Update("can_delete", true)
+		ForgetCachedSettings("")
+	})
+
+	as := func(role Role) *gin.Engine {
+		r := gin.New()
+		r.Use(func(c *gin.Context) {
+			c.Set("userId", "u1")
+			c.Set("role", string(role))
+		}, PermissionMiddleware())
+		ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+		api := Guard(r.Group("/api"))
+		api.GET("/admin/users", Read("users"), ok)
+		api.GET("/questions", Read("questions"), ok)
+		api.PUT("/papers/:id", Write("papers"), ok)
+		api.DELETE("/papers/:id", Write("papers"), ok)
+		api.GET("/me", SignedIn, ok)
+		api.GET("/legacy/:id", Read("no_such_module"), ok)
+		r.GET("/api/undeclared", ok)
+		return r
+	}
+
+	tests := []struct {
+		name           string
+		role           Role
+		method, url    string
+		expectedStatus int
+	}{
+		{"Admin reads users", RoleAdmin, "GET", "/api/admin/users", http.StatusOK},
+		{"Teacher reads questions", RoleTeacher, "GET", "/api/questions", http.StatusOK},
+		{"Teacher lacks the users module", RoleTeacher, "GET", "/api/admin/users", http.StatusForbidden},
+		{"Teacher updates papers", RoleTeacher, "PUT", "/api/papers/p1", http.StatusOK},
+		{"Teacher may not delete papers", RoleTeacher, "DELETE", "/api/papers/p1", http.StatusForbidden},
+		{"Admin deletes papers", RoleAdmin, "DELETE", "/api/papers/p1", http.StatusOK},
+		{"Student reaches signed-in routes", RoleStudent, "GET", "/api/me", http.StatusOK},
+		{"Unknown module", RoleAdmin, "GET", "/api/legacy/1", http.StatusForbidden},
+		{"Undeclared route", RoleAdmin, "GET", "/api/undeclared", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.url, nil)
+			w := httptest.NewRecorder()
+			as(tt.role).ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func TestUpdateRolePermissions(t *testing.T) {
+	DB.Exec("DELETE FROM audit_logs")
+	t.Cleanup(func() {
+		DB.Exec("DELETE FROM role_permissions")
+		DB.Create(append(defaultRolePermissions(), defaultParentPermissions()...))
+		ForgetCachedSettings("")
+	})
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("userId", "1")
+		c.Set("role", RoleAdmin)
+	})
+	r.POST("/admin/permissions", UpdateRolePermissions)
+	post := func(perms []RolePermission) Response {
+		body, _ := json.Marshal(perms)
+		req, _ := http.NewRequest("POST", "/admin/permissions", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+	current := func() map[string]RolePermission {
+		var rows []RolePermission
+		DB.Find(&rows)
+		byKey := make(map[string]RolePermission)
+		for _, rp := range rows {
+			rp.TenantID = ""
+			byKey[permissionKey(rp)] = rp
+		}
+		return byKey
+	}
+	// edited returns the current rules with change applied to every rule
+	edited := func(change func(rp *RolePermission)) []RolePermission {
+		var perms []RolePermission
+		for _, rp := range current() {
+			change(&rp)
+			perms = append(perms, rp)
+		}
+		return perms
+	}
+	before := current()
+
+	rejected := []struct {
+		name     string
+		perms    []RolePermission
+		expected string
+	}{
+		{"Unknown module", append(edited(func(*RolePermission) {}), RolePermission{Role: RoleTeacher, ModuleID: "nope"}), "Unknown module: nope"},
+		{"Unknown role", append(edited(func(*RolePermission) {}), RolePermission{Role: RoleSuperAdmin, ModuleID: "users"}), "Unknown role: SUPER_ADMIN"},
+		{"Duplicate rule", append(edited(func(*RolePermission) {}), RolePermission{Role: RoleTeacher, ModuleID: "papers"}), "Duplicate permission: TEACHER/papers"},
+		{"Removing own access", edited(func(rp *RolePermission) {
+			if rp.Role == RoleAdmin && rp.ModuleID == "permissions" {
+				rp.CanUpdate = false
+			}
+		}), errPermissionsLockout.Error()},
+		{"Leaving out own access", nil, errPermissionsLockout.Error()},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, post(tt.perms).Err)
+			assert.Equal(t, before, current())
+		})
+	}
+
+	t.Run("Failed write changes nothing", func(t *testing.T) {
+		DB.Callback().Create().Before("gorm:create").Register("test:fail_permissions", func(tx *gorm.DB) {
+			if tx.Statement.Table == "role_permissions" {
+				tx.AddError(errors.New("disk full"))
+			}
+		})
+		defer DB.Callback().Create().Remove("test:fail_permissions")
+
+		perms := edited(func(rp *RolePermission) { rp.CanRead = true })
+		perms = append(perms, RolePermission{Role: RoleTeacher, ModuleID: "users", CanRead: true})
+		assert.Equal(t, "Failed to update permissions", post(perms).Err)
+		assert.Equal(t, before, current())
+	})
+
+	t.Run("Only differences are written and audited", func(t *testing.T) {
+		perms := edited(func(rp *RolePermission) {
+			if rp.Role == RoleTeacher && rp.ModuleID == "papers" {
+				rp.CanDelete = false
+			}
+		})
+		for i, rp := range perms {
+			if rp.Role == RoleParent && rp.ModuleID == "help_docs" {
+				perms = append(perms[:i], perms[i+1:]...)
+				break
+			}
+		}
+		perms = append(perms, RolePermission{Role: RoleTeacher, ModuleID: "users", CanRead: true})
+		resp := post(perms)
+		assert.Equal(t, 0, resp.Code, resp.Err)
+
+		after := current()
+		assert.False(t, after["TEACHER/papers"].CanDelete)
+		assert.True(t, after["TEACHER/users"].CanRead)
+		assert.NotContains(t, after, "PARENT/help_docs")
+		assert.Len(t, after, len(before))
+
+		var log AuditLog
+		DB.First(&log, "action = ?", "UPDATE_PERMISSIONS")
+		assert.Equal(t, "Changed permissions: PARENT/help_docs: ui -> none; "+
+			"TEACHER/papers: ui,read,create,update,delete -> ui,read,create,update; "+
+			"TEACHER/users: none -> read", log.Details)
+
+		// Posting the same list again changes nothing and isn't audited
+		resp = post(perms)
+		assert.Equal(t, 0, resp.Code, resp.Err)
+		var count int64
+		DB.Model(&AuditLog{}).Where("action = ?", "UPDATE_PERMISSIONS").Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+}
+
+func TestDefaultPermissionsThroughRoutes(t *testing.T) {
+	DB.Exec("DELETE FROM users")
+	DB.Exec("DELETE FROM classes")
+	DB.Exec("DELETE FROM user_sessions")
+	DB.Exec("DELETE FROM refresh_tokens")
+	ForgetCachedSettings("")
+	LoginAttempts = NewMemoryAttemptStore()
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	DB.Create(&User{ID: "t1", Username: "teacher1", Password: string(hashed), Role: RoleTeacher, Status: "active"})
+	DB.Create(&User{ID: "s1", Username: "student1", Password: string(hashed), Role: RoleStudent, Status: "active"})
+	DB.Create(&Class{ID: "k1", Name: "1A", TeacherIDs: []string{"t1"}, StudentIDs: []string{"s1"}})
+
+	r := gin.New()
+	registerRoutes(r)
+	call := func(method, url, token string, payload any) (int, Response) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	tokens := make(map[Role]string)
+	for role, username := range map[Role]string{RoleTeacher: "teacher1", RoleStudent: "student1"} {
+		_, resp := call("POST", "/api/auth/login", "", map[string]string{"username": username, "password": "pw"})
+		if !assert.Equal(t, 0, resp.Code, resp.Err) {
+			return
+		}
+		tokens[role] = resp.Data.(map[string]any)["token"].(string)
+	}
+
+	// The school-wide stats are for admins only
+	for _, role := range []Role{RoleTeacher, RoleStudent} {
+		status, _ := call("GET", "/api/dashboard/stats", tokens[role], nil)
+		assert.Equal(t, http.StatusForbidden, status, role)
+	}
+
+	// The seeded rules of a school let everyone use their own pages
+	tests := []struct {
+		name        string
+		role        Role
+		method, url string
+	}{
+		{"Teacher reads own stats", RoleTeacher, "GET", "/api/teacher/stats"},
+		{"Student reads own stats", RoleStudent, "GET", "/api/student/stats"},
+		{"Teacher lists students", RoleTeacher, "GET", "/api/students"},
+		{"Teacher prints a reset code", RoleTeacher, "POST", "/api/students/s1/reset-code"},
+		{"Teacher invites a parent", RoleTeacher, "POST", "/api/students/s1/parent-invite"},
+		{"Teacher prints a login card", RoleTeacher, "POST", "/api/students/s1/login-card"},
+		{"Teacher sets picture password", RoleTeacher, "POST", "/api/students/s1/picture-password"},
+		{"Teacher reads student login", RoleTeacher, "GET", "/api/students/s1/login"},
+		{"Teacher revokes student login", RoleTeacher, "DELETE", "/api/students/s1/login"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, resp := call(tt.method, tt.url, tokens[tt.role], nil)
+			assert.Equal(t, http.StatusOK, status)
+			assert.Equal(t, 0, resp.Code, resp.Err)
+		})
+	}
+}
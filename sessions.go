@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errSessionFinished = errors.New("Session not found or already finished")
+
+// maxAttempts tries close a question, mirrored by PracticeSession.tsx
+const maxAttempts = 4
+
+// StartSession opens a server side attempt log. For homework the question list
+// comes from the assigned paper, for free practice from the request.
+func StartSession(c *gin.Context) {
+	var req struct {
+		HomeworkID  string   `json:"homeworkId"`
+		QuestionIDs []string `json:"questionIds"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
+
+	userId, _ := c.Get("userId")
+	role, _ := c.Get("role")
+	questionIDs := req.QuestionIDs
+
+	if req.HomeworkID != "" {
+		var hw Homework
+		var paper Paper
+		if err := TenantDB(c).First(&hw, "id = ?", req.HomeworkID).Error; err != nil {
+			SendJSON(c, 1, "Homework not found", nil)
+			return
+		}
+		if fmt.Sprintf("%v", role) == string(RoleStudent) && !slices.Contains(hw.StudentIDs, fmt.Sprintf("%v", userId)) {
+			SendJSON(c, 1, "Homework is not assigned to you", nil)
+			return
+		}
+		if err := TenantDB(c).First(&paper, "id = ?", hw.PaperID).Error; err != nil {
+			SendJSON(c, 1, "Paper not found", nil)
+			return
+		}
+		questionIDs = paperQuestionIDs(paper)
+	} else if len(questionIDs) > 0 {
+		// Keep only questions that actually exist
+		var existing []string
+		TenantDB(c).Model(&Question{}).Where("id IN ?", questionIDs).Pluck("id", &existing)
+		known := make(map[string]bool)
+		for _, id := range existing {
+			known[id] = true
+		}
+		filtered := make([]string, 0, len(existing))
+		for _, id := range questionIDs {
+			if known[id] {
+				filtered = append(filtered, id)
+				delete(known, id)
+			}
+		}
+		questionIDs = filtered
+
+		// Homework questions are only answered in the homework's own session,
+		// or free practice would let a student try out the assignment first
+		if fmt.Sprintf("%v", role) == string(RoleStudent) {
+			open := openHomeworkQuestions(TenantDB(c), fmt.Sprintf("%v", userId))
+			for _, id := range questionIDs {
+				if open[id] {
+					SendJSON(c, 1, "Questions of open homework can't be practiced", nil)
+					return
+				}
+			}
+		}
+	}
+
+	if len(questionIDs) == 0 {
+		SendJSON(c, 1, "No questions for session", nil)
+		return
+	}
+
+	s := PracticeSession{
+		ID:          strconv.FormatInt(time.Now().UnixNano(), 36),
+		StudentID:   fmt.Sprintf("%v", userId),
+		HomeworkID:  req.HomeworkID,
+		QuestionIDs: questionIDs,
+		Attempts:    make(map[string][]AttemptLog),
+		Status:      "active",
+		CreatedAt:   time.Now().Format("2006-01-02 15:04:05"),
+	}
+	if err := TenantDB(c).Create(&s).Error; err != nil {
+		SendJSON(c, 1, "Failed to start session", nil)
+		return
+	}
+	SendJSON(c, 0, "", gin.H{
+		"id":          s.ID,
+		"questionIds": s.QuestionIDs,
+		"maxAttempts": maxAttempts,
+	})
+}
+
+// paperQuestionIDs lists the questions of a paper in order
+func paperQuestionIDs(paper Paper) []string {
+	ids := make([]string, 0, len(paper.Questions))
+	for _, q := range paper.Questions {
+		ids = append(ids, q.ID)
+	}
+	if len(ids) == 0 {
+		ids = paper.QuestionIDs
+	}
+	return ids
+}
+
+// openHomeworkQuestions is the set of questions in homework assigned to the
+// student that they haven't handed in yet
+func openHomeworkQuestions(db *gorm.DB, studentID string) map[string]bool {
+	open := make(map[string]bool)
+	var homeworks []Homework
+	db.Where("student_ids LIKE ?", "%\""+studentID+"\"%").Find(&homeworks)
+	for _, hw := range homeworks {
+		var done int64
+		db.Model(&History{}).Where("student_id = ? AND homework_id = ?", studentID, hw.ID).Count(&done)
+		if done > 0 {
+			continue
+		}
+		var paper Paper
+		if err := db.First(&paper, "id = ?", hw.PaperID).Error; err != nil {
+			continue
+		}
+		for _, id := range paperQuestionIDs(paper) {
+			open[id] = true
+		}
+	}
+	return open
+}
+
+// SubmitSessionAnswer grades one answer for one question of a session and
+// appends it to the server held attempt log
+func SubmitSessionAnswer(c *gin.Context) {
+	id := c.Param("id")
+	var req struct {
+		QuestionID string `json:"questionId" binding:"required"`
+		Answer     string `json:"answer"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
+
+	userId, _ := c.Get("userId")
+	studentId := fmt.Sprintf("%v", userId)
+
+	var q Question
+	if err := TenantDB(c).First(&q, "id = ?", req.QuestionID).Error; err != nil {
+		SendJSON(c, 1, "Question not found", nil)
+		return
+	}
+
+	logs, errMsg := recordAttempt(TenantDB(c), id, studentId, q, req.Answer)
+	if errMsg != "" {
+		SendJSON(c, 1, errMsg, nil)
+		return
+	}
+	SendJSON(c, 0, "", attemptResult(c, studentId, q, logs))
+}
+
+// recordAttempt grades an answer to a question of the student's session and
+// appends it to the attempt log. On failure errMsg says why.
+func recordAttempt(db *gorm.DB, sessionID, studentID string, q Question, answer string) (logs []AttemptLog, errMsg string) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Locked so concurrent answers can't both pass the attempt limit
+		var s PracticeSession
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&s, "id = ? AND student_id = ?", sessionID, studentID).Error; err != nil {
+			errMsg = "Session not found"
+			return err
+		}
+		if s.Status != "active" {
+			errMsg = "Session already finished"
+			return fmt.Errorf("session %s finished", s.ID)
+		}
+
+		inSession := false
+		for _, qid := range s.QuestionIDs {
+			if qid == q.ID {
+				inSession = true
+				break
+			}
+		}
+		if !inSession {
+			errMsg = "Question is not part of this session"
+			return fmt.Errorf("question %s not in session", q.ID)
+		}
+
+		if s.Attempts == nil {
+			s.Attempts = make(map[string][]AttemptLog)
+		}
+		logs = s.Attempts[q.ID]
+		if questionClosed(logs) {
+			errMsg = "Question already finished"
+			return fmt.Errorf("question %s closed", q.ID)
+		}
+
+		logs = append(logs, AttemptLog{
+			Answer:    answer,
+			Timestamp: time.Now().UnixMilli(),
+			IsCorrect: GradeAnswer(q, answer),
+		})
+		s.Attempts[q.ID] = logs
+		return tx.Save(&s).Error
+	})
+	if err != nil && errMsg == "" {
+		errMsg = "Failed to record answer"
+	}
+	return logs, errMsg
+}
+
+// attemptResult is the response to the latest attempt in logs
+func attemptResult(c *gin.Context, studentId string, q Question, logs []AttemptLog) gin.H {
+	log := logs[len(logs)-1]
+	attemptsLeft := 0
+	if !log.IsCorrect {
+		attemptsLeft = maxAttempts - len(logs)
+	}
+
+	// Teachers previewing a paper always get the key. Students get the answer
+	// and hint after a correct reply or when their wrong-book stage allows it;
+	// running out of attempts reveals nothing.
+	showAnswer := log.IsCorrect
+	role, _ := c.Get("role")
+	if fmt.Sprintf("%v", role) != string(RoleStudent) {
+		showAnswer = true
+	} else if !showAnswer {
+		showAnswer = RevealableAnswers(TenantDB(c), studentId, []string{q.ID})[q.ID]
+	}
+	showHint := showAnswer
+
+	res := gin.H{
+		"correct":      log.IsCorrect,
+		"attempts":     len(logs),
+		"attemptsLeft": attemptsLeft,
+		"showHint":     showHint,
+		"showAnswer":   showAnswer,
+	}
+	if showHint {
+		res["hint"] = q.Hint
+	}
+	if showAnswer {
+		res["answer"] = q.Answer
+	}
+	return res
+}
+
+// questionClosed reports whether a question accepts no more attempts
+func questionClosed(logs []AttemptLog) bool {
+	if len(logs) >= maxAttempts {
+		return true
+	}
+	return len(logs) > 0 && logs[len(logs)-1].IsCorrect
+}
+
+// sessionResults turns the attempt log of a session into history results for
+// GradeHistory, in the session's question order
+func sessionResults(s PracticeSession) []any {
+	results := make([]any, 0, len(s.Attempts))
+	for _, qid := range s.QuestionIDs {
+		logs := s.Attempts[qid]
+		if len(logs) == 0 {
+			continue
+		}
+		results = append(results, HistoryQuestionResult{
+			ID:         qid,
+			UserAnswer: logs[len(logs)-1].Answer,
+			Attempts:   len(logs),
+			AttemptLog: logs,
+		})
+	}
+	return results
+}
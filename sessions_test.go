@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPracticeSessionFlow(t *testing.T) {
+	DB.Exec("DELETE FROM questions")
+	DB.Exec("DELETE FROM practice_sessions")
+	DB.Exec("DELETE FROM student_wrong_questions")
+	DB.Create(&Question{ID: "sq1", Type: QuestionTypeCalculation, Answer: "7", Hint: "3+4"})
+	DB.Create(&Question{ID: "sq2", Type: QuestionTypeTrueFalse, Answer: "正确"})
+	DB.Create(&Question{ID: "sq3", Type: QuestionTypeFillBlank, Answer: "x"})
+
+	r := gin.Default()
+	r.Use(func(c *gin.Context) {
+		c.Set("userId", "s1")
+		c.Set("role", RoleStudent)
+	})
+	r.POST("/sessions", StartSession)
+	r.POST("/sessions/:id/answer", SubmitSessionAnswer)
+	r.POST("/history", CreateHistory)
+
+	post := func(url string, payload any) map[string]any {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", url, bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp struct {
+			Code int            `json:"code"`
+			Err  string         `json:"err"`
+			Data map[string]any `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if resp.Code != 0 {
+			return map[string]any{"err": resp.Err}
+		}
+		return resp.Data
+	}
+
+	started := post("/sessions", map[string]any{"questionIds": []string{"sq1", "sq2", "missing"}})
+	sessionID := started["id"].(string)
+	assert.Equal(t, []any{"sq1", "sq2"}, started["questionIds"])
+
+	answer := func(qid, val string) map[string]any {
+		return post("/sessions/"+sessionID+"/answer", map[string]string{"questionId": qid, "answer": val})
+	}
+
+	tests := []struct {
+		name         string
+		questionID   string
+		answer       string
+		correct      bool
+		attemptsLeft float64
+		showHint     bool
+		showAnswer   bool
+	}{
+		{"First wrong try", "sq1", "6", false, 3, false, false},
+		{"Second wrong try", "sq1", "8", false, 2, false, false},
+		{"Third wrong try", "sq1", "9", false, 1, false, false},
+		{"Using up the tries reveals nothing", "sq1", "5", false, 0, false, false},
+		{"Correct on first try", "sq2", "对", true, 0, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := answer(tt.questionID, tt.answer)
+			assert.Equal(t, tt.correct, res["correct"])
+			assert.Equal(t, tt.attemptsLeft, res["attemptsLeft"])
+			assert.Equal(t, tt.showHint, res["showHint"])
+			assert.Equal(t, tt.showAnswer, res["showAnswer"])
+		})
+	}
+
+	// Closed questions and foreign questions are rejected
+	assert.Equal(t, "Question already finished", answer("sq1", "7")["err"])
+	assert.Equal(t, "Question is not part of this session", answer("sq3", "x")["err"])
+
+	// The history is built from the server log, not the client payload
+	history := post("/history", map[string]any{
+		"sessionId":    sessionID,
+		"correctCount": 2,
+		"questions":    []map[string]any{{"id": "sq1", "status": "correct", "userAnswer": "7"}},
+	})
+	assert.Equal(t, float64(1), history["correctCount"])
+	assert.Equal(t, float64(1), history["wrongCount"])
+	assert.Equal(t, float64(2), history["total"])
+
+	// A session can only be finalized once
+	assert.NotNil(t, post("/history", map[string]any{"sessionId": sessionID})["err"])
+
+	// Homework runs in a session of a student it was assigned to
+	DB.Exec("DELETE FROM homeworks")
+	DB.Exec("DELETE FROM papers")
+	DB.Create(&Paper{ID: "sp1", QuestionIDs: []string{"sq1"}})
+	DB.Create(&Homework{ID: "sh1", PaperID: "sp1", StudentIDs: []string{"s1"}})
+	DB.Create(&Homework{ID: "sh2", PaperID: "sp1", StudentIDs: []string{"s2"}})
+	assert.Equal(t, "Homework is not assigned to you", post("/sessions", map[string]any{"homeworkId": "sh2"})["err"])
+	assert.Equal(t, "Questions of open homework can't be practiced", post("/sessions", map[string]any{"questionIds": []string{"sq2", "sq1"}})["err"])
+	assert.Equal(t, []any{"sq1"}, post("/sessions", map[string]any{"homeworkId": "sh1"})["questionIds"])
+	assert.NotNil(t, post("/history", map[string]any{"homeworkId": "sh1", "questions": []map[string]any{{"id": "sq1", "userAnswer": "7"}}})["err"])
+
+	// Once handed in, its questions are free to practice
+	DB.Create(&History{ID: "shh1", StudentID: "s1", HomeworkID: "sh1"})
+	assert.Equal(t, []any{"sq1"}, post("/sessions", map[string]any{"questionIds": []string{"sq1"}})["questionIds"])
+}
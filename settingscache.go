@@ -0,0 +1,109 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Role permissions are checked on every protected request and the error logic
+// config on every practice answer, so both are kept in memory per school.
+// Handlers that change them call InvalidateSettings. Entries also expire after
+// settingsCacheTTL, so replicas converge even when nobody tells them.
+
+var settingsCacheTTL = time.Minute
+
+// SettingsChanged is called with the school ID after this instance changed
+// its permissions or config. With more than one replica, set it to publish
+// the ID (Redis, NATS, Postgres NOTIFY...) and have every replica pass what
+// it receives to ForgetCachedSettings.
+var SettingsChanged func(tenantID string)
+
+type cachedSetting struct {
+	value   any
+	expires time.Time
+}
+
+// settingsCache also counts the invalidations of every school, so a load
+// that was under way while the school's settings changed isn't kept
+var settingsCache = struct {
+	sync.Mutex
+	tenants     map[string]map[string]cachedSetting
+	generations map[string]uint64
+}{tenants: make(map[string]map[string]cachedSetting), generations: make(map[string]uint64)}
+
+// cachedSettingFor returns the cached value of key in the school db is scoped
+// to, calling load on a miss. A failed load is not cached.
+func cachedSettingFor(db *gorm.DB, key string, load func() (any, error)) (any, error) {
+	tenantID := TenantFrom(db.Statement.Context)
+	now := time.Now()
+
+	settingsCache.Lock()
+	entry, ok := settingsCache.tenants[tenantID][key]
+	generation := settingsCache.generations[tenantID]
+	settingsCache.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.value, nil
+	}
+
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+	settingsCache.Lock()
+	// Rows read before an invalidation may be the old ones
+	if settingsCache.generations[tenantID] == generation {
+		if settingsCache.tenants[tenantID] == nil {
+			settingsCache.tenants[tenantID] = make(map[string]cachedSetting)
+		}
+		settingsCache.tenants[tenantID][key] = cachedSetting{value: value, expires: now.Add(settingsCacheTTL)}
+	}
+	settingsCache.Unlock()
+	return value, nil
+}
+
+// ForgetCachedSettings drops what this instance cached for the school. The
+// unscoped entries go too, as they read every school's rows.
+func ForgetCachedSettings(tenantID string) {
+	settingsCache.Lock()
+	delete(settingsCache.tenants, tenantID)
+	delete(settingsCache.tenants, "")
+	settingsCache.generations[tenantID]++
+	if tenantID != "" {
+		settingsCache.generations[""]++
+	}
+	settingsCache.Unlock()
+}
+
+// InvalidateSettings drops the cached settings of the school here and tells
+// the other instances through SettingsChanged
+func InvalidateSettings(tenantID string) {
+	ForgetCachedSettings(tenantID)
+	if SettingsChanged != nil {
+		SettingsChanged(tenantID)
+	}
+}
+
+// CachedRolePermission returns what role may do with module in the school db
+// is scoped to. A role whose permissions can't be loaded gets no access.
+func CachedRolePermission(db *gorm.DB, role, module string) (RolePermission, bool) {
+	perms, err := cachedSettingFor(db, "permissions:"+role, func() (any, error) {
+		var rows []RolePermission
+		if err := db.Where("role = ?", role).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		byModule := make(map[string]RolePermission, len(rows))
+		for _, rp := range rows {
+			if _, ok := byModule[rp.ModuleID]; !ok {
+				byModule[rp.ModuleID] = rp
+			}
+		}
+		return byModule, nil
+	})
+	if err != nil {
+		return RolePermission{}, false
+	}
+	rp, ok := perms.(map[string]RolePermission)[module]
+	return rp, ok
+}
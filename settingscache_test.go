@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettingsCache(t *testing.T) {
+	DB.Exec("DELETE FROM system_configs")
+	ForgetCachedSettings("")
+	var published []string
+	SettingsChanged = func(tenantID string) { published = append(published, tenantID) }
+	t.Cleanup(func() {
+		SettingsChanged = nil
+		DB.Exec("DELETE FROM system_configs")
+		ForgetCachedSettings("")
+	})
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("userId", "u1")
+		c.Set("role", c.GetHeader("X-Role"))
+	}, PermissionMiddleware())
+	api := Guard(r.Group("/api"))
+	api.GET("/questions", Read("questions"), func(c *gin.Context) { c.Status(http.StatusOK) })
+	api.GET("/admin/permissions", Read("permissions"), GetRolePermissions)
+	api.POST("/admin/permissions", Update("permissions"), UpdateRolePermissions)
+	api.POST("/admin/config", Update("system_config"), UpdateSystemConfig)
+	api.POST("/admin/settings", Update("system_config"), UpdateSystemSettings)
+
+	call := func(role Role, method, url string, payload any) int {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		req.Header.Set("X-Role", string(role))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("Role permissions", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, call(RoleTeacher, "GET", "/api/questions", nil))
+
+		// Writes past the handlers only show once the entry expires
+		DB.Model(&RolePermission{}).Where("role = ? AND module_id = ?", RoleTeacher, "questions").Update("can_read", false)
+		assert.Equal(t, http.StatusOK, call(RoleTeacher, "GET", "/api/questions", nil), "served from the cache")
+
+		var perms []RolePermission
+		DB.Find(&perms)
+		for i := range perms {
+			if perms[i].Role == RoleTeacher && perms[i].ModuleID == "questions" {
+				perms[i].CanRead = true
+			}
+		}
+		assert.Equal(t, http.StatusOK, call(RoleAdmin, "POST", "/api/admin/permissions", perms))
+		assert.Equal(t, []string{""}, published)
+
+		for i := range perms {
+			if perms[i].Role == RoleTeacher && perms[i].ModuleID == "questions" {
+				perms[i].CanRead = false
+			}
+		}
+		call(RoleAdmin, "POST", "/api/admin/permissions", perms)
+		assert.Equal(t, http.StatusForbidden, call(RoleTeacher, "GET", "/api/questions", nil), "updating permissions invalidates")
+
+		for i := range perms {
+			perms[i].CanRead = perms[i].CanRead || perms[i].ModuleID == "questions"
+		}
+		call(RoleAdmin, "POST", "/api/admin/permissions", perms)
+		assert.Equal(t, http.StatusOK, call(RoleTeacher, "GET", "/api/questions", nil))
+	})
+
+	t.Run("Error logic config", func(t *testing.T) {
+		published = nil
+		assert.True(t, LoadErrorLogicConfig(DB).GlobalEnabled)
+
+		conf := defaultErrorLogicConfig()
+		conf.GlobalEnabled = false
+		assert.Equal(t, http.StatusOK, call(RoleAdmin, "POST", "/api/admin/config", conf))
+		assert.False(t, LoadErrorLogicConfig(DB).GlobalEnabled)
+		assert.Equal(t, []string{""}, published)
+
+		// Callers may change what they got without touching the cache
+		LoadErrorLogicConfig(DB).Stages[1] = StageConfig{Label: "Changed"}
+		assert.NotEqual(t, "Changed", LoadErrorLogicConfig(DB).Stages[1].Label)
+	})
+
+	t.Run("System settings", func(t *testing.T) {
+		published = nil
+		assert.False(t, loadSystemSettings(DB).RegistrationEnabled)
+		call(RoleAdmin, "POST", "/api/admin/settings", map[string]any{"registrationEnabled": true})
+		assert.True(t, loadSystemSettings(DB).RegistrationEnabled)
+		assert.Equal(t, []string{""}, published)
+	})
+
+	t.Run("Failed loads are not cached", func(t *testing.T) {
+		ForgetCachedSettings("")
+		loads := 0
+		load := func() (any, error) {
+			loads++
+			if loads == 1 {
+				return nil, errors.New("database is away")
+			}
+			return loads, nil
+		}
+		_, err := cachedSettingFor(DB, "test", load)
+		assert.Error(t, err)
+		value, err := cachedSettingFor(DB, "test", load)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, value)
+	})
+
+	t.Run("Loads racing an invalidation are not kept", func(t *testing.T) {
+		ForgetCachedSettings("")
+		loads := 0
+		load := func() (any, error) {
+			loads++
+			if loads == 1 {
+				// The school's permissions change while the old rows are being read
+				ForgetCachedSettings("north")
+			}
+			return loads, nil
+		}
+		value, _ := cachedSettingFor(DB, "test", load)
+		assert.Equal(t, 1, value)
+		value, _ = cachedSettingFor(DB, "test", load)
+		assert.Equal(t, 2, value, "the old rows were not cached")
+		value, _ = cachedSettingFor(DB, "test", load)
+		assert.Equal(t, 2, value)
+	})
+
+	t.Run("Entries expire", func(t *testing.T) {
+		ttl := settingsCacheTTL
+		settingsCacheTTL = 0
+		t.Cleanup(func() { settingsCacheTTL = ttl })
+		ForgetCachedSettings("")
+		assert.True(t, loadSystemSettings(DB).RegistrationEnabled)
+
+		DB.Exec("UPDATE system_configs SET value = ? WHERE `key` = ?", `{"registrationEnabled": false}`, "system_settings")
+		time.Sleep(time.Millisecond)
+		assert.False(t, loadSystemSettings(DB).RegistrationEnabled)
+	})
+}
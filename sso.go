@@ -0,0 +1,592 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// Teachers can sign in through their district's OpenID Connect provider
+// instead of a local password. The browser goes to /auth/sso/login, which
+// redirects to the provider (authorization code flow with PKCE); the provider
+// sends it back to /auth/sso/callback, where the ID token is verified, its
+// claims are mapped to a User and Role, and the account is created on first
+// sign-in. The web app gets a one-time code in the URL fragment and posts it
+// to /auth/sso/exchange for the session tokens, so no token ever sits in the
+// browser history.
+
+// AppSSO is the configured provider, nil while single sign-on is off
+var AppSSO *OIDCProvider
+
+var (
+	errSSODisabled    = errors.New("Single sign-on is not configured")
+	errSSORejected    = errors.New("Your account is not allowed to sign in here")
+	errInvalidSSOCode = errors.New("Sign-in expired, please try again")
+)
+
+// ssoCodeTTL is how long the web app has to redeem a sign-in code
+const ssoCodeTTL = time.Minute
+
+// ssoStateCookie carries state, nonce and PKCE verifier from login to callback
+const (
+	ssoStateCookie = "sso_state"
+	ssoStateTTL    = 10 * time.Minute
+)
+
+// ssoRoles are the roles a provider may grant
+var ssoRoles = map[Role]bool{RoleTeacher: true, RoleAdmin: true}
+
+// OIDCProvider is one OpenID Connect identity provider
+type OIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string // our /api/auth/sso/callback as registered at the provider
+	FrontendURL  string // where the web app is served
+	Scopes       []string
+
+	UsernameClaim string
+	NameClaim     string
+	// RoleClaim names a string or list claim; RoleMap maps its values to roles.
+	// Without a RoleClaim everyone signing in gets DefaultRole.
+	RoleClaim   string
+	RoleMap     map[string]Role
+	DefaultRole Role
+
+	Tenant       string // school code new accounts are created in
+	LinkExisting bool   // sign in to a local account with the same username
+
+	Client *http.Client
+
+	mu          sync.Mutex
+	meta        *oidcMetadata
+	keys        map[string]any
+	keysFetched time.Time
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// ssoIdentity is what the ID token says about the user
+type ssoIdentity struct {
+	Subject  string
+	Username string
+	Name     string
+	Role     Role
+}
+
+// NewOIDCProviderFromEnv reads the OIDC_* settings. It returns nil when
+// OIDC_ISSUER is unset, which leaves single sign-on off.
+func NewOIDCProviderFromEnv() (*OIDCProvider, error) {
+	issuer := strings.TrimSuffix(getEnv("OIDC_ISSUER", ""), "/")
+	if issuer == "" {
+		return nil, nil
+	}
+	p := &OIDCProvider{
+		Issuer:        issuer,
+		ClientID:      getEnv("OIDC_CLIENT_ID", ""),
+		ClientSecret:  getEnv("OIDC_CLIENT_SECRET", ""),
+		RedirectURL:   getEnv("OIDC_REDIRECT_URL", ""),
+		FrontendURL:   getEnv("OIDC_FRONTEND_URL", "/"),
+		Scopes:        strings.Fields(getEnv("OIDC_SCOPES", "openid profile email")),
+		UsernameClaim: getEnv("OIDC_USERNAME_CLAIM", "preferred_username"),
+		NameClaim:     getEnv("OIDC_NAME_CLAIM", "name"),
+		RoleClaim:     getEnv("OIDC_ROLE_CLAIM", ""),
+		RoleMap:       map[string]Role{},
+		DefaultRole:   Role(getEnv("OIDC_DEFAULT_ROLE", string(RoleTeacher))),
+		Tenant:        getEnv("OIDC_TENANT", ""),
+		LinkExisting:  getEnv("OIDC_LINK_EXISTING", "false") == "true",
+		Client:        &http.Client{Timeout: 10 * time.Second},
+	}
+	if p.ClientID == "" || p.RedirectURL == "" {
+		return nil, errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required with OIDC_ISSUER")
+	}
+	// OIDC_ROLE_MAP looks like "teachers=TEACHER,it-staff=ADMIN"
+	for _, pair := range strings.Split(getEnv("OIDC_ROLE_MAP", ""), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		value, role, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid OIDC_ROLE_MAP entry %q", pair)
+		}
+		p.RoleMap[strings.TrimSpace(value)] = Role(strings.TrimSpace(role))
+	}
+	for _, role := range p.RoleMap {
+		if !ssoRoles[role] {
+			return nil, fmt.Errorf("OIDC_ROLE_MAP: single sign-on can't grant role %q", role)
+		}
+	}
+	if p.RoleClaim == "" && !ssoRoles[p.DefaultRole] {
+		return nil, fmt.Errorf("OIDC_DEFAULT_ROLE: single sign-on can't grant role %q", p.DefaultRole)
+	}
+	return p, nil
+}
+
+// metadata loads the provider's discovery document once
+func (p *OIDCProvider) metadata() (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+	var meta oidcMetadata
+	if err := p.getJSON(p.Issuer+"/.well-known/openid-configuration", &meta); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if strings.TrimSuffix(meta.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("discovery: issuer %q does not match %q", meta.Issuer, p.Issuer)
+	}
+	p.meta = &meta
+	return p.meta, nil
+}
+
+func (p *OIDCProvider) getJSON(u string, v any) error {
+	res, err := p.Client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// AuthURL is where the browser is sent to sign in
+func (p *OIDCProvider) AuthURL(state, nonce, verifier string) (string, error) {
+	meta, err := p.metadata()
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified identity
+func (p *OIDCProvider) Exchange(code, verifier, nonce string) (ssoIdentity, error) {
+	meta, err := p.metadata()
+	if err != nil {
+		return ssoIdentity{}, err
+	}
+	res, err := p.Client.PostForm(meta.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		return ssoIdentity{}, fmt.Errorf("token request: %w", err)
+	}
+	defer res.Body.Close()
+	var tokens struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tokens); err != nil || res.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return ssoIdentity{}, fmt.Errorf("token request: %s %s", res.Status, tokens.Error)
+	}
+	return p.verifyIDToken(tokens.IDToken, nonce)
+}
+
+// verifyIDToken checks signature, issuer, audience, expiry and nonce, then maps the claims
+func (p *OIDCProvider) verifyIDToken(raw, nonce string) (ssoIdentity, error) {
+	token, err := jwt.Parse(raw, p.keyFor,
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return ssoIdentity{}, fmt.Errorf("id token: %w", err)
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return ssoIdentity{}, errors.New("id token: nonce mismatch")
+	}
+
+	id := ssoIdentity{}
+	id.Subject, _ = claims["sub"].(string)
+	id.Username, _ = claims[p.UsernameClaim].(string)
+	id.Name, _ = claims[p.NameClaim].(string)
+	if id.Subject == "" || id.Username == "" {
+		return ssoIdentity{}, fmt.Errorf("id token: missing sub or %s claim", p.UsernameClaim)
+	}
+	if id.Name == "" {
+		id.Name = id.Username
+	}
+	id.Role = p.mapRole(claims[p.RoleClaim])
+	if id.Role == "" {
+		return ssoIdentity{}, errSSORejected
+	}
+	return id, nil
+}
+
+// mapRole picks the role for a role claim value, the highest one when the
+// claim is a list such as groups. Empty means the user may not sign in.
+func (p *OIDCProvider) mapRole(claim any) Role {
+	if p.RoleClaim == "" {
+		return p.DefaultRole
+	}
+	var values []string
+	switch v := claim.(type) {
+	case string:
+		values = []string{v}
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	var role Role
+	for _, v := range values {
+		switch p.RoleMap[v] {
+		case RoleAdmin:
+			return RoleAdmin
+		case RoleTeacher:
+			role = RoleTeacher
+		}
+	}
+	return role
+}
+
+// keyFor finds the provider key a token was signed with, fetching the JWKS
+// again (at most every minKeyReload) when the kid is unknown after a rotation
+func (p *OIDCProvider) keyFor(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	stale := time.Since(p.keysFetched) > minKeyReload
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	meta, err := p.metadata()
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := p.getJSON(meta.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	keys := make(map[string]any)
+	for _, jwk := range set.Keys {
+		if k, err := parseJWK(jwk); err == nil {
+			keys[jwk["kid"]] = k
+		}
+	}
+	p.mu.Lock()
+	p.keys, p.keysFetched = keys, time.Now()
+	p.mu.Unlock()
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// parseJWK reads an RSA or P-256 public key in JSON Web Key format
+func parseJWK(jwk map[string]string) (any, error) {
+	num := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, errors.New("invalid key parameter")
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch jwk["kty"] {
+	case "RSA":
+		n, err := num(jwk["n"])
+		if err != nil {
+			return nil, err
+		}
+		e, err := num(jwk["e"])
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if jwk["crv"] != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk["crv"])
+		}
+		x, err := num(jwk["x"])
+		if err != nil {
+			return nil, err
+		}
+		y, err := num(jwk["y"])
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk["kty"])
+	}
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// SSOLoginHandler starts a sign-in at the provider
+func SSOLoginHandler(c *gin.Context) {
+	if AppSSO == nil {
+		SendJSON(c, 1, errSSODisabled.Error(), nil)
+		return
+	}
+	var values [3]string // state, nonce, PKCE verifier
+	for i := range values {
+		v, err := randomToken()
+		if err != nil {
+			SendJSON(c, 1, "Failed to start sign-in", nil)
+			return
+		}
+		values[i] = v
+	}
+	authURL, err := AppSSO.AuthURL(values[0], values[1], values[2])
+	if err != nil {
+		SendJSON(c, 1, "Identity provider is unavailable", nil)
+		return
+	}
+	cookie, err := AppKeys.Sign(jwt.MapClaims{
+		"typ":      "sso_state",
+		"state":    values[0],
+		"nonce":    values[1],
+		"verifier": values[2],
+		"exp":      time.Now().Add(ssoStateTTL).Unix(),
+	})
+	if err != nil {
+		SendJSON(c, 1, "Failed to start sign-in", nil)
+		return
+	}
+	setSSOCookie(c, cookie, int(ssoStateTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// setSSOCookie sets the state cookie; Lax so it comes back with the provider's redirect
+func setSSOCookie(c *gin.Context, value string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoStateCookie, value, maxAge, "/", "", secure, true)
+}
+
+// SSOCallbackHandler finishes a sign-in and sends the browser back to the web app
+func SSOCallbackHandler(c *gin.Context) {
+	if AppSSO == nil {
+		SendJSON(c, 1, errSSODisabled.Error(), nil)
+		return
+	}
+	fail := func(msg string) {
+		c.Redirect(http.StatusFound, AppSSO.FrontendURL+"#/login?ssoError="+url.QueryEscape(msg))
+	}
+
+	raw, _ := c.Cookie(ssoStateCookie)
+	setSSOCookie(c, "", -1)
+	token, err := AppKeys.Parse(raw)
+	if err != nil || !token.Valid {
+		fail("Sign-in expired, please try again")
+		return
+	}
+	state, _ := token.Claims.(jwt.MapClaims)
+	if typ, _ := state["typ"].(string); typ != "sso_state" || state["state"] != c.Query("state") {
+		fail("Sign-in expired, please try again")
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		fail("Sign-in was cancelled")
+		return
+	}
+
+	nonce, _ := state["nonce"].(string)
+	verifier, _ := state["verifier"].(string)
+	identity, err := AppSSO.Exchange(c.Query("code"), verifier, nonce)
+	if errors.Is(err, errSSORejected) {
+		fail(err.Error())
+		return
+	}
+	if err != nil {
+		fail("Sign-in failed")
+		return
+	}
+
+	user, created, err := provisionSSOUser(AppSSO, identity)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	if userDisabled(user) {
+		fail("Account is disabled")
+		return
+	}
+	if _, err := LoadTenant(user.TenantID); err != nil {
+		fail(err.Error())
+		return
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		fail("Failed to create session")
+		return
+	}
+	now := time.Now()
+	DB.Where("expires_at < ?", now.Format(timeLayout)).Delete(&SSOLoginCode{})
+	err = DB.Create(&SSOLoginCode{
+		Hash:      hashToken(code),
+		UserID:    user.ID,
+		ExpiresAt: now.Add(ssoCodeTTL).Format(timeLayout),
+	}).Error
+	if err != nil {
+		fail("Failed to create session")
+		return
+	}
+
+	if created {
+		c.Set("userId", user.ID)
+		c.Set("role", string(user.Role))
+		c.Set("tenantId", user.TenantID)
+		AddAuditLog(c, "REGISTER", fmt.Sprintf("New user from single sign-on: %s", user.Username))
+	}
+	c.Redirect(http.StatusFound, AppSSO.FrontendURL+"#/login?sso="+url.QueryEscape(code))
+}
+
+// SSOExchangeHandler redeems the code of a finished single sign-on for the
+// session tokens. Deleting the code before use makes it single-use.
+func SSOExchangeHandler(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendJSON(c, 1, "Invalid request", nil)
+		return
+	}
+
+	var login SSOLoginCode
+	var user User
+	if DB.First(&login, "hash = ?", hashToken(req.Code)).Error != nil {
+		SendJSON(c, 1, errInvalidSSOCode.Error(), nil)
+		return
+	}
+	res := DB.Where("hash = ?", login.Hash).Delete(&SSOLoginCode{})
+	if res.Error != nil || res.RowsAffected == 0 || login.ExpiresAt < time.Now().Format(timeLayout) ||
+		DB.First(&user, "id = ?", login.UserID).Error != nil {
+		SendJSON(c, 1, errInvalidSSOCode.Error(), nil)
+		return
+	}
+	completeLogin(c, user, " through single sign-on", false)
+}
+
+// provisionSSOUser finds the account linked to identity, links or creates one
+// on first sign-in, and keeps its name and role in step with the provider
+func provisionSSOUser(p *OIDCProvider, identity ssoIdentity) (User, bool, error) {
+	var user User
+	created := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now().Format(timeLayout)
+		var link UserIdentity
+		err := tx.First(&link, "issuer = ? AND subject = ?", p.Issuer, identity.Subject).Error
+		if err == nil {
+			err = tx.First(&user, "id = ?", link.UserID).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// The account was deleted, the next sign-in starts over
+				if err := tx.Delete(&link).Error; err != nil {
+					return err
+				}
+			} else if err != nil {
+				return err
+			}
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if user.ID == "" {
+			tenant, err := TenantByCode(p.Tenant)
+			if err != nil {
+				return err
+			}
+			err = tx.First(&user, "username = ?", identity.Username).Error
+			switch {
+			case err == nil:
+				if !p.LinkExisting || user.TenantID != tenant.ID || !ssoRoles[user.Role] {
+					return errors.New("Username is already taken by another account")
+				}
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				// No password: the account signs in through the provider only
+				user = User{
+					ID:       strconv.FormatInt(time.Now().UnixNano(), 36),
+					TenantID: tenant.ID,
+					Username: identity.Username,
+					Name:     identity.Name,
+					Role:     identity.Role,
+					Status:   "active",
+				}
+				if err := tx.Create(&user).Error; err != nil {
+					return err
+				}
+				created = true
+			default:
+				return err
+			}
+			link = UserIdentity{Issuer: p.Issuer, Subject: identity.Subject, UserID: user.ID, CreatedAt: now}
+		}
+
+		if user.Name != identity.Name || user.Role != identity.Role {
+			user.Name, user.Role = identity.Name, identity.Role
+			if err := tx.Model(&User{}).Where("id = ?", user.ID).
+				Updates(map[string]any{"name": user.Name, "role": user.Role}).Error; err != nil {
+				return err
+			}
+		}
+		link.LastLoginAt = now
+		return tx.Save(&link).Error
+	})
+	return user, created, err
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockIdP is a minimal OpenID Connect provider: it signs in whoever Claims
+// describes without asking, and issues RS256 ID tokens
+type mockIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	Claims jwt.MapClaims // claims of the next sign-in
+	Nonce  string        // overrides the nonce echoed back, to test rejection
+	grants map[string]url.Values
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{key: key, grants: map[string]url.Values{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "idp-1", "alg": "RS256",
+			"n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		code := "code-" + q.Get("state")[:8]
+		idp.mu.Lock()
+		idp.grants[code] = q
+		idp.mu.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		idp.mu.Lock()
+		grant, ok := idp.grants[r.PostForm.Get("code")]
+		delete(idp.grants, r.PostForm.Get("code"))
+		claims, nonce := jwt.MapClaims{}, idp.Nonce
+		for k, v := range idp.Claims {
+			claims[k] = v
+		}
+		idp.mu.Unlock()
+
+		challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || r.PostForm.Get("client_secret") != "secret" ||
+			base64.RawURLEncoding.EncodeToString(challenge[:]) != grant.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		if nonce == "" {
+			nonce = grant.Get("nonce")
+		}
+		claims["iss"], claims["aud"], claims["nonce"] = idp.URL, grant.Get("client_id"), nonce
+		claims["exp"] = time.Now().Add(time.Minute).Unix()
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "idp-1"
+		signed, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func TestSSOLogin(t *testing.T) {
+	DB.Exec("DELETE FROM users")
+	DB.Exec("DELETE FROM user_identities")
+	DB.Exec("DELETE FROM tenants WHERE id <> ?", defaultTenantID)
+	DB.Create(&Tenant{ID: "t2", Code: "district", Name: "District School", Status: "active"})
+	DB.Create(&User{ID: "local", Username: "carol", Role: RoleTeacher, Status: "active"})
+
+	idp := newMockIdP(t)
+	AppSSO = &OIDCProvider{
+		Issuer: idp.URL, ClientID: "smartedu", ClientSecret: "secret",
+		RedirectURL: "http://app.test/api/auth/sso/callback", FrontendURL: "http://app.test/",
+		Scopes: []string{"openid"}, UsernameClaim: "preferred_username", NameClaim: "name",
+		RoleClaim: "groups", RoleMap: map[string]Role{"teachers": RoleTeacher, "it": RoleAdmin},
+		Tenant: "district", Client: idp.Client(),
+	}
+	t.Cleanup(func() { AppSSO = nil })
+
+	r := gin.Default()
+	r.GET("/auth/sso/login", SSOLoginHandler)
+	r.GET("/auth/sso/callback", SSOCallbackHandler)
+	r.POST("/auth/sso/exchange", SSOExchangeHandler)
+	r.GET("/me", AuthMiddleware(), GetMe)
+
+	// signIn runs the browser's part: our login, the provider, our callback.
+	// It returns the fragment query the web app receives.
+	signIn := func(t *testing.T, tamper func(cookie *http.Cookie, callback url.Values)) url.Values {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/auth/sso/login", nil))
+		if w.Code != http.StatusFound {
+			t.Fatalf("login: %d %s", w.Code, w.Body.String())
+		}
+		cookie := w.Result().Cookies()[0]
+
+		client := *idp.Client()
+		client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+		res, err := client.Get(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		callback, _ := url.Parse(res.Header.Get("Location"))
+		q := callback.Query()
+		if tamper != nil {
+			tamper(cookie, q)
+		}
+
+		req := httptest.NewRequest("GET", "/auth/sso/callback?"+q.Encode(), nil)
+		req.AddCookie(cookie)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		location := w.Header().Get("Location")
+		if w.Code != http.StatusFound || !strings.HasPrefix(location, "http://app.test/#/login?") {
+			t.Fatalf("callback: %d %s", w.Code, location)
+		}
+		result, _ := url.ParseQuery(strings.TrimPrefix(location, "http://app.test/#/login?"))
+		return result
+	}
+	exchange := func(code string) Response {
+		body, _ := json.Marshal(map[string]string{"code": code})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/auth/sso/exchange", bytes.NewBuffer(body)))
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+	// me redeems the handed-over code like the web app does and loads the account
+	me := func(t *testing.T, code string) map[string]any {
+		resp := exchange(code)
+		if resp.Code != 0 {
+			t.Fatalf("exchange: %s", resp.Err)
+		}
+
+		req := httptest.NewRequest("GET", "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+resp.Data.(map[string]any)["token"].(string))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("me: %s", w.Body.String())
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Data.(map[string]any)
+	}
+
+	t.Run("First sign-in creates the account", func(t *testing.T) {
+		idp.Claims = jwt.MapClaims{"sub": "s-1", "preferred_username": "dave", "name": "Dave", "groups": []string{"staff", "teachers"}}
+		result := signIn(t, nil)
+		assert.Empty(t, result.Get("ssoError"))
+		user := me(t, result.Get("sso"))
+		assert.Equal(t, "dave", user["username"])
+		assert.Equal(t, string(RoleTeacher), user["role"])
+
+		var created User
+		DB.First(&created, "username = ?", "dave")
+		assert.Equal(t, "t2", created.TenantID)
+		assert.Empty(t, created.Password)
+	})
+
+	t.Run("Codes are single-use and short-lived", func(t *testing.T) {
+		idp.Claims = jwt.MapClaims{"sub": "s-1", "preferred_username": "dave", "name": "Dave", "groups": "teachers"}
+		code := signIn(t, nil).Get("sso")
+		me(t, code)
+		assert.Equal(t, 1, exchange(code).Code, "a replayed code")
+
+		code = signIn(t, nil).Get("sso")
+		DB.Model(&SSOLoginCode{}).Where("hash = ?", hashToken(code)).Update("expires_at", time.Now().Add(-time.Second).Format(timeLayout))
+		assert.Equal(t, 1, exchange(code).Code, "an expired code")
+		assert.Equal(t, 1, exchange("made-up").Code)
+	})
+
+	t.Run("Later sign-ins follow the provider", func(t *testing.T) {
+		idp.Claims = jwt.MapClaims{"sub": "s-1", "preferred_username": "dave", "name": "Dave Smith", "groups": []string{"teachers", "it"}}
+		user := me(t, signIn(t, nil).Get("sso"))
+		assert.Equal(t, string(RoleAdmin), user["role"])
+		assert.Equal(t, "Dave Smith", user["name"])
+
+		var count int64
+		DB.Model(&User{}).Where("username = ?", "dave").Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		nonce  string
+		tamper func(cookie *http.Cookie, callback url.Values)
+	}{
+		{"No mapped group", jwt.MapClaims{"sub": "s-2", "preferred_username": "erin", "groups": []string{"students"}}, "", nil},
+		{"Missing username", jwt.MapClaims{"sub": "s-2", "groups": "teachers"}, "", nil},
+		{"Replayed nonce", jwt.MapClaims{"sub": "s-2", "preferred_username": "erin", "groups": "teachers"}, "stale", nil},
+		{"Forged state", jwt.MapClaims{"sub": "s-2", "preferred_username": "erin", "groups": "teachers"}, "",
+			func(_ *http.Cookie, q url.Values) { q.Set("state", "forged") }},
+		{"No state cookie", jwt.MapClaims{"sub": "s-2", "preferred_username": "erin", "groups": "teachers"}, "",
+			func(c *http.Cookie, _ url.Values) { c.Value = "" }},
+		{"Local account with the same username", jwt.MapClaims{"sub": "s-3", "preferred_username": "carol", "groups": "teachers"}, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp.Claims, idp.Nonce = tt.claims, tt.nonce
+			defer func() { idp.Nonce = "" }()
+			result := signIn(t, tt.tamper)
+			assert.NotEmpty(t, result.Get("ssoError"))
+			assert.Empty(t, result.Get("sso"))
+		})
+	}
+	var identities int64
+	DB.Model(&UserIdentity{}).Count(&identities)
+	assert.Equal(t, int64(1), identities)
+
+	t.Run("Linking local accounts is opt-in", func(t *testing.T) {
+		AppSSO.LinkExisting = true
+		AppSSO.Tenant = ""
+		idp.Claims = jwt.MapClaims{"sub": "s-3", "preferred_username": "carol", "name": "Carol", "groups": "teachers"}
+		user := me(t, signIn(t, nil).Get("sso"))
+		assert.Equal(t, "local", user["id"])
+	})
+}
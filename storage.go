@@ -1,9 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -17,62 +25,343 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
-func UploadBase64ToOSS(base64Str string) (string, error) {
-	if !strings.HasPrefix(base64Str, "data:image") {
-		return base64Str, nil
+// Storage keeps uploaded files and hands back the URL they are served from.
+// Put streams the body, which large uploads read from their temp file.
+type Storage interface {
+	Put(key string, body io.ReadSeeker, contentType string) (string, error)
+	Delete(key string) error
+}
+
+// AppStorage is the backend chosen by NewStorageFromEnv at startup
+var AppStorage Storage
+
+// NewStorageFromEnv builds the storage backend selected by STORAGE_DRIVER
+// ("local", "s3" or "oss"). Without STORAGE_DRIVER, OSS is used when OSS keys
+// are configured and local disk otherwise.
+func NewStorageFromEnv() (Storage, error) {
+	driver := os.Getenv("STORAGE_DRIVER")
+	if driver == "" {
+		driver = "local"
+		if os.Getenv("OSS_ACCESS_KEY") != "" {
+			driver = "oss"
+		}
 	}
 
-	// Fetch keys inside function to ensure we catch changes
-	accessKey := os.Getenv("OSS_ACCESS_KEY")
-	secretKey := os.Getenv("OSS_SECRET_KEY")
-	endpoint := getEnv("OSS_ENDPOINT", "oss-cn-chengdu.aliyuncs.com")
-	bucketName := getEnv("OSS_BUCKET_NAME", "yilmz-assets")
-	urlPrefix := getEnv("OSS_URL_PREFIX", "https://yilmz-assets.oss-cn-beijing.aliyuncs.com/")
+	switch driver {
+	case "local":
+		return NewLocalStorage(
+			getEnv("STORAGE_LOCAL_DIR", "uploads"),
+			getEnv("STORAGE_PUBLIC_URL", "http://localhost:8080/uploads/"),
+		)
+	case "s3":
+		return NewS3Storage(
+			os.Getenv("S3_ENDPOINT"),
+			getEnv("S3_REGION", "us-east-1"),
+			os.Getenv("S3_BUCKET"),
+			os.Getenv("S3_ACCESS_KEY"),
+			os.Getenv("S3_SECRET_KEY"),
+			os.Getenv("S3_URL_PREFIX"),
+		)
+	case "oss":
+		return NewOSSStorage(
+			os.Getenv("OSS_ENDPOINT"),
+			os.Getenv("OSS_BUCKET_NAME"),
+			os.Getenv("OSS_ACCESS_KEY"),
+			os.Getenv("OSS_SECRET_KEY"),
+			os.Getenv("OSS_URL_PREFIX"),
+		)
+	}
+	return nil, fmt.Errorf("unknown STORAGE_DRIVER %q", driver)
+}
+
+// LocalStorage writes files below Dir, which main serves under /uploads
+type LocalStorage struct {
+	Dir       string
+	PublicURL string
+}
 
-	if accessKey == "" || secretKey == "" {
-		fmt.Printf("[OSS] Warning: Missing OSS_ACCESS_KEY or OSS_SECRET_KEY. Falling back to base64.\n")
-		return base64Str, nil
+func NewLocalStorage(dir, publicURL string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create storage dir: %w", err)
 	}
+	return &LocalStorage{Dir: dir, PublicURL: ensureTrailingSlash(publicURL)}, nil
+}
 
-	// 1. Parse Base64
-	parts := strings.Split(base64Str, ";base64,")
-	if len(parts) != 2 {
-		return base64Str, nil
+func (s *LocalStorage) path(key string) (string, error) {
+	p := filepath.Join(s.Dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, filepath.Clean(s.Dir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return p, nil
+}
+
+func (s *LocalStorage) Put(key string, body io.ReadSeeker, contentType string) (string, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
 	}
-	
-	data, err := base64.StdEncoding.DecodeString(parts[1])
 	if err != nil {
-		fmt.Printf("[OSS] Error decoding base64: %v\n", err)
-		return base64Str, nil
+		os.Remove(p)
+		return "", err
 	}
+	return s.PublicURL + key, nil
+}
 
-	// 2. Initialize OSS
-	client, err := oss.New(endpoint, accessKey, secretKey)
+func (s *LocalStorage) Delete(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// S3Storage talks to any S3-compatible endpoint (AWS, MinIO) with path-style
+// requests signed with AWS Signature V4
+type S3Storage struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	URLPrefix string
+	client    *http.Client
+}
+
+func NewS3Storage(endpoint, region, bucket, accessKey, secretKey, urlPrefix string) (*S3Storage, error) {
+	if endpoint == "" || bucket == "" || accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("s3 storage requires S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY and S3_SECRET_KEY")
+	}
+	endpoint = strings.TrimRight(endpoint, "/")
+	if urlPrefix == "" {
+		urlPrefix = endpoint + "/" + bucket + "/"
+	}
+	return &S3Storage{
+		Endpoint:  endpoint,
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		URLPrefix: ensureTrailingSlash(urlPrefix),
+		client:    &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+func (s *S3Storage) objectURL(key string) string {
+	segments := strings.Split(key, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	return s.Endpoint + "/" + url.PathEscape(s.Bucket) + "/" + strings.Join(segments, "/")
+}
+
+// Put reads the body twice: once for the payload hash the signature covers,
+// then to send it
+func (s *S3Storage) Put(key string, body io.ReadSeeker, contentType string) (string, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, body)
+	if err != nil {
+		return "", err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(key), io.NopCloser(body))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	if err := s.do(req, hex.EncodeToString(hash.Sum(nil))); err != nil {
+		return "", fmt.Errorf("s3 put %s: %w", key, err)
+	}
+	return s.URLPrefix + key, nil
+}
+
+func (s *S3Storage) Delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, s.objectURL(key), nil)
 	if err != nil {
-		fmt.Printf("[OSS] Error creating client: %v\n", err)
-		return base64Str, nil
+		return err
+	}
+	if err := s.do(req, sha256Hex(nil)); err != nil {
+		return fmt.Errorf("s3 delete %s: %w", key, err)
 	}
+	return nil
+}
 
+func (s *S3Storage) do(req *http.Request, payloadHash string) error {
+	s.sign(req, payloadHash, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// sign adds AWS Signature V4 headers to req, whose body hashes to payloadHash
+func (s *S3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	names := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		headers["content-type"] = ct
+		names = append([]string{"content-type"}, names...)
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// OSSStorage uploads to an Aliyun OSS bucket. The client is created once.
+type OSSStorage struct {
+	bucket    *oss.Bucket
+	URLPrefix string
+}
+
+func NewOSSStorage(endpoint, bucketName, accessKey, secretKey, urlPrefix string) (*OSSStorage, error) {
+	if endpoint == "" || bucketName == "" || accessKey == "" || secretKey == "" || urlPrefix == "" {
+		return nil, fmt.Errorf("oss storage requires OSS_ENDPOINT, OSS_BUCKET_NAME, OSS_ACCESS_KEY, OSS_SECRET_KEY and OSS_URL_PREFIX")
+	}
+	client, err := oss.New(endpoint, accessKey, secretKey)
+	if err != nil {
+		return nil, fmt.Errorf("create oss client: %w", err)
+	}
 	bucket, err := client.Bucket(bucketName)
 	if err != nil {
-		fmt.Printf("[OSS] Error getting bucket: %v\n", err)
-		return base64Str, nil
+		return nil, fmt.Errorf("open oss bucket: %w", err)
 	}
+	return &OSSStorage{bucket: bucket, URLPrefix: ensureTrailingSlash(urlPrefix)}, nil
+}
+
+func (s *OSSStorage) Put(key string, body io.ReadSeeker, contentType string) (string, error) {
+	if err := s.bucket.PutObject(key, body, oss.ContentType(contentType)); err != nil {
+		return "", fmt.Errorf("oss put %s: %w", key, err)
+	}
+	return s.URLPrefix + key, nil
+}
+
+func (s *OSSStorage) Delete(key string) error {
+	if err := s.bucket.DeleteObject(key); err != nil {
+		return fmt.Errorf("oss delete %s: %w", key, err)
+	}
+	return nil
+}
+
+func ensureTrailingSlash(s string) string {
+	if s != "" && !strings.HasSuffix(s, "/") {
+		return s + "/"
+	}
+	return s
+}
 
-	// 3. Generate Filename
-	ext := "png"
-	if strings.Contains(parts[0], "jpeg") { ext = "jpg" }
-	
-	filename := fmt.Sprintf("questions/%d.%s", time.Now().UnixNano(), ext)
+// UploadDataURL stores a "data:image/...;base64," string under prefix and
+// returns its URL. Anything that is not a data URL (an existing link) is
+// returned unchanged. Upload failures are returned, never swallowed.
+func UploadDataURL(dataURL, prefix string) (string, error) {
+	if !strings.HasPrefix(dataURL, "data:") {
+		return dataURL, nil
+	}
+	if AppStorage == nil {
+		return "", fmt.Errorf("storage is not configured")
+	}
 
-	// 4. Upload
-	err = bucket.PutObject(filename, strings.NewReader(string(data)))
+	// 1. Parse Base64
+	parts := strings.SplitN(dataURL, ";base64,", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid data URL")
+	}
+	data, err := base64.StdEncoding.DecodeString(parts[1])
 	if err != nil {
-		fmt.Printf("[OSS] Upload failed: %v\n", err)
-		return base64Str, nil
+		return "", fmt.Errorf("invalid base64 data: %w", err)
+	}
+
+	// 2. Generate Filename
+	contentType := strings.TrimPrefix(parts[0], "data:")
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		return "", fmt.Errorf("unsupported image type %q", contentType)
 	}
 
-	finalURL := urlPrefix + filename
-	fmt.Printf("[OSS] Success: Uploaded to %s\n", finalURL)
-	return finalURL, nil
+	// Images are re-encoded upright, EXIF-free and capped in width
+	outputs, err := ProcessImage(data)
+	if err != nil {
+		return "", err
+	}
+	data, contentType, ext = outputs[0].Data, outputs[0].MimeType, outputs[0].Extension
+	key := fmt.Sprintf("%s/%d.%s", prefix, time.Now().UnixNano(), ext)
+
+	// 3. Upload
+	return AppStorage.Put(key, bytes.NewReader(data), contentType)
+}
+
+// imageExtensions are the image types accepted as data URLs. SVG is not one
+// of them: it can carry script, and uploads are served from our own origin.
+var imageExtensions = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/jpg":  "jpg",
+	"image/gif":  "gif",
+	"image/webp": "webp",
 }
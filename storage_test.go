@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewLocalStorage(dir, "http://localhost:8080/uploads")
+	assert.NoError(t, err)
+
+	url, err := s.Put("questions/a.png", strings.NewReader("png"), "image/png")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/uploads/questions/a.png", url)
+
+	data, err := os.ReadFile(filepath.Join(dir, "questions", "a.png"))
+	assert.NoError(t, err)
+	assert.Equal(t, "png", string(data))
+
+	_, err = s.Put("../escape.png", strings.NewReader("x"), "image/png")
+	assert.Error(t, err)
+
+	assert.NoError(t, s.Delete("questions/a.png"))
+	_, err = os.Stat(filepath.Join(dir, "questions", "a.png"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestUploadDataURL(t *testing.T) {
+	prev := AppStorage
+	defer func() { AppStorage = prev }()
+	s, _ := NewLocalStorage(t.TempDir(), "/uploads/")
+	AppStorage = s
+	pngData := base64.StdEncoding.EncodeToString(testPNG(4, 4))
+
+	tests := []struct {
+		name    string
+		input   string
+		prefix  string
+		wantErr bool
+	}{
+		{"Plain URL passes through", "https://cdn.example.com/a.png", "https://cdn.example.com/a.png", false},
+		{"PNG is stored", "data:image/png;base64," + pngData, "/uploads/questions/", false},
+		{"Undecodable image", "data:image/jpeg;base64,aGVsbG8=", "", true},
+		{"Broken base64", "data:image/png;base64,***", "", true},
+		{"Missing base64 marker", "data:image/png,hello", "", true},
+		{"Unsupported type", "data:text/html;base64,aGVsbG8=", "", true},
+		{"SVG can carry script", "data:image/svg+xml;base64,PHN2Zy8+", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, err := UploadDataURL(tt.input, "questions")
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, strings.HasPrefix(url, tt.prefix), url)
+		})
+	}
+}
+
+func TestS3StorageSignsRequests(t *testing.T) {
+	var gotPath, gotAuth, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		if r.URL.Path == "/assets/questions/denied.png" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("AccessDenied"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	s, err := NewS3Storage(server.URL, "us-east-1", "assets", "minio", "minio123", "")
+	assert.NoError(t, err)
+
+	url, err := s.Put("questions/a.png", strings.NewReader("png"), "image/png")
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/assets/questions/a.png", url)
+	assert.Equal(t, "/assets/questions/a.png", gotPath)
+	assert.Equal(t, "png", gotBody)
+	assert.True(t, strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=minio/"))
+	assert.Contains(t, gotAuth, "SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date")
+
+	// Errors from the endpoint are surfaced
+	_, err = s.Put("questions/denied.png", strings.NewReader("png"), "image/png")
+	assert.ErrorContains(t, err, "AccessDenied")
+
+	_, err = NewS3Storage("", "us-east-1", "assets", "k", "s", "")
+	assert.Error(t, err)
+}
+
+func TestLocalUploadsAreSandboxed(t *testing.T) {
+	prev := AppStorage
+	defer func() { AppStorage = prev }()
+	dir := t.TempDir()
+	s, _ := NewLocalStorage(dir, "/uploads/")
+	AppStorage = s
+	s.Put("questions/old.svg", strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`), "image/svg+xml")
+
+	r := gin.New()
+	registerRoutes(r)
+	req, _ := http.NewRequest("GET", "/uploads/questions/old.svg", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Security-Policy"), "sandbox")
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Young students who can't type a password yet log in one of two ways: by
+// scanning a QR card their teacher printed, or on a classroom tablet, where
+// the teacher opened the class roster with its roster code, by tapping their
+// name and then a short sequence of pictures. Both count failed attempts like
+// password logins and end in the same session and JWT.
+
+const (
+	pictureCount          = 12 // pictures to choose from, numbered 0..11 in the web app
+	pictureSequenceLength = 4
+)
+
+var (
+	errInvalidLoginCard  = errors.New("Invalid or revoked login card")
+	errInvalidRosterCode = errors.New("Invalid class code")
+)
+
+// loginCardURL is what a card's QR code opens; APP_URL is where the web app is served
+func loginCardURL(token string) string {
+	return strings.TrimSuffix(getEnv("APP_URL", "http://localhost:3000"), "/") + "/#/card/" + token
+}
+
+func rosterCodeHash(code string) string {
+	return hashToken("roster:" + normalizeResetCode(code))
+}
+
+// pictureSequence validates a picked sequence and returns the string that is hashed
+func pictureSequence(pictures []int) (string, bool) {
+	if len(pictures) != pictureSequenceLength {
+		return "", false
+	}
+	parts := make([]string, len(pictures))
+	for i, p := range pictures {
+		if p < 0 || p >= pictureCount {
+			return "", false
+		}
+		parts[i] = strconv.Itoa(p)
+	}
+	return strings.Join(parts, "-"), true
+}
+
+// saveStudentLogin applies update to the student's login row, creating it if needed
+func saveStudentLogin(db *gorm.DB, studentID string, update func(l *StudentLogin)) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var l StudentLogin
+		err := tx.First(&l, "student_id = ?", studentID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			l = StudentLogin{StudentID: studentID}
+		} else if err != nil {
+			return err
+		}
+		update(&l)
+		return tx.Save(&l).Error
+	})
+}
+
+// loadManagedStudent checks that a teacher of the student or an admin asks and loads the student
+func loadManagedStudent(c *gin.Context) (User, bool) {
+	var student User
+	role, _ := c.Get("role")
+	if r := fmt.Sprintf("%v", role); r != string(RoleTeacher) && r != string(RoleAdmin) {
+		SendJSON(c, 1, "Only teachers can manage student logins", nil)
+		return student, false
+	}
+	if !RequireStudentAccess(c, c.Param("id")) {
+		return student, false
+	}
+	if err := TenantDB(c).First(&student, "id = ? AND role = ?", c.Param("id"), RoleStudent).Error; err != nil {
+		SendJSON(c, 1, "Student not found", nil)
+		return student, false
+	}
+	return student, true
+}
+
+// passwordFreeLogin records a card or picture login and logs the student in.
+// The session never typed the password an admin or import set, so it is let
+// past the forced password change. The account still has to change it before
+// the next password login.
+func passwordFreeLogin(c *gin.Context, tenantID string, student User, how string) {
+	ForTenant(tenantID).Model(&StudentLogin{}).Where("student_id = ?", student.ID).
+		Update("last_login_at", time.Now().Format(timeLayout))
+	completeLogin(c, student, how, true)
+}
+
+// issueLoginCard makes a new card for the student; the previous card stops working
+func issueLoginCard(c *gin.Context, student User) (gin.H, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	link := loginCardURL(token)
+	png, err := qrcode.Encode(link, qrcode.Medium, 256)
+	if err != nil {
+		return nil, err
+	}
+	userId, _ := c.Get("userId")
+	err = saveStudentLogin(TenantDB(c), student.ID, func(l *StudentLogin) {
+		l.CardHash = hashToken(token)
+		l.CardIssuedAt = time.Now().Format(timeLayout)
+		l.UpdatedBy = fmt.Sprintf("%v", userId)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"studentId": student.ID,
+		"username":  student.Username,
+		"name":      student.Name,
+		"url":       link,
+		"qr":        "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}, nil
+}
+
+// CreateLoginCard prints a QR login card for one student. The card is only
+// ever returned here; printing a new one revokes the old.
+func CreateLoginCard(c *gin.Context) {
+	student, ok := loadManagedStudent(c)
+	if !ok {
+		return
+	}
+	card, err := issueLoginCard(c, student)
+	if err != nil {
+		SendJSON(c, 1, "Failed to create login card", nil)
+		return
+	}
+	AddAuditLog(c, "CREATE_LOGIN_CARD", fmt.Sprintf("Login card for student %s", student.Username))
+	SendJSON(c, 0, "", card)
+}
+
+// CreateClassLoginCards prints a fresh login card for every student of a class
+func CreateClassLoginCards(c *gin.Context) {
+	cls, ok := loadManagedClass(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	var students []User
+	TenantDB(c).Where("id IN ? AND role = ?", cls.StudentIDs, RoleStudent).Order("name").Find(&students)
+
+	cards := make([]gin.H, 0, len(students))
+	for _, student := range students {
+		card, err := issueLoginCard(c, student)
+		if err != nil {
+			SendJSON(c, 1, "Failed to create login cards", nil)
+			return
+		}
+		cards = append(cards, card)
+	}
+	AddAuditLog(c, "CREATE_LOGIN_CARD", fmt.Sprintf("Login cards for the %d students of class %s", len(cards), cls.Name))
+	SendJSON(c, 0, "", cards)
+}
+
+// CreatePicturePassword gives a student a new random picture sequence to learn
+func CreatePicturePassword(c *gin.Context) {
+	student, ok := loadManagedStudent(c)
+	if !ok {
+		return
+	}
+	pictures := make([]int, pictureSequenceLength)
+	for i := range pictures {
+		n, err := rand.Int(rand.Reader, big.NewInt(pictureCount))
+		if err != nil {
+			SendJSON(c, 1, "Failed to create picture password", nil)
+			return
+		}
+		pictures[i] = int(n.Int64())
+	}
+	seq, _ := pictureSequence(pictures)
+	hashed, err := bcrypt.GenerateFromPassword([]byte(seq), bcrypt.DefaultCost)
+	if err != nil {
+		SendJSON(c, 1, "Failed to create picture password", nil)
+		return
+	}
+	userId, _ := c.Get("userId")
+	err = saveStudentLogin(TenantDB(c), student.ID, func(l *StudentLogin) {
+		l.PictureHash = string(hashed)
+		l.PictureIssuedAt = time.Now().Format(timeLayout)
+		l.UpdatedBy = fmt.Sprintf("%v", userId)
+	})
+	if err != nil {
+		SendJSON(c, 1, "Failed to create picture password", nil)
+		return
+	}
+	AddAuditLog(c, "CREATE_PICTURE_PASSWORD", fmt.Sprintf("Picture password for student %s", student.Username))
+	SendJSON(c, 0, "", gin.H{"studentId": student.ID, "name": student.Name, "pictures": pictures})
+}
+
+// GetStudentLogin shows which password-free logins a student has
+func GetStudentLogin(c *gin.Context) {
+	student, ok := loadManagedStudent(c)
+	if !ok {
+		return
+	}
+	var l StudentLogin
+	TenantDB(c).First(&l, "student_id = ?", student.ID)
+	SendJSON(c, 0, "", gin.H{
+		"studentId":       student.ID,
+		"card":            l.CardHash != "",
+		"cardIssuedAt":    l.CardIssuedAt,
+		"picture":         l.PictureHash != "",
+		"pictureIssuedAt": l.PictureIssuedAt,
+		"lastLoginAt":     l.LastLoginAt,
+	})
+}
+
+// RevokeStudentLogin turns off the card (?method=card), the picture password
+// (?method=picture) or both, and logs the student out everywhere
+func RevokeStudentLogin(c *gin.Context) {
+	student, ok := loadManagedStudent(c)
+	if !ok {
+		return
+	}
+	method := c.Query("method")
+	if method != "" && method != "card" && method != "picture" {
+		SendJSON(c, 1, "Invalid login method", nil)
+		return
+	}
+	userId, _ := c.Get("userId")
+	err := saveStudentLogin(TenantDB(c), student.ID, func(l *StudentLogin) {
+		if method != "picture" {
+			l.CardHash, l.CardIssuedAt = "", ""
+		}
+		if method != "card" {
+			l.PictureHash, l.PictureIssuedAt = "", ""
+		}
+		l.UpdatedBy = fmt.Sprintf("%v", userId)
+	})
+	if err != nil {
+		SendJSON(c, 1, "Failed to revoke login", nil)
+		return
+	}
+	RevokeUserSessions(student.ID)
+	if method == "" {
+		method = "card and picture"
+	}
+	AddAuditLog(c, "REVOKE_STUDENT_LOGIN", fmt.Sprintf("Revoked %s login of student %s", method, student.Username))
+	SendJSON(c, 0, "", gin.H{"message": "Login revoked"})
+}
+
+// CreateRosterCode sets a new code that opens the class roster for picture
+// logins; the previous code stops working
+func CreateRosterCode(c *gin.Context) {
+	cls, ok := loadManagedClass(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	raw, err := randomCode(printedCodeAlphabet, 8)
+	if err != nil {
+		SendJSON(c, 1, "Failed to create class code", nil)
+		return
+	}
+	if err := TenantDB(c).Model(&Class{}).Where("id = ?", cls.ID).Update("roster_code_hash", rosterCodeHash(raw)).Error; err != nil {
+		SendJSON(c, 1, "Failed to create class code", nil)
+		return
+	}
+	AddAuditLog(c, "CREATE_ROSTER_CODE", fmt.Sprintf("Roster code for class %s", cls.Name))
+	SendJSON(c, 0, "", gin.H{"classId": cls.ID, "name": cls.Name, "code": raw[:4] + "-" + raw[4:]})
+}
+
+// rosterClass finds the class a roster code opens. A wrong code counts as a
+// failed login of the IP; false means the response was written.
+func rosterClass(c *gin.Context, code string) (Class, bool) {
+	var cls Class
+	if !checkLoginAllowed(c, "") {
+		return cls, false
+	}
+	if code == "" || DB.First(&cls, "roster_code_hash = ?", rosterCodeHash(code)).Error != nil {
+		loginFailed(c, "")
+		SendJSON(c, 1, errInvalidRosterCode.Error(), nil)
+		return cls, false
+	}
+	if _, err := LoadTenant(cls.TenantID); err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return cls, false
+	}
+	return cls, true
+}
+
+// GetClassRoster lists the students of a class who can log in with pictures
+func GetClassRoster(c *gin.Context) {
+	cls, ok := rosterClass(c, c.Query("code"))
+	if !ok {
+		return
+	}
+	db := ForTenant(cls.TenantID)
+	var ids []string
+	db.Model(&StudentLogin{}).Where("student_id IN ? AND picture_hash <> ''", cls.StudentIDs).Pluck("student_id", &ids)
+	var students []User
+	db.Select("id", "name").Where("id IN ? AND role = ? AND status = ?", ids, RoleStudent, "active").Find(&students)
+	sort.Slice(students, func(i, j int) bool { return students[i].Name < students[j].Name })
+
+	list := make([]gin.H, 0, len(students))
+	for _, s := range students {
+		list = append(list, gin.H{"id": s.ID, "name": s.Name})
+	}
+	SendJSON(c, 0, "", gin.H{"className": cls.Name, "students": list, "pictureCount": pictureCount, "sequenceLength": pictureSequenceLength})
+}
+
+// PictureLoginHandler logs a student in with the pictures picked on the roster
+func PictureLoginHandler(c *gin.Context) {
+	var req struct {
+		RosterCode string `json:"rosterCode" binding:"required"`
+		StudentID  string `json:"studentId" binding:"required"`
+		Pictures   []int  `json:"pictures" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendJSON(c, 1, "Invalid request", nil)
+		return
+	}
+	cls, ok := rosterClass(c, req.RosterCode)
+	if !ok {
+		return
+	}
+	enrolled := false
+	for _, id := range cls.StudentIDs {
+		enrolled = enrolled || id == req.StudentID
+	}
+	var student User
+	if !enrolled || ForTenant(cls.TenantID).First(&student, "id = ? AND role = ?", req.StudentID, RoleStudent).Error != nil {
+		SendJSON(c, 1, "Student not found", nil)
+		return
+	}
+	if !checkLoginAllowed(c, student.Username) {
+		return
+	}
+
+	var l StudentLogin
+	seq, valid := pictureSequence(req.Pictures)
+	if ForTenant(cls.TenantID).First(&l, "student_id = ?", student.ID).Error != nil || l.PictureHash == "" ||
+		!valid || bcrypt.CompareHashAndPassword([]byte(l.PictureHash), []byte(seq)) != nil {
+		loginFailed(c, student.Username)
+		SendJSON(c, 1, "Wrong pictures", nil)
+		return
+	}
+	loginSucceeded(student.Username)
+	passwordFreeLogin(c, cls.TenantID, student, " with a picture password")
+}
+
+// CardLoginHandler logs a student in with the token of a QR login card
+func CardLoginHandler(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendJSON(c, 1, "Invalid request", nil)
+		return
+	}
+	if !checkLoginAllowed(c, "") {
+		return
+	}
+
+	// Card tokens are unique across schools, the student's school comes from the card
+	var l StudentLogin
+	var student User
+	if DB.First(&l, "card_hash = ?", hashToken(strings.TrimSpace(req.Token))).Error != nil ||
+		ForTenant(l.TenantID).First(&student, "id = ? AND role = ?", l.StudentID, RoleStudent).Error != nil {
+		loginFailed(c, "")
+		SendJSON(c, 1, errInvalidLoginCard.Error(), nil)
+		return
+	}
+	passwordFreeLogin(c, l.TenantID, student, " with a login card")
+}
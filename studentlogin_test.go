@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStudentCardAndPictureLogin(t *testing.T) {
+	DB.Exec("DELETE FROM users")
+	DB.Exec("DELETE FROM classes")
+	DB.Exec("DELETE FROM student_logins")
+	LoginAttempts = NewMemoryAttemptStore()
+	DB.Create(&User{ID: "t1", Username: "teacher1", Role: RoleTeacher, Status: "active"})
+	DB.Create(&User{ID: "t2", Username: "teacher2", Role: RoleTeacher, Status: "active"})
+	// Like imported students, they still have to replace their first password
+	DB.Create(&User{ID: "s1", Username: "kid1", Name: "Amy", Role: RoleStudent, Status: "active", Grade: 1, MustChangePassword: true})
+	DB.Create(&User{ID: "s2", Username: "kid2", Name: "Ben", Role: RoleStudent, Status: "active", Grade: 1, MustChangePassword: true})
+	DB.Create(&Class{ID: "k1", Name: "1A", TeacherIDs: []string{"t1"}, StudentIDs: []string{"s1", "s2"}})
+
+	r := gin.Default()
+	r.POST("/auth/card-login", CardLoginHandler)
+	r.GET("/auth/roster", GetClassRoster)
+	r.POST("/auth/picture-login", PictureLoginHandler)
+	r.GET("/me", AuthMiddleware(), GetMe)
+	teacher := r.Group("/", func(c *gin.Context) {
+		c.Set("userId", c.GetHeader("X-User"))
+		c.Set("role", string(RoleTeacher))
+	})
+	teacher.POST("/students/:id/login-card", CreateLoginCard)
+	teacher.POST("/students/:id/picture-password", CreatePicturePassword)
+	teacher.GET("/students/:id/login", GetStudentLogin)
+	teacher.DELETE("/students/:id/login", RevokeStudentLogin)
+	teacher.POST("/classes/:id/login-cards", CreateClassLoginCards)
+	teacher.POST("/classes/:id/roster-code", CreateRosterCode)
+
+	call := func(method, url, user string, payload any) (int, Response) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		req.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	// loggedInAs checks a login response carries a working access token of the student
+	loggedInAs := func(t *testing.T, resp Response, studentID string) string {
+		if !assert.Equal(t, 0, resp.Code, resp.Err) {
+			return ""
+		}
+		data := resp.Data.(map[string]any)
+		req, _ := http.NewRequest("GET", "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+data["token"].(string))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"id":"`+studentID+`"`)
+		assert.Contains(t, w.Body.String(), `"mustChangePassword":false`)
+		return data["token"].(string)
+	}
+
+	_, denied := call("POST", "/students/s1/login-card", "t2", nil)
+	assert.Equal(t, 1, denied.Code, "only the student's teachers print cards")
+
+	t.Run("QR card", func(t *testing.T) {
+		_, resp := call("POST", "/students/s1/login-card", "t1", nil)
+		assert.Equal(t, 0, resp.Code, resp.Err)
+		card := resp.Data.(map[string]any)
+		assert.True(t, strings.HasPrefix(card["qr"].(string), "data:image/png;base64,"))
+		token := card["url"].(string)[strings.LastIndex(card["url"].(string), "/")+1:]
+
+		_, resp = call("POST", "/auth/card-login", "", map[string]string{"token": token})
+		loggedInAs(t, resp, "s1")
+		assert.Equal(t, false, resp.Data.(map[string]any)["user"].(map[string]any)["mustChangePassword"])
+
+		// The card session is let through, the set password still has to be changed
+		var student User
+		DB.First(&student, "id = ?", "s1")
+		assert.True(t, student.MustChangePassword)
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest("POST", "/auth/login", nil)
+		tokens, _ := StartUserSession(ctx, student, false)
+		req, _ := http.NewRequest("GET", "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens["token"].(string))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code, "a password session must change it first")
+
+		// Printing a new card retires the old one
+		_, resp = call("POST", "/students/s1/login-card", "t1", nil)
+		newToken := resp.Data.(map[string]any)["url"].(string)
+		newToken = newToken[strings.LastIndex(newToken, "/")+1:]
+		_, resp = call("POST", "/auth/card-login", "", map[string]string{"token": token})
+		assert.Equal(t, 1, resp.Code)
+
+		_, resp = call("POST", "/auth/card-login", "", map[string]string{"token": newToken})
+		session := loggedInAs(t, resp, "s1")
+		_, resp = call("DELETE", "/students/s1/login?method=card", "t1", nil)
+		assert.Equal(t, 0, resp.Code, resp.Err)
+		_, resp = call("POST", "/auth/card-login", "", map[string]string{"token": newToken})
+		assert.Equal(t, 1, resp.Code, "a revoked card no longer works")
+
+		req, _ = http.NewRequest("GET", "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+session)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "revoking logs the student out")
+
+		_, resp = call("POST", "/classes/k1/login-cards", "t1", nil)
+		assert.Equal(t, 0, resp.Code, resp.Err)
+		assert.Len(t, resp.Data, 2)
+	})
+
+	t.Run("Picture password", func(t *testing.T) {
+		_, resp := call("POST", "/students/s2/picture-password", "t1", nil)
+		assert.Equal(t, 0, resp.Code, resp.Err)
+		var pictures []int
+		for _, p := range resp.Data.(map[string]any)["pictures"].([]any) {
+			pictures = append(pictures, int(p.(float64)))
+		}
+		assert.Len(t, pictures, pictureSequenceLength)
+		wrong := append([]int{}, pictures...)
+		wrong[0] = (wrong[0] + 1) % pictureCount
+
+		_, resp = call("POST", "/classes/k1/roster-code", "t1", nil)
+		code := resp.Data.(map[string]any)["code"].(string)
+
+		_, resp = call("GET", "/auth/roster?code=WRONG-CODE", "", nil)
+		assert.Equal(t, 1, resp.Code)
+		_, resp = call("GET", "/auth/roster?code="+code, "", nil)
+		assert.Equal(t, 0, resp.Code, resp.Err)
+		students := resp.Data.(map[string]any)["students"].([]any)
+		assert.Len(t, students, 1, "only students with a picture password are listed")
+		assert.Equal(t, "s2", students[0].(map[string]any)["id"])
+
+		login := func(seq []int) Response {
+			_, resp := call("POST", "/auth/picture-login", "", map[string]any{"rosterCode": code, "studentId": "s2", "pictures": seq})
+			return resp
+		}
+		loggedInAs(t, login(pictures), "s2")
+
+		for i := 0; i <= userLoginPolicy.FreeFailures; i++ {
+			assert.Equal(t, "Wrong pictures", login(wrong).Err)
+		}
+		assert.Contains(t, login(pictures).Err, "Too many failed login attempts", "guessing backs off like passwords do")
+		LoginAttempts = NewMemoryAttemptStore()
+
+		_, resp = call("POST", "/auth/picture-login", "", map[string]any{"rosterCode": code, "studentId": "s3", "pictures": pictures})
+		assert.Equal(t, 1, resp.Code, "only students of the class")
+
+		_, resp = call("DELETE", "/students/s2/login?method=picture", "t1", nil)
+		assert.Equal(t, 0, resp.Code, resp.Err)
+		assert.Equal(t, 1, login(pictures).Code)
+		_, resp = call("GET", "/students/s2/login", "t1", nil)
+		assert.Equal(t, false, resp.Data.(map[string]any)["picture"])
+	})
+}
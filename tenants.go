@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Every school is a tenant. Access tokens carry the user's tenant as "tid",
+// AuthMiddleware puts it on the request and TenantDB binds it to the query
+// context, where the callbacks below add `tenant_id = ?` to every query,
+// update and delete of a school-owned table and stamp it on every insert.
+// A DB without a tenant in its context (migrations, CLI commands, super-admin
+// handlers) is not scoped.
+
+// defaultTenantID is the school that existed before tenants were introduced.
+// Rows written without a tenant fall back to it through the column default.
+const defaultTenantID = "default"
+
+var (
+	errTenantNotFound = errors.New("School not found")
+	errTenantDisabled = errors.New("School is disabled")
+	// Super-admins stand above the schools, so no school admin may create,
+	// change or delete one
+	errSuperAdminManaged = errors.New("Super-admins are managed with the tenants command")
+)
+
+// tenantModels are the school-owned tables
+var tenantModels = []any{
+	&User{}, &Question{}, &Paper{}, &Homework{}, &Class{}, &History{},
+	&PracticeSession{}, &Resource{}, &Asset{}, &AuditLog{}, &Reinforcement{},
+	&StudentWrongQuestion{}, &ParentLink{}, &ParentInvite{}, &SystemConfig{}, &RolePermission{},
+	&StudentLogin{},
+}
+
+// tenantTables holds the table names of tenantModels, so DB.Table("homeworks")
+// queries without a model are scoped too
+var tenantTables = map[string]bool{}
+
+var tenantCodePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,31}$`)
+
+type tenantContextKey struct{}
+
+// WithTenant returns a context whose queries are scoped to the tenant
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenantID)
+}
+
+// TenantFrom returns the tenant bound to ctx, empty when unscoped
+func TenantFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	tid, _ := ctx.Value(tenantContextKey{}).(string)
+	return tid
+}
+
+// TenantID returns the tenant of the authenticated request
+func TenantID(c *gin.Context) string {
+	return c.GetString("tenantId")
+}
+
+// ForTenant returns DB scoped to one tenant; an empty ID leaves it unscoped
+func ForTenant(tenantID string) *gorm.DB {
+	if tenantID == "" {
+		return DB
+	}
+	return DB.WithContext(WithTenant(context.Background(), tenantID))
+}
+
+// TenantDB returns DB scoped to the tenant of the request
+func TenantDB(c *gin.Context) *gorm.DB {
+	return ForTenant(TenantID(c))
+}
+
+// RegisterTenantScoping installs the tenant callbacks on db
+func RegisterTenantScoping(db *gorm.DB) error {
+	for _, model := range tenantModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		tenantTables[stmt.Schema.Table] = true
+	}
+
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").Register("tenant:stamp", stampTenant); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register("tenant:scope", scopeTenant); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("tenant:scope", scopeTenant); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("tenant:scope", scopeTenantUpdate); err != nil {
+		return err
+	}
+	return cb.Delete().Before("gorm:delete").Register("tenant:scope", scopeTenant)
+}
+
+func scopeTenant(db *gorm.DB) {
+	tid := TenantFrom(db.Statement.Context)
+	if tid == "" || !tenantTables[db.Statement.Table] {
+		return
+	}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: "tenant_id"}, Value: tid},
+	}})
+}
+
+// scopeTenantUpdate also pins tenant_id, so a Save of a struct that came from
+// a request body can't blank it or move the row to another school
+func scopeTenantUpdate(db *gorm.DB) {
+	scopeTenant(db)
+	tid := TenantFrom(db.Statement.Context)
+	if tid == "" || db.Statement.Schema == nil || db.Statement.Schema.LookUpField("TenantID") == nil {
+		return
+	}
+	if _, isMap := db.Statement.Dest.(map[string]any); isMap {
+		return
+	}
+	db.Statement.SetColumn("TenantID", tid, true)
+}
+
+func stampTenant(db *gorm.DB) {
+	tid := TenantFrom(db.Statement.Context)
+	if tid == "" || db.Statement.Schema == nil {
+		return
+	}
+	field := db.Statement.Schema.LookUpField("TenantID")
+	if field == nil {
+		return
+	}
+	switch rv := db.Statement.ReflectValue; rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			field.Set(db.Statement.Context, reflect.Indirect(rv.Index(i)), tid)
+		}
+	case reflect.Struct:
+		field.Set(db.Statement.Context, rv, tid)
+	}
+	// Save falls back to an upsert when its update matched nothing, which
+	// would overwrite another school's row with the same ID
+	if _, ok := db.Statement.Clauses["ON CONFLICT"]; ok {
+		db.Statement.AddClause(clause.OnConflict{DoNothing: true})
+	}
+}
+
+// LoadTenant returns an active tenant by ID
+func LoadTenant(tenantID string) (Tenant, error) {
+	var t Tenant
+	if err := DB.First(&t, "id = ?", tenantID).Error; err != nil {
+		return t, errTenantNotFound
+	}
+	if t.Status != "active" {
+		return t, errTenantDisabled
+	}
+	return t, nil
+}
+
+// TenantByCode resolves the school code a user types on the public pages;
+// an empty code is the default school
+func TenantByCode(code string) (Tenant, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if code == "" {
+		return LoadTenant(defaultTenantID)
+	}
+	var t Tenant
+	if err := DB.First(&t, "code = ?", code).Error; err != nil {
+		return t, errTenantNotFound
+	}
+	return LoadTenant(t.ID)
+}
+
+// seedTenantDefaults creates the default permissions and error logic config of
+// the tenant tx is scoped to
+func seedTenantDefaults(tx *gorm.DB) error {
+	var permCount int64
+	tx.Model(&RolePermission{}).Count(&permCount)
+	if permCount == 0 {
+		perms := append(defaultRolePermissions(), defaultParentPermissions()...)
+		if err := tx.Create(&perms).Error; err != nil {
+			return err
+		}
+	}
+
+	var configCount int64
+	tx.Model(&SystemConfig{}).Where(&SystemConfig{Key: "error_logic"}).Count(&configCount)
+	if configCount == 0 {
+		if err := tx.Create(&SystemConfig{Key: "error_logic", Value: defaultErrorLogicJSON}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SuperAdminMiddleware lets only super-admins through. Tenant management is
+// not a permission module, so no school admin can grant it to themselves.
+func SuperAdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, _ := c.Get("role")
+		if fmt.Sprintf("%v", role) != string(RoleSuperAdmin) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Super-admin access required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// TenantInfo is a tenant with its number of users
+type TenantInfo struct {
+	Tenant
+	Users int64 `json:"users"`
+}
+
+// GetTenants lists all schools
+func GetTenants(c *gin.Context) {
+	var tenants []Tenant
+	DB.Order("created_at").Find(&tenants)
+
+	counts := make(map[string]int64)
+	var rows []struct {
+		TenantID string
+		Count    int64
+	}
+	DB.Model(&User{}).Select("tenant_id, COUNT(*) AS count").Group("tenant_id").Scan(&rows)
+	for _, row := range rows {
+		counts[row.TenantID] = row.Count
+	}
+
+	result := make([]TenantInfo, 0, len(tenants))
+	for _, t := range tenants {
+		result = append(result, TenantInfo{Tenant: t, Users: counts[t.ID]})
+	}
+	SendJSON(c, 0, "", result)
+}
+
+// CreateTenant creates a school with the default permissions and config and
+// its first admin, who has to change the password at first login
+func CreateTenant(c *gin.Context) {
+	var req struct {
+		Code          string `json:"code" binding:"required"`
+		Name          string `json:"name" binding:"required"`
+		AdminUsername string `json:"adminUsername" binding:"required"`
+		AdminPassword string `json:"adminPassword" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendJSON(c, 1, "Code, name, admin username and password are required", nil)
+		return
+	}
+	req.Code = strings.ToLower(strings.TrimSpace(req.Code))
+	if !tenantCodePattern.MatchString(req.Code) {
+		SendJSON(c, 1, "The code must be 2-32 lowercase letters, digits or dashes", nil)
+		return
+	}
+
+	var count int64
+	DB.Model(&Tenant{}).Where("code = ?", req.Code).Count(&count)
+	if count > 0 {
+		SendJSON(c, 1, "A school with this code already exists", nil)
+		return
+	}
+	DB.Model(&User{}).Where("username = ?", req.AdminUsername).Count(&count)
+	if count > 0 {
+		SendJSON(c, 1, "Username already exists", nil)
+		return
+	}
+
+	now := time.Now()
+	tenant := Tenant{
+		ID:        strconv.FormatInt(now.UnixNano(), 36),
+		Code:      req.Code,
+		Name:      strings.TrimSpace(req.Name),
+		Status:    "active",
+		CreatedAt: now.Format(timeLayout),
+	}
+	// The new school has no settings yet, so its admin's password is checked
+	// against the default policy
+	hashed, err := hashPassword(ForTenant(tenant.ID), req.AdminPassword, req.AdminUsername)
+	if err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
+	admin := User{
+		ID:                 strconv.FormatInt(now.UnixNano()+1, 36),
+		Username:           req.AdminUsername,
+		Password:           hashed,
+		Name:               req.AdminUsername,
+		Role:               RoleAdmin,
+		Status:             "active",
+		MustChangePassword: true,
+	}
+
+	err = ForTenant(tenant.ID).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&tenant).Error; err != nil {
+			return err
+		}
+		if err := seedTenantDefaults(tx); err != nil {
+			return err
+		}
+		return tx.Create(&admin).Error
+	})
+	if err != nil {
+		SendJSON(c, 1, "Failed to create school", nil)
+		return
+	}
+	InvalidateSettings(tenant.ID)
+
+	AddAuditLog(c, "CREATE_TENANT", fmt.Sprintf("Created school %s (%s) with admin %s", tenant.Name, tenant.Code, admin.Username))
+	SendJSON(c, 0, "", TenantInfo{Tenant: tenant, Users: 1})
+}
+
+// UpdateTenant renames a school or enables/disables it. Users of a disabled
+// school can't log in and their tokens stop working at once.
+func UpdateTenant(c *gin.Context) {
+	var tenant Tenant
+	if err := DB.First(&tenant, "id = ?", c.Param("id")).Error; err != nil {
+		SendJSON(c, 1, errTenantNotFound.Error(), nil)
+		return
+	}
+	var req struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
+
+	if name := strings.TrimSpace(req.Name); name != "" {
+		tenant.Name = name
+	}
+	switch req.Status {
+	case "":
+	case "active", "disabled":
+		if req.Status == "disabled" && tenant.ID == TenantID(c) {
+			SendJSON(c, 1, "You can't disable your own school", nil)
+			return
+		}
+		tenant.Status = req.Status
+	default:
+		SendJSON(c, 1, "Status must be active or disabled", nil)
+		return
+	}
+
+	if err := DB.Save(&tenant).Error; err != nil {
+		SendJSON(c, 1, "Failed to update school", nil)
+		return
+	}
+	AddAuditLog(c, "UPDATE_TENANT", fmt.Sprintf("Updated school %s (%s), status %s", tenant.Name, tenant.Code, tenant.Status))
+	SendJSON(c, 0, "", tenant)
+}
+
+// RunTenantsCommand implements `tenants list|super-admin <username> [password]`
+func RunTenantsCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: tenants list|super-admin <username> [password]")
+	}
+	db, err := OpenDB()
+	if err != nil {
+		return err
+	}
+	if err := CheckSchemaVersion(db); err != nil {
+		return err
+	}
+	DB = db
+
+	switch args[0] {
+	case "list":
+		var tenants []Tenant
+		if err := DB.Order("created_at").Find(&tenants).Error; err != nil {
+			return err
+		}
+		for _, t := range tenants {
+			fmt.Printf("%-16s %-16s %-8s %s\n", t.ID, t.Code, t.Status, t.Name)
+		}
+	case "super-admin":
+		if len(args) < 2 {
+			return errors.New("usage: tenants super-admin <username> [password]")
+		}
+		var user User
+		if err := DB.First(&user, "username = ?", args[1]).Error; err == nil {
+			if err := DB.Model(&user).Update("role", RoleSuperAdmin).Error; err != nil {
+				return err
+			}
+			fmt.Printf("%s is now a super-admin of school %s\n", user.Username, user.TenantID)
+			return nil
+		}
+		// A new super-admin belongs to the default school
+		if len(args) < 3 {
+			return fmt.Errorf("user %s does not exist, give a password to create it", args[1])
+		}
+		hashed, err := hashPassword(ForTenant(defaultTenantID), args[2], args[1])
+		if err != nil {
+			return err
+		}
+		user = User{
+			ID:                 strconv.FormatInt(time.Now().UnixNano(), 36),
+			TenantID:           defaultTenantID,
+			Username:           args[1],
+			Name:               args[1],
+			Password:           hashed,
+			Role:               RoleSuperAdmin,
+			Status:             "active",
+			MustChangePassword: true,
+		}
+		if err := DB.Create(&user).Error; err != nil {
+			return err
+		}
+		fmt.Printf("Created super-admin %s\n", user.Username)
+	default:
+		return fmt.Errorf("unknown tenants command %q", args[0])
+	}
+	return nil
+}
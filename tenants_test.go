@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTenantScoping(t *testing.T) {
+	DB.Exec("DELETE FROM questions")
+	DB.Exec("DELETE FROM users")
+	DB.Exec("DELETE FROM tenants WHERE id <> ?", defaultTenantID)
+	DB.Create(&Tenant{ID: "t2", Code: "second", Name: "Second School", Status: "active"})
+	DB.Create(&Question{ID: "q1", StemText: "default school", Answer: "1"})
+	DB.Create(&Question{ID: "q2", TenantID: "t2", StemText: "second school", Answer: "2"})
+	assert.NoError(t, seedTenantDefaults(ForTenant("t2")))
+	t.Cleanup(func() { DB.Exec("DELETE FROM questions") }) // question IDs only have second precision
+
+	as := func(tenantID string) *gin.Engine {
+		r := gin.Default()
+		r.Use(func(c *gin.Context) {
+			c.Set("userId", "a-"+tenantID)
+			c.Set("role", string(RoleAdmin))
+			c.Set("tenantId", tenantID)
+		})
+		r.GET("/questions", GetQuestions)
+		r.POST("/questions", CreateQuestion)
+		r.PUT("/questions/:id", UpdateQuestion)
+		r.DELETE("/questions/:id", DeleteQuestion)
+		r.PUT("/admin/settings", UpdateSystemSettings)
+		r.GET("/admin/permissions", GetRolePermissions)
+		r.GET("/dashboard/stats", GetDashboardStats)
+		return r
+	}
+	call := func(r *gin.Engine, method, url string, payload any) Response {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+	stems := func(tenantID string) []string {
+		var res []string
+		ForTenant(tenantID).Model(&Question{}).Order("id").Pluck("stem_text", &res)
+		return res
+	}
+
+	resp := call(as("t2"), "GET", "/questions", nil)
+	list := resp.Data.(map[string]any)["list"].([]any)
+	assert.Len(t, list, 1)
+	assert.Equal(t, "q2", list[0].(map[string]any)["id"])
+
+	t.Run("Writes stay in the school", func(t *testing.T) {
+		resp := call(as("t2"), "POST", "/questions", map[string]any{"subject": "MATH", "type": "CALCULATION", "stemText": "new", "answer": "3"})
+		assert.Equal(t, 0, resp.Code, resp.Err)
+		assert.ElementsMatch(t, []string{"second school", "new"}, stems("t2"))
+
+		// Another school's question can't be edited, taken over or deleted
+		call(as("t2"), "PUT", "/questions/q1", map[string]any{"subject": "MATH", "type": "CALCULATION", "stemText": "hijacked", "answer": "1"})
+		call(as("t2"), "DELETE", "/questions/q1", nil)
+		assert.Equal(t, []string{"default school"}, stems(defaultTenantID))
+
+		call(as("t2"), "PUT", "/questions/q2", map[string]any{"subject": "MATH", "type": "CALCULATION", "stemText": "edited", "answer": "2"})
+		var q Question
+		DB.First(&q, "id = ?", "q2")
+		assert.Equal(t, "t2", q.TenantID, "saving a request body keeps the row's school")
+		assert.Equal(t, "edited", q.StemText)
+	})
+
+	t.Run("Config and permissions are per school", func(t *testing.T) {
+		resp := call(as("t2"), "PUT", "/admin/settings", map[string]any{"registrationEnabled": true})
+		assert.Equal(t, 0, resp.Code, resp.Err)
+		assert.True(t, loadSystemSettings(ForTenant("t2")).RegistrationEnabled)
+		assert.False(t, loadSystemSettings(ForTenant(defaultTenantID)).RegistrationEnabled)
+
+		var own, all int64
+		ForTenant("t2").Model(&RolePermission{}).Count(&own)
+		DB.Model(&RolePermission{}).Count(&all)
+		resp = call(as("t2"), "GET", "/admin/permissions", nil)
+		assert.Len(t, resp.Data, int(own))
+		assert.Greater(t, all, own)
+	})
+
+	t.Run("Online users are counted per school", func(t *testing.T) {
+		DB.Create(&User{ID: "u1", Username: "first", Role: RoleStudent})
+		DB.Create(&User{ID: "u2", TenantID: "t2", Username: "second", Role: RoleStudent})
+		ActiveUsers.Store("u1", time.Now().Unix())
+		ActiveUsers.Store("u2", time.Now().Unix())
+		defer ActiveUsers.Delete("u1")
+		defer ActiveUsers.Delete("u2")
+
+		resp := call(as("t2"), "GET", "/dashboard/stats", nil)
+		assert.Equal(t, float64(1), resp.Data.(map[string]any)["onlineUsers"])
+	})
+}
+
+func TestTenantTokens(t *testing.T) {
+	DB.Exec("DELETE FROM users")
+	DB.Exec("DELETE FROM tenants WHERE id <> ?", defaultTenantID)
+	DB.Create(&Tenant{ID: "t2", Code: "second", Name: "Second School", Status: "active"})
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	DB.Create(&User{ID: "u2", TenantID: "t2", Username: "bob", Password: string(hashed), Role: RoleTeacher, Status: "active"})
+
+	r := gin.Default()
+	r.POST("/auth/login", LoginHandler)
+	r.GET("/me", AuthMiddleware(), func(c *gin.Context) { SendJSON(c, 0, "", TenantID(c)) })
+
+	login := func() Response {
+		body, _ := json.Marshal(map[string]string{"username": "bob", "password": "pw"})
+		req, _ := http.NewRequest("POST", "/auth/login", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+	me := func(token string) (int, any) {
+		req, _ := http.NewRequest("GET", "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.Data
+	}
+
+	resp := login()
+	assert.Equal(t, 0, resp.Code, resp.Err)
+	token := resp.Data.(map[string]any)["token"].(string)
+	status, tid := me(token)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "t2", tid)
+
+	// A token signed for another school, or from before tenants, is refused
+	forged, _ := AppKeys.Sign(jwt.MapClaims{"userId": "u2", "role": "TEACHER", "sid": "x", "tid": defaultTenantID, "exp": 9999999999})
+	status, _ = me(forged)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// Disabling the school logs its users out and keeps them out
+	DB.Model(&Tenant{}).Where("id = ?", "t2").Update("status", "disabled")
+	status, _ = me(token)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, 1, login().Code)
+}
+
+func TestCreateTenant(t *testing.T) {
+	DB.Exec("DELETE FROM users")
+	DB.Exec("DELETE FROM tenants WHERE id <> ?", defaultTenantID)
+
+	as := func(role Role) *gin.Engine {
+		r := gin.Default()
+		r.Use(func(c *gin.Context) {
+			c.Set("userId", "root")
+			c.Set("role", string(role))
+			c.Set("tenantId", defaultTenantID)
+		})
+		super := r.Group("/super", SuperAdminMiddleware())
+		super.GET("/tenants", GetTenants)
+		super.POST("/tenants", CreateTenant)
+		super.PUT("/tenants/:id", UpdateTenant)
+		return r
+	}
+	call := func(r *gin.Engine, method, url string, payload any) (int, Response) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	payload := map[string]string{"code": "North-High", "name": "North High", "adminUsername": "north-admin", "adminPassword": "Start-2024"}
+
+	status, _ := call(as(RoleAdmin), "POST", "/super/tenants", payload)
+	assert.Equal(t, http.StatusForbidden, status, "school admins can't manage schools")
+
+	_, resp := call(as(RoleSuperAdmin), "POST", "/super/tenants", payload)
+	assert.Equal(t, 0, resp.Code, resp.Err)
+	tid := resp.Data.(map[string]any)["id"].(string)
+
+	var admin User
+	DB.First(&admin, "username = ?", "north-admin")
+	assert.Equal(t, tid, admin.TenantID)
+	assert.Equal(t, RoleAdmin, admin.Role)
+	assert.True(t, admin.MustChangePassword)
+	var perms int64
+	ForTenant(tid).Model(&RolePermission{}).Count(&perms)
+	assert.NotZero(t, perms)
+	assert.True(t, LoadErrorLogicConfig(ForTenant(tid)).GlobalEnabled)
+
+	tests := []struct {
+		name    string
+		payload map[string]string
+	}{
+		{"Duplicate code", map[string]string{"code": "north-high", "name": "x", "adminUsername": "other", "adminPassword": "Start-2024"}},
+		{"Bad code", map[string]string{"code": "north high", "name": "x", "adminUsername": "other", "adminPassword": "Start-2024"}},
+		{"Taken username", map[string]string{"code": "south", "name": "x", "adminUsername": "north-admin", "adminPassword": "Start-2024"}},
+		{"Weak password", map[string]string{"code": "south", "name": "x", "adminUsername": "other", "adminPassword": "123"}},
+	}
+	for _, tt := range tests {
+		_, resp := call(as(RoleSuperAdmin), "POST", "/super/tenants", tt.payload)
+		assert.Equal(t, 1, resp.Code, tt.name)
+	}
+
+	_, resp = call(as(RoleSuperAdmin), "PUT", "/super/tenants/"+tid, map[string]string{"status": "disabled"})
+	assert.Equal(t, 0, resp.Code, resp.Err)
+	_, err := LoadTenant(tid)
+	assert.ErrorIs(t, err, errTenantDisabled)
+	_, resp = call(as(RoleSuperAdmin), "PUT", "/super/tenants/"+defaultTenantID, map[string]string{"status": "disabled"})
+	assert.Equal(t, 1, resp.Code, "super-admins can't lock out their own school")
+
+	_, resp = call(as(RoleSuperAdmin), "GET", "/super/tenants", nil)
+	assert.Len(t, resp.Data, 2)
+}
@@ -0,0 +1,24 @@
+-- The SQLite schema the boot-time AutoMigrate of the first release created,
+-- with a few rows, for testing upgrades of databases from before migrations
+CREATE TABLE `users` (`id` varchar(191),`username` varchar(191),`name` varchar(191),`password` varchar(191),`role` varchar(191),`status` varchar(191),PRIMARY KEY (`id`),CONSTRAINT `uni_users_username` UNIQUE (`username`));
+CREATE TABLE `questions` (`id` varchar(191),`subject` varchar(191),`grade` integer,`type` varchar(191),`stem_text` text,`stem_image` text,`answer` text,`options` text,`hint` text,PRIMARY KEY (`id`));
+CREATE TABLE `papers` (`id` varchar(191),`name` varchar(191),`questions` text,`question_ids` text,`total` integer,PRIMARY KEY (`id`));
+CREATE TABLE `homeworks` (`id` varchar(191),`teacher_id` varchar(191),`paper_id` varchar(191),`name` varchar(191),`class_id` varchar(191),`start_date` varchar(191),`end_date` varchar(191),`status` varchar(191),`completed` integer,`total` integer,`student_ids` text,PRIMARY KEY (`id`));
+CREATE TABLE `histories` (`id` varchar(191),`student_id` varchar(191),`homework_id` varchar(191),`type` varchar(191),`name` varchar(191),`name_en` varchar(191),`date` varchar(191),`score` varchar(191),`total` varchar(191),`correct_count` integer,`wrong_count` integer,`questions` text,PRIMARY KEY (`id`));
+CREATE TABLE `reinforcements` (`id` varchar(191),`name` varchar(191),`type` varchar(191),`image` text,`prompt` text,`duration` integer,`is_global` numeric,`target_student_ids` text,`rule_type` varchar(191),`rule_value` integer,`is_active` numeric DEFAULT true,PRIMARY KEY (`id`));
+CREATE TABLE `resources` (`id` varchar(191),`name` varchar(191),`url` text,`type` varchar(191),`tags` text,`visibility` varchar(191),`creator_id` varchar(191),`created_at` varchar(191),PRIMARY KEY (`id`));
+CREATE TABLE `audit_logs` (`id` varchar(191),`user_id` varchar(191),`username` varchar(191),`action` varchar(191),`details` text,`timestamp` varchar(191),PRIMARY KEY (`id`));
+CREATE TABLE `student_wrong_questions` (`id` varchar(191),`student_id` varchar(191),`question_id` varchar(191),`status` integer,`error_count` integer,`last_updated` varchar(191),PRIMARY KEY (`id`),CONSTRAINT `fk_student_wrong_questions_question` FOREIGN KEY (`question_id`) REFERENCES `questions`(`id`));
+CREATE INDEX `idx_student_wrong_questions_question_id` ON `student_wrong_questions`(`question_id`);
+CREATE INDEX `idx_student_wrong_questions_student_id` ON `student_wrong_questions`(`student_id`);
+CREATE TABLE `system_configs` (`key` varchar(191),`value` text,PRIMARY KEY (`key`));
+CREATE TABLE `role_permissions` (`role` varchar(191),`module_id` varchar(191),`ui_access` numeric,`api_access` numeric,PRIMARY KEY (`role`,`module_id`));
+INSERT INTO `users` VALUES ('1','admin','Admin','$2a$10$notarealhashnotarealhashnotarealhashnotarealhashnotar','ADMIN','active');
+INSERT INTO `users` VALUES ('t1','teacher','Teacher','$2a$10$notarealhashnotarealhashnotarealhashnotarealhashnotar','TEACHER','active');
+INSERT INTO `questions` VALUES ('q1','数学',3,'MULTIPLE_CHOICE','1+1=?','','B','[{"text":"1","value":"A"},{"text":"2","value":"B"}]','');
+INSERT INTO `histories` VALUES ('h1','s1','','practice','Practice','Practice','2024-01-01','3/4','4',3,1,'[]');
+INSERT INTO `system_configs` VALUES ('error_logic','{"globalEnabled": false}');
+INSERT INTO `role_permissions` VALUES ('ADMIN','dashboard',1,1);
+INSERT INTO `role_permissions` VALUES ('ADMIN','permissions',1,1);
+INSERT INTO `role_permissions` VALUES ('TEACHER','dashboard',1,1);
+INSERT INTO `role_permissions` VALUES ('TEACHER','papers',1,0);
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const mb = 1 << 20
+
+// uploadKind describes which MIME types are accepted for a kind of asset and how large they may be
+type uploadKind struct {
+	MaxSize   int64
+	MimeTypes map[string]string // detected MIME type -> file extension
+}
+
+// uploadKinds is the allow-list for POST /api/uploads. The type is decided by
+// sniffing the content, never by the client's filename or Content-Type.
+var uploadKinds = map[string]uploadKind{
+	"image": {MaxSize: 10 * mb, MimeTypes: map[string]string{
+		"image/png":  "png",
+		"image/jpeg": "jpg",
+		"image/gif":  "gif",
+		"image/webp": "webp",
+	}},
+	"audio": {MaxSize: 20 * mb, MimeTypes: map[string]string{
+		"audio/mpeg":  "mp3",
+		"audio/wav":   "wav",
+		"audio/ogg":   "ogg",
+		"audio/x-m4a": "m4a",
+		"audio/mp4":   "m4a",
+		"audio/aac":   "aac",
+	}},
+	"video": {MaxSize: 200 * mb, MimeTypes: map[string]string{
+		"video/mp4":       "mp4",
+		"video/webm":      "webm",
+		"video/quicktime": "mov",
+	}},
+	"pdf": {MaxSize: 20 * mb, MimeTypes: map[string]string{
+		"application/pdf": "pdf",
+	}},
+}
+
+// maxUploadBody caps the whole multipart request, above the largest per-kind limit
+const maxUploadBody = 200*mb + 1*mb
+
+// sniffLen is how much of an upload is read to detect its type, the most
+// mimetype looks at
+const sniffLen = 3072
+
+var errUnsupportedType = errors.New("unsupported file type")
+
+// sniffUpload detects the real type of data and returns its kind and extension
+func sniffUpload(data []byte) (kind, mimeType, ext string, err error) {
+	detected := mimetype.Detect(data)
+	for m := detected; m != nil; m = m.Parent() {
+		mimeType = strings.SplitN(m.String(), ";", 2)[0]
+		for name, k := range uploadKinds {
+			if e, ok := k.MimeTypes[mimeType]; ok {
+				return name, mimeType, e, nil
+			}
+		}
+	}
+	return "", detected.String(), "", errUnsupportedType
+}
+
+// UploadAsset accepts one multipart "file", validates it and stores it as an Asset
+func UploadAsset(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadBody)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		SendJSON(c, 1, "File is required", nil)
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		SendJSON(c, 1, "Failed to read file", nil)
+		return
+	}
+	defer file.Close()
+
+	// Only the head is read to tell the type; large files stay in the temp
+	// file the multipart parser spooled them to and are streamed from there
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		SendJSON(c, 1, "Failed to read file", nil)
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		SendJSON(c, 1, "Failed to read file", nil)
+		return
+	}
+
+	kind, mimeType, ext, err := sniffUpload(head[:n])
+	if err != nil {
+		SendJSON(c, 1, fmt.Sprintf("Unsupported file type: %s", mimeType), nil)
+		return
+	}
+	if limit := uploadKinds[kind].MaxSize; fileHeader.Size > limit {
+		SendJSON(c, 1, fmt.Sprintf("File too large: %s files are limited to %d MB", kind, limit/mb), nil)
+		return
+	}
+
+	userId, _ := c.Get("userId")
+	asset := Asset{
+		ID:        strconv.FormatInt(time.Now().UnixNano(), 36),
+		Kind:      kind,
+		MimeType:  mimeType,
+		Size:      fileHeader.Size,
+		Name:      fileHeader.Filename,
+		CreatorID: fmt.Sprintf("%v", userId),
+		CreatedAt: time.Now().Format("2006-01-02 15:04:05"),
+	}
+
+	if kind == "image" {
+		// Images are small enough to decode in memory, see uploadKinds
+		data, err := io.ReadAll(io.LimitReader(file, fileHeader.Size))
+		if err != nil {
+			SendJSON(c, 1, "Failed to read file", nil)
+			return
+		}
+		if err := storeImageAsset(&asset, data); err != nil {
+			SendJSON(c, 1, "Failed to process image: "+err.Error(), nil)
+			return
+		}
+	} else {
+		asset.Key = fmt.Sprintf("assets/%s/%s.%s", kind, asset.ID, ext)
+		url, err := AppStorage.Put(asset.Key, file, mimeType)
+		if err != nil {
+			SendJSON(c, 1, "Failed to store file: "+err.Error(), nil)
+			return
+		}
+		asset.URL = url
+	}
+
+	if err := TenantDB(c).Create(&asset).Error; err != nil {
+		deleteAssetFiles(asset)
+		SendJSON(c, 1, "Failed to save asset", nil)
+		return
+	}
+	AddAuditLog(c, "UPLOAD_ASSET", fmt.Sprintf("Uploaded %s: %s", kind, asset.Name))
+	SendJSON(c, 0, "", asset)
+}
+
+// storeImageAsset runs an image through ProcessImage and stores the main image
+// and every variant. The recorded size and MIME type are those of the stored
+// main image, not the upload.
+func storeImageAsset(asset *Asset, data []byte) error {
+	outputs, err := ProcessImage(data)
+	if err != nil {
+		return err
+	}
+
+	for _, out := range outputs {
+		key := fmt.Sprintf("assets/image/%s.%s", asset.ID, out.Extension)
+		if out.Name != "original" {
+			key = fmt.Sprintf("assets/image/%s_%s.%s", asset.ID, out.Name, out.Extension)
+		}
+		url, err := AppStorage.Put(key, bytes.NewReader(out.Data), out.MimeType)
+		if err != nil {
+			deleteAssetFiles(*asset)
+			return err
+		}
+		variant := ImageVariant{Name: out.Name, Width: out.Width, Height: out.Height, Size: int64(len(out.Data)), Key: key, URL: url}
+		asset.Variants = append(asset.Variants, variant)
+
+		switch out.Name {
+		case "original":
+			asset.Key = key
+			asset.URL = url
+			asset.MimeType = out.MimeType
+			asset.Size = variant.Size
+			asset.Width = out.Width
+			asset.Height = out.Height
+		case "thumb":
+			asset.ThumbnailURL = url
+		}
+	}
+	asset.SrcSet = SrcSet(asset.Variants)
+	return nil
+}
+
+// deleteAssetFiles removes the stored file of an asset and all its variants
+func deleteAssetFiles(asset Asset) {
+	if asset.Key != "" {
+		AppStorage.Delete(asset.Key)
+	}
+	for _, v := range asset.Variants {
+		if v.Key != asset.Key {
+			AppStorage.Delete(v.Key)
+		}
+	}
+}
+
+func GetAsset(c *gin.Context) {
+	var asset Asset
+	if err := TenantDB(c).First(&asset, "id = ?", c.Param("id")).Error; err != nil {
+		SendJSON(c, 1, "Asset not found", nil)
+		return
+	}
+	SendJSON(c, 0, "", asset)
+}
+
+// resolveAsset looks up an asset referenced by ID, optionally requiring a kind
+func resolveAsset(db *gorm.DB, id, kind string) (Asset, error) {
+	var asset Asset
+	if err := db.First(&asset, "id = ?", id).Error; err != nil {
+		return asset, fmt.Errorf("asset %s not found", id)
+	}
+	if kind != "" && asset.Kind != kind {
+		return asset, fmt.Errorf("asset %s is not of kind %s", id, kind)
+	}
+	return asset, nil
+}
+
+// sandboxUploads keeps uploaded files served from our origin from running
+// script or being sniffed as another type
+func sandboxUploads(c *gin.Context) {
+	c.Header("Content-Security-Policy", "default-src 'none'; img-src 'self'; style-src 'unsafe-inline'; sandbox")
+	c.Header("X-Content-Type-Options", "nosniff")
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadAsset(t *testing.T) {
+	prev := AppStorage
+	defer func() { AppStorage = prev }()
+	s, _ := NewLocalStorage(t.TempDir(), "/uploads/")
+	AppStorage = s
+	DB.Exec("DELETE FROM assets")
+	pngData := testPNG(800, 600)
+
+	r := gin.Default()
+	r.Use(func(c *gin.Context) {
+		c.Set("userId", "t1")
+		c.Set("role", RoleTeacher)
+	})
+	r.POST("/uploads", UploadAsset)
+	r.POST("/questions", CreateQuestion)
+	r.POST("/resources", CreateResource)
+
+	upload := func(filename string, data []byte) (int, Asset) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", filename)
+		fw.Write(data)
+		mw.Close()
+
+		req, _ := http.NewRequest("POST", "/uploads", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var asset Asset
+		resp := struct {
+			Code int `json:"code"`
+			Data any `json:"data"`
+		}{Data: &asset}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Code, asset
+	}
+
+	tests := []struct {
+		name     string
+		filename string
+		data     []byte
+		wantCode int
+		wantKind string
+	}{
+		{"PNG image", "a.png", pngData, 0, "image"},
+		{"PDF document", "a.pdf", []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n"), 0, "pdf"},
+		{"Script renamed to png is rejected", "evil.png", []byte("<script>alert(1)</script>"), 1, ""},
+		{"Plain text is rejected", "notes.txt", []byte("hello"), 1, ""},
+		{"Oversized image is rejected", "big.png", append(append([]byte{}, pngData...), make([]byte, 10*mb)...), 1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, asset := upload(tt.filename, tt.data)
+			assert.Equal(t, tt.wantCode, code)
+			if tt.wantCode == 0 {
+				assert.Equal(t, tt.wantKind, asset.Kind)
+				assert.True(t, strings.HasPrefix(asset.URL, "/uploads/assets/"+tt.wantKind+"/"))
+			}
+			if tt.wantKind == "image" {
+				assert.Equal(t, 800, asset.Width)
+				assert.Len(t, asset.Variants, 4) // original, w320, w640, thumb
+				assert.Contains(t, asset.SrcSet, " 320w, ")
+				assert.NotEmpty(t, asset.ThumbnailURL)
+			}
+		})
+	}
+
+	// Questions and resources reference uploaded assets by ID
+	_, img := upload("stem.png", pngData)
+	pdfData := append([]byte("%PDF-1.4\n"), bytes.Repeat([]byte("0 0 m\n"), 2*sniffLen)...)
+	_, doc := upload("sheet.pdf", pdfData)
+	stored, err := os.ReadFile(filepath.Join(s.Dir, "assets", "pdf", doc.ID+".pdf"))
+	assert.NoError(t, err)
+	assert.Equal(t, pdfData, stored, "streamed past the sniffed head")
+	assert.Equal(t, int64(len(pdfData)), doc.Size)
+
+	post := func(url string, payload any, out any) int {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", url, bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		resp := struct {
+			Code int `json:"code"`
+			Data any `json:"data"`
+		}{Data: out}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Code
+	}
+
+	var q Question
+	code := post("/questions", map[string]any{
+		"subject": "MATH", "type": "MULTIPLE_CHOICE", "stemText": "Which?", "answer": "A",
+		"stemAssetId": img.ID,
+		"options":     []map[string]any{{"value": "A", "text": "a", "assetId": img.ID}},
+	}, &q)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, img.URL, q.StemImage)
+	assert.Equal(t, img.URL, q.Options[0].Image)
+	assert.Equal(t, img.SrcSet, q.StemSrcSet)
+
+	code = post("/questions", map[string]any{
+		"subject": "MATH", "type": "MULTIPLE_CHOICE", "stemText": "Which?", "answer": "A",
+		"stemAssetId": doc.ID,
+	}, nil)
+	assert.Equal(t, 1, code, "a pdf cannot be used as a stem image")
+
+	var res Resource
+	code = post("/resources", map[string]any{"name": "Worksheet", "assetId": doc.ID}, &res)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, doc.URL, res.URL)
+	assert.Equal(t, "pdf", res.Type)
+}
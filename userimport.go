@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"gorm.io/gorm"
+)
+
+// Whole classes are onboarded from a spreadsheet with the columns name,
+// username, grade and class. Every row is validated first; only a file
+// without errors creates accounts, all in one transaction.
+
+const (
+	maxImportSize = 5 * mb
+	maxImportRows = 2000
+)
+
+// importColumns maps accepted header names to fields
+var importColumns = map[string]string{
+	"name": "name", "姓名": "name",
+	"username": "username", "用户名": "username", "账号": "username", "手机号": "username",
+	"grade": "grade", "年级": "grade",
+	"class": "class", "班级": "class",
+}
+
+var importUsernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.@-]{3,64}$`)
+
+type importRow struct {
+	Row      int    `json:"row"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Grade    int    `json:"grade,omitempty"`
+	Class    string `json:"class,omitempty"`
+	// "existing" or "new" when the row names a class
+	ClassAction string `json:"classAction,omitempty"`
+}
+
+type importError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// importCredential is one line of the credential sheet handed out to students
+type importCredential struct {
+	Row      int    `json:"row"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Class    string `json:"class,omitempty"`
+}
+
+// readImportTable returns the rows of a CSV or the first sheet of an XLSX file
+func readImportTable(filename string, data []byte) ([][]string, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == ".xlsx" || (ext != ".csv" && bytes.HasPrefix(data, []byte("PK\x03\x04"))) {
+		f, err := excelize.OpenReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid XLSX file: %v", err)
+		}
+		defer f.Close()
+		sheets := f.GetSheetList()
+		if len(sheets) == 0 {
+			return nil, errors.New("The workbook has no sheets")
+		}
+		return f.GetRows(sheets[0])
+	}
+
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	// Excel on Chinese Windows saves CSV as GB18030
+	if !utf8.Valid(data) {
+		decoded, err := simplifiedchinese.GB18030.NewDecoder().Bytes(data)
+		if err != nil {
+			return nil, errors.New("The CSV file is neither UTF-8 nor GB18030")
+		}
+		data = decoded
+	}
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CSV file: %v", err)
+	}
+	return rows, nil
+}
+
+// parseImportRows validates the table against the database. Row numbers are
+// the spreadsheet's own, so the header is row 1.
+func parseImportRows(db *gorm.DB, table [][]string, userID, role string) ([]importRow, []importError, map[string]*Class) {
+	var errs []importError
+	if len(table) == 0 {
+		return nil, []importError{{Row: 1, Message: "The file is empty"}}, nil
+	}
+
+	columns := make(map[string]int)
+	for i, h := range table[0] {
+		if field, ok := importColumns[strings.ToLower(strings.TrimSpace(h))]; ok {
+			columns[field] = i
+		}
+	}
+	for _, field := range []string{"name", "username"} {
+		if _, ok := columns[field]; !ok {
+			errs = append(errs, importError{Row: 1, Field: field, Message: "Missing column: " + field})
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs, nil
+	}
+	if len(table)-1 > maxImportRows {
+		return nil, []importError{{Row: 1, Message: fmt.Sprintf("At most %d students can be imported at once", maxImportRows)}}, nil
+	}
+
+	cell := func(record []string, field string) string {
+		i, ok := columns[field]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var classes []Class
+	db.Find(&classes)
+	classByName := make(map[string]*Class)
+	for i := range classes {
+		classByName[strings.ToLower(strings.TrimSpace(classes[i].Name))] = &classes[i]
+	}
+	newClasses := make(map[string]*Class)
+
+	rows := make([]importRow, 0, len(table)-1)
+	seen := make(map[string]int)
+	for i, record := range table[1:] {
+		rowNum := i + 2
+		row := importRow{
+			Row:      rowNum,
+			Name:     cell(record, "name"),
+			Username: cell(record, "username"),
+			Class:    cell(record, "class"),
+		}
+		if row.Name == "" && row.Username == "" && cell(record, "grade") == "" && row.Class == "" {
+			continue // blank line
+		}
+		fail := func(field, msg string) {
+			errs = append(errs, importError{Row: rowNum, Field: field, Message: msg})
+		}
+
+		if row.Name == "" {
+			fail("name", "Name is required")
+		} else if utf8.RuneCountInString(row.Name) > 64 {
+			fail("name", "Name is longer than 64 characters")
+		}
+
+		key := strings.ToLower(row.Username)
+		switch {
+		case row.Username == "":
+			fail("username", "Username is required")
+		case !importUsernamePattern.MatchString(row.Username):
+			fail("username", "Username must be 3-64 letters, digits or _.@-")
+		case seen[key] != 0:
+			fail("username", fmt.Sprintf("Duplicate of row %d", seen[key]))
+		default:
+			seen[key] = rowNum
+			// Usernames are unique across all schools, not just this one
+			var count int64
+			DB.Model(&User{}).Where("LOWER(username) = ?", key).Count(&count)
+			if count > 0 {
+				fail("username", "Username already exists")
+			}
+		}
+
+		if g := cell(record, "grade"); g != "" {
+			grade, err := strconv.Atoi(strings.TrimSuffix(g, "年级"))
+			if err != nil || grade < 1 || grade > 12 {
+				fail("grade", "Grade must be a number from 1 to 12")
+			}
+			row.Grade = grade
+		}
+
+		if row.Class != "" {
+			name := strings.ToLower(row.Class)
+			if cls, ok := classByName[name]; ok {
+				row.ClassAction = "existing"
+				if !canManageClass(userID, role, *cls) {
+					fail("class", "You do not teach class "+cls.Name)
+				} else if row.Grade != 0 && cls.Grade != 0 && cls.Grade != row.Grade {
+					fail("grade", fmt.Sprintf("Class %s is grade %d", cls.Name, cls.Grade))
+				}
+			} else {
+				row.ClassAction = "new"
+				if cls, ok := newClasses[name]; ok {
+					if row.Grade != 0 && cls.Grade != 0 && cls.Grade != row.Grade {
+						fail("grade", fmt.Sprintf("Class %s is grade %d in an earlier row", cls.Name, cls.Grade))
+					}
+				} else {
+					newClasses[name] = &Class{Name: row.Class, Grade: row.Grade}
+				}
+			}
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 && len(errs) == 0 {
+		errs = append(errs, importError{Row: 1, Message: "The file has no students"})
+	}
+
+	for name, cls := range newClasses {
+		classByName[name] = cls
+	}
+	return rows, errs, classByName
+}
+
+// generatePassword returns a random initial password that meets the policy,
+// without characters that are easy to misread on a printed sheet
+func generatePassword(policy PasswordPolicy, username string) (string, error) {
+	alphabet := "abcdefghjkmnpqrstuvwxyz23456789"
+	if policy.RequireMixedCase {
+		alphabet += "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	}
+	if policy.RequireSymbol {
+		alphabet += "#%+=?@"
+	}
+	length := policy.MinLength
+	if length < 8 {
+		length = 8
+	}
+	for i := 0; i < 100; i++ {
+		pw, err := randomCode(alphabet, length)
+		if err != nil {
+			return "", err
+		}
+		if policy.Check(pw, username) == nil {
+			return pw, nil
+		}
+	}
+	return "", errors.New("Could not generate a password for the current policy")
+}
+
+// hashImportPasswords hashes in parallel; bcrypt dominates the cost of a large import
+func hashImportPasswords(passwords []string) ([]string, error) {
+	hashes := make([]string, len(passwords))
+	errs := make([]error, len(passwords))
+	sem := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for i, pw := range passwords {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, pw string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			h, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+			hashes[i], errs[i] = string(h), err
+		}(i, pw)
+	}
+	wg.Wait()
+	return hashes, errors.Join(errs...)
+}
+
+// ImportUsers creates student accounts from an uploaded CSV or XLSX file
+// (multipart field "file"). With dryRun=true it only reports what would
+// happen. The response of a real import holds the generated initial
+// passwords; they are not stored anywhere else.
+func ImportUsers(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize+mb)
+	fh, err := c.FormFile("file")
+	if err != nil {
+		SendJSON(c, 1, "A CSV or XLSX file is required", nil)
+		return
+	}
+	if fh.Size > maxImportSize {
+		SendJSON(c, 1, fmt.Sprintf("File is larger than %d MB", maxImportSize/mb), nil)
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		SendJSON(c, 1, "Failed to read file", nil)
+		return
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		SendJSON(c, 1, "Failed to read file", nil)
+		return
+	}
+
+	table, err := readImportTable(fh.Filename, data)
+	if err != nil {
+		SendJSON(c, 1, err.Error(), nil)
+		return
+	}
+	userId, _ := c.Get("userId")
+	role, _ := c.Get("role")
+	uid, roleName := fmt.Sprintf("%v", userId), fmt.Sprintf("%v", role)
+	rows, rowErrs, classes := parseImportRows(TenantDB(c), table, uid, roleName)
+
+	dryRun, _ := strconv.ParseBool(c.DefaultQuery("dryRun", c.PostForm("dryRun")))
+	result := gin.H{
+		"dryRun": dryRun,
+		"total":  len(rows),
+		"rows":   rows,
+		"errors": rowErrs,
+	}
+	if rowErrs == nil {
+		result["errors"] = []importError{}
+	}
+	if dryRun {
+		SendJSON(c, 0, "", result)
+		return
+	}
+	if len(rowErrs) > 0 {
+		SendJSON(c, 1, fmt.Sprintf("%d problems found, nothing was imported", len(rowErrs)), result)
+		return
+	}
+
+	policy := loadSystemSettings(TenantDB(c)).PasswordPolicy
+	passwords := make([]string, len(rows))
+	for i, row := range rows {
+		if passwords[i], err = generatePassword(policy, row.Username); err != nil {
+			SendJSON(c, 1, err.Error(), nil)
+			return
+		}
+	}
+	hashes, err := hashImportPasswords(passwords)
+	if err != nil {
+		SendJSON(c, 1, "Failed to hash passwords", nil)
+		return
+	}
+
+	now := time.Now()
+	credentials := make([]importCredential, 0, len(rows))
+	err = TenantDB(c).Transaction(func(tx *gorm.DB) error {
+		for i, row := range rows {
+			user := User{
+				ID:                 strconv.FormatInt(now.UnixNano()+int64(i), 36),
+				Username:           row.Username,
+				Name:               row.Name,
+				Password:           hashes[i],
+				Role:               RoleStudent,
+				Status:             "active",
+				Grade:              row.Grade,
+				MustChangePassword: true,
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return fmt.Errorf("row %d: %w", row.Row, err)
+			}
+			if row.Class != "" {
+				cls := classes[strings.ToLower(row.Class)]
+				cls.StudentIDs = append(cls.StudentIDs, user.ID)
+			}
+			credentials = append(credentials, importCredential{
+				Row: row.Row, ID: user.ID, Name: user.Name, Username: user.Username,
+				Password: passwords[i], Class: row.Class,
+			})
+		}
+
+		saved := make(map[*Class]bool)
+		for _, row := range rows {
+			cls := classes[strings.ToLower(row.Class)]
+			if row.Class == "" || saved[cls] {
+				continue
+			}
+			saved[cls] = true
+			if cls.ID == "" {
+				cls.ID = strconv.FormatInt(time.Now().UnixNano()+int64(len(saved)), 36)
+				cls.CreatedAt = now.Format(timeLayout)
+				cls.TeacherIDs = []string{}
+				if roleName == string(RoleTeacher) {
+					cls.TeacherIDs = []string{uid}
+				}
+				if err := tx.Create(cls).Error; err != nil {
+					return err
+				}
+			} else if err := tx.Save(cls).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("user import: %v", err)
+		SendJSON(c, 1, "Import failed, nothing was imported", nil)
+		return
+	}
+
+	AddAuditLog(c, "IMPORT_USERS", fmt.Sprintf("Imported %d students from %s", len(credentials), fh.Filename))
+	result["credentials"] = credentials
+	SendJSON(c, 0, "", result)
+}
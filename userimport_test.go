@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"gorm.io/gorm"
+)
+
+type importResult struct {
+	Rows        []importRow        `json:"rows"`
+	Errors      []importError      `json:"errors"`
+	Credentials []importCredential `json:"credentials"`
+}
+
+func postImport(t *testing.T, userID string, role Role, filename string, data []byte, dryRun bool) (int, string, importResult) {
+	r := gin.Default()
+	r.Use(func(c *gin.Context) {
+		c.Set("userId", userID)
+		c.Set("role", string(role))
+	})
+	r.POST("/users/import", ImportUsers)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", filename)
+	fw.Write(data)
+	if dryRun {
+		mw.WriteField("dryRun", "true")
+	}
+	mw.Close()
+	req, _ := http.NewRequest("POST", "/users/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var result importResult
+	resp := struct {
+		Code int    `json:"code"`
+		Err  string `json:"err"`
+		Data any    `json:"data"`
+	}{Data: &result}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("bad response %s: %v", w.Body.String(), err)
+	}
+	return resp.Code, resp.Err, result
+}
+
+func resetImportTables() {
+	DB.Exec("DELETE FROM users")
+	DB.Exec("DELETE FROM classes")
+	DB.Create(&User{ID: "t1", Username: "teacher1", Role: RoleTeacher, Status: "active"})
+	DB.Create(&User{ID: "old", Username: "taken", Role: RoleStudent, Status: "active"})
+	DB.Create(&User{ID: "other", TenantID: "school2", Username: "elsewhere", Role: RoleStudent, Status: "active"})
+	DB.Create(&Class{ID: "c1", Name: "1A", Grade: 1, TeacherIDs: []string{"t1"}, StudentIDs: []string{}})
+	DB.Create(&Class{ID: "c2", Name: "2B", Grade: 2, TeacherIDs: []string{"t2"}, StudentIDs: []string{}})
+}
+
+func TestImportUsersDryRunReportsRowErrors(t *testing.T) {
+	resetImportTables()
+	csv := "姓名,用户名,年级,班级\n" +
+		"小明,xiaoming,1,1A\n" +
+		",noname,1,1A\n" +
+		"小红,no spaces,1,1A\n" +
+		"小刚,XiaoMing,1,1A\n" +
+		"小李,taken,1,\n" +
+		"小王,xiaowang,十三,\n" +
+		"小张,xiaozhang,2,2B\n" +
+		"小赵,xiaozhao,2,1A\n" +
+		",,,\n" +
+		"小陈,xiaochen,3年级,3C\n" +
+		"小周,Elsewhere,1,\n"
+
+	code, _, result := postImport(t, "t1", RoleTeacher, "students.csv", []byte(csv), true)
+	assert.Equal(t, 0, code)
+
+	tests := []struct {
+		row   int
+		field string
+	}{
+		{3, "name"},
+		{4, "username"},
+		{5, "username"}, // duplicate of row 2, case-insensitive
+		{6, "username"}, // already exists
+		{7, "grade"},
+		{8, "class"},     // t1 does not teach 2B
+		{9, "grade"},     // 1A is grade 1
+		{12, "username"}, // taken in another school
+	}
+	assert.Len(t, result.Errors, len(tests))
+	for i, tt := range tests {
+		if i < len(result.Errors) {
+			assert.Equal(t, tt.row, result.Errors[i].Row)
+			assert.Equal(t, tt.field, result.Errors[i].Field, "row %d", tt.row)
+		}
+	}
+	assert.Len(t, result.Rows, 10, "the blank line is skipped")
+	assert.Equal(t, "existing", result.Rows[0].ClassAction)
+	last := result.Rows[len(result.Rows)-2]
+	assert.Equal(t, 3, last.Grade)
+	assert.Equal(t, "new", last.ClassAction)
+
+	// A file with errors imports nothing, even without a dry run
+	code, errMsg, _ := postImport(t, "t1", RoleTeacher, "students.csv", []byte(csv), false)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, errMsg, "nothing was imported")
+	var count int64
+	DB.Model(&User{}).Count(&count)
+	assert.Equal(t, int64(3), count)
+}
+
+func TestImportUsersCreatesAccountsAndClasses(t *testing.T) {
+	resetImportTables()
+	csv := "\xef\xbb\xbfname,username,grade,class\n" +
+		"小明,xiaoming,1,1A\n" +
+		"小红,xiaohong,1,1A\n" +
+		"小刚,xiaogang,3,3C\n"
+
+	code, errMsg, result := postImport(t, "t1", RoleTeacher, "students.csv", []byte(csv), false)
+	assert.Equal(t, 0, code, errMsg)
+	assert.Len(t, result.Credentials, 3)
+
+	policy := loadSystemSettings(DB).PasswordPolicy
+	for _, cred := range result.Credentials {
+		var user User
+		assert.NoError(t, DB.First(&user, "id = ?", cred.ID).Error)
+		assert.Equal(t, RoleStudent, user.Role)
+		assert.True(t, user.MustChangePassword)
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(cred.Password)))
+		assert.NoError(t, policy.Check(cred.Password, cred.Username))
+	}
+
+	var class1A Class
+	DB.First(&class1A, "id = ?", "c1")
+	assert.ElementsMatch(t, []string{result.Credentials[0].ID, result.Credentials[1].ID}, class1A.StudentIDs)
+
+	var class3C Class
+	assert.NoError(t, DB.First(&class3C, "name = ?", "3C").Error)
+	assert.Equal(t, 3, class3C.Grade)
+	assert.Equal(t, []string{"t1"}, class3C.TeacherIDs, "the importing teacher teaches new classes")
+	assert.Equal(t, []string{result.Credentials[2].ID}, class3C.StudentIDs)
+}
+
+func TestImportUsersHidesDatabaseErrors(t *testing.T) {
+	resetImportTables()
+	DB.Callback().Create().Before("gorm:create").Register("test:fail_import", func(tx *gorm.DB) {
+		if tx.Statement.Table == "classes" {
+			tx.AddError(errors.New("disk full at /var/lib/mysql"))
+		}
+	})
+	defer DB.Callback().Create().Remove("test:fail_import")
+
+	csv := "name,username,grade,class\n小刚,xiaogang,3,3C\n"
+	code, errMsg, _ := postImport(t, "t1", RoleTeacher, "students.csv", []byte(csv), false)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "Import failed, nothing was imported", errMsg)
+	var count int64
+	DB.Model(&User{}).Where("username = ?", "xiaogang").Count(&count)
+	assert.Zero(t, count)
+}
+
+func TestImportUsersReadsXLSXAndGB18030(t *testing.T) {
+	resetImportTables()
+
+	f := excelize.NewFile()
+	f.SetSheetRow("Sheet1", "A1", &[]any{"姓名", "账号", "年级"})
+	f.SetSheetRow("Sheet1", "A2", &[]any{"小明", "xiaoming", 4})
+	var xlsx bytes.Buffer
+	assert.NoError(t, f.Write(&xlsx))
+
+	code, errMsg, result := postImport(t, "1", RoleAdmin, "students.xlsx", xlsx.Bytes(), true)
+	assert.Equal(t, 0, code, errMsg)
+	if assert.Len(t, result.Rows, 1) {
+		assert.Equal(t, importRow{Row: 2, Name: "小明", Username: "xiaoming", Grade: 4}, result.Rows[0])
+	}
+
+	gbk, err := simplifiedchinese.GB18030.NewEncoder().Bytes([]byte("姓名,用户名\n小红,xiaohong\n"))
+	assert.NoError(t, err)
+	code, errMsg, result = postImport(t, "1", RoleAdmin, "students.csv", gbk, true)
+	assert.Equal(t, 0, code, errMsg)
+	if assert.Len(t, result.Rows, 1) {
+		assert.Equal(t, "小红", result.Rows[0].Name)
+	}
+
+	code, _, result = postImport(t, "1", RoleAdmin, "students.csv", []byte("full name,login\nA,b\n"), true)
+	assert.Equal(t, 0, code)
+	assert.Len(t, result.Errors, 2, "both required columns are reported missing")
+}
@@ -21,6 +21,9 @@ import Permissions from './views/Admin/Permissions';
 import AuditLogs from './views/Admin/AuditLogs';
 import HomeworkAudit from './views/Admin/HomeworkAudit';
 import SystemConfig from './views/Admin/SystemConfig';
+import Children from './views/Parent/Children';
+import Tenants from './views/Super/Tenants';
+import { CardLogin, PictureLogin } from './views/Student/QuickLogin';
 import Help from './views/Help';
 import Layout from './components/Layout';
 
@@ -28,6 +31,8 @@ interface AuthContextType {
   user: User | null;
   permissions: any[];
   login: (username: string, password: string) => Promise<boolean>;
+  startSession: (result: { user: User; token: string; refreshToken: string }) => Promise<void>;
+  loginWithSSO: (code: string) => Promise<boolean>;
   logout: () => void;
   updateUser: (user: Partial<User>) => void;
 }
@@ -59,13 +64,14 @@ const App: React.FC = () => {
     if (savedUser) {
       try {
          const parsed = JSON.parse(savedUser);
-         if (isTokenExpired(parsed.token)) {
+         // An expired access token is renewed on the first request if a refresh token is kept
+         if (isTokenExpired(parsed.token) && !parsed.refreshToken) {
            localStorage.removeItem('user');
            setUser(null);
          } else {
            setUser(parsed);
-           // Fetch permissions on restore
-           api.me.getPermissions().then(perms => {
+           // Fetch permissions on restore; blocked until a required password change is done
+           if (!parsed.mustChangePassword) api.me.getPermissions().then(perms => {
              setPermissions(perms);
            }).catch(err => console.error("Restore permissions error", err));
          }
@@ -85,17 +91,22 @@ const App: React.FC = () => {
     localStorage.setItem('theme', themeMode);
   }, [themeMode]);
 
-  const login = async (username: string, password: string): Promise<boolean> => {
-    try {
-      const { user, token } = await api.auth.login(username, password);
-      const userWithToken = { ...user, token };
-      setUser(userWithToken);
-      localStorage.setItem('user', JSON.stringify(userWithToken));
-      
-      // Fetch permissions on login
+  // Keeps the tokens of any successful login: password, login card or picture password
+  const startSession = async ({ user, token, refreshToken }: { user: User; token: string; refreshToken: string }) => {
+    const userWithToken = { ...user, token, refreshToken };
+    setUser(userWithToken);
+    localStorage.setItem('user', JSON.stringify(userWithToken));
+    
+    // Fetch permissions on login, or once the required password change is done
+    if (!user.mustChangePassword) {
       const perms = await api.me.getPermissions();
       setPermissions(perms);
-      
+    }
+  };
+
+  const login = async (username: string, password: string): Promise<boolean> => {
+    try {
+      await startSession(await api.auth.login(username, password));
       return true;
     } catch (error) {
       console.error("Login failed", error);
@@ -103,7 +114,23 @@ const App: React.FC = () => {
     }
   };
 
+  // Finishes a single sign-on: the handed-over one-time code is redeemed for the tokens
+  const loginWithSSO = async (code: string): Promise<boolean> => {
+    try {
+      await startSession(await api.auth.ssoExchange(code));
+      return true;
+    } catch (error) {
+      console.error("SSO login failed", error);
+      return false;
+    }
+  };
+
   const logout = () => {
+    // Tokens rotate in localStorage (see api.ts), the state copy may be stale
+    const saved = JSON.parse(localStorage.getItem('user') || 'null');
+    if (saved?.refreshToken) {
+      api.auth.logout(saved.refreshToken).catch(err => console.error("Logout error", err));
+    }
     setUser(null);
     setPermissions([]);
     localStorage.removeItem('user');
@@ -111,20 +138,26 @@ const App: React.FC = () => {
 
   const updateUser = (updatedFields: Partial<User>) => {
     if (user) {
-      const newUser = { ...user, ...updatedFields };
+      const saved = JSON.parse(localStorage.getItem('user') || 'null');
+      const newUser = { ...user, ...updatedFields, token: saved?.token ?? user.token, refreshToken: saved?.refreshToken ?? user.refreshToken };
       setUser(newUser);
       localStorage.setItem('user', JSON.stringify(newUser));
+      if (user.mustChangePassword && !newUser.mustChangePassword) {
+        api.me.getPermissions().then(setPermissions).catch(err => console.error("Permissions error", err));
+      }
     }
   };
 
   const effectiveDarkMode = getEffectiveDarkMode(themeMode);
 
   return (
-    <AuthContext.Provider value={{ user, permissions, login, logout, updateUser }}>
+    <AuthContext.Provider value={{ user, permissions, login, startSession, loginWithSSO, logout, updateUser }}>
       <HashRouter>
         <div className={`min-h-screen ${effectiveDarkMode ? 'dark bg-gray-900 text-white' : 'bg-gray-50 text-gray-900'}`}>
           <Routes>
             <Route path="/login" element={!user ? <Login language={language} /> : <Navigate to="/" />} />
+            <Route path="/card/:token" element={<CardLogin language={language} />} />
+            <Route path="/picture-login" element={<PictureLogin language={language} />} />
             
             <Route element={user ? (
               <Layout 
@@ -151,6 +184,8 @@ const App: React.FC = () => {
                   <Route path="/admin/audit" element={<HomeworkAudit language={language} />} />
                   <Route path="/admin/config" element={<SystemConfig language={language} />} />
                   <Route path="/help" element={<Help language={language} />} />
+                  <Route path="/children" element={<Children language={language} />} />
+                  <Route path="/tenants" element={<Tenants language={language} />} />
                 </Routes>
               </Layout>
             ) : <Navigate to="/login" />}>
@@ -0,0 +1,151 @@
+import React, { useState } from 'react';
+import { Upload, X, Printer, AlertTriangle } from 'lucide-react';
+import { api } from '../services/api.ts';
+import { ImportUsersResult } from '../types';
+
+interface ImportUsersModalProps {
+  isOpen: boolean;
+  onClose: (imported: boolean) => void;
+  language: 'zh' | 'en';
+}
+
+// A file is checked with a dry run first; only a file without problems can be
+// imported, after which the generated passwords are printed once
+const ImportUsersModal: React.FC<ImportUsersModalProps> = ({ isOpen, onClose, language }) => {
+  const [file, setFile] = useState<File | null>(null);
+  const [preview, setPreview] = useState<ImportUsersResult | null>(null);
+  const [imported, setImported] = useState<ImportUsersResult | null>(null);
+  const [error, setError] = useState('');
+  const [loading, setLoading] = useState(false);
+
+  if (!isOpen) return null;
+
+  const zh = language === 'zh';
+
+  const close = () => {
+    const done = !!imported;
+    setFile(null);
+    setPreview(null);
+    setImported(null);
+    setError('');
+    onClose(done);
+  };
+
+  const handleFile = async (f: File | null) => {
+    setFile(f);
+    setPreview(null);
+    setError('');
+    if (!f) return;
+    setLoading(true);
+    try {
+      setPreview(await api.admin.importUsers(f, true));
+    } catch (err: any) {
+      setError(err.message);
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const handleImport = async () => {
+    if (!file) return;
+    setLoading(true);
+    try {
+      setImported(await api.admin.importUsers(file, false));
+    } catch (err: any) {
+      setError(err.message);
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const handlePrint = () => {
+    const win = window.open('', '_blank', 'width=800,height=600');
+    if (!win || !imported?.credentials) return;
+    const escape = (v: string) => v.replace(/[&<>"']/g, ch => `&#${ch.charCodeAt(0)};`);
+    const title = zh ? '学生账号' : 'Student Accounts';
+    const note = zh ? '首次登录后需要修改密码。' : 'The password must be changed at first login.';
+    const slips = imported.credentials.map(c => `
+      <div style="border:1px dashed #999;padding:12px;margin:8px;display:inline-block;width:300px;page-break-inside:avoid">
+        <p style="margin:0;font-weight:bold">${escape(c.name)}${c.class ? ` · ${escape(c.class)}` : ''}</p>
+        <p style="margin:4px 0">${zh ? '用户名' : 'Username'}: <b>${escape(c.username)}</b></p>
+        <p style="margin:4px 0">${zh ? '初始密码' : 'Password'}: <b style="font-family:monospace;font-size:18px">${escape(c.password)}</b></p>
+        <p style="margin:0;font-size:11px;color:#555">${note}</p>
+      </div>`).join('');
+    win.document.write(`<html><head><title>${title}</title></head><body style="font-family:sans-serif;padding:16px">${slips}</body></html>`);
+    win.document.close();
+    win.print();
+  };
+
+  const rowErrors = preview?.errors || [];
+
+  return (
+    <div className="fixed inset-0 z-[100] flex items-center justify-center p-4 bg-black/60 backdrop-blur-sm">
+      <div className="bg-white dark:bg-gray-800 w-full max-w-2xl rounded-3xl shadow-2xl overflow-hidden">
+        <div className="p-6 border-b dark:border-gray-700 flex justify-between items-center bg-primary-50 dark:bg-primary-950/20">
+          <h3 className="text-xl font-black text-primary-600 flex items-center gap-2">
+            <Upload className="w-5 h-5" />
+            {zh ? '批量导入学生' : 'Import Students'}
+          </h3>
+          <button onClick={close} className="p-2 hover:bg-gray-200 dark:hover:bg-gray-700 rounded-full transition-colors">
+            <X className="w-5 h-5 text-gray-500" />
+          </button>
+        </div>
+
+        <div className="p-6 space-y-4 max-h-[70vh] overflow-y-auto">
+          {error && <div className="p-3 rounded-lg text-sm font-bold bg-red-50 text-red-700">{error}</div>}
+
+          {imported ? (
+            <>
+              <div className="p-3 rounded-lg text-sm font-bold bg-green-50 text-green-700">
+                {zh ? `已创建 ${imported.credentials?.length || 0} 个账号。初始密码只显示这一次，请立即打印。` : `${imported.credentials?.length || 0} accounts created. The initial passwords are only shown now, print them.`}
+              </div>
+              <button
+                onClick={handlePrint}
+                className="w-full py-4 bg-primary-600 hover:bg-primary-700 text-white rounded-2xl font-black flex items-center justify-center gap-2"
+              >
+                <Printer className="w-5 h-5" />
+                {zh ? '打印账号单' : 'Print Credential Sheet'}
+              </button>
+            </>
+          ) : (
+            <>
+              <p className="text-sm text-gray-500 dark:text-gray-400">
+                {zh ? 'CSV 或 XLSX 文件，表头为：姓名、用户名、年级、班级' : 'A CSV or XLSX file with the columns name, username, grade, class'}
+              </p>
+              <input
+                type="file"
+                accept=".csv,.xlsx"
+                onChange={e => handleFile(e.target.files?.[0] || null)}
+                className="w-full text-sm dark:text-white"
+              />
+
+              {preview && (
+                <div className="space-y-2">
+                  <p className="text-sm font-bold dark:text-white">
+                    {zh ? `共 ${preview.total} 名学生，${rowErrors.length} 个问题` : `${preview.total} students, ${rowErrors.length} problems`}
+                  </p>
+                  {rowErrors.map((e, i) => (
+                    <div key={i} className="flex items-start gap-2 text-sm text-red-600">
+                      <AlertTriangle className="w-4 h-4 shrink-0 mt-0.5" />
+                      <span>{zh ? `第 ${e.row} 行` : `Row ${e.row}`}{e.field ? ` (${e.field})` : ''}: {e.message}</span>
+                    </div>
+                  ))}
+                </div>
+              )}
+
+              <button
+                onClick={handleImport}
+                disabled={loading || !preview || rowErrors.length > 0 || preview.total === 0}
+                className="w-full py-4 bg-primary-600 hover:bg-primary-700 text-white rounded-2xl font-black shadow-xl shadow-primary-600/30 transition-all disabled:opacity-50"
+              >
+                {zh ? '导入' : 'Import'}
+              </button>
+            </>
+          )}
+        </div>
+      </div>
+    </div>
+  );
+};
+
+export default ImportUsersModal;
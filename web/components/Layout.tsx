@@ -3,7 +3,7 @@ import React, { useState, useContext } from 'react';
 import { AuthContext } from '../App';
 import { Role } from '../types';
 import { Link, useLocation } from 'react-router-dom';
-import { Menu, X, Home, BookOpen, Clock, BarChart2, PlusCircle, Settings, Users, ClipboardList, Sun, Moon, Languages, ShieldCheck, FileText, AlertTriangle, HelpCircle, User as UserIcon, LogOut, Lock } from 'lucide-react';
+import { Menu, X, Home, BookOpen, Clock, BarChart2, PlusCircle, Settings, Users, ClipboardList, Sun, Moon, Languages, ShieldCheck, FileText, AlertTriangle, HelpCircle, User as UserIcon, LogOut, Lock, Building2 } from 'lucide-react';
 import { ProfileModal } from './ProfileModal';
 
 interface LayoutProps {
@@ -38,8 +38,17 @@ const Layout: React.FC<LayoutProps> = ({ children, language, setLanguage, themeM
       'permissions': { icon: Lock, label: '权限设置', labelEn: 'Permissions', path: '/permissions' },
       'help_docs': { icon: HelpCircle, label: '帮助文档', labelEn: 'Help', path: '/help' },
       'stats': { icon: BarChart2, label: '统计分析', labelEn: 'Stats', path: '/stats' },
+      'children': { icon: Users, label: '我的孩子', labelEn: 'My Children', path: '/children' },
     };
 
+    // Super-admins manage schools, which is not a permission module
+    if (auth?.user?.role === Role.SUPER_ADMIN) {
+      return [
+        { id: 'tenants', icon: Building2, label: '学校管理', labelEn: 'Schools', path: '/tenants' },
+        { id: 'help_docs', ...registry.help_docs },
+      ];
+    }
+
     // 2. Build the candidate list based on role-specific requirements
     let candidateItems: any[] = [];
     
@@ -48,7 +57,7 @@ const Layout: React.FC<LayoutProps> = ({ children, language, setLanguage, themeM
         { id: 'dashboard', ...registry.dashboard, label: '首页', labelEn: 'Home' },
         { id: 'assignments', icon: ClipboardList, label: '家庭作业', labelEn: 'Homework', path: '/homework' },
         { id: 'assignments', icon: Clock, label: '答题历史', labelEn: 'History', path: '/history' },
-        { id: 'dashboard', icon: AlertTriangle, label: '错题本', labelEn: 'Mistakes', path: '/wrong-book' },
+        { id: 'wrong_book', icon: AlertTriangle, label: '错题本', labelEn: 'Mistakes', path: '/wrong-book' },
         { id: 'stats', ...registry.stats },
         { id: 'help_docs', ...registry.help_docs },
         // Include others from registry in case they are enabled via backend
@@ -56,6 +65,11 @@ const Layout: React.FC<LayoutProps> = ({ children, language, setLanguage, themeM
           .filter(id => !['dashboard', 'assignments', 'stats', 'help_docs'].includes(id))
           .map(id => ({ id, ...registry[id] }))
       ];
+    } else if (auth?.user?.role === Role.PARENT) {
+      candidateItems = [
+        { id: 'children', ...registry.children },
+        { id: 'help_docs', ...registry.help_docs },
+      ];
     } else if (auth?.user?.role === Role.TEACHER) {
       candidateItems = [
         { id: 'dashboard', ...registry.dashboard },
@@ -251,12 +265,14 @@ const Layout: React.FC<LayoutProps> = ({ children, language, setLanguage, themeM
       </div>
 
       <ProfileModal 
-        isOpen={isProfileOpen}
+        isOpen={isProfileOpen || !!auth?.user?.mustChangePassword}
+        forcePasswordChange={!!auth?.user?.mustChangePassword}
         onClose={() => setIsProfileOpen(false)}
         currentUser={auth?.user}
         onUpdate={(updatedUser) => {
           auth?.updateUser({
-            name: updatedUser.name
+            name: updatedUser.name,
+            mustChangePassword: updatedUser.mustChangePassword
           });
         }}
       />
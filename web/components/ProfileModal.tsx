@@ -7,9 +7,11 @@ interface ProfileModalProps {
   onClose: () => void;
   currentUser: any;
   onUpdate: (user: any) => void;
+  // The account was given a password by an admin and must set its own before doing anything else
+  forcePasswordChange?: boolean;
 }
 
-export const ProfileModal: React.FC<ProfileModalProps> = ({ isOpen, onClose, currentUser, onUpdate }) => {
+export const ProfileModal: React.FC<ProfileModalProps> = ({ isOpen, onClose, currentUser, onUpdate, forcePasswordChange }) => {
   const [name, setName] = useState(currentUser?.name || '');
   const [password, setPassword] = useState('');
   const [confirmPassword, setConfirmPassword] = useState('');
@@ -31,6 +33,10 @@ export const ProfileModal: React.FC<ProfileModalProps> = ({ isOpen, onClose, cur
     e.preventDefault();
     setMessage({ type: '', text: '' });
 
+    if (forcePasswordChange && !password) {
+      setMessage({ type: 'error', text: '请先设置新密码' });
+      return;
+    }
     if (password && password !== confirmPassword) {
       setMessage({ type: 'error', text: '密码不一致' });
       return;
@@ -60,12 +66,19 @@ export const ProfileModal: React.FC<ProfileModalProps> = ({ isOpen, onClose, cur
             <UserIcon className="w-5 h-5 text-indigo-500" />
             个人设置
           </h3>
-          <button onClick={onClose} className="text-gray-400 hover:text-gray-600 transition-colors">
-            <X className="w-5 h-5" />
-          </button>
+          {!forcePasswordChange && (
+            <button onClick={onClose} className="text-gray-400 hover:text-gray-600 transition-colors">
+              <X className="w-5 h-5" />
+            </button>
+          )}
         </div>
 
         <form onSubmit={handleSubmit} className="p-6 space-y-4">
+          {forcePasswordChange && (
+            <div className="p-3 rounded-lg text-sm bg-amber-50 text-amber-700">
+              当前密码由管理员设置，请先修改密码后再继续使用
+            </div>
+          )}
           {message.text && (
             <div className={`p-3 rounded-lg text-sm ${
               message.type === 'success' ? 'bg-green-50 text-green-700' : 'bg-red-50 text-red-700'
@@ -126,6 +139,7 @@ export const ProfileModal: React.FC<ProfileModalProps> = ({ isOpen, onClose, cur
             <button
               type="button"
               onClick={onClose}
+              hidden={forcePasswordChange}
               className="flex-1 px-4 py-2 text-gray-700 bg-gray-100 hover:bg-gray-200 rounded-xl font-medium transition-all"
             >
               取消
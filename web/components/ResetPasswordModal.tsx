@@ -0,0 +1,132 @@
+import React, { useState } from 'react';
+import { KeyRound, X, Send } from 'lucide-react';
+import { api } from '../services/api.ts';
+
+interface ResetPasswordModalProps {
+  isOpen: boolean;
+  onClose: (username?: string) => void;
+  language: 'zh' | 'en';
+}
+
+// Students with a phone number get a code by SMS; younger students type in the
+// code their teacher printed for them
+const ResetPasswordModal: React.FC<ResetPasswordModalProps> = ({ isOpen, onClose, language }) => {
+  const [username, setUsername] = useState('');
+  const [code, setCode] = useState('');
+  const [password, setPassword] = useState('');
+  const [confirmPassword, setConfirmPassword] = useState('');
+  const [message, setMessage] = useState({ type: '', text: '' });
+  const [loading, setLoading] = useState(false);
+
+  if (!isOpen) return null;
+
+  const zh = language === 'zh';
+
+  const handleSendCode = async () => {
+    if (!username.trim()) return;
+    setMessage({ type: '', text: '' });
+    try {
+      await api.auth.requestPasswordReset(username.trim());
+      setMessage({ type: 'success', text: zh ? '如果该账号绑定了手机号，验证码已发送' : 'If the account has a phone number, a code has been sent' });
+    } catch (err: any) {
+      setMessage({ type: 'error', text: err.message });
+    }
+  };
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    if (password !== confirmPassword) {
+      setMessage({ type: 'error', text: zh ? '两次输入的密码不一致' : 'Passwords do not match' });
+      return;
+    }
+    setLoading(true);
+    try {
+      await api.auth.confirmPasswordReset(username.trim(), code, password);
+      setMessage({ type: 'success', text: zh ? '密码已重置，请使用新密码登录' : 'Password reset, please log in with the new password' });
+      setTimeout(() => onClose(username.trim()), 1500);
+    } catch (err: any) {
+      setMessage({ type: 'error', text: err.message });
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const inputClass = "w-full px-4 py-3 rounded-xl border dark:border-gray-700 dark:bg-gray-900 focus:ring-4 focus:ring-primary-500/20 focus:border-primary-500 transition-all outline-none font-bold dark:text-white";
+
+  return (
+    <div className="fixed inset-0 z-[100] flex items-center justify-center p-4 bg-black/60 backdrop-blur-sm">
+      <div className="bg-white dark:bg-gray-800 w-full max-w-md rounded-3xl shadow-2xl overflow-hidden">
+        <div className="p-6 border-b dark:border-gray-700 flex justify-between items-center bg-primary-50 dark:bg-primary-950/20">
+          <h3 className="text-xl font-black text-primary-600 flex items-center gap-2">
+            <KeyRound className="w-5 h-5" />
+            {zh ? '重置密码' : 'Reset Password'}
+          </h3>
+          <button onClick={() => onClose()} className="p-2 hover:bg-gray-200 dark:hover:bg-gray-700 rounded-full transition-colors">
+            <X className="w-5 h-5 text-gray-500" />
+          </button>
+        </div>
+
+        <form onSubmit={handleSubmit} className="p-6 space-y-4">
+          {message.text && (
+            <div className={`p-3 rounded-lg text-sm font-bold ${message.type === 'success' ? 'bg-green-50 text-green-700' : 'bg-red-50 text-red-700'}`}>
+              {message.text}
+            </div>
+          )}
+
+          <div className="flex gap-2">
+            <input
+              type="text"
+              value={username}
+              onChange={e => setUsername(e.target.value)}
+              className={inputClass}
+              placeholder={zh ? '用户名或手机号' : 'Username or phone number'}
+              required
+            />
+            <button
+              type="button"
+              onClick={handleSendCode}
+              className="shrink-0 px-4 rounded-xl bg-gray-100 dark:bg-gray-700 font-bold text-sm text-gray-600 dark:text-gray-200 hover:bg-gray-200 flex items-center gap-1"
+            >
+              <Send className="w-4 h-4" />
+              {zh ? '发送验证码' : 'Send Code'}
+            </button>
+          </div>
+          <input
+            type="text"
+            value={code}
+            onChange={e => setCode(e.target.value)}
+            className={`${inputClass} tracking-widest uppercase`}
+            placeholder={zh ? '验证码或老师给的重置码' : 'Code from SMS or your teacher'}
+            required
+          />
+          <input
+            type="password"
+            value={password}
+            onChange={e => setPassword(e.target.value)}
+            className={inputClass}
+            placeholder={zh ? '新密码' : 'New password'}
+            required
+          />
+          <input
+            type="password"
+            value={confirmPassword}
+            onChange={e => setConfirmPassword(e.target.value)}
+            className={inputClass}
+            placeholder={zh ? '再次输入新密码' : 'Re-enter new password'}
+            required
+          />
+
+          <button
+            type="submit"
+            disabled={loading}
+            className="w-full py-4 bg-primary-600 hover:bg-primary-700 text-white rounded-2xl font-black shadow-xl shadow-primary-600/30 transition-all disabled:opacity-50"
+          >
+            {zh ? '重置密码' : 'Reset Password'}
+          </button>
+        </form>
+      </div>
+    </div>
+  );
+};
+
+export default ResetPasswordModal;
@@ -1,4 +1,4 @@
-import { Question, User, Resource } from '../types';
+import { Question, User, Resource, AnswerCheckResult, PracticeSessionInfo, SessionAnswerResult, Class, Asset, LoginLockout, ImportUsersResult, Child, WeeklySummary, Tenant, LoginCard, StudentLoginStatus } from '../types';
 
 const isProd = typeof import.meta !== 'undefined' && import.meta.env && import.meta.env.PROD;
 const API_URL = isProd
@@ -14,6 +14,37 @@ const getHeaders = () => {
   };
 };
 
+// Access tokens are short-lived: on a 401 the refresh token is exchanged once
+// for a new pair and the request is retried
+let refreshing: Promise<boolean> | null = null;
+
+const refreshSession = (): Promise<boolean> => {
+  if (!refreshing) {
+    refreshing = (async () => {
+      const userStr = localStorage.getItem('user');
+      const saved = userStr ? JSON.parse(userStr) : null;
+      if (!saved?.refreshToken) return false;
+      const res = await fetch(`${API_URL}/auth/refresh`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refreshToken: saved.refreshToken }),
+      });
+      const result = await res.json().catch(() => null);
+      if (!result || result.code !== 0) return false;
+      localStorage.setItem('user', JSON.stringify({ ...saved, token: result.data.token, refreshToken: result.data.refreshToken }));
+      return true;
+    })().finally(() => { refreshing = null; });
+  }
+  return refreshing;
+};
+
+const authFetch = async (url: string, init: RequestInit = {}): Promise<Response> => {
+  const res = await fetch(url, init);
+  if (res.status !== 401 || !(await refreshSession())) return res;
+  const headers = { ...(init.headers as Record<string, string>), Authorization: getHeaders().Authorization };
+  return fetch(url, { ...init, headers });
+};
+
 const handleResponse = async (res: Response) => {
   if (res.status === 401) {
     localStorage.removeItem('user');
@@ -37,7 +68,7 @@ const handleResponse = async (res: Response) => {
 
 export const api = {
   auth: {
-    login: async (username: string, password: string): Promise<{ user: User; token: string }> => {
+    login: async (username: string, password: string): Promise<{ user: User; token: string; refreshToken: string; expiresIn: number }> => {
       const res = await fetch(`${API_URL}/auth/login`, {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
@@ -45,18 +76,90 @@ export const api = {
       });
       return handleResponse(res);
     },
-    register: async (phoneNumber: string, password: string): Promise<any> => {
+    // Single sign-on is a full page visit; the callback comes back to #/login?sso=<one-time code>
+    ssoLoginUrl: `${API_URL}/auth/sso/login`,
+    ssoExchange: async (code: string): Promise<{ user: User; token: string; refreshToken: string; expiresIn: number }> => {
+      const res = await fetch(`${API_URL}/auth/sso/exchange`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ code }),
+      });
+      return handleResponse(res);
+    },
+    refresh: async (refreshToken: string): Promise<{ token: string; refreshToken: string; expiresIn: number }> => {
+      const res = await fetch(`${API_URL}/auth/refresh`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refreshToken }),
+      });
+      return handleResponse(res);
+    },
+    // Young students: a QR card token, or a name on the class roster plus a picture sequence
+    cardLogin: async (token: string): Promise<{ user: User; token: string; refreshToken: string; expiresIn: number }> => {
+      const res = await fetch(`${API_URL}/auth/card-login`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ token }),
+      });
+      return handleResponse(res);
+    },
+    roster: async (code: string): Promise<{ className: string; students: { id: string; name: string }[]; pictureCount: number; sequenceLength: number }> => {
+      const res = await fetch(`${API_URL}/auth/roster?code=${encodeURIComponent(code)}`, { headers: { 'Content-Type': 'application/json' } });
+      return handleResponse(res);
+    },
+    pictureLogin: async (rosterCode: string, studentId: string, pictures: number[]): Promise<{ user: User; token: string; refreshToken: string; expiresIn: number }> => {
+      const res = await fetch(`${API_URL}/auth/picture-login`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ rosterCode, studentId, pictures }),
+      });
+      return handleResponse(res);
+    },
+    logout: async (refreshToken: string): Promise<void> => {
+      await fetch(`${API_URL}/auth/logout`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refreshToken }),
+      });
+    },
+    logoutAll: async (): Promise<void> => {
+      const res = await authFetch(`${API_URL}/auth/logout-all`, {
+        method: 'POST',
+        headers: getHeaders(),
+      });
+      return handleResponse(res);
+    },
+    requestPasswordReset: async (username: string): Promise<void> => {
+      const res = await fetch(`${API_URL}/auth/password-reset/request`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ username }),
+      });
+      return handleResponse(res);
+    },
+    confirmPasswordReset: async (username: string, code: string, password: string): Promise<void> => {
+      const res = await fetch(`${API_URL}/auth/password-reset/confirm`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ username, code, password }),
+      });
+      return handleResponse(res);
+    },
+    // With an invite code the account becomes a parent linked to the invited student;
+    // tenant is the school code and may be left out for the default school
+    register: async (phoneNumber: string, password: string, inviteCode?: string, tenant?: string): Promise<any> => {
       const res = await fetch(`${API_URL}/auth/register`, {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({ phoneNumber, password }),
+        body: JSON.stringify({ phoneNumber, password, inviteCode, tenant }),
       });
       return handleResponse(res);
     },
   },
   config: {
-    getPublic: async (): Promise<any> => {
-      const res = await fetch(`${API_URL}/config/public`, { headers: { 'Content-Type': 'application/json' } });
+    getPublic: async (tenant?: string): Promise<any> => {
+      const params = tenant ? `?tenant=${encodeURIComponent(tenant)}` : '';
+      const res = await fetch(`${API_URL}/config/public${params}`, { headers: { 'Content-Type': 'application/json' } });
       return handleResponse(res);
     }
   },
@@ -68,11 +171,11 @@ export const api = {
       if (params.subject) urlParams.append('subject', params.subject);
       if (params.grade) urlParams.append('grade', params.grade.toString());
       
-      const res = await fetch(`${API_URL}/questions?${urlParams.toString()}`, { headers: getHeaders() });
+      const res = await authFetch(`${API_URL}/questions?${urlParams.toString()}`, { headers: getHeaders() });
       return handleResponse(res);
     },
     create: async (data: Partial<Question>): Promise<Question> => {
-      const res = await fetch(`${API_URL}/questions`, {
+      const res = await authFetch(`${API_URL}/questions`, {
         method: 'POST',
         headers: getHeaders(),
         body: JSON.stringify(data),
@@ -80,7 +183,7 @@ export const api = {
       return handleResponse(res);
     },
     bulkCreate: async (data: Partial<Question>[]): Promise<{ imported: number }> => {
-      const res = await fetch(`${API_URL}/questions/bulk`, {
+      const res = await authFetch(`${API_URL}/questions/bulk`, {
         method: 'POST',
         headers: getHeaders(),
         body: JSON.stringify(data),
@@ -88,7 +191,7 @@ export const api = {
       return handleResponse(res);
     },
     update: async (id: string, data: Partial<Question>): Promise<Question> => {
-      const res = await fetch(`${API_URL}/questions/${id}`, {
+      const res = await authFetch(`${API_URL}/questions/${id}`, {
         method: 'PUT',
         headers: getHeaders(),
         body: JSON.stringify(data),
@@ -96,7 +199,7 @@ export const api = {
       return handleResponse(res);
     },
     delete: async (id: string): Promise<void> => {
-      const res = await fetch(`${API_URL}/questions/${id}`, {
+      const res = await authFetch(`${API_URL}/questions/${id}`, {
         method: 'DELETE',
         headers: getHeaders(),
       });
@@ -107,15 +210,24 @@ export const api = {
       }
       if (!res.ok) throw new Error('Failed to delete question');
     },
+    // Students pass the practice session the try counts against
+    check: async (id: string, answer: string, sessionId?: string): Promise<AnswerCheckResult> => {
+      const res = await authFetch(`${API_URL}/questions/${id}/check`, {
+        method: 'POST',
+        headers: getHeaders(),
+        body: JSON.stringify({ answer, sessionId }),
+      });
+      return handleResponse(res);
+    },
   },
   papers: {
     list: async (): Promise<any[]> => {
-      const res = await fetch(`${API_URL}/papers`, { headers: getHeaders() });
+      const res = await authFetch(`${API_URL}/papers`, { headers: getHeaders() });
       const data = await handleResponse(res);
       return data || [];
     },
     create: async (data: any): Promise<any> => {
-      const res = await fetch(`${API_URL}/papers`, {
+      const res = await authFetch(`${API_URL}/papers`, {
         method: 'POST',
         headers: getHeaders(),
         body: JSON.stringify(data),
@@ -123,7 +235,7 @@ export const api = {
       return handleResponse(res);
     },
     update: async (id: string, data: any): Promise<any> => {
-      const res = await fetch(`${API_URL}/papers/${id}`, {
+      const res = await authFetch(`${API_URL}/papers/${id}`, {
         method: 'PUT',
         headers: getHeaders(),
         body: JSON.stringify(data),
@@ -131,7 +243,7 @@ export const api = {
       return handleResponse(res);
     },
     delete: async (id: string): Promise<void> => {
-      const res = await fetch(`${API_URL}/papers/${id}`, {
+      const res = await authFetch(`${API_URL}/papers/${id}`, {
         method: 'DELETE',
         headers: getHeaders(),
       });
@@ -145,12 +257,12 @@ export const api = {
   },
   homework: {
     list: async (): Promise<any[]> => {
-      const res = await fetch(`${API_URL}/homeworks`, { headers: getHeaders() });
+      const res = await authFetch(`${API_URL}/homeworks`, { headers: getHeaders() });
       const data = await handleResponse(res);
       return data || [];
     },
     assign: async (data: any): Promise<any> => {
-      const res = await fetch(`${API_URL}/homeworks/assign`, {
+      const res = await authFetch(`${API_URL}/homeworks/assign`, {
         method: 'POST',
         headers: getHeaders(),
         body: JSON.stringify(data),
@@ -158,7 +270,7 @@ export const api = {
       return handleResponse(res);
     },
     complete: async (id: string): Promise<any> => {
-      const res = await fetch(`${API_URL}/homeworks/${id}/complete`, {
+      const res = await authFetch(`${API_URL}/homeworks/${id}/complete`, {
         method: 'PUT',
         headers: getHeaders(),
       });
@@ -167,14 +279,32 @@ export const api = {
   },
   dashboard: {
     stats: async (): Promise<any> => {
-      const res = await fetch(`${API_URL}/dashboard/stats`, { headers: getHeaders() });
+      const res = await authFetch(`${API_URL}/dashboard/stats`, { headers: getHeaders() });
       return handleResponse(res);
     },
     onlineUsers: async (): Promise<any> => {
-      const res = await fetch(`${API_URL}/dashboard/online-users`, { headers: getHeaders() });
+      const res = await authFetch(`${API_URL}/dashboard/online-users`, { headers: getHeaders() });
       return handleResponse(res);
     }
   },
+  sessions: {
+    start: async (data: { homeworkId?: string; questionIds: string[] }): Promise<PracticeSessionInfo> => {
+      const res = await authFetch(`${API_URL}/sessions`, {
+        method: 'POST',
+        headers: getHeaders(),
+        body: JSON.stringify(data),
+      });
+      return handleResponse(res);
+    },
+    answer: async (sessionId: string, questionId: string, answer: string): Promise<SessionAnswerResult> => {
+      const res = await authFetch(`${API_URL}/sessions/${sessionId}/answer`, {
+        method: 'POST',
+        headers: getHeaders(),
+        body: JSON.stringify({ questionId, answer }),
+      });
+      return handleResponse(res);
+    },
+  },
   history: {
     list: async (page = 1, pageSize = 10, homeworkId?: string, studentId?: string): Promise<{ list: any[], total: number }> => {
       const params = new URLSearchParams({
@@ -183,11 +313,11 @@ export const api = {
       });
       if (homeworkId) params.append('homeworkId', homeworkId);
       if (studentId) params.append('studentId', studentId);
-      const res = await fetch(`${API_URL}/history?${params.toString()}`, { headers: getHeaders() });
+      const res = await authFetch(`${API_URL}/history?${params.toString()}`, { headers: getHeaders() });
       return handleResponse(res);
     },
     create: async (data: any): Promise<any> => {
-      const res = await fetch(`${API_URL}/history`, {
+      const res = await authFetch(`${API_URL}/history`, {
         method: 'POST',
         headers: getHeaders(),
         body: JSON.stringify(data),
@@ -197,35 +327,213 @@ export const api = {
   },
   student: {
     stats: async (): Promise<any> => {
-      const res = await fetch(`${API_URL}/student/stats`, { headers: getHeaders() });
+      const res = await authFetch(`${API_URL}/student/stats`, { headers: getHeaders() });
       return handleResponse(res);
     }
   },
+  classes: {
+    list: async (): Promise<Class[]> => {
+      const res = await authFetch(`${API_URL}/classes`, { headers: getHeaders() });
+      const data = await handleResponse(res);
+      return data || [];
+    },
+    create: async (data: Partial<Class>): Promise<Class> => {
+      const res = await authFetch(`${API_URL}/classes`, {
+        method: 'POST',
+        headers: getHeaders(),
+        body: JSON.stringify(data),
+      });
+      return handleResponse(res);
+    },
+    update: async (id: string, data: Partial<Class>): Promise<Class> => {
+      const res = await authFetch(`${API_URL}/classes/${id}`, {
+        method: 'PUT',
+        headers: getHeaders(),
+        body: JSON.stringify(data),
+      });
+      return handleResponse(res);
+    },
+    delete: async (id: string): Promise<void> => {
+      const res = await authFetch(`${API_URL}/classes/${id}`, {
+        method: 'DELETE',
+        headers: getHeaders(),
+      });
+      return handleResponse(res);
+    },
+    enroll: async (id: string, studentIds: string[]): Promise<Class> => {
+      const res = await authFetch(`${API_URL}/classes/${id}/students`, {
+        method: 'POST',
+        headers: getHeaders(),
+        body: JSON.stringify({ studentIds }),
+      });
+      return handleResponse(res);
+    },
+    unenroll: async (id: string, studentId: string): Promise<Class> => {
+      const res = await authFetch(`${API_URL}/classes/${id}/students/${studentId}`, {
+        method: 'DELETE',
+        headers: getHeaders(),
+      });
+      return handleResponse(res);
+    },
+    // Fresh QR login cards for every student; earlier cards stop working
+    loginCards: async (id: string): Promise<LoginCard[]> => {
+      const res = await authFetch(`${API_URL}/classes/${id}/login-cards`, {
+        method: 'POST',
+        headers: getHeaders(),
+      });
+      const data = await handleResponse(res);
+      return data || [];
+    },
+    // The code that opens the class roster for picture logins on a classroom tablet
+    createRosterCode: async (id: string): Promise<{ classId: string; name: string; code: string }> => {
+      const res = await authFetch(`${API_URL}/classes/${id}/roster-code`, {
+        method: 'POST',
+        headers: getHeaders(),
+      });
+      return handleResponse(res);
+    },
+  },
   students: {
     list: async (): Promise<User[]> => {
-      const res = await fetch(`${API_URL}/students`, { headers: getHeaders() });
+      const res = await authFetch(`${API_URL}/students`, { headers: getHeaders() });
       const data = await handleResponse(res);
       return data || [];
     },
     getDetail: async (id: string): Promise<any> => {
-      const res = await fetch(`${API_URL}/students/${id}`, { headers: getHeaders() });
+      const res = await authFetch(`${API_URL}/students/${id}`, { headers: getHeaders() });
+      return handleResponse(res);
+    },
+    // A one-time code to print for a student who forgot their password
+    createResetCode: async (id: string): Promise<{ studentId: string; username: string; name: string; code: string; expiresAt: string }> => {
+      const res = await authFetch(`${API_URL}/students/${id}/reset-code`, {
+        method: 'POST',
+        headers: getHeaders(),
+      });
+      return handleResponse(res);
+    },
+    createParentInvite: async (id: string): Promise<{ studentId: string; name: string; code: string; expiresAt: string }> => {
+      const res = await authFetch(`${API_URL}/students/${id}/parent-invite`, {
+        method: 'POST',
+        headers: getHeaders(),
+      });
+      return handleResponse(res);
+    },
+    listParents: async (id: string): Promise<User[]> => {
+      const res = await authFetch(`${API_URL}/students/${id}/parents`, { headers: getHeaders() });
+      const data = await handleResponse(res);
+      return data || [];
+    },
+    removeParent: async (id: string, parentId: string): Promise<void> => {
+      const res = await authFetch(`${API_URL}/students/${id}/parents/${parentId}`, {
+        method: 'DELETE',
+        headers: getHeaders(),
+      });
+      return handleResponse(res);
+    },
+    loginStatus: async (id: string): Promise<StudentLoginStatus> => {
+      const res = await authFetch(`${API_URL}/students/${id}/login`, { headers: getHeaders() });
+      return handleResponse(res);
+    },
+    createLoginCard: async (id: string): Promise<LoginCard> => {
+      const res = await authFetch(`${API_URL}/students/${id}/login-card`, {
+        method: 'POST',
+        headers: getHeaders(),
+      });
+      return handleResponse(res);
+    },
+    createPicturePassword: async (id: string): Promise<{ studentId: string; name: string; pictures: number[] }> => {
+      const res = await authFetch(`${API_URL}/students/${id}/picture-password`, {
+        method: 'POST',
+        headers: getHeaders(),
+      });
+      return handleResponse(res);
+    },
+    // Turns off the card, the picture password or (without method) both, and logs the student out
+    revokeLogin: async (id: string, method?: 'card' | 'picture'): Promise<void> => {
+      const params = method ? `?method=${method}` : '';
+      const res = await authFetch(`${API_URL}/students/${id}/login${params}`, {
+        method: 'DELETE',
+        headers: getHeaders(),
+      });
+      return handleResponse(res);
+    }
+  },
+  parent: {
+    children: async (): Promise<Child[]> => {
+      const res = await authFetch(`${API_URL}/parent/children`, { headers: getHeaders() });
+      const data = await handleResponse(res);
+      return data || [];
+    },
+    linkChild: async (code: string): Promise<{ studentId: string }> => {
+      const res = await authFetch(`${API_URL}/parent/children`, {
+        method: 'POST',
+        headers: getHeaders(),
+        body: JSON.stringify({ code }),
+      });
+      return handleResponse(res);
+    },
+    unlinkChild: async (id: string): Promise<void> => {
+      const res = await authFetch(`${API_URL}/parent/children/${id}`, {
+        method: 'DELETE',
+        headers: getHeaders(),
+      });
+      return handleResponse(res);
+    },
+    weekly: async (id: string, week?: string): Promise<WeeklySummary> => {
+      const params = week ? `?week=${week}` : '';
+      const res = await authFetch(`${API_URL}/parent/children/${id}/weekly${params}`, { headers: getHeaders() });
       return handleResponse(res);
     }
   },
   teacher: {
     stats: async (): Promise<any> => {
-      const res = await fetch(`${API_URL}/teacher/stats`, { headers: getHeaders() });
+      const res = await authFetch(`${API_URL}/teacher/stats`, { headers: getHeaders() });
       return handleResponse(res);
     }
   },
+  super: {
+    tenants: async (): Promise<Tenant[]> => {
+      const res = await authFetch(`${API_URL}/super/tenants`, { headers: getHeaders() });
+      const data = await handleResponse(res);
+      return data || [];
+    },
+    createTenant: async (data: { code: string; name: string; adminUsername: string; adminPassword: string }): Promise<Tenant> => {
+      const res = await authFetch(`${API_URL}/super/tenants`, {
+        method: 'POST',
+        headers: getHeaders(),
+        body: JSON.stringify(data),
+      });
+      return handleResponse(res);
+    },
+    updateTenant: async (id: string, data: { name?: string; status?: 'active' | 'disabled' }): Promise<Tenant> => {
+      const res = await authFetch(`${API_URL}/super/tenants/${id}`, {
+        method: 'PUT',
+        headers: getHeaders(),
+        body: JSON.stringify(data),
+      });
+      return handleResponse(res);
+    },
+    listLockouts: async (): Promise<LoginLockout[]> => {
+      const res = await authFetch(`${API_URL}/super/lockouts`, { headers: getHeaders() });
+      const data = await handleResponse(res);
+      return data || [];
+    },
+    clearLockout: async (key: string): Promise<void> => {
+      const res = await authFetch(`${API_URL}/super/lockouts/${encodeURIComponent(key)}`, {
+        method: 'DELETE',
+        headers: getHeaders(),
+      });
+      return handleResponse(res);
+    },
+  },
   admin: {
     listUsers: async (): Promise<User[]> => {
-      const res = await fetch(`${API_URL}/admin/users`, { headers: getHeaders() });
+      const res = await authFetch(`${API_URL}/admin/users`, { headers: getHeaders() });
       const data = await handleResponse(res);
       return data || [];
     },
     createUser: async (data: Partial<User>): Promise<User> => {
-      const res = await fetch(`${API_URL}/admin/users`, {
+      const res = await authFetch(`${API_URL}/admin/users`, {
         method: 'POST',
         headers: getHeaders(),
         body: JSON.stringify(data),
@@ -233,7 +541,7 @@ export const api = {
       return handleResponse(res);
     },
     updateUser: async (id: string, data: Partial<User>): Promise<User> => {
-      const res = await fetch(`${API_URL}/admin/users/${id}`, {
+      const res = await authFetch(`${API_URL}/admin/users/${id}`, {
         method: 'PUT',
         headers: getHeaders(),
         body: JSON.stringify(data),
@@ -241,7 +549,7 @@ export const api = {
       return handleResponse(res);
     },
     deleteUser: async (id: string): Promise<void> => {
-       const res = await fetch(`${API_URL}/admin/users/${id}`, {
+       const res = await authFetch(`${API_URL}/admin/users/${id}`, {
         method: 'DELETE',
         headers: getHeaders(),
        });
@@ -252,27 +560,59 @@ export const api = {
        }
        if (!res.ok) throw new Error('Failed to delete user');
     },
+    // Multipart upload of a CSV/XLSX; a dry run only validates the rows
+    importUsers: async (file: File, dryRun: boolean): Promise<ImportUsersResult> => {
+      const { Authorization } = getHeaders();
+      const form = new FormData();
+      form.append('file', file);
+      form.append('dryRun', String(dryRun));
+      const res = await authFetch(`${API_URL}/admin/users/import`, {
+        method: 'POST',
+        headers: { Authorization },
+        body: form,
+      });
+      return handleResponse(res);
+    },
+    listLockouts: async (): Promise<LoginLockout[]> => {
+      const res = await authFetch(`${API_URL}/admin/users/lockouts`, { headers: getHeaders() });
+      const data = await handleResponse(res);
+      return data || [];
+    },
+    unlockUser: async (id: string): Promise<void> => {
+      const res = await authFetch(`${API_URL}/admin/users/${id}/unlock`, {
+        method: 'POST',
+        headers: getHeaders(),
+      });
+      return handleResponse(res);
+    },
+    clearLockout: async (key: string): Promise<void> => {
+      const res = await authFetch(`${API_URL}/admin/users/lockouts/${encodeURIComponent(key)}`, {
+        method: 'DELETE',
+        headers: getHeaders(),
+      });
+      return handleResponse(res);
+    },
     logs: async (): Promise<any[]> => {
-       const res = await fetch(`${API_URL}/admin/logs`, { headers: getHeaders() });
+       const res = await authFetch(`${API_URL}/admin/logs`, { headers: getHeaders() });
        const data = await handleResponse(res);
        return data || [];
     },
     homeworks: async (): Promise<any[]> => {
-       const res = await fetch(`${API_URL}/admin/homeworks`, { headers: getHeaders() });
+       const res = await authFetch(`${API_URL}/admin/homeworks`, { headers: getHeaders() });
        const data = await handleResponse(res);
        return data || [];
     },
     practices: async (): Promise<any[]> => {
-       const res = await fetch(`${API_URL}/admin/practices`, { headers: getHeaders() });
+       const res = await authFetch(`${API_URL}/admin/practices`, { headers: getHeaders() });
        const data = await handleResponse(res);
        return data || [];
     },
     getConfig: async (): Promise<any> => {
-      const res = await fetch(`${API_URL}/admin/config`, { headers: getHeaders() });
+      const res = await authFetch(`${API_URL}/admin/config`, { headers: getHeaders() });
       return handleResponse(res);
     },
     updateConfig: async (data: any): Promise<any> => {
-       const res = await fetch(`${API_URL}/admin/config`, {
+       const res = await authFetch(`${API_URL}/admin/config`, {
         method: 'POST',
         headers: getHeaders(),
         body: JSON.stringify(data),
@@ -280,11 +620,11 @@ export const api = {
       return handleResponse(res);
     },
     getSettings: async (): Promise<any> => {
-      const res = await fetch(`${API_URL}/admin/settings`, { headers: getHeaders() });
+      const res = await authFetch(`${API_URL}/admin/settings`, { headers: getHeaders() });
       return handleResponse(res);
     },
     updateSettings: async (data: any): Promise<any> => {
-       const res = await fetch(`${API_URL}/admin/settings`, {
+       const res = await authFetch(`${API_URL}/admin/settings`, {
         method: 'POST',
         headers: getHeaders(),
         body: JSON.stringify(data),
@@ -292,26 +632,35 @@ export const api = {
       return handleResponse(res);
     },
     listPermissions: async (): Promise<any[]> => {
-      const res = await fetch(`${API_URL}/admin/permissions`, { headers: getHeaders() });
+      const res = await authFetch(`${API_URL}/admin/permissions`, { headers: getHeaders() });
       const data = await handleResponse(res);
       return data || [];
     },
     updatePermissions: async (data: any[]): Promise<any> => {
-      const res = await fetch(`${API_URL}/admin/permissions`, {
+      const res = await authFetch(`${API_URL}/admin/permissions`, {
         method: 'POST',
         headers: getHeaders(),
         body: JSON.stringify(data),
       });
       return handleResponse(res);
+    },
+    // Hands all content without a creator to one teacher or admin; returns how many of each type changed
+    assignContentCreator: async (creatorId: string, types?: ('questions' | 'papers' | 'reinforcements')[]): Promise<Record<string, number>> => {
+      const res = await authFetch(`${API_URL}/admin/content/creator`, {
+        method: 'POST',
+        headers: getHeaders(),
+        body: JSON.stringify({ creatorId, types }),
+      });
+      return handleResponse(res);
     }
   },
   me: {
     get: async (): Promise<User> => {
-      const res = await fetch(`${API_URL}/me`, { headers: getHeaders() });
+      const res = await authFetch(`${API_URL}/me`, { headers: getHeaders() });
       return handleResponse(res);
     },
     update: async (data: { name?: string; password?: string }): Promise<User> => {
-      const res = await fetch(`${API_URL}/me`, {
+      const res = await authFetch(`${API_URL}/me`, {
         method: 'PUT',
         headers: getHeaders(),
         body: JSON.stringify(data),
@@ -319,7 +668,7 @@ export const api = {
       return handleResponse(res);
     },
     getPermissions: async (): Promise<any[]> => {
-      const res = await fetch(`${API_URL}/me/permissions`, { headers: getHeaders() });
+      const res = await authFetch(`${API_URL}/me/permissions`, { headers: getHeaders() });
       const data = await handleResponse(res);
       return data || [];
     }
@@ -329,19 +678,19 @@ export const api = {
       const url = studentId 
         ? `${API_URL}/wrong-book?studentId=${studentId}`
         : `${API_URL}/wrong-book`;
-      const res = await fetch(url, { headers: getHeaders() });
+      const res = await authFetch(url, { headers: getHeaders() });
       const data = await handleResponse(res);
       return data || [];
     }
   },
   reinforcements: {
     list: async (): Promise<any[]> => {
-      const res = await fetch(`${API_URL}/reinforcements`, { headers: getHeaders() });
+      const res = await authFetch(`${API_URL}/reinforcements`, { headers: getHeaders() });
       const data = await handleResponse(res);
       return data || [];
     },
     create: async (data: any): Promise<any> => {
-      const res = await fetch(`${API_URL}/reinforcements`, {
+      const res = await authFetch(`${API_URL}/reinforcements`, {
         method: 'POST',
         headers: getHeaders(),
         body: JSON.stringify(data),
@@ -349,7 +698,7 @@ export const api = {
       return handleResponse(res);
     },
     update: async (id: string, data: any): Promise<any> => {
-      const res = await fetch(`${API_URL}/reinforcements/${id}`, {
+      const res = await authFetch(`${API_URL}/reinforcements/${id}`, {
         method: 'PUT',
         headers: getHeaders(),
         body: JSON.stringify(data),
@@ -357,7 +706,7 @@ export const api = {
       return handleResponse(res);
     },
     delete: async (id: string): Promise<void> => {
-      const res = await fetch(`${API_URL}/reinforcements/${id}`, {
+      const res = await authFetch(`${API_URL}/reinforcements/${id}`, {
         method: 'DELETE',
         headers: getHeaders(),
       });
@@ -369,6 +718,24 @@ export const api = {
       if (!res.ok) throw new Error('Failed to delete reinforcement');
     }
   },
+  uploads: {
+    // Multipart upload; the browser sets the multipart Content-Type itself
+    upload: async (file: File): Promise<Asset> => {
+      const { Authorization } = getHeaders();
+      const form = new FormData();
+      form.append('file', file);
+      const res = await authFetch(`${API_URL}/uploads`, {
+        method: 'POST',
+        headers: { Authorization },
+        body: form,
+      });
+      return handleResponse(res);
+    },
+    get: async (id: string): Promise<Asset> => {
+      const res = await authFetch(`${API_URL}/uploads/${id}`, { headers: getHeaders() });
+      return handleResponse(res);
+    }
+  },
   resources: {
     list: async (page = 1, pageSize = 10, keyword = ''): Promise<{ list: Resource[], total: number }> => {
       const params = new URLSearchParams({
@@ -376,11 +743,11 @@ export const api = {
         pageSize: pageSize.toString(),
         keyword: keyword
       });
-      const res = await fetch(`${API_URL}/resources?${params.toString()}`, { headers: getHeaders() });
+      const res = await authFetch(`${API_URL}/resources?${params.toString()}`, { headers: getHeaders() });
       return handleResponse(res);
     },
     create: async (data: any): Promise<Resource> => {
-      const res = await fetch(`${API_URL}/resources`, {
+      const res = await authFetch(`${API_URL}/resources`, {
         method: 'POST',
         headers: getHeaders(),
         body: JSON.stringify(data),
@@ -388,7 +755,7 @@ export const api = {
       return handleResponse(res);
     },
     update: async (id: string, data: any): Promise<Resource> => {
-      const res = await fetch(`${API_URL}/resources/${id}`, {
+      const res = await authFetch(`${API_URL}/resources/${id}`, {
         method: 'PUT',
         headers: getHeaders(),
         body: JSON.stringify(data),
@@ -396,7 +763,7 @@ export const api = {
       return handleResponse(res);
     },
     delete: async (id: string): Promise<void> => {
-      const res = await fetch(`${API_URL}/resources/${id}`, {
+      const res = await authFetch(`${API_URL}/resources/${id}`, {
         method: 'DELETE',
         headers: getHeaders(),
       });
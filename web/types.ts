@@ -2,7 +2,9 @@
 export enum Role {
   STUDENT = 'STUDENT',
   TEACHER = 'TEACHER',
-  ADMIN = 'ADMIN'
+  ADMIN = 'ADMIN',
+  PARENT = 'PARENT',
+  SUPER_ADMIN = 'SUPER_ADMIN'
 }
 
 export enum Subject {
@@ -28,27 +30,158 @@ export interface User {
   role: Role;
   grade?: number;
   token?: string;
+  refreshToken?: string;
+  // Only PUT /me works until the user replaces a password an admin set
+  mustChangePassword?: boolean;
+  // Teachers of a department share its content pool
+  department?: string;
+}
+
+// Who authored a question, paper or reinforcement and who else may change it
+export interface Ownership {
+  creatorId?: string;
+  coOwnerIds?: string[];
+  // Shared with this department's pool, which all its teachers may edit
+  department?: string;
+}
+
+export interface PasswordPolicy {
+  minLength: number;
+  requireLetter: boolean;
+  requireDigit: boolean;
+  requireMixedCase: boolean;
+  requireSymbol: boolean;
+  disallowUsername: boolean;
+}
+
+// A username ("user:<name>") or IP ("ip:<addr>") that is throttled after failed logins
+export interface LoginLockout {
+  key: string;
+  failures: number;
+  lastFailureAt: string;
+  locked: boolean;
+  retryAfter: number; // seconds
+}
+
+// A printed QR login card; qr is a PNG data URL of url
+export interface LoginCard {
+  studentId: string;
+  username: string;
+  name: string;
+  url: string;
+  qr: string;
+}
+
+// The password-free logins a young student has
+export interface StudentLoginStatus {
+  studentId: string;
+  card: boolean;
+  cardIssuedAt: string;
+  picture: boolean;
+  pictureIssuedAt: string;
+  lastLoginAt: string;
+}
+
+// A school; every account and its data belong to exactly one
+export interface Tenant {
+  id: string;
+  code: string;
+  name: string;
+  status: 'active' | 'disabled';
+  createdAt: string;
+  users: number;
+}
+
+// A student linked to the logged-in parent
+export interface Child {
+  id: string;
+  name: string;
+  username: string;
+  grade?: number;
+  classes: string[];
+}
+
+export interface HomeworkBrief {
+  id: string;
+  name: string;
+  status: 'pending' | 'completed' | 'overdue';
+  startDate: string;
+  endDate: string;
+  correctCount: number;
+  total: string;
+  accuracyRate: number;
+}
+
+// One child's week, Monday to Sunday
+export interface WeeklySummary {
+  studentId: string;
+  weekStart: string;
+  weekEnd: string;
+  practices: number;
+  questions: number;
+  correct: number;
+  accuracy: number;
+  activeDays: number;
+  days: { date: string; practices: number; questions: number; correct: number }[];
+  homework: HomeworkBrief[];
+  homeworkSummary: { completed: number; pending: number; overdue: number };
+  wrongBook: { open: number; difficult: number; masteredThisWeek: number };
+}
+
+// Result of a bulk student import; credentials only come back from a real import
+export interface ImportUsersResult {
+  dryRun: boolean;
+  total: number;
+  rows: { row: number; name: string; username: string; grade?: number; class?: string; classAction?: 'existing' | 'new' }[];
+  errors: { row: number; field?: string; message: string }[];
+  credentials?: { row: number; id: string; name: string; username: string; password: string; class?: string }[];
 }
 
 export interface QuestionOption {
   text?: string;
   image?: string;
+  // ID of an image from api.uploads.upload; the server fills in image
+  assetId?: string;
+  srcset?: string;
   value: string;
 }
 
-export interface Question {
+export interface Question extends Ownership {
   id: string;
   subject: Subject | string;
   grade: number;
   type: QuestionType | string;
   stemText: string;
   stemImage?: string;
+  stemAssetId?: string;
+  // srcset built from the server-side resized copies of the stem image
+  stemSrcset?: string;
   options?: QuestionOption[];
-  answer: string;
+  // Answer and hint are omitted for students, see api.questions.check
+  answer?: string;
   hint?: string;
 }
 
-export interface Reinforcement {
+export interface AnswerCheckResult {
+  correct: boolean;
+  showAnswer: boolean;
+  answer?: string;
+  hint?: string;
+}
+
+export interface PracticeSessionInfo {
+  id: string;
+  questionIds: string[];
+  maxAttempts: number;
+}
+
+export interface SessionAnswerResult extends AnswerCheckResult {
+  attempts: number;
+  attemptsLeft: number;
+  showHint: boolean;
+}
+
+export interface Reinforcement extends Ownership {
   id: string;
   name: string;
   type: 'ANIMATION' | 'VIDEO';
@@ -71,9 +204,46 @@ export interface Resource {
   id: string;
   name: string;
   url: string;
+  assetId?: string;
+  thumbnailUrl?: string;
   type: string;
   tags: string[];
   visibility: 'personal' | 'public';
   creatorId: string;
   createdAt: string;
 }
+
+export interface Asset {
+  id: string;
+  kind: 'image' | 'audio' | 'video' | 'pdf';
+  mimeType: string;
+  size: number;
+  name: string;
+  url: string;
+  width?: number;
+  height?: number;
+  variants?: ImageVariant[];
+  srcset?: string;
+  thumbnailUrl?: string;
+  creatorId: string;
+  createdAt: string;
+}
+
+export interface ImageVariant {
+  name: string;
+  width: number;
+  height: number;
+  size: number;
+  key: string;
+  url: string;
+}
+
+export interface Class {
+  id: string;
+  name: string;
+  grade: number;
+  subject: string;
+  teacherIds: string[];
+  studentIds: string[];
+  createdAt: string;
+}
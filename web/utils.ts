@@ -1,4 +1,4 @@
-import { QuestionType } from './types';
+import { QuestionType, User, Ownership, Role } from './types';
 
 export const delay = (ms: number) => new Promise(res => setTimeout(res, ms));
 
@@ -56,4 +56,17 @@ export const isTokenExpired = (token: string): boolean => {
   } catch (e) {
     return true;
   }
-};
\ No newline at end of file
+};
+// The pictures of picture passwords, in the order the server numbers them (0..11)
+export const LOGIN_PICTURES = ['🍎', '🐶', '⭐', '🚗', '🐱', '🌈', '⚽', '🐟', '🌻', '🎈', '🐘', '🍌'];
+
+// Owners of content are its creator and co-owners; admins own everything
+export const isContentOwner = (user: User | null | undefined, item: Ownership): boolean => {
+  if (!user) return false;
+  if (user.role === Role.ADMIN) return true;
+  return (!!item.creatorId && item.creatorId === user.id) || (item.coOwnerIds || []).includes(user.id);
+};
+
+// Teachers of the department a content is shared with may edit it too
+export const canEditContent = (user: User | null | undefined, item: Ownership): boolean =>
+  isContentOwner(user, item) || (!!user && user.role === Role.TEACHER && !!item.department && item.department === user.department);
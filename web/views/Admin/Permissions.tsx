@@ -4,12 +4,26 @@ import { Lock, Unlock, Shield, X, Check, Layout, BookOpen, ClipboardList, Settin
 import { api } from '../../services/api';
 import { Role } from '../../types';
 
+type ApiAction = 'read' | 'create' | 'update' | 'delete';
+
 interface ModulePermission {
   id: string;
   ui: boolean;   // View Access
-  api: boolean;  // Interaction/Mutation Access
+  read: boolean;
+  create: boolean;
+  update: boolean;
+  delete: boolean;
 }
 
+const API_ACTIONS: { id: ApiAction; short: string; label: string; labelEn: string }[] = [
+  { id: 'read', short: 'R', label: '查看', labelEn: 'Read' },
+  { id: 'create', short: 'C', label: '新建', labelEn: 'Create' },
+  { id: 'update', short: 'U', label: '修改', labelEn: 'Update' },
+  { id: 'delete', short: 'D', label: '删除', labelEn: 'Delete' },
+];
+
+const NO_ACCESS = { ui: false, read: false, create: false, update: false, delete: false };
+
 interface PermissionRole {
   role: string;
   roleEn: string;
@@ -19,6 +33,7 @@ interface PermissionRole {
 
 const ALL_MODULES = [
   { id: 'dashboard', label: '控制台', icon: Layout },
+  { id: 'wrong_book', label: '错题本', icon: AlertCircle },
   { id: 'students', label: '学生管理', icon: Users },
   { id: 'questions', label: '题目管理', icon: BookOpen },
   { id: 'papers', label: '试卷管理', icon: ClipboardList },
@@ -29,9 +44,11 @@ const ALL_MODULES = [
   { id: 'homework_audit', label: '作业审计', icon: ShieldCheck },
   { id: 'audit_logs', label: '审计日志', icon: ShieldCheck },
   { id: 'stats', label: '统计报表', icon: BarChart },
+  { id: 'own_stats', label: '个人统计', icon: BarChart },
   { id: 'help_docs', label: '帮助文档', icon: HelpCircle },
   { id: 'permissions', label: '权限设置', icon: Lock },
   { id: 'system_config', label: '系统配置', icon: Settings },
+  { id: 'children', label: '我的孩子', icon: Users },
 ];
 
 const Permissions: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
@@ -39,6 +56,7 @@ const Permissions: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
     { role: '管理员', roleEn: Role.ADMIN, permissions: [], level: 'RESTRICTED' },
     { role: '教师', roleEn: Role.TEACHER, permissions: [], level: 'RESTRICTED' },
     { role: '学生', roleEn: Role.STUDENT, permissions: [], level: 'RESTRICTED' },
+    { role: '家长', roleEn: Role.PARENT, permissions: [], level: 'RESTRICTED' },
   ]);
 
   const [loading, setLoading] = useState(true);
@@ -54,13 +72,16 @@ const Permissions: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
         const rolePerms = perms.filter(p => p.role === r.roleEn).map(p => ({
           id: p.moduleId,
           ui: p.uiAccess,
-          api: p.apiAccess
+          read: p.canRead,
+          create: p.canCreate,
+          update: p.canUpdate,
+          delete: p.canDelete
         }));
         
         // Ensure all modules are present even if not in DB
         const fullPerms = ALL_MODULES.map(m => {
           const found = rolePerms.find(rp => rp.id === m.id);
-          return found || { id: m.id, ui: false, api: false };
+          return found || { id: m.id, ...NO_ACCESS };
         });
 
         const uiCount = fullPerms.filter(p => p.ui).length;
@@ -84,18 +105,24 @@ const Permissions: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
     setIsModalOpen(true);
   };
 
-  const handleToggle = (moduleId: string, type: 'ui' | 'api') => {
+  const handleToggle = (moduleId: string, type: 'ui' | ApiAction) => {
     if (!editingRole) return;
     const newPerms = editingRole.permissions.map(p => {
       if (p.id === moduleId) {
         const newVal = !p[type];
-        // Rules: API access usually implies UI visibility
-        if (type === 'api' && newVal) {
-          return { ...p, api: true, ui: true };
-        }
         // Rules: Removing UI visibility usually removes API access
         if (type === 'ui' && !newVal) {
-          return { ...p, ui: false, api: false };
+          return { ...p, ...NO_ACCESS };
+        }
+        // Rules: Changing data needs reading it, and usually UI visibility
+        if (type !== 'ui' && type !== 'read' && newVal) {
+          return { ...p, [type]: true, read: true, ui: true };
+        }
+        if (type === 'read' && !newVal) {
+          return { ...p, read: false, create: false, update: false, delete: false };
+        }
+        if (type === 'read') {
+          return { ...p, read: true, ui: true };
         }
         return { ...p, [type]: newVal };
       }
@@ -121,7 +148,10 @@ const Permissions: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
           role: r.roleEn,
           moduleId: p.id,
           uiAccess: p.ui,
-          apiAccess: p.api
+          canRead: p.read,
+          canCreate: p.create,
+          canUpdate: p.update,
+          canDelete: p.delete
         });
       });
     });
@@ -186,7 +216,9 @@ const Permissions: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
                       </div>
                       <div className="px-2 py-2 flex gap-1 bg-white/50 dark:bg-black/20">
                          <Eye className={`w-3 h-3 ${perm.ui ? 'text-blue-500' : 'text-gray-300'}`} />
-                         <Cpu className={`w-3 h-3 ${perm.api ? 'text-red-500' : 'text-gray-300'}`} />
+                         {API_ACTIONS.map(action => (
+                           <span key={action.id} className={`text-[9px] font-black leading-3 ${perm[action.id] ? 'text-red-500' : 'text-gray-300'}`}>{action.short}</span>
+                         ))}
                       </div>
                     </div>
                   );
@@ -223,12 +255,12 @@ const Permissions: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
               <div className="flex-1 overflow-y-auto space-y-4 pr-2 scrollbar-thin">
                  <div className="grid grid-cols-1 gap-4">
                    {ALL_MODULES.map(mod => {
-                     const perm = editingRole.permissions.find(p => p.id === mod.id) || { ui: false, api: false };
+                     const perm = editingRole.permissions.find(p => p.id === mod.id) || { id: mod.id, ...NO_ACCESS };
                      return (
                        <div 
                          key={mod.id}
                          className={`p-6 rounded-[2rem] border-2 transition-all flex flex-col sm:flex-row items-center justify-between gap-6 ${
-                           perm.ui || perm.api 
+                           perm.ui || perm.read 
                            ? 'border-primary-500/20 bg-primary-50/10 dark:bg-primary-900/5' 
                            : 'border-gray-100 dark:border-gray-700 bg-gray-50/50 dark:bg-gray-900/50 opacity-60'
                          }`}
@@ -257,18 +289,21 @@ const Permissions: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
                               {language === 'zh' ? '界面可见' : 'UI ACCESS'}
                             </button>
                             
-                            {/* API Permission Toggle */}
-                            <button 
-                              onClick={() => handleToggle(mod.id, 'api')}
-                              className={`flex-1 sm:w-32 py-3 px-4 rounded-xl text-[10px] font-black uppercase tracking-widest flex items-center justify-center gap-2 border-2 transition-all ${
-                                perm.api 
-                                ? 'bg-red-100 border-red-500 text-red-600 dark:bg-red-900/30' 
-                                : 'bg-white dark:bg-gray-900 border-gray-200 dark:border-gray-700 text-gray-400'
-                              }`}
-                            >
-                              <Cpu className="w-3.5 h-3.5" />
-                              {language === 'zh' ? '接口读写' : 'API ACCESS'}
-                            </button>
+                            {/* API Permission Toggles, one per action */}
+                            {API_ACTIONS.map(action => (
+                              <button 
+                                key={action.id}
+                                onClick={() => handleToggle(mod.id, action.id)}
+                                className={`flex-1 sm:w-20 py-3 px-2 rounded-xl text-[10px] font-black uppercase tracking-widest flex items-center justify-center gap-1 border-2 transition-all ${
+                                  perm[action.id] 
+                                  ? 'bg-red-100 border-red-500 text-red-600 dark:bg-red-900/30' 
+                                  : 'bg-white dark:bg-gray-900 border-gray-200 dark:border-gray-700 text-gray-400'
+                                }`}
+                              >
+                                <Cpu className="w-3.5 h-3.5" />
+                                {language === 'zh' ? action.label : action.labelEn}
+                              </button>
+                            ))}
                          </div>
                        </div>
                      );
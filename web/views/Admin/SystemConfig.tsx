@@ -133,6 +133,39 @@ const SystemConfig: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
                  {language === 'zh' ? '开放用户注册 (手机号)' : 'Enable Public Registration (Phone)'}
                </span>
             </div>
+            <div className="space-y-3">
+               <div className="flex items-center gap-4">
+                  <span className="font-bold dark:text-white">
+                    {language === 'zh' ? '密码最短长度' : 'Minimum Password Length'}
+                  </span>
+                  <input
+                    type="number"
+                    min={6}
+                    max={72}
+                    value={settings.passwordPolicy?.minLength ?? 8}
+                    onChange={e => setSettings({...settings, passwordPolicy: {...settings.passwordPolicy, minLength: parseInt(e.target.value) || 0}})}
+                    className="w-20 px-3 py-1 rounded-lg border dark:border-gray-600 dark:bg-gray-800 dark:text-white"
+                  />
+               </div>
+               <div className="flex flex-wrap gap-6">
+                  {[
+                    { key: 'requireLetter', zh: '必须包含字母', en: 'Require a letter' },
+                    { key: 'requireDigit', zh: '必须包含数字', en: 'Require a digit' },
+                    { key: 'requireMixedCase', zh: '必须包含大小写', en: 'Require mixed case' },
+                    { key: 'requireSymbol', zh: '必须包含符号', en: 'Require a symbol' },
+                    { key: 'disallowUsername', zh: '不得包含用户名', en: 'Must not contain username' },
+                  ].map(rule => (
+                    <label key={rule.key} className="flex items-center gap-2 font-bold dark:text-white cursor-pointer">
+                      <input
+                        type="checkbox"
+                        checked={!!settings.passwordPolicy?.[rule.key]}
+                        onChange={e => setSettings({...settings, passwordPolicy: {...settings.passwordPolicy, [rule.key]: e.target.checked}})}
+                      />
+                      {language === 'zh' ? rule.zh : rule.en}
+                    </label>
+                  ))}
+               </div>
+            </div>
          </div>
 
          <div className="bg-gray-50 dark:bg-gray-900/50 p-8 rounded-3xl border dark:border-gray-700 space-y-6">
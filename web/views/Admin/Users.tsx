@@ -1,9 +1,10 @@
 
 import React, { useState, useEffect } from 'react';
-import { UserPlus, MoreHorizontal, User as UserIcon, X, Search, Filter, Edit2, Trash2, CheckCircle, XCircle, Lock, Key } from 'lucide-react';
+import { UserPlus, MoreHorizontal, User as UserIcon, X, Search, Filter, Edit2, Trash2, CheckCircle, XCircle, Lock, Key, Upload } from 'lucide-react';
 import { Role } from '../../types';
 import { api } from '../../services/api.ts';
 import ConfirmationModal from '../../components/ConfirmationModal';
+import ImportUsersModal from '../../components/ImportUsersModal';
 
 interface UserItem {
   id: string;
@@ -14,6 +15,7 @@ interface UserItem {
   lastLogin?: string; // Not in backend model yet
   password?: string;
   grade?: string;
+  locked?: boolean; // Throttled after failed logins
 }
 
 const Users: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
@@ -38,6 +40,8 @@ const Users: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
     setLoading(true);
     try {
       const data = await api.admin.listUsers();
+      const lockouts = await api.admin.listLockouts().catch(() => []);
+      const lockedKeys = new Set(lockouts.map(l => l.key));
       // Map backend User to frontend UserItem
       const mapped = data.map((u: any) => ({
         id: u.id,
@@ -46,6 +50,7 @@ const Users: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
         role: u.role,
         status: u.status || 'active',
         lastLogin: '-', // Placeholder
+        locked: lockedKeys.has(`user:${String(u.username).trim().toLowerCase()}`),
         password: u.password // Backend shouldn't send password really but mock does
       }));
       setUsers(mapped);
@@ -57,6 +62,7 @@ const Users: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
   };
 
   const [isModalOpen, setIsModalOpen] = useState(false);
+  const [isImportOpen, setIsImportOpen] = useState(false);
   const [editingUser, setEditingUser] = useState<UserItem | null>(null);
 
   // Form State
@@ -117,6 +123,15 @@ const Users: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
     }
   };
 
+  const handleUnlock = async (id: string) => {
+    try {
+      await api.admin.unlockUser(id);
+      fetchUsers();
+    } catch (error) {
+      console.error("Failed to unlock", error);
+    }
+  };
+
   const handleDelete = async (id: string) => {
     setConfirmationModalProps({
       title: language === 'zh' ? '确认删除' : 'Confirm Delete',
@@ -149,6 +164,14 @@ const Users: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
     <div className="space-y-6 animate-in fade-in duration-500">
       <div className="flex items-center justify-between">
         <h2 className="text-2xl font-black dark:text-white uppercase tracking-tight">{language === 'zh' ? '用户管理' : 'User Accounts'}</h2>
+        <div className="flex gap-3">
+        <button
+          onClick={() => setIsImportOpen(true)}
+          className="bg-white dark:bg-gray-800 border dark:border-gray-700 dark:text-white px-6 py-3 rounded-2xl font-black flex items-center gap-2 shadow-sm hover:bg-gray-50 transition-all"
+        >
+          <Upload className="w-5 h-5" />
+          {language === 'zh' ? '批量导入' : 'Import'}
+        </button>
         <button 
           onClick={() => handleOpenModal()}
           className="bg-primary-600 text-white px-6 py-3 rounded-2xl font-black flex items-center gap-2 shadow-xl shadow-primary-500/30 hover:bg-primary-700 transition-all hover:scale-105 active:scale-95"
@@ -156,6 +179,7 @@ const Users: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
           <UserPlus className="w-5 h-5" />
           {language === 'zh' ? '创建新用户' : 'Add User'}
         </button>
+        </div>
       </div>
 
       <div className="flex gap-4">
@@ -233,6 +257,15 @@ const Users: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
                   </td>
                   <td className="px-8 py-5 text-right">
                     <div className="flex items-center justify-end gap-2 opacity-0 group-hover:opacity-100 transition-opacity">
+                      {u.locked && (
+                        <button
+                          onClick={() => handleUnlock(u.id)}
+                          className="p-2 text-amber-500 hover:text-amber-600 hover:bg-amber-50 dark:hover:bg-amber-900/20 rounded-xl transition-all"
+                          title={language === 'zh' ? '登录失败次数过多，点击解锁' : 'Locked after failed logins, click to unlock'}
+                        >
+                          <Lock className="w-5 h-5" />
+                        </button>
+                      )}
                       <button 
                         onClick={() => handleOpenModal(u)}
                         className="p-2 text-gray-400 hover:text-primary-600 hover:bg-primary-50 dark:hover:bg-primary-900/20 rounded-xl transition-all"
@@ -318,6 +351,7 @@ const Users: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
                            <option value={Role.STUDENT}>{language === 'zh' ? '学生' : 'Student'}</option>
                            <option value={Role.TEACHER}>{language === 'zh' ? '教师' : 'Teacher'}</option>
                            <option value={Role.ADMIN}>{language === 'zh' ? '管理员' : 'Admin'}</option>
+                           <option value={Role.PARENT}>{language === 'zh' ? '家长' : 'Parent'}</option>
                         </select>
                     </div>
                     <div>
@@ -352,6 +386,12 @@ const Users: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
         </div>
       )}
 
+      <ImportUsersModal
+        isOpen={isImportOpen}
+        onClose={(imported) => { setIsImportOpen(false); if (imported) fetchUsers(); }}
+        language={language}
+      />
+
       {isConfirmationModalOpen && (
         <ConfirmationModal
           isOpen={isConfirmationModalOpen}
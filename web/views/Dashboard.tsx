@@ -7,6 +7,7 @@ import { SUBJECTS } from '../utils.ts';
 import { useNavigate } from 'react-router-dom';
 import { PlayCircle, FileText, ChevronRight, BarChart2, Users, TrendingUp, Activity, Award, CheckCircle, X } from 'lucide-react';
 import Loading from '../components/Loading';
+import Children from './Parent/Children';
 
 interface DashboardProps {
   language: 'zh' | 'en';
@@ -619,6 +620,7 @@ const Dashboard: React.FC<DashboardProps> = ({ language }) => {
   
   if (auth?.user?.role === Role.STUDENT) return <StudentDashboard language={language} />;
   if (auth?.user?.role === Role.TEACHER) return <TeacherDashboard language={language} />;
+  if (auth?.user?.role === Role.PARENT) return <Children language={language} />;
   return <AdminDashboard language={language} />;
 };
 
@@ -1,8 +1,10 @@
 
 import React, { useState, useContext, useEffect } from 'react';
+import { useSearchParams } from 'react-router-dom';
 import { AuthContext } from '../App';
-import { Lock, User as UserIcon, AlertCircle, UserPlus, ArrowRight, CheckCircle2, X } from 'lucide-react';
+import { Lock, User as UserIcon, AlertCircle, UserPlus, ArrowRight, CheckCircle2, X, KeyRound } from 'lucide-react';
 import { api } from '../services/api.ts';
+import ResetPasswordModal from '../components/ResetPasswordModal';
 
 interface LoginProps {
   language: 'zh' | 'en';
@@ -16,17 +18,43 @@ const Login: React.FC<LoginProps> = ({ language }) => {
   const [regPhone, setRegPhone] = useState('');
   const [regPassword, setRegPassword] = useState('');
   const [regConfirmPassword, setRegConfirmPassword] = useState('');
+  const [regInviteCode, setRegInviteCode] = useState('');
+  const [regTenant, setRegTenant] = useState('');
   const [agreedToPrivacy, setAgreedToPrivacy] = useState(false);
   const [showPrivacyModal, setShowPrivacyModal] = useState(false);
   const [error, setError] = useState(false);
   const [errMsg, setErrMsg] = useState('');
   const [success, setSuccess] = useState(false);
   const [regEnabled, setRegEnabled] = useState(false);
+  const [showResetModal, setShowResetModal] = useState(false);
+  const [ssoEnabled, setSsoEnabled] = useState(false);
+  const [searchParams, setSearchParams] = useSearchParams();
 
+  // Registration is switched on per school
   useEffect(() => {
-    api.config.getPublic().then(res => {
+    api.config.getPublic(regTenant.trim() || undefined).then(res => {
       setRegEnabled(res.registrationEnabled);
-    }).catch(() => {});
+      setSsoEnabled(res.ssoEnabled);
+    }).catch(() => setRegEnabled(false));
+  }, [regTenant]);
+
+  // Back from the identity provider with a session or an error
+  useEffect(() => {
+    const handoff = searchParams.get('sso');
+    const ssoError = searchParams.get('ssoError');
+    if (!handoff && !ssoError) return;
+    setSearchParams({}, { replace: true });
+    if (ssoError) {
+      setError(true);
+      setErrMsg(ssoError);
+      return;
+    }
+    auth?.loginWithSSO(handoff!).then(ok => {
+      if (!ok) {
+        setError(true);
+        setErrMsg(language === 'zh' ? '统一身份认证登录失败' : 'Single sign-on failed');
+      }
+    });
   }, []);
 
   const handleSubmit = async (e: React.FormEvent) => {
@@ -50,7 +78,7 @@ const Login: React.FC<LoginProps> = ({ language }) => {
       }
 
       try {
-        await api.auth.register(regPhone, regPassword);
+        await api.auth.register(regPhone, regPassword, regInviteCode.trim() || undefined, regTenant.trim() || undefined);
         setSuccess(true);
         setTimeout(() => {
           setIsRegister(false);
@@ -59,6 +87,7 @@ const Login: React.FC<LoginProps> = ({ language }) => {
           setPassword('');
           setRegPassword('');
           setRegConfirmPassword('');
+          setRegInviteCode('');
         }, 1500);
       } catch (err: any) {
         setError(true);
@@ -162,6 +191,37 @@ const Login: React.FC<LoginProps> = ({ language }) => {
                 </div>
               </div>
               
+              {!regInviteCode.trim() && (
+                <div>
+                  <label className="block text-[10px] font-black text-gray-400 uppercase mb-2 tracking-widest">
+                    {language === 'zh' ? '学校代码' : 'School Code'}
+                  </label>
+                  <input
+                    type="text"
+                    value={regTenant}
+                    onChange={(e) => setRegTenant(e.target.value)}
+                    className="w-full px-4 py-4 rounded-xl border dark:border-gray-700 dark:bg-gray-900 focus:ring-4 focus:ring-primary-500/20 focus:border-primary-500 transition-all outline-none font-bold dark:text-white"
+                    placeholder={language === 'zh' ? '学校提供的代码（可留空）' : 'Code from your school (optional)'}
+                  />
+                </div>
+              )}
+
+              <div>
+                <label className="block text-[10px] font-black text-gray-400 uppercase mb-2 tracking-widest">
+                  {language === 'zh' ? '家长邀请码' : 'Parent Invite Code'}
+                </label>
+                <input
+                  type="text"
+                  value={regInviteCode}
+                  onChange={(e) => setRegInviteCode(e.target.value)}
+                  className="w-full px-4 py-4 rounded-xl border dark:border-gray-700 dark:bg-gray-900 focus:ring-4 focus:ring-primary-500/20 focus:border-primary-500 transition-all outline-none font-bold dark:text-white tracking-widest uppercase"
+                  placeholder={regEnabled
+                    ? (language === 'zh' ? '家长填写老师给的邀请码（学生留空）' : 'Parents: code from the teacher (students leave empty)')
+                    : (language === 'zh' ? '老师给的邀请码' : 'Code from the teacher')}
+                  required={!regEnabled}
+                />
+              </div>
+
               <div className="flex items-center gap-2 px-2">
                 <input
                   type="checkbox"
@@ -238,24 +298,58 @@ const Login: React.FC<LoginProps> = ({ language }) => {
           </button>
         </form>
 
-        {regEnabled && (
-          <div className="mt-8 text-center">
+        {!isRegister && ssoEnabled && (
+          <a
+            href={api.auth.ssoLoginUrl}
+            className="mt-4 w-full py-4 border-2 border-primary-600 text-primary-600 hover:bg-primary-50 dark:hover:bg-gray-900 rounded-2xl font-black flex items-center justify-center gap-2 transition-all"
+          >
+            <KeyRound className="w-5 h-5" />
+            {language === 'zh' ? '教师统一身份认证登录' : 'Teachers: sign in with your school account'}
+          </a>
+        )}
+
+        {!isRegister && (
+          <div className="mt-4 text-center">
             <button
-              onClick={() => {
-                setIsRegister(!isRegister);
-                setError(false);
-                setSuccess(false);
-              }}
-              className="text-sm font-bold text-gray-500 hover:text-primary-600 transition-colors"
+              onClick={() => setShowResetModal(true)}
+              className="text-sm font-bold text-gray-400 hover:text-primary-600 transition-colors"
             >
-              {isRegister 
-                ? (language === 'zh' ? '已有账户？去登录' : 'Already have an account? Log in')
-                : (language === 'zh' ? '没有账户？注册新账户' : 'No account? Create one')}
+              {language === 'zh' ? '忘记密码？' : 'Forgot password?'}
             </button>
           </div>
         )}
+
+        {/* Parents with an invite code can register even when open registration is off */}
+        <div className="mt-8 text-center">
+          <button
+            onClick={() => {
+              setIsRegister(!isRegister);
+              setError(false);
+              setSuccess(false);
+            }}
+            className="text-sm font-bold text-gray-500 hover:text-primary-600 transition-colors"
+          >
+            {isRegister 
+              ? (language === 'zh' ? '已有账户？去登录' : 'Already have an account? Log in')
+              : regEnabled
+                ? (language === 'zh' ? '没有账户？注册新账户' : 'No account? Create one')
+                : (language === 'zh' ? '家长？用邀请码注册' : 'Parent? Register with an invite code')}
+          </button>
+        </div>
       </div>
 
+      <ResetPasswordModal
+        isOpen={showResetModal}
+        language={language}
+        onClose={(resetUsername) => {
+          setShowResetModal(false);
+          if (resetUsername) {
+            setUsername(resetUsername);
+            setPassword('');
+          }
+        }}
+      />
+
       {showPrivacyModal && (
         <div className="fixed inset-0 z-[100] flex items-center justify-center p-4 bg-black/60 backdrop-blur-sm animate-in fade-in duration-300">
           <div className="bg-white dark:bg-gray-800 w-full max-w-lg rounded-3xl shadow-2xl overflow-hidden flex flex-col max-h-[80vh] animate-in zoom-in-95 duration-300">
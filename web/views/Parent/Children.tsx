@@ -0,0 +1,187 @@
+import React, { useEffect, useState } from 'react';
+import { ChevronLeft, ChevronRight, Link2, CheckCircle, Clock, AlertTriangle, BookOpen } from 'lucide-react';
+import { api } from '../../services/api.ts';
+import { Child, WeeklySummary } from '../../types';
+import Loading from '../../components/Loading';
+
+// Read-only weekly view of the children linked to a parent account
+const Children: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
+  const zh = language === 'zh';
+  const [children, setChildren] = useState<Child[]>([]);
+  const [selected, setSelected] = useState<string | null>(null);
+  const [week, setWeek] = useState<string | undefined>(undefined);
+  const [summary, setSummary] = useState<WeeklySummary | null>(null);
+  const [code, setCode] = useState('');
+  const [message, setMessage] = useState({ type: '', text: '' });
+  const [loading, setLoading] = useState(true);
+
+  const fetchChildren = async () => {
+    try {
+      const list = await api.parent.children();
+      setChildren(list);
+      if (list.length > 0 && !list.some(c => c.id === selected)) setSelected(list[0].id);
+    } catch (err) {
+      console.error(err);
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  useEffect(() => { fetchChildren(); }, []);
+
+  useEffect(() => {
+    if (!selected) return;
+    api.parent.weekly(selected, week).then(setSummary).catch(console.error);
+  }, [selected, week]);
+
+  const shiftWeek = (days: number) => {
+    if (!summary) return;
+    const d = new Date(summary.weekStart + 'T00:00:00');
+    d.setDate(d.getDate() + days);
+    const pad = (n: number) => String(n).padStart(2, '0');
+    setWeek(`${d.getFullYear()}-${pad(d.getMonth() + 1)}-${pad(d.getDate())}`);
+  };
+
+  const handleLink = async (e: React.FormEvent) => {
+    e.preventDefault();
+    if (!code.trim()) return;
+    try {
+      const res = await api.parent.linkChild(code.trim());
+      setCode('');
+      setMessage({ type: 'success', text: zh ? '已关联孩子' : 'Child linked' });
+      setSelected(res.studentId);
+      fetchChildren();
+    } catch (err: any) {
+      setMessage({ type: 'error', text: err.message });
+    }
+  };
+
+  if (loading) return <Loading />;
+
+  const statusIcon = (status: string) =>
+    status === 'completed' ? <CheckCircle className="w-4 h-4 text-green-500" /> :
+    status === 'overdue' ? <AlertTriangle className="w-4 h-4 text-red-500" /> :
+    <Clock className="w-4 h-4 text-amber-500" />;
+
+  return (
+    <div className="space-y-6 animate-in fade-in duration-500">
+      <div className="flex flex-wrap items-center justify-between gap-4">
+        <h2 className="text-2xl font-black dark:text-white uppercase tracking-tight">{zh ? '我的孩子' : 'My Children'}</h2>
+        <form onSubmit={handleLink} className="flex gap-2">
+          <input
+            type="text"
+            value={code}
+            onChange={e => setCode(e.target.value)}
+            placeholder={zh ? '输入老师给的邀请码' : 'Invite code from the teacher'}
+            className="px-4 py-3 bg-white dark:bg-gray-800 border dark:border-gray-700 rounded-2xl outline-none dark:text-white font-bold tracking-widest uppercase"
+          />
+          <button type="submit" className="bg-primary-600 text-white px-4 py-3 rounded-2xl font-black flex items-center gap-2 hover:bg-primary-700">
+            <Link2 className="w-4 h-4" />
+            {zh ? '关联' : 'Link'}
+          </button>
+        </form>
+      </div>
+
+      {message.text && (
+        <div className={`p-3 rounded-lg text-sm font-bold ${message.type === 'success' ? 'bg-green-50 text-green-700' : 'bg-red-50 text-red-700'}`}>
+          {message.text}
+        </div>
+      )}
+
+      {children.length === 0 ? (
+        <p className="text-gray-500 dark:text-gray-400">{zh ? '还没有关联孩子，请输入老师给的邀请码。' : 'No children linked yet. Enter the invite code from the teacher.'}</p>
+      ) : (
+        <div className="flex gap-2 flex-wrap">
+          {children.map(c => (
+            <button
+              key={c.id}
+              onClick={() => { setSelected(c.id); setWeek(undefined); }}
+              className={`px-5 py-3 rounded-2xl font-black transition-all ${selected === c.id ? 'bg-primary-600 text-white' : 'bg-white dark:bg-gray-800 dark:text-white border dark:border-gray-700'}`}
+            >
+              {c.name || c.username}
+              {c.classes.length > 0 && <span className="ml-2 text-xs opacity-70">{c.classes.join(', ')}</span>}
+            </button>
+          ))}
+        </div>
+      )}
+
+      {summary && selected && (
+        <div className="space-y-6">
+          <div className="flex items-center gap-4">
+            <button onClick={() => shiftWeek(-7)} className="p-2 rounded-xl bg-white dark:bg-gray-800 border dark:border-gray-700">
+              <ChevronLeft className="w-5 h-5 dark:text-white" />
+            </button>
+            <span className="font-black dark:text-white">{summary.weekStart} ~ {summary.weekEnd}</span>
+            <button onClick={() => shiftWeek(7)} className="p-2 rounded-xl bg-white dark:bg-gray-800 border dark:border-gray-700">
+              <ChevronRight className="w-5 h-5 dark:text-white" />
+            </button>
+          </div>
+
+          <div className="grid grid-cols-2 md:grid-cols-4 gap-4">
+            {[
+              { label: zh ? '练习次数' : 'Practices', value: summary.practices },
+              { label: zh ? '答题数' : 'Questions', value: summary.questions },
+              { label: zh ? '正确率' : 'Accuracy', value: `${summary.accuracy.toFixed(0)}%` },
+              { label: zh ? '学习天数' : 'Active Days', value: `${summary.activeDays}/7` },
+            ].map(card => (
+              <div key={card.label} className="bg-white dark:bg-gray-800 rounded-3xl p-6 border dark:border-gray-700">
+                <p className="text-[10px] text-gray-400 uppercase font-black tracking-widest">{card.label}</p>
+                <p className="text-3xl font-black dark:text-white mt-2">{card.value}</p>
+              </div>
+            ))}
+          </div>
+
+          <div className="grid md:grid-cols-2 gap-6">
+            <div className="bg-white dark:bg-gray-800 rounded-3xl p-6 border dark:border-gray-700">
+              <h3 className="font-black dark:text-white mb-4">
+                {zh ? '本周作业' : 'Homework this week'}
+                <span className="ml-2 text-xs text-gray-400">
+                  {zh
+                    ? `完成 ${summary.homeworkSummary.completed} · 未完成 ${summary.homeworkSummary.pending} · 逾期 ${summary.homeworkSummary.overdue}`
+                    : `${summary.homeworkSummary.completed} done · ${summary.homeworkSummary.pending} pending · ${summary.homeworkSummary.overdue} overdue`}
+                </span>
+              </h3>
+              {summary.homework.length === 0 ? (
+                <p className="text-sm text-gray-400">{zh ? '本周没有作业' : 'No homework this week'}</p>
+              ) : (
+                <ul className="space-y-2">
+                  {summary.homework.map(hw => (
+                    <li key={hw.id} className="flex items-center justify-between text-sm dark:text-gray-200">
+                      <span className="flex items-center gap-2">{statusIcon(hw.status)}{hw.name}</span>
+                      <span className="text-gray-400">
+                        {hw.status === 'completed' ? `${hw.accuracyRate.toFixed(0)}%` : `${zh ? '截止' : 'Due'} ${hw.endDate}`}
+                      </span>
+                    </li>
+                  ))}
+                </ul>
+              )}
+            </div>
+
+            <div className="bg-white dark:bg-gray-800 rounded-3xl p-6 border dark:border-gray-700">
+              <h3 className="font-black dark:text-white mb-4 flex items-center gap-2">
+                <BookOpen className="w-4 h-4" />
+                {zh ? '错题本' : 'Wrong Book'}
+              </h3>
+              <div className="grid grid-cols-3 gap-4 text-center">
+                <div>
+                  <p className="text-2xl font-black text-amber-500">{summary.wrongBook.open}</p>
+                  <p className="text-xs text-gray-400">{zh ? '待巩固' : 'To review'}</p>
+                </div>
+                <div>
+                  <p className="text-2xl font-black text-red-500">{summary.wrongBook.difficult}</p>
+                  <p className="text-xs text-gray-400">{zh ? '困难' : 'Difficult'}</p>
+                </div>
+                <div>
+                  <p className="text-2xl font-black text-green-500">{summary.wrongBook.masteredThisWeek}</p>
+                  <p className="text-xs text-gray-400">{zh ? '本周掌握' : 'Mastered this week'}</p>
+                </div>
+              </div>
+            </div>
+          </div>
+        </div>
+      )}
+    </div>
+  );
+};
+
+export default Children;
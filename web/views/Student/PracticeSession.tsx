@@ -1,6 +1,6 @@
 import React, { useState, useEffect, useCallback, useRef } from 'react';
 import { useNavigate, useSearchParams } from 'react-router-dom';
-import { Question, QuestionType, Subject, AttemptState } from '../../types';
+import { Question, QuestionType, Subject, AttemptState, SessionAnswerResult } from '../../types';
 import { api } from '../../services/api.ts';
 import { REVERSE_TYPE_MAP, SUBJECTS } from '../../utils.ts';
 import { X, ChevronRight, CheckCircle2, HelpCircle, Trophy, PlayCircle, RefreshCcw, Hand, Timer, Brain, Zap, Activity } from 'lucide-react';
@@ -190,9 +190,12 @@ const PracticeSession: React.FC<PracticeSessionProps> = ({ language, themeMode }
   const [queue, setQueue] = useState<Question[]>([]);
   const [currentIdx, setCurrentIdx] = useState(0);
   const [attemptMap, setAttemptMap] = useState<Record<string, number>>({});
-  const attemptLogs = useRef<Record<string, { answer: string; isCorrect: boolean; timestamp: number }[]>>({});
+  // Attempts are recorded server side, the session id ties them together
+  const sessionIdRef = useRef<string>('');
   const questionMap = useRef<Record<string, Question>>({});
   const [selectedAnswer, setSelectedAnswer] = useState<string | null>(null);
+  const [lastCheck, setLastCheck] = useState<SessionAnswerResult | null>(null);
+  const checkingRef = useRef(false);
   const [multiAnswers, setMultiAnswers] = useState<string[]>([]);
   const [isShowingFeedback, setIsShowingFeedback] = useState(false);
   const [isShowingCorrectAnswer, setIsShowingCorrectAnswer] = useState(false);
@@ -242,13 +245,20 @@ const PracticeSession: React.FC<PracticeSessionProps> = ({ language, themeMode }
           data = res.list || [];
         }
         
+        // Open a server side session for the attempt log
+        if (data.length > 0) {
+          const session = await api.sessions.start({
+            homeworkId: homeworkId || undefined,
+            questionIds: data.map(q => q.id),
+          });
+          sessionIdRef.current = session.id;
+          data = data.filter(q => session.questionIds.includes(q.id));
+        }
+
         // Populate question map
         data.forEach(q => {
           questionMap.current[q.id] = q;
         });
-        
-        // Reset logs for new session
-        attemptLogs.current = {};
 
         setQueue(data);
         setTotalInitial(data.length);
@@ -268,41 +278,15 @@ const PracticeSession: React.FC<PracticeSessionProps> = ({ language, themeMode }
     const targetSubject = SUBJECTS.find(s => s.id === subjectParam || s.name === subjectParam);
     const subjectLabel = targetSubject ? targetSubject.name : (subjectParam || '自主练习');
     
-    // Construct results from attemptLogs
-    const results = Object.entries(attemptLogs.current).map(([id, logs]: [string, any[]]) => {
-      const q = questionMap.current[id];
-      if (!q) return null;
-      
-      const lastLog = logs[logs.length - 1];
-      const isCorrect = lastLog?.isCorrect || false;
-      
-      return {
-        id: q.id,
-        subject: q.subject,
-        stem: q.stemText,
-        stemImage: q.stemImage,
-        options: q.options,
-        status: isCorrect ? 'correct' : 'wrong',
-        answer: q.answer,
-        userAnswer: lastLog?.answer || '',
-        attempts: logs.length,
-        attemptLog: logs
-      };
-    }).filter(Boolean);
-
-    const correctCount = results.filter(r => r?.status === 'correct').length;
-    const wrongCount = results.filter(r => r?.status === 'wrong').length;
-
     try {
       await api.history.create({
         type: homeworkId ? 'homework' : 'practice',
         name: homeworkId ? '家庭作业完成' : `${subjectLabel}练习`,
         nameEn: homeworkId ? 'Homework Finished' : `${targetSubject?.enName || subjectParam || 'Practice'} Practice`,
-        correctCount: correctCount, 
-        wrongCount: wrongCount,
-        total: totalInitial.toString(),
+        total: totalInitial,
         homeworkId: homeworkId || "",
-        questions: results 
+        // Results and score are graded from the server side attempt log
+        sessionId: sessionIdRef.current,
       });
 
       if (homeworkId) {
@@ -315,6 +299,7 @@ const PracticeSession: React.FC<PracticeSessionProps> = ({ language, themeMode }
   };
 
   const currentQuestion = queue[currentIdx];
+  const answerKey = lastCheck?.answer ?? currentQuestion?.answer ?? '';
 
   const handleMultiToggle = (val: string) => {
     if (selectedAnswer || isShowingFeedback || isShowingCorrectAnswer) return;
@@ -328,24 +313,26 @@ const PracticeSession: React.FC<PracticeSessionProps> = ({ language, themeMode }
     handleAnswer(multiAnswers.join(','));
   };
 
-  const handleAnswer = (val: string) => {
-    if (isShowingFeedback || isShowingCorrectAnswer || selectedAnswer || !currentQuestion) return;
+  const handleAnswer = async (val: string) => {
+    if (isShowingFeedback || isShowingCorrectAnswer || selectedAnswer || !currentQuestion || checkingRef.current) return;
+
+    // Correctness is decided by the server, students never receive the answer key
+    checkingRef.current = true;
+    let check: SessionAnswerResult;
+    try {
+      check = await api.sessions.answer(sessionIdRef.current, currentQuestion.id, val);
+    } catch (e) {
+      console.error(e);
+      return;
+    } finally {
+      checkingRef.current = false;
+    }
+
+    setLastCheck(check);
     setSelectedAnswer(val);
     setTotalAnswered(prev => prev + 1);
     
-    const isCorrect = val === currentQuestion.answer;
-    
-    // Log the attempt
-    if (!attemptLogs.current[currentQuestion.id]) {
-      attemptLogs.current[currentQuestion.id] = [];
-    }
-    attemptLogs.current[currentQuestion.id].push({ 
-      answer: val, 
-      isCorrect, 
-      timestamp: Date.now() 
-    });
-
-    const currentAttempts = attemptMap[currentQuestion.id] || 0;
+    const isCorrect = check.correct;
 
     if (isCorrect) {
       const newFinishedCount = finishedCount + 1;
@@ -397,12 +384,12 @@ const PracticeSession: React.FC<PracticeSessionProps> = ({ language, themeMode }
         setTimeout(() => proceedToNext(true, false), 1200);
       }
     } else {
-      const nextAttemptCount = currentAttempts + 1;
-      setAttemptMap(prev => ({ ...prev, [currentQuestion.id]: nextAttemptCount }));
+      setAttemptMap(prev => ({ ...prev, [currentQuestion.id]: check.attempts }));
 
-      if (nextAttemptCount === 1) {
+      // The server decides when hints and answers unlock
+      if (check.attemptsLeft > 0 && !check.showHint) {
         setTimeout(() => proceedToNext(false, true), 1200);
-      } else if (nextAttemptCount === 2) {
+      } else if (check.attemptsLeft > 0) {
         setIsShowingFeedback(true);
         setTimeout(() => {
           setIsShowingFeedback(false);
@@ -425,6 +412,7 @@ const PracticeSession: React.FC<PracticeSessionProps> = ({ language, themeMode }
 
   const proceedToNext = (wasCorrect: boolean, shouldRequeue: boolean = false) => {
     setSelectedAnswer(null);
+    setLastCheck(null);
     setMultiAnswers([]);
     setIsShowingFeedback(false);
     setIsShowingCorrectAnswer(false);
@@ -495,7 +483,7 @@ const PracticeSession: React.FC<PracticeSessionProps> = ({ language, themeMode }
                 </h2>
                 {currentQuestion.stemImage && (
                   <div className="rounded-[2rem] overflow-hidden border-4 border-gray-50 dark:border-gray-800 shadow-inner">
-                    <img src={currentQuestion.stemImage} alt="Question Stem" className="w-full object-cover max-h-72" />
+                    <img src={currentQuestion.stemImage} srcSet={currentQuestion.stemSrcset || undefined} sizes="(max-width: 768px) 100vw, 768px" alt="Question Stem" className="w-full object-cover max-h-72" />
                   </div>
                 )}
               </div>
@@ -507,25 +495,25 @@ const PracticeSession: React.FC<PracticeSessionProps> = ({ language, themeMode }
                   </div>
                   <div className="flex-1">
                     <p className="font-black text-amber-800 dark:text-amber-400 mb-1 tracking-wide">{language === 'zh' ? '老师的小纸条' : 'Teacher Hint'}</p>
-                    <p className="text-amber-700 dark:text-amber-500/80 leading-relaxed">{currentQuestion.hint || (language === 'zh' ? '再仔细想想哦！' : 'Think again!')}</p>
+                    <p className="text-amber-700 dark:text-amber-500/80 leading-relaxed">{lastCheck?.hint || currentQuestion.hint || (language === 'zh' ? '再仔细想想哦！' : 'Think again!')}</p>
                   </div>
                 </div>
               )}
 
-              {isShowingCorrectAnswer && (
+              {isShowingCorrectAnswer && answerKey && (
                 <div className="bg-green-50 dark:bg-green-900/10 p-6 flex items-start gap-4 animate-in slide-in-from-top duration-500 border-b dark:border-gray-700">
                   <div className="bg-green-100 dark:bg-green-900/40 p-2 rounded-xl">
                     <CheckCircle2 className="w-6 h-6 text-green-600" />
                   </div>
                   <div className="flex-1">
                     <p className="font-black text-green-800 dark:text-green-400 mb-1 tracking-wide">{language === 'zh' ? '正确答案是' : 'The correct answer is'}</p>
-                    <p className="text-green-700 dark:text-green-500/80 text-xl font-black">{currentQuestion.answer}</p>
+                    <p className="text-green-700 dark:text-green-500/80 text-xl font-black">{answerKey}</p>
                   </div>
                 </div>
               )}
 
               <div className="p-8 md:p-12 bg-gray-50/30 dark:bg-gray-900/30">
-                {(currentQuestion.type === QuestionType.MULTIPLE_SELECT || (currentQuestion.type === QuestionType.MULTIPLE_CHOICE && currentQuestion.answer.includes(','))) && (
+                {(currentQuestion.type === QuestionType.MULTIPLE_SELECT || (currentQuestion.type === QuestionType.MULTIPLE_CHOICE && answerKey.includes(','))) && (
                   <div className="space-y-6">
                     <div className={`grid gap-4 ${currentQuestion.options?.[0]?.image ? 'grid-cols-2' : 'grid-cols-1'}`}>
                       {currentQuestion.options?.filter((opt: any) => {
@@ -539,7 +527,7 @@ const PracticeSession: React.FC<PracticeSessionProps> = ({ language, themeMode }
                         
                         const isSelected = multiAnswers.includes(optValue);
                         const isFinalSelected = selectedAnswer && selectedAnswer.split(',').includes(optValue);
-                        const isCorrect = currentQuestion.answer.split(',').includes(optValue);
+                        const isCorrect = answerKey ? answerKey.split(',').includes(optValue) : !!(isFinalSelected && lastCheck?.correct);
                         
                         let bgColor = 'bg-white dark:bg-gray-800 hover:border-primary-500';
                         if (selectedAnswer) {
@@ -596,7 +584,7 @@ const PracticeSession: React.FC<PracticeSessionProps> = ({ language, themeMode }
                   </div>
                 )}
 
-                {currentQuestion.type === QuestionType.MULTIPLE_CHOICE && !currentQuestion.answer.includes(',') && (
+                {currentQuestion.type === QuestionType.MULTIPLE_CHOICE && !answerKey.includes(',') && (
                   <div className={`grid gap-4 ${currentQuestion.options?.[0]?.image ? 'grid-cols-2' : 'grid-cols-1'}`}>
                     {currentQuestion.options?.filter((opt: any) => {
                       const optText = typeof opt === 'string' ? opt : opt.text;
@@ -608,7 +596,7 @@ const PracticeSession: React.FC<PracticeSessionProps> = ({ language, themeMode }
                       const optValue = typeof opt === 'string' ? opt : opt.value;
                       
                       const isSelected = selectedAnswer === optValue;
-                      const isCorrect = optValue === currentQuestion.answer;
+                      const isCorrect = !!lastCheck?.correct;
                       return (
                         <button
                           key={i}
@@ -654,7 +642,7 @@ const PracticeSession: React.FC<PracticeSessionProps> = ({ language, themeMode }
                           className={`
                             w-full px-8 py-6 bg-white dark:bg-gray-800 border-4 rounded-[2rem] outline-none text-2xl font-black dark:text-white transition-all shadow-inner
                             ${selectedAnswer 
-                              ? (selectedAnswer === currentQuestion.answer ? 'border-green-500' : 'border-red-500')
+                              ? (lastCheck?.correct ? 'border-green-500' : 'border-red-500')
                               : 'border-transparent focus:border-primary-500 focus:shadow-primary-100/50'
                             }
                           `}
@@ -664,7 +652,7 @@ const PracticeSession: React.FC<PracticeSessionProps> = ({ language, themeMode }
                         />
                         {selectedAnswer && (
                           <div className="absolute right-6 top-1/2 -translate-y-1/2">
-                            {selectedAnswer === currentQuestion.answer 
+                            {lastCheck?.correct 
                               ? <CheckCircle2 className="w-10 h-10 text-green-500" />
                               : <X className="w-10 h-10 text-red-500" />
                             }
@@ -690,7 +678,7 @@ const PracticeSession: React.FC<PracticeSessionProps> = ({ language, themeMode }
                   <div className="grid grid-cols-2 gap-6">
                     {['正确', '错误'].map(val => {
                       const isSelected = selectedAnswer === val;
-                      const isCorrect = val === currentQuestion.answer;
+                      const isCorrect = !!lastCheck?.correct;
                       return (
                         <button
                             key={val}
@@ -0,0 +1,143 @@
+import React, { useContext, useEffect, useState } from 'react';
+import { useParams, useSearchParams, Navigate } from 'react-router-dom';
+import { AlertCircle, ArrowLeft } from 'lucide-react';
+import { AuthContext } from '../../App';
+import { api } from '../../services/api.ts';
+import { LOGIN_PICTURES } from '../../utils.ts';
+import Loading from '../../components/Loading';
+
+const Frame: React.FC<{ children: React.ReactNode }> = ({ children }) => (
+  <div className="min-h-screen flex items-center justify-center p-4 bg-gradient-to-br from-primary-50 to-blue-100 dark:from-gray-900 dark:to-primary-950">
+    <div className="w-full max-w-2xl bg-white dark:bg-gray-800 rounded-3xl shadow-xl p-8">{children}</div>
+  </div>
+);
+
+// Opened by scanning a printed QR login card: /#/card/<token>
+export const CardLogin: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
+  const auth = useContext(AuthContext);
+  const { token } = useParams();
+  const [error, setError] = useState('');
+
+  useEffect(() => {
+    if (!token) return;
+    api.auth.cardLogin(token)
+      .then(res => auth?.startSession(res))
+      .catch(err => setError(err.message));
+  }, [token]);
+
+  if (auth?.user) return <Navigate to="/" />;
+  return (
+    <Frame>
+      {error ? (
+        <div className="text-center space-y-4">
+          <AlertCircle className="w-12 h-12 text-red-500 mx-auto" />
+          <p className="font-bold text-red-600">{error}</p>
+          <p className="text-gray-500">{language === 'zh' ? '请找老师重新打印登录卡' : 'Ask your teacher for a new login card'}</p>
+        </div>
+      ) : <Loading />}
+    </Frame>
+  );
+};
+
+// Classroom tablet: /#/picture-login?class=<roster code>. Tap your name, then your pictures.
+export const PictureLogin: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
+  const auth = useContext(AuthContext);
+  const zh = language === 'zh';
+  const [searchParams] = useSearchParams();
+  const [code, setCode] = useState(searchParams.get('class') || localStorage.getItem('rosterCode') || '');
+  const [codeInput, setCodeInput] = useState('');
+  const [roster, setRoster] = useState<{ className: string; students: { id: string; name: string }[]; sequenceLength: number } | null>(null);
+  const [student, setStudent] = useState<{ id: string; name: string } | null>(null);
+  const [picked, setPicked] = useState<number[]>([]);
+  const [error, setError] = useState('');
+
+  useEffect(() => {
+    if (!code) return;
+    api.auth.roster(code).then(res => {
+      setRoster(res);
+      // The tablet stays set up for this class
+      localStorage.setItem('rosterCode', code);
+      setError('');
+    }).catch(err => {
+      setError(err.message);
+      setRoster(null);
+      localStorage.removeItem('rosterCode');
+    });
+  }, [code]);
+
+  const pick = async (picture: number) => {
+    if (!roster || !student) return;
+    const next = [...picked, picture];
+    setPicked(next);
+    if (next.length < roster.sequenceLength) return;
+    try {
+      auth?.startSession(await api.auth.pictureLogin(code, student.id, next));
+    } catch (err: any) {
+      setError(err.message);
+      setPicked([]);
+    }
+  };
+
+  if (auth?.user) return <Navigate to="/" />;
+
+  if (!roster) {
+    return (
+      <Frame>
+        <form onSubmit={e => { e.preventDefault(); setCode(codeInput.trim()); }} className="space-y-4">
+          <h2 className="text-2xl font-black dark:text-white">{zh ? '输入班级代码' : 'Enter the class code'}</h2>
+          <input
+            value={codeInput}
+            onChange={e => setCodeInput(e.target.value)}
+            className="w-full px-4 py-4 rounded-xl border dark:border-gray-700 dark:bg-gray-900 outline-none font-bold dark:text-white tracking-widest uppercase"
+            placeholder="XXXX-XXXX"
+          />
+          {error && <p className="text-sm font-bold text-red-500">{error}</p>}
+          <button type="submit" className="w-full py-4 bg-primary-600 text-white rounded-2xl font-black">{zh ? '打开班级' : 'Open class'}</button>
+        </form>
+      </Frame>
+    );
+  }
+
+  return (
+    <Frame>
+      {!student ? (
+        <>
+          <h2 className="text-2xl font-black dark:text-white mb-6">{roster.className} · {zh ? '点一下你的名字' : 'Tap your name'}</h2>
+          <div className="grid grid-cols-2 sm:grid-cols-3 gap-4">
+            {roster.students.map(s => (
+              <button
+                key={s.id}
+                onClick={() => { setStudent(s); setPicked([]); setError(''); }}
+                className="py-6 rounded-2xl bg-primary-50 dark:bg-gray-900 text-2xl font-black text-primary-700 dark:text-white hover:bg-primary-100"
+              >
+                {s.name}
+              </button>
+            ))}
+          </div>
+        </>
+      ) : (
+        <>
+          <button onClick={() => { setStudent(null); setError(''); }} className="mb-4 flex items-center gap-1 text-gray-500 font-bold">
+            <ArrowLeft className="w-4 h-4" /> {zh ? '返回' : 'Back'}
+          </button>
+          <h2 className="text-2xl font-black dark:text-white">{student.name}</h2>
+          <div className="flex gap-3 my-6">
+            {Array.from({ length: roster.sequenceLength }).map((_, i) => (
+              <div key={i} className="w-14 h-14 rounded-2xl border-2 border-dashed flex items-center justify-center text-3xl">
+                {i < picked.length ? '●' : ''}
+              </div>
+            ))}
+          </div>
+          {error && <p className="mb-4 text-sm font-bold text-red-500">{error}</p>}
+          <div className="grid grid-cols-4 gap-4">
+            {LOGIN_PICTURES.map((emoji, i) => (
+              <button key={i} onClick={() => pick(i)} className="aspect-square rounded-2xl bg-gray-50 dark:bg-gray-900 text-5xl hover:scale-105 transition-transform">
+                {emoji}
+              </button>
+            ))}
+          </div>
+        </>
+      )}
+    </Frame>
+  );
+};
@@ -0,0 +1,104 @@
+import React, { useEffect, useState } from 'react';
+import { Plus, Power } from 'lucide-react';
+import { api } from '../../services/api.ts';
+import { Tenant } from '../../types';
+import Loading from '../../components/Loading';
+
+// Schools on this installation; only super-admins get here
+const Tenants: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
+  const zh = language === 'zh';
+  const [tenants, setTenants] = useState<Tenant[]>([]);
+  const [form, setForm] = useState({ code: '', name: '', adminUsername: '', adminPassword: '' });
+  const [message, setMessage] = useState({ type: '', text: '' });
+  const [loading, setLoading] = useState(true);
+
+  const fetchTenants = () => {
+    api.super.tenants()
+      .then(setTenants)
+      .catch(console.error)
+      .finally(() => setLoading(false));
+  };
+
+  useEffect(() => { fetchTenants(); }, []);
+
+  const handleCreate = async (e: React.FormEvent) => {
+    e.preventDefault();
+    try {
+      await api.super.createTenant(form);
+      setForm({ code: '', name: '', adminUsername: '', adminPassword: '' });
+      setMessage({ type: 'success', text: zh ? '学校已创建，管理员首次登录需修改密码' : 'School created; its admin must change the password on first login' });
+      fetchTenants();
+    } catch (err: any) {
+      setMessage({ type: 'error', text: err.message });
+    }
+  };
+
+  const toggleStatus = async (tenant: Tenant) => {
+    try {
+      await api.super.updateTenant(tenant.id, { status: tenant.status === 'active' ? 'disabled' : 'active' });
+      fetchTenants();
+    } catch (err: any) {
+      setMessage({ type: 'error', text: err.message });
+    }
+  };
+
+  if (loading) return <Loading />;
+
+  const input = "px-4 py-3 bg-white dark:bg-gray-800 border dark:border-gray-700 rounded-2xl outline-none dark:text-white font-bold";
+
+  return (
+    <div className="space-y-6 animate-in fade-in duration-500">
+      <h2 className="text-2xl font-black dark:text-white uppercase tracking-tight">{zh ? '学校管理' : 'Schools'}</h2>
+
+      <form onSubmit={handleCreate} className="grid grid-cols-1 md:grid-cols-5 gap-2">
+        <input className={input} value={form.code} onChange={e => setForm({ ...form, code: e.target.value })} placeholder={zh ? '学校代码' : 'School code'} required />
+        <input className={input} value={form.name} onChange={e => setForm({ ...form, name: e.target.value })} placeholder={zh ? '学校名称' : 'School name'} required />
+        <input className={input} value={form.adminUsername} onChange={e => setForm({ ...form, adminUsername: e.target.value })} placeholder={zh ? '管理员用户名' : 'Admin username'} required />
+        <input className={input} type="password" value={form.adminPassword} onChange={e => setForm({ ...form, adminPassword: e.target.value })} placeholder={zh ? '初始密码' : 'Initial password'} required />
+        <button type="submit" className="bg-primary-600 text-white px-4 py-3 rounded-2xl font-black flex items-center justify-center gap-2 hover:bg-primary-700">
+          <Plus className="w-4 h-4" />
+          {zh ? '新建学校' : 'Add school'}
+        </button>
+      </form>
+
+      {message.text && (
+        <p className={`text-sm font-bold ${message.type === 'error' ? 'text-red-500' : 'text-green-600'}`}>{message.text}</p>
+      )}
+
+      <div className="bg-white dark:bg-gray-800 rounded-3xl overflow-hidden border dark:border-gray-700 shadow-sm">
+        <table className="w-full text-left">
+          <thead className="bg-gray-50 dark:bg-gray-900/50">
+            <tr className="text-xs font-black text-gray-400 uppercase tracking-widest">
+              <th className="px-6 py-4">{zh ? '代码' : 'Code'}</th>
+              <th className="px-6 py-4">{zh ? '名称' : 'Name'}</th>
+              <th className="px-6 py-4">{zh ? '用户数' : 'Users'}</th>
+              <th className="px-6 py-4">{zh ? '创建时间' : 'Created'}</th>
+              <th className="px-6 py-4">{zh ? '状态' : 'Status'}</th>
+            </tr>
+          </thead>
+          <tbody className="divide-y dark:divide-gray-700">
+            {tenants.map(t => (
+              <tr key={t.id} className="hover:bg-gray-50 dark:hover:bg-gray-900/30 transition-colors">
+                <td className="px-6 py-4 font-mono text-sm dark:text-gray-300">{t.code}</td>
+                <td className="px-6 py-4 font-bold dark:text-white">{t.name}</td>
+                <td className="px-6 py-4 text-sm dark:text-gray-300">{t.users}</td>
+                <td className="px-6 py-4 text-sm text-gray-500">{t.createdAt}</td>
+                <td className="px-6 py-4">
+                  <button
+                    onClick={() => toggleStatus(t)}
+                    className={`flex items-center gap-1 text-xs font-black uppercase ${t.status === 'active' ? 'text-green-600' : 'text-gray-400'}`}
+                  >
+                    <Power className="w-4 h-4" />
+                    {t.status === 'active' ? (zh ? '启用' : 'Active') : (zh ? '停用' : 'Disabled')}
+                  </button>
+                </td>
+              </tr>
+            ))}
+          </tbody>
+        </table>
+      </div>
+    </div>
+  );
+};
+
+export default Tenants;
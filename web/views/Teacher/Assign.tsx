@@ -196,7 +196,7 @@ const Assign: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
       await api.homework.assign({
         paperId: finalPaperId,
         name: paper ? paper.name : 'Homework',
-        classId: '3-1', // Mock class
+        classId: '', // Students are picked individually, no class expansion
         startDate: new Date().toISOString().split('T')[0],
         endDate: deadline,
         studentIds: selectedStudents // Backend needs to handle this (currently AssignHomework takes generic 'h')
@@ -1,9 +1,10 @@
-import React, { useState, useEffect, useRef } from 'react';
+import React, { useState, useEffect, useRef, useContext } from 'react';
 import { Plus, Search, Filter, Edit2, Trash2, X, Image as ImageIcon, CheckCircle, Circle, CheckSquare, Square, Upload, Eye, ChevronLeft, ChevronRight } from 'lucide-react';
 import * as XLSX from 'xlsx';
 import { api } from '../../services/api.ts';
 import { Question, QuestionType } from '../../types.ts';
-import { GRADE_MAP, REVERSE_GRADE_MAP, TYPE_MAP, REVERSE_TYPE_MAP, SUBJECTS } from '../../utils.ts';
+import { GRADE_MAP, REVERSE_GRADE_MAP, TYPE_MAP, REVERSE_TYPE_MAP, SUBJECTS, canEditContent, isContentOwner } from '../../utils.ts';
+import { AuthContext } from '../../App';
 import Loading from '../../components/Loading';
 
 interface OptionRowProps {
@@ -87,6 +88,7 @@ const OptionRow: React.FC<OptionRowProps> = ({
 };
 
 const Questions: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
+  const auth = useContext(AuthContext);
   const [isModalOpen, setIsModalOpen] = useState(false);
   const [isImportModalOpen, setIsImportModalOpen] = useState(false);
   const [importData, setImportData] = useState<any[]>([]);
@@ -346,7 +348,10 @@ const Questions: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
       stemText: formStem,
       stemImage: formStemImage,
       options: ['单选题', '多选题', QuestionType.MULTIPLE_CHOICE, QuestionType.MULTIPLE_SELECT].includes(formType) ? validOptions : undefined,
-      answer: Array.isArray(formAnswer) ? formAnswer.join(',') : formAnswer
+      answer: Array.isArray(formAnswer) ? formAnswer.join(',') : formAnswer,
+      // Saving replaces the question, so send its sharing back unchanged
+      coOwnerIds: editingQuestion?.coOwnerIds || [],
+      department: editingQuestion?.department || ''
     };
 
     try {
@@ -470,12 +475,16 @@ const Questions: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
                    <button onClick={() => setPreviewQuestion(q)} className="p-3 text-gray-400 hover:text-blue-500 hover:bg-blue-50 dark:hover:bg-blue-900/20 rounded-2xl transition-all">
                      <Eye className="w-5 h-5" />
                    </button>
-                   <button onClick={() => handleOpenModal(q)} className="p-3 text-gray-400 hover:text-primary-600 hover:bg-primary-50 dark:hover:bg-primary-900/20 rounded-2xl transition-all">
-                     <Edit2 className="w-5 h-5" />
-                   </button>
-                   <button onClick={() => handleDelete(q.id)} className="p-3 text-gray-400 hover:text-red-500 hover:bg-red-50 dark:hover:bg-red-900/20 rounded-2xl transition-all">
-                     <Trash2 className="w-5 h-5" />
-                   </button>
+                   {canEditContent(auth?.user, q) && (
+                     <button onClick={() => handleOpenModal(q)} className="p-3 text-gray-400 hover:text-primary-600 hover:bg-primary-50 dark:hover:bg-primary-900/20 rounded-2xl transition-all">
+                       <Edit2 className="w-5 h-5" />
+                     </button>
+                   )}
+                   {isContentOwner(auth?.user, q) && (
+                     <button onClick={() => handleDelete(q.id)} className="p-3 text-gray-400 hover:text-red-500 hover:bg-red-50 dark:hover:bg-red-900/20 rounded-2xl transition-all">
+                       <Trash2 className="w-5 h-5" />
+                     </button>
+                   )}
                 </div>
               </div>
             );
@@ -24,6 +24,7 @@ const Resources: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
   const [formTags, setFormTags] = useState<string[]>([]);
   const [tagInput, setTagInput] = useState('');
   const [uploadedFile, setUploadedFile] = useState<string | null>(null);
+  const [uploadedAssetId, setUploadedAssetId] = useState<string | null>(null);
   const fileInputRef = useRef<HTMLInputElement>(null);
 
   // Modal State for Alerts
@@ -68,11 +69,21 @@ const Resources: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
         setIsConfirmationModalOpen(true);
         return;
       }
-      const reader = new FileReader();
-      reader.onload = (ev) => {
-        setUploadedFile(ev.target?.result as string);
-      };
-      reader.readAsDataURL(file);
+      // The server resizes the image and generates the thumbnail
+      api.uploads.upload(file).then(asset => {
+        setUploadedFile(asset.url);
+        setUploadedAssetId(asset.id);
+      }).catch(err => {
+        console.error(err);
+        setConfirmationModalProps({
+          title: language === 'zh' ? '上传失败' : 'Upload Failed',
+          message: err.message,
+          type: 'error',
+          language: language,
+          onConfirm: () => setIsConfirmationModalOpen(false),
+        });
+        setIsConfirmationModalOpen(true);
+      });
     }
   };
 
@@ -94,6 +105,7 @@ const Resources: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
       visibility: formVisibility,
       tags: formTags,
       url: uploadedFile,
+      assetId: uploadedAssetId || undefined,
       type: 'image'
     };
 
@@ -165,6 +177,7 @@ const Resources: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
     setFormTags([]);
     setTagInput('');
     setUploadedFile(null);
+    setUploadedAssetId(null);
   };
 
   const openEdit = (item: Resource) => {
@@ -230,7 +243,7 @@ const Resources: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
             {items.map(item => (
               <div key={item.id} className="group relative bg-white dark:bg-gray-800 rounded-3xl overflow-hidden border dark:border-gray-700 shadow-sm hover:shadow-xl transition-all">
                 <div className="aspect-square bg-gray-50 dark:bg-gray-900 flex items-center justify-center overflow-hidden">
-                  <img src={item.url} alt={item.name} className="w-full h-full object-cover group-hover:scale-110 transition-transform duration-500" />
+                  <img src={item.thumbnailUrl || item.url} alt={item.name} className="w-full h-full object-cover group-hover:scale-110 transition-transform duration-500" />
                   
                   {/* Hover Overlay */}
                   <div className="absolute inset-0 bg-black/60 opacity-0 group-hover:opacity-100 transition-opacity flex flex-col items-center justify-center gap-3 p-4">
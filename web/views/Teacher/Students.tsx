@@ -17,12 +17,15 @@ import {
   Info,
   PlayCircle,
   Trophy,
-  Gamepad2
+  Gamepad2,
+  Printer,
+  QrCode,
+  Ban
 } from 'lucide-react';
 import { api } from '../../services/api.ts';
-import { User } from '../../types';
+import { User, Class, LoginCard } from '../../types';
 import Loading from '../../components/Loading';
-import { SUBJECTS } from '../../utils.ts';
+import { SUBJECTS, LOGIN_PICTURES } from '../../utils.ts';
 
 const Students: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
   const [students, setStudents] = useState<User[]>([]);
@@ -32,6 +35,8 @@ const Students: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
   const [loadingDetail, setLoadingDetail] = useState(false);
   const [search, setSearch] = useState('');
   const [error, setError] = useState<string | null>(null);
+  const [classes, setClasses] = useState<Class[]>([]);
+  const [cardClass, setCardClass] = useState('');
 
   // Detail View State
   const [viewingHistory, setViewingHistory] = useState<any>(null);
@@ -75,6 +80,7 @@ const Students: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
 
   useEffect(() => {
     fetchStudents();
+    api.classes.list().then(setClasses).catch(console.error);
   }, []);
 
   const fetchStudents = async () => {
@@ -94,6 +100,133 @@ const Students: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
     }
   };
 
+  // Opens a print dialog with a one-time reset code for a student without a phone
+  const handlePrintResetCode = async (id: string) => {
+    try {
+      const slip = await api.students.createResetCode(id);
+      const win = window.open('', '_blank', 'width=480,height=360');
+      if (!win) return;
+      const title = language === 'zh' ? '密码重置码' : 'Password Reset Code';
+      const note = language === 'zh'
+        ? `在登录页点击"忘记密码"，输入用户名和重置码后设置新密码。有效期至 ${slip.expiresAt}，仅可使用一次。`
+        : `On the login page choose "Forgot password?", enter the username and this code, then pick a new password. Valid until ${slip.expiresAt}, one use only.`;
+      const escape = (v: string) => v.replace(/[&<>"']/g, ch => `&#${ch.charCodeAt(0)};`);
+      win.document.write(`<html><head><title>${title}</title></head><body style="font-family:sans-serif;padding:24px">
+        <h2>${title}</h2>
+        <p>${escape(slip.name || slip.username)} (${escape(slip.username)})</p>
+        <p style="font-size:32px;font-weight:bold;letter-spacing:6px">${escape(slip.code)}</p>
+        <p style="font-size:12px;color:#555">${note}</p>
+      </body></html>`);
+      win.document.close();
+      win.print();
+    } catch (err: any) {
+      alert(err.message);
+    }
+  };
+
+  // Opens a print dialog with QR login cards; printing a card retires the student's previous one
+  const printLoginCards = (cards: LoginCard[]) => {
+    const win = window.open('', '_blank', 'width=720,height=640');
+    if (!win) return;
+    const title = language === 'zh' ? '扫码登录卡' : 'QR Login Cards';
+    const note = language === 'zh' ? '用平板扫描二维码即可登录，请妥善保管。' : 'Scan the code with the tablet to log in. Keep this card safe.';
+    const escape = (v: string) => v.replace(/[&<>"']/g, ch => `&#${ch.charCodeAt(0)};`);
+    win.document.write(`<html><head><title>${title}</title></head><body style="font-family:sans-serif;padding:24px">
+      ${cards.map(card => `<div style="display:inline-block;width:260px;margin:8px;padding:16px;border:1px dashed #999;text-align:center;page-break-inside:avoid">
+        <p style="font-size:22px;font-weight:bold">${escape(card.name || card.username)}</p>
+        <img src="${card.qr}" width="200" height="200" />
+        <p style="font-size:11px;color:#555">${note}</p>
+      </div>`).join('')}
+    </body></html>`);
+    win.document.close();
+    win.print();
+  };
+
+  const handlePrintLoginCard = async (id: string) => {
+    try {
+      printLoginCards([await api.students.createLoginCard(id)]);
+    } catch (err: any) {
+      alert(err.message);
+    }
+  };
+
+  const handlePrintClassCards = async () => {
+    if (!cardClass) return;
+    try {
+      printLoginCards(await api.classes.loginCards(cardClass));
+    } catch (err: any) {
+      alert(err.message);
+    }
+  };
+
+  // Shows the code that sets up a classroom tablet for picture logins; the previous code stops working
+  const handleRosterCode = async () => {
+    if (!cardClass) return;
+    try {
+      const res = await api.classes.createRosterCode(cardClass);
+      const link = `${window.location.origin}${window.location.pathname}#/picture-login?class=${res.code}`;
+      alert(language === 'zh'
+        ? `${res.name} 的班级代码：${res.code}\n在教室平板上打开：${link}`
+        : `Class code of ${res.name}: ${res.code}\nOpen on the classroom tablet: ${link}`);
+    } catch (err: any) {
+      alert(err.message);
+    }
+  };
+
+  const handlePrintPicturePassword = async (id: string) => {
+    try {
+      const res = await api.students.createPicturePassword(id);
+      const win = window.open('', '_blank', 'width=480,height=360');
+      if (!win) return;
+      const title = language === 'zh' ? '图片密码' : 'Picture Password';
+      const note = language === 'zh' ? '在教室平板上点自己的名字，再按顺序点这些图片。' : 'On the classroom tablet tap your name, then these pictures in order.';
+      const escape = (v: string) => v.replace(/[&<>"']/g, ch => `&#${ch.charCodeAt(0)};`);
+      win.document.write(`<html><head><title>${title}</title></head><body style="font-family:sans-serif;padding:24px">
+        <h2>${title}</h2>
+        <p>${escape(res.name || '')}</p>
+        <p style="font-size:48px;letter-spacing:12px">${res.pictures.map(p => LOGIN_PICTURES[p]).join('')}</p>
+        <p style="font-size:12px;color:#555">${note}</p>
+      </body></html>`);
+      win.document.close();
+      win.print();
+    } catch (err: any) {
+      alert(err.message);
+    }
+  };
+
+  const handleRevokeQuickLogin = async (id: string) => {
+    if (!confirm(language === 'zh' ? '停用该学生的登录卡和图片密码，并让其退出登录？' : 'Turn off this student\'s login card and picture password and log them out?')) return;
+    try {
+      await api.students.revokeLogin(id);
+    } catch (err: any) {
+      alert(err.message);
+    }
+  };
+
+  // Opens a print dialog with a one-time code a parent uses to link their account
+  const handlePrintParentInvite = async (id: string) => {
+    try {
+      const slip = await api.students.createParentInvite(id);
+      const win = window.open('', '_blank', 'width=480,height=360');
+      if (!win) return;
+      const title = language === 'zh' ? '家长邀请码' : 'Parent Invite Code';
+      const note = language === 'zh'
+        ? `家长在登录页选择"用邀请码注册"，或登录后在"我的孩子"中输入此码，即可查看孩子的学习情况。有效期至 ${slip.expiresAt}，仅可使用一次。`
+        : `Parents register with this code on the login page, or enter it under "My Children" once logged in, to follow the child's progress. Valid until ${slip.expiresAt}, one use only.`;
+      const escape = (v: string) => v.replace(/[&<>"']/g, ch => `&#${ch.charCodeAt(0)};`);
+      win.document.write(`<html><head><title>${title}</title></head><body style="font-family:sans-serif;padding:24px">
+        <h2>${title}</h2>
+        <p>${escape(slip.name || '')}</p>
+        <p style="font-size:32px;font-weight:bold;letter-spacing:6px">${escape(slip.code)}</p>
+        <p style="font-size:12px;color:#555">${note}</p>
+      </body></html>`);
+      win.document.close();
+      win.print();
+    } catch (err: any) {
+      alert(err.message);
+    }
+  };
+
   const handleSelectStudent = async (id: string) => {
     setSelectedStudent(id);
     setLoadingDetail(true);
@@ -142,6 +275,24 @@ const Students: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
               className="w-full pl-10 pr-4 py-2 bg-gray-50 dark:bg-gray-900 border dark:border-gray-700 rounded-xl outline-none focus:ring-2 focus:ring-primary-500/20 text-sm dark:text-white"
             />
           </div>
+          {classes.length > 0 && (
+            <div className="mt-3 flex gap-2">
+              <select
+                value={cardClass}
+                onChange={(e) => setCardClass(e.target.value)}
+                className="flex-1 min-w-0 px-2 py-1.5 bg-gray-50 dark:bg-gray-900 border dark:border-gray-700 rounded-lg text-xs dark:text-white"
+              >
+                <option value="">{language === 'zh' ? '选择班级…' : 'Class…'}</option>
+                {classes.map(cls => <option key={cls.id} value={cls.id}>{cls.name}</option>)}
+              </select>
+              <button onClick={handlePrintClassCards} disabled={!cardClass} title={language === 'zh' ? '打印全班登录卡' : 'Print login cards for the class'} className="p-1.5 rounded-lg bg-gray-100 dark:bg-gray-700 disabled:opacity-40">
+                <QrCode className="w-4 h-4 dark:text-gray-300" />
+              </button>
+              <button onClick={handleRosterCode} disabled={!cardClass} title={language === 'zh' ? '平板班级代码' : 'Tablet class code'} className="p-1.5 rounded-lg bg-gray-100 dark:bg-gray-700 disabled:opacity-40">
+                <Users className="w-4 h-4 dark:text-gray-300" />
+              </button>
+            </div>
+          )}
         </div>
         
         <div className="flex-1 overflow-y-auto p-2">
@@ -213,6 +364,41 @@ const Students: React.FC<{ language: 'zh' | 'en' }> = ({ language }) => {
                        <span className="px-3 py-1 bg-green-50 dark:bg-green-900/30 text-green-600 dark:text-green-400 text-[10px] font-black uppercase tracking-widest rounded-lg">
                           Active
                        </span>
+                       <button
+                          onClick={() => handlePrintResetCode(detail.student.id)}
+                          className="px-3 py-1 bg-gray-100 dark:bg-gray-700 text-gray-600 dark:text-gray-300 text-[10px] font-black uppercase tracking-widest rounded-lg flex items-center gap-1 hover:bg-gray-200"
+                       >
+                          <Printer className="w-3 h-3" />
+                          {language === 'zh' ? '打印重置码' : 'Print Reset Code'}
+                       </button>
+                       <button
+                          onClick={() => handlePrintParentInvite(detail.student.id)}
+                          className="px-3 py-1 bg-gray-100 dark:bg-gray-700 text-gray-600 dark:text-gray-300 text-[10px] font-black uppercase tracking-widest rounded-lg flex items-center gap-1 hover:bg-gray-200"
+                       >
+                          <Printer className="w-3 h-3" />
+                          {language === 'zh' ? '邀请家长' : 'Invite Parent'}
+                       </button>
+                       <button
+                          onClick={() => handlePrintLoginCard(detail.student.id)}
+                          className="px-3 py-1 bg-gray-100 dark:bg-gray-700 text-gray-600 dark:text-gray-300 text-[10px] font-black uppercase tracking-widest rounded-lg flex items-center gap-1 hover:bg-gray-200"
+                       >
+                          <QrCode className="w-3 h-3" />
+                          {language === 'zh' ? '登录卡' : 'Login Card'}
+                       </button>
+                       <button
+                          onClick={() => handlePrintPicturePassword(detail.student.id)}
+                          className="px-3 py-1 bg-gray-100 dark:bg-gray-700 text-gray-600 dark:text-gray-300 text-[10px] font-black uppercase tracking-widest rounded-lg flex items-center gap-1 hover:bg-gray-200"
+                       >
+                          <Printer className="w-3 h-3" />
+                          {language === 'zh' ? '图片密码' : 'Picture Password'}
+                       </button>
+                       <button
+                          onClick={() => handleRevokeQuickLogin(detail.student.id)}
+                          className="px-3 py-1 bg-gray-100 dark:bg-gray-700 text-red-600 text-[10px] font-black uppercase tracking-widest rounded-lg flex items-center gap-1 hover:bg-gray-200"
+                       >
+                          <Ban className="w-3 h-3" />
+                          {language === 'zh' ? '停用快捷登录' : 'Revoke Quick Login'}
+                       </button>
                     </div>
                   </div>
                </div>